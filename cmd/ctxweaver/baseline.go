@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/mpyw/ctxweaver/internal"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+// defaultBaselineFile is the file written by the baseline subcommand by default.
+const defaultBaselineFile = "ctxweaver.baseline.json"
+
+// runBaseline dispatches the baseline actions. Only "create" is supported:
+// it records the eligible functions of the target packages that have no
+// generated statement yet (or, with -since, all functions declared at a git
+// revision) to a baseline file. Weaving, checking and coverage with -baseline
+// then leave those functions alone.
+func runBaseline(args []string) error {
+	if len(args) == 0 || args[0] != "create" {
+		return fmt.Errorf("baseline requires an action (create)")
+	}
+	args = args[1:]
+
+	output := flag.String("o", defaultBaselineFile, "write the baseline to this file")
+	opts := parseFlags(args)
+	if opts.baselineFile != "" {
+		return fmt.Errorf("baseline cannot be combined with -baseline")
+	}
+
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	patterns, err := getPatterns(cfg)
+	if err != nil {
+		return err
+	}
+
+	baseline, err := resolveBaseline(opts, patterns, cfg.Test)
+	if err != nil {
+		return err
+	}
+	if baseline == nil {
+		tmplContent, err := cfg.Template.Content()
+		if err != nil {
+			return fmt.Errorf("failed to get template: %w", err)
+		}
+		tmpl, err := parseTemplate(tmplContent)
+		if err != nil {
+			return err
+		}
+		proc, err := createProcessor(cfg, tmpl, opts)
+		if err != nil {
+			return err
+		}
+		var errs []error
+		if baseline, errs, err = proc.CollectBaseline(patterns); err != nil {
+			return err
+		}
+		if len(errs) > 0 {
+			fmt.Fprintln(os.Stderr, "Errors:")
+			for _, e := range errs {
+				fmt.Fprintf(os.Stderr, "  %v\n", e)
+			}
+			return fmt.Errorf("%d error(s) occurred", len(errs))
+		}
+	}
+
+	if err := baseline.Save(*output); err != nil {
+		return err
+	}
+	if !opts.silent {
+		fmt.Printf("  %s✓%s %d functions recorded in %s\n", co(internal.ColorGreen), co(internal.ColorReset), len(baseline.Functions()), *output)
+	}
+	return nil
+}
+
+// resolveBaseline returns the baseline selected by -baseline or -since,
+// or nil if neither is given.
+func resolveBaseline(opts *options, patterns []string, test bool) (*processor.Baseline, error) {
+	switch {
+	case opts.baselineFile != "" && opts.since != "":
+		return nil, fmt.Errorf("-baseline and -since are mutually exclusive")
+	case opts.baselineFile != "":
+		return processor.LoadBaseline(opts.baselineFile)
+	case opts.since != "":
+		return processor.GitBaseline(opts.since, patterns, test)
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun_BaselineAndCheck(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+	baselinePath := filepath.Join(tmpDir, "ctxweaver.baseline.json")
+	files := map[string]string{
+		"ctxweaver.yaml": `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`,
+		"go.mod": "module test\n\ngo 1.21\n",
+		"test.go": `package test
+
+import "context"
+
+func trace(context.Context) {}
+
+func Woven(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func Legacy(ctx context.Context) {
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	t.Run("baseline requires an action", func(t *testing.T) {
+		setup("baseline", "-config", configPath)
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "baseline requires an action") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("check fails without baseline", func(t *testing.T) {
+		setup("check", "-config", configPath, "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "1 file(s) need changes") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("check annotates functions in github format", func(t *testing.T) {
+		setup("check", "-config", configPath, "-silent", "-format", "github")
+		var err error
+		out := captureStdout(t, func() { err = run() })
+		if err == nil || !strings.Contains(err.Error(), "1 file(s) need changes") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		want := "::warning file=test.go,line=11::function Legacy missing instrumentation\n"
+		if string(out) != want {
+			t.Errorf("output = %q, want %q", out, want)
+		}
+	})
+
+	t.Run("check rejects unknown format", func(t *testing.T) {
+		setup("check", "-config", configPath, "-silent", "-format", "xml")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), `unknown format "xml"`) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("format requires check", func(t *testing.T) {
+		setup("-config", configPath, "-silent", "-format", "github")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "-format github is only supported by check") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("baseline create records unwoven functions", func(t *testing.T) {
+		setup("baseline", "create", "-config", configPath, "-silent", "-o", baselinePath)
+		if err := run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, err := os.ReadFile(baselinePath)
+		if err != nil {
+			t.Fatalf("failed to read baseline: %v", err)
+		}
+		if !strings.Contains(string(data), `"test.Legacy"`) || strings.Contains(string(data), `"test.Woven"`) {
+			t.Errorf("unexpected baseline:\n%s", data)
+		}
+	})
+
+	t.Run("check passes with baseline", func(t *testing.T) {
+		setup("check", "-config", configPath, "-silent", "-baseline", baselinePath)
+		if err := run(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("baseline and since are mutually exclusive", func(t *testing.T) {
+		setup("check", "-config", configPath, "-silent", "-baseline", baselinePath, "-since", "HEAD")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "mutually exclusive") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mpyw/ctxweaver/internal"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+// coverageJSON is the JSON output of the coverage subcommand.
+type coverageJSON struct {
+	Labels   labelsFlag                  `json:"labels,omitempty"`
+	Packages []processor.PackageCoverage `json:"packages"`
+	Total    processor.PackageCoverage   `json:"total"`
+}
+
+// runCoverage reports, per package, how many eligible functions are instrumented.
+// No file is modified and hooks are not run.
+func runCoverage(args []string) error {
+	opts := parseFlags(args)
+	if opts.format != "text" && opts.format != "json" {
+		return fmt.Errorf("unknown format %q: use text or json", opts.format)
+	}
+
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	patterns, err := getPatterns(cfg)
+	if err != nil {
+		return err
+	}
+
+	if opts.baseline, err = resolveBaseline(opts, patterns, cfg.Test); err != nil {
+		return err
+	}
+
+	tmplContent, err := cfg.Template.Content()
+	if err != nil {
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+	tmpl, err := parseTemplate(tmplContent)
+	if err != nil {
+		return err
+	}
+
+	proc, err := createProcessor(cfg, tmpl, opts)
+	if err != nil {
+		return err
+	}
+
+	result, err := proc.Coverage(patterns)
+	if err != nil {
+		return err
+	}
+
+	if opts.format == "json" {
+		out := coverageJSON{Labels: opts.labels, Packages: result.Packages, Total: result.Total()}
+		if out.Packages == nil {
+			out.Packages = []processor.PackageCoverage{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	} else if !opts.silent {
+		printCoverage(result)
+	}
+
+	if len(result.Errors) > 0 {
+		fmt.Fprintln(os.Stderr, "Errors:")
+		for _, e := range result.Errors {
+			fmt.Fprintf(os.Stderr, "  %v\n", e)
+		}
+		return fmt.Errorf("%d error(s) occurred", len(result.Errors))
+	}
+	return nil
+}
+
+// printCoverage prints the coverage as an aligned table followed by the total.
+func printCoverage(result *processor.CoverageResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "INSTRUMENTED\tELIGIBLE\tCOVERAGE\t\tPACKAGE")
+	for _, c := range result.Packages {
+		fmt.Fprintf(w, "%d\t%d\t%.1f%%\t\t%s\n", c.Instrumented, c.Eligible, c.Ratio()*100, c.Package)
+	}
+	_ = w.Flush()
+
+	total := result.Total()
+	color := internal.ColorGreen
+	if total.Instrumented < total.Eligible {
+		color = internal.ColorYellow
+	}
+	fmt.Printf("  %s%d/%d functions instrumented (%.1f%%)%s\n", co(color), total.Instrumented, total.Eligible, total.Ratio()*100, co(internal.ColorReset))
+}
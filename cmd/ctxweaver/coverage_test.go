@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun_Coverage(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+	config := `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`
+	files := map[string]string{
+		"ctxweaver.yaml": config,
+		"go.mod":         "module test\n\ngo 1.21\n",
+		"test.go": `package test
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func Bar(ctx context.Context) {
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	t.Run("json output", func(t *testing.T) {
+		setup("coverage", "-config", configPath, "-format", "json")
+		out := captureStdout(t, func() {
+			if err := run(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		var got coverageJSON
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatalf("invalid JSON output: %v\n%s", err, out)
+		}
+		if len(got.Packages) != 1 || got.Packages[0].Package != "test" {
+			t.Fatalf("unexpected packages: %+v", got.Packages)
+		}
+		if got.Total.Eligible != 2 || got.Total.Instrumented != 1 {
+			t.Errorf("Total = %+v, want 2 eligible and 1 instrumented", got.Total)
+		}
+	})
+
+	t.Run("text output", func(t *testing.T) {
+		setup("coverage", "-config", configPath)
+		out := captureStdout(t, func() {
+			if err := run(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+		if !strings.Contains(string(out), "1/2 functions instrumented (50.0%)") {
+			t.Errorf("unexpected output:\n%s", out)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		setup("coverage", "-config", configPath, "-format", "xml")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), `unknown format "xml"`) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) []byte {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- data
+	}()
+
+	fn()
+	_ = w.Close()
+	return <-done
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+
+	"golang.org/x/tools/go/packages"
+
+	"github.com/mpyw/ctxweaver/internal"
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+// severity classifies a doctor finding.
+type severity int
+
+const (
+	severityOK severity = iota
+	severityWarning
+	severityError
+)
+
+// finding is a single diagnostic result reported by the doctor subcommand.
+type finding struct {
+	severity severity
+	check    string
+	message  string
+	hint     string
+}
+
+// runDoctor validates the configuration without processing any packages.
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("ctxweaver doctor", flag.ContinueOnError)
+	configFile := fs.String("config", "ctxweaver.yaml", "path to configuration file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	findings := diagnose(*configFile)
+	printFindings(findings)
+
+	var errs int
+	for _, f := range findings {
+		if f.severity == severityError {
+			errs++
+		}
+	}
+	if errs > 0 {
+		return fmt.Errorf("doctor found %d problem(s)", errs)
+	}
+	return nil
+}
+
+// diagnose runs all configuration checks and collects their findings.
+// Checks that depend on a previous step are skipped when that step fails.
+func diagnose(configFile string) []finding {
+	cfg, err := config.LoadConfig(configFile, checkTemplates)
+	var te *config.TemplateError
+	if errors.As(err, &te) {
+		var hint string
+		if errors.Is(err, fs.ErrNotExist) {
+			hint = "template files are resolved relative to the current working directory"
+		}
+		return []finding{{severity: severityError, check: "template", message: te.Error(), hint: hint}}
+	}
+	if err != nil {
+		return []finding{{severity: severityError, check: "config", message: err.Error()}}
+	}
+	findings := []finding{{severity: severityOK, check: "config", message: configFile + " matches the schema"}}
+
+	if err := cfg.CheckMinVersion(binaryVersion()); err != nil {
+		findings = append(findings, finding{severity: severityError, check: "min_version", message: err.Error()})
+	}
+
+	if !cfg.Carriers.UseDefault() && len(cfg.Carriers.Custom) == 0 {
+		findings = append(findings, finding{
+			severity: severityWarning,
+			check:    "carriers",
+			message:  "default carriers are disabled and no custom carriers are defined",
+			hint:     "no function will match; add carriers.custom or set carriers.default to true",
+		})
+	}
+
+	findings = append(findings, checkExcludeDefault(cfg.Carriers.ExcludeDefault)...)
+	findings = append(findings, checkRegexps("packages.regexps", cfg.Packages.Regexps)...)
+	findings = append(findings, checkRegexps("functions.regexps", cfg.Functions.Regexps)...)
+	findings = append(findings, checkTemplate(cfg)...)
+	findings = append(findings, checkImports(config.ParseImports(cfg.Imports))...)
+
+	return findings
+}
+
+// checkExcludeDefault reports carriers.exclude_default entries that match no default carrier.
+func checkExcludeDefault(names []string) []finding {
+	var findings []finding
+	for _, name := range names {
+		if !slices.ContainsFunc(config.DefaultCarriers(), func(def config.CarrierDef) bool { return def.MatchesName(name) }) {
+			findings = append(findings, finding{
+				severity: severityWarning,
+				check:    "carriers.exclude_default",
+				message:  fmt.Sprintf("%q matches no default carrier", name),
+				hint:     "use 'package/path.Type' or 'name.Type', e.g. 'gin.Context'",
+			})
+		}
+	}
+	return findings
+}
+
+// checkRegexps compiles every pattern in r and reports the ones that fail.
+func checkRegexps(field string, r config.Regexps) []finding {
+	var findings []finding
+	check := func(kind string, patterns []string) {
+		for _, pattern := range patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				findings = append(findings, finding{
+					severity: severityError,
+					check:    field + "." + kind,
+					message:  fmt.Sprintf("invalid regex pattern %q: %v", pattern, err),
+					hint:     "patterns use Go RE2 syntax: https://pkg.go.dev/regexp/syntax",
+				})
+			}
+		}
+	}
+	check("only", r.Only)
+	check("omit", r.Omit)
+	if len(findings) == 0 {
+		return []finding{{severity: severityOK, check: field, message: "all patterns compile"}}
+	}
+	return findings
+}
+
+// checkTemplate resolves, parses, and renders the template against sample variables.
+func checkTemplate(cfg *config.Config) []finding {
+	content, err := cfg.Template.Content()
+	if err != nil {
+		hint := "set template to an inline string, {file: path}, or {preset: name}"
+		if cfg.Template.File != "" {
+			hint = "template.file is resolved relative to the current working directory"
+		}
+		return []finding{{severity: severityError, check: "template", message: err.Error(), hint: hint}}
+	}
+
+	tmpl, err := template.Parse(content)
+	if err != nil {
+		return []finding{{severity: severityError, check: "template", message: err.Error()}}
+	}
+
+	if err := tmpl.Validate(); err != nil {
+		return []finding{{severity: severityError, check: "template", message: err.Error()}}
+	}
+
+	return []finding{{severity: severityOK, check: "template", message: "renders valid Go statements"}}
+}
+
+// checkImports verifies that every configured import resolves in the current module.
+func checkImports(imports []config.Import) []finding {
+	if len(imports) == 0 {
+		return []finding{{severity: severityOK, check: "imports", message: "no imports configured"}}
+	}
+
+	paths := make([]string, 0, len(imports))
+	for _, imp := range imports {
+		paths = append(paths, imp.Path)
+	}
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName}, paths...)
+	if err != nil {
+		return []finding{{severity: severityError, check: "imports", message: err.Error()}}
+	}
+
+	var findings []finding
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) == 0 {
+			continue
+		}
+		findings = append(findings, finding{
+			severity: severityError,
+			check:    "imports",
+			message:  fmt.Sprintf("%s: %v", pkg.PkgPath, pkg.Errors[0]),
+			hint:     "run `go get " + pkg.PkgPath + "` in your module",
+		})
+	}
+	if len(findings) == 0 {
+		return []finding{{severity: severityOK, check: "imports", message: fmt.Sprintf("%d import(s) resolve", len(imports))}}
+	}
+	return findings
+}
+
+// printFindings prints findings in a checklist format.
+func printFindings(findings []finding) {
+	for _, f := range findings {
+		var mark, color string
+		switch f.severity {
+		case severityOK:
+			mark, color = "✓", internal.ColorGreen
+		case severityWarning:
+			mark, color = "!", internal.ColorYellow
+		default:
+			mark, color = "✗", internal.ColorRed
+		}
+		fmt.Printf("  %s%s%s %s: %s\n", co(color), mark, co(internal.ColorReset), f.check, f.message)
+		if f.hint != "" {
+			fmt.Printf("    %s%s%s\n", co(internal.ColorDim), f.hint, co(internal.ColorReset))
+		}
+	}
+}
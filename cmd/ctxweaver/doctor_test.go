@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	tests := map[string]struct {
+		config     string
+		wantErrors []string
+	}{
+		"valid config": {
+			config: `template: "defer trace({{.Ctx}}, {{.FuncName | quote}})"
+packages:
+  patterns:
+    - ./...
+`,
+		},
+		"schema violation": {
+			config: `template: "defer trace({{.Ctx}})"
+unknown: true
+`,
+			wantErrors: []string{"config"},
+		},
+		"invalid regexps": {
+			config: `template: "defer trace({{.Ctx}})"
+packages:
+  patterns: [./...]
+  regexps:
+    omit: ["[invalid"]
+functions:
+  regexps:
+    only: ["(unclosed"]
+`,
+			wantErrors: []string{"packages.regexps.omit", "functions.regexps.only"},
+		},
+		"unknown template field": {
+			config: `template: "defer trace({{.FunName}})"
+packages:
+  patterns: [./...]
+`,
+			wantErrors: []string{"template"},
+		},
+		"template renders invalid Go": {
+			config: `template: "defer trace({{.Ctx}}"
+packages:
+  patterns: [./...]
+`,
+			wantErrors: []string{"template"},
+		},
+		"missing template file": {
+			config: `template:
+  file: nonexistent.tmpl
+packages:
+  patterns: [./...]
+`,
+			wantErrors: []string{"template"},
+		},
+		"no carriers is only a warning": {
+			config: `template: "defer trace({{.Ctx}})"
+packages:
+  patterns: [./...]
+carriers:
+  custom: []
+  default: false
+`,
+		},
+		"unresolvable import": {
+			config: `template: "defer trace({{.Ctx}})"
+imports:
+  - example.invalid/does/not/exist
+packages:
+  patterns: [./...]
+`,
+			wantErrors: []string{"imports"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+			if err := os.WriteFile(configPath, []byte(tt.config), 0o644); err != nil {
+				t.Fatalf("failed to write config: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+				t.Fatalf("failed to write go.mod: %v", err)
+			}
+
+			oldWd, _ := os.Getwd()
+			_ = os.Chdir(tmpDir)
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			var gotErrors []string
+			for _, f := range diagnose(configPath) {
+				if f.severity == severityError {
+					gotErrors = append(gotErrors, f.check)
+				}
+			}
+
+			if strings.Join(gotErrors, ",") != strings.Join(tt.wantErrors, ",") {
+				t.Errorf("error checks = %v, want %v", gotErrors, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestRun_Doctor(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+	config := `template: "defer trace({{.Ctx}})"
+imports: []
+packages:
+  patterns: [./...]
+`
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	t.Run("healthy config", func(t *testing.T) {
+		os.Args = []string{"ctxweaver", "doctor", "-config", configPath}
+		if err := run(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("missing config", func(t *testing.T) {
+		os.Args = []string{"ctxweaver", "doctor", "-config", filepath.Join(tmpDir, "missing.yaml")}
+		err := run()
+		if err == nil {
+			t.Fatal("expected error for missing config")
+		}
+		if !strings.Contains(err.Error(), "doctor found 1 problem(s)") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
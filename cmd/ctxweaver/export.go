@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+// exportJSON is the JSON output of the export subcommand.
+type exportJSON struct {
+	Labels    labelsFlag             `json:"labels,omitempty"`
+	Functions []processor.FuncExport `json:"functions"`
+}
+
+// runExport lists every eligible function with its instrumentation state, for
+// audits and dashboards. No file is modified and hooks are not run.
+func runExport(args []string) error {
+	opts := parseFlags(args)
+	if opts.format != "text" && opts.format != "json" {
+		return fmt.Errorf("unknown format %q: use text or json", opts.format)
+	}
+
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	patterns, err := getPatterns(cfg)
+	if err != nil {
+		return err
+	}
+
+	if opts.baseline, err = resolveBaseline(opts, patterns, cfg.Test); err != nil {
+		return err
+	}
+
+	tmplContent, err := cfg.Template.Content()
+	if err != nil {
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+	tmpl, err := parseTemplate(tmplContent)
+	if err != nil {
+		return err
+	}
+
+	proc, err := createProcessor(cfg, tmpl, opts)
+	if err != nil {
+		return err
+	}
+
+	result, err := proc.Export(patterns)
+	if err != nil {
+		return err
+	}
+
+	// File paths relative to the working directory, as in the other reports
+	out := exportJSON{Labels: opts.labels, Functions: make([]processor.FuncExport, 0, len(result.Funcs))}
+	for _, fe := range result.Funcs {
+		fe.File = relPath(fe.File)
+		out.Functions = append(out.Functions, fe)
+	}
+
+	if opts.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	} else if !opts.silent {
+		printExport(out.Functions)
+	}
+
+	if len(result.Errors) > 0 {
+		fmt.Fprintln(os.Stderr, "Errors:")
+		for _, e := range result.Errors {
+			fmt.Fprintf(os.Stderr, "  %v\n", e)
+		}
+		return fmt.Errorf("%d error(s) occurred", len(result.Errors))
+	}
+	return nil
+}
+
+// printExport prints the functions as an aligned table.
+func printExport(funcs []processor.FuncExport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATE\tLOCATION\tFUNC\tCARRIER")
+	for _, fe := range funcs {
+		state := fe.State
+		if fe.Baseline {
+			state += " (baseline)"
+		}
+		fmt.Fprintf(w, "%s\t%s:%d\t%s.%s\t%s\n", state, fe.File, fe.Line, fe.Package, fe.Func, fe.Carrier)
+	}
+	_ = w.Flush()
+}
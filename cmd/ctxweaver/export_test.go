@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+func TestRun_Export(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+	config := `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`
+	files := map[string]string{
+		"ctxweaver.yaml": config,
+		"go.mod":         "module test\n\ngo 1.21\n",
+		"test.go": `package test
+
+import "context"
+
+func trace(context.Context) {}
+
+type Service struct{}
+
+func (s *Service) Foo(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func Bar(ctx context.Context) {
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	t.Run("json output", func(t *testing.T) {
+		setup("export", "-config", configPath, "-format", "json")
+		out := captureStdout(t, func() {
+			if err := run(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		var got exportJSON
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatalf("invalid JSON output: %v\n%s", err, out)
+		}
+		want := []processor.FuncExport{
+			{Package: "test", File: "test.go", Line: 9, Func: "(*Service).Foo", Receiver: "*Service", Carrier: "context.Context", State: processor.StateInstrumented},
+			{Package: "test", File: "test.go", Line: 13, Func: "Bar", Carrier: "context.Context", State: processor.StateMissing},
+		}
+		if diff := cmp.Diff(want, got.Functions); diff != "" {
+			t.Errorf("Functions mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("json output with labels", func(t *testing.T) {
+		setup("export", "-config", configPath, "-format", "json", "-label", "team=payments", "-label", "wave=2")
+		out := captureStdout(t, func() {
+			if err := run(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+
+		var got exportJSON
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatalf("invalid JSON output: %v\n%s", err, out)
+		}
+		if diff := cmp.Diff(labelsFlag{"team": "payments", "wave": "2"}, got.Labels); diff != "" {
+			t.Errorf("Labels mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("text output", func(t *testing.T) {
+		setup("export", "-config", configPath)
+		out := captureStdout(t, func() {
+			if err := run(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+		for _, want := range []string{"instrumented  test.go:9   test.(*Service).Foo", "missing       test.go:13  test.Bar"} {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("output does not contain %q:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		setup("export", "-config", configPath, "-format", "github")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), `unknown format "github"`) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
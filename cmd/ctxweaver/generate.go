@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// runGenerate weaves the package of the current directory when run by
+// go generate, e.g. from a //go:generate ctxweaver generate directive.
+// The config file is looked up from the package directory upwards to the
+// module root, and the weave runs from its directory as a normal run would.
+// With -file, only the file containing the directive ($GOFILE) is woven.
+func runGenerate(args []string) error {
+	fileOnly := flag.Bool("file", false, "only weave the file containing the //go:generate directive ($GOFILE)")
+	opts := parseFlags(args)
+	if flag.NArg() > 0 {
+		return fmt.Errorf("generate takes no patterns: it weaves the package of the current directory")
+	}
+	if opts.funcKey != "" || opts.line != "" {
+		return fmt.Errorf("generate cannot be combined with -func or -line")
+	}
+	if os.Getenv("GOPACKAGE") == "" {
+		return fmt.Errorf("generate must be run by go generate (GOPACKAGE is not set)")
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	if *fileOnly {
+		gofile := os.Getenv("GOFILE")
+		if gofile == "" {
+			return fmt.Errorf("-file requires GOFILE to be set by go generate")
+		}
+		opts.file = filepath.Join(dir, gofile)
+	}
+
+	// Run from the directory of the config file, where its relative paths
+	// (template files, hooks, scaffolds) are resolved as in a normal run
+	configFile := opts.configFile
+	if !isFlagPassed("config") {
+		configFile = findConfig(dir, opts.configFile)
+	}
+	if configFile != "" {
+		abs, err := filepath.Abs(configFile)
+		if err != nil {
+			return err
+		}
+		if err := os.Chdir(filepath.Dir(abs)); err != nil {
+			return fmt.Errorf("failed to change to the config directory: %w", err)
+		}
+		opts.configFile = abs
+	}
+
+	if opts.file == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get working directory: %w", err)
+		}
+		rel, err := filepath.Rel(wd, dir)
+		if err != nil {
+			return err
+		}
+		pattern := "."
+		if rel != "." {
+			pattern = "./" + filepath.ToSlash(rel)
+		}
+		opts.patterns = []string{pattern}
+	}
+	return weave(opts)
+}
+
+// findConfig returns the path of the config file named name in dir or its
+// parent directories, up to the module root (the nearest directory containing
+// go.mod). Returns an empty string if there is none.
+func findConfig(dir, name string) string {
+	for {
+		if path := filepath.Join(dir, name); fileExists(path) {
+			return path
+		}
+		if fileExists(filepath.Join(dir, "go.mod")) {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
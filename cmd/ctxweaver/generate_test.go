@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun_Generate(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	const src = `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`
+	write := func(t *testing.T, files map[string]string) string {
+		t.Helper()
+		tmpDir := t.TempDir()
+		for name, content := range files {
+			path := filepath.Join(tmpDir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatalf("failed to create directory: %v", err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+				t.Fatalf("failed to write %s: %v", name, err)
+			}
+		}
+		return tmpDir
+	}
+	files := map[string]string{
+		"ctxweaver.yaml": `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`,
+		"go.mod":             "module test\n\ngo 1.21\n",
+		"service/service.go": "//go:generate ctxweaver generate\n\n" + src,
+		"service/other.go":   strings.Replace(src, "Foo", "Bar", 1),
+		"other/other.go":     strings.Replace(src, "package service", "package other", 1),
+	}
+
+	tests := map[string]struct {
+		args     []string
+		gofile   string
+		woven    []string
+		notWoven []string
+	}{
+		"package of the directory is woven": {
+			args:     []string{"generate", "-silent"},
+			woven:    []string{"service/service.go", "service/other.go"},
+			notWoven: []string{"other/other.go"},
+		},
+		"file only": {
+			args:     []string{"generate", "-silent", "-file"},
+			gofile:   "service.go",
+			woven:    []string{"service/service.go"},
+			notWoven: []string{"service/other.go", "other/other.go"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := write(t, files)
+			t.Setenv("GOPACKAGE", "service")
+			t.Setenv("GOFILE", tt.gofile)
+
+			oldWd, _ := os.Getwd()
+			_ = os.Chdir(filepath.Join(tmpDir, "service"))
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			setup(tt.args...)
+			if err := run(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, name := range tt.woven {
+				content, _ := os.ReadFile(filepath.Join(tmpDir, name))
+				if !strings.Contains(string(content), "defer trace(ctx)") {
+					t.Errorf("%s should be woven:\n%s", name, content)
+				}
+			}
+			for _, name := range tt.notWoven {
+				content, _ := os.ReadFile(filepath.Join(tmpDir, name))
+				if strings.Contains(string(content), "defer trace(ctx)") {
+					t.Errorf("%s should not be woven:\n%s", name, content)
+				}
+			}
+		})
+	}
+
+	t.Run("outside go generate", func(t *testing.T) {
+		t.Setenv("GOPACKAGE", "")
+		setup("generate")
+		if err := run(); err == nil || !strings.Contains(err.Error(), "must be run by go generate") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("patterns are rejected", func(t *testing.T) {
+		t.Setenv("GOPACKAGE", "service")
+		setup("generate", "./...")
+		if err := run(); err == nil || !strings.Contains(err.Error(), "takes no patterns") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestFindConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"ctxweaver.yaml", "mod/go.mod", "mod/pkg/sub/.keep"} {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	// The lookup stops at the module root
+	if got := findConfig(filepath.Join(tmpDir, "mod", "pkg", "sub"), "ctxweaver.yaml"); got != "" {
+		t.Errorf("findConfig() = %q, want none below the module root", got)
+	}
+
+	if err := os.WriteFile(filepath.Join(tmpDir, "mod", "ctxweaver.yaml"), nil, 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	want := filepath.Join(tmpDir, "mod", "ctxweaver.yaml")
+	if got := findConfig(filepath.Join(tmpDir, "mod", "pkg", "sub"), "ctxweaver.yaml"); got != want {
+		t.Errorf("findConfig() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mpyw/ctxweaver/internal"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+// confirmPrompt returns the processor.ConfirmFunc of -interactive: every
+// function about to be modified is shown on out with its statements, and the
+// answer is read from in: y(es), n(o) (the default), a(ll) to modify the
+// remaining functions without asking, or q(uit) to leave them alone. The end
+// of in is taken as q.
+func confirmPrompt(in io.Reader, out io.Writer) processor.ConfirmFunc {
+	r := bufio.NewReader(in)
+	var all, quit bool
+	return func(c processor.Confirmation) bool {
+		switch {
+		case all:
+			return true
+		case quit:
+			return false
+		}
+
+		fmt.Fprintf(out, "\n%s%s%s %s(%s)%s\n", ce(internal.ColorCyan), c.Package, ce(internal.ColorReset), ce(internal.ColorDim), c.File, ce(internal.ColorReset))
+		fmt.Fprintf(out, "  %s\n", c.Header)
+		sign, color := "+", internal.ColorGreen
+		if c.Op == "remove" {
+			sign, color = "-", internal.ColorRed
+		}
+		for line := range strings.Lines(c.Statement) {
+			fmt.Fprintf(out, "  %s%s \t%s%s\n", ce(color), sign, strings.TrimRight(line, "\n"), ce(internal.ColorReset))
+		}
+
+		for {
+			fmt.Fprintf(out, "%s %s? [y/N/a/q] ", c.Op, c.Func)
+			line, err := r.ReadString('\n')
+			answer := strings.ToLower(strings.TrimSpace(line))
+			if err != nil && answer == "" {
+				fmt.Fprintln(out)
+				quit = true
+				return false
+			}
+			switch answer {
+			case "y", "yes":
+				return true
+			case "", "n", "no":
+				return false
+			case "a", "all":
+				all = true
+				return true
+			case "q", "quit":
+				quit = true
+				return false
+			}
+		}
+	}
+}
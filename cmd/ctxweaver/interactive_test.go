@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+func TestConfirmPrompt(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		input string
+		want  []bool
+	}{
+		"yes and no": {
+			input: "y\nn\nyes\n\n",
+			want:  []bool{true, false, true, false},
+		},
+		"all": {
+			input: "n\na\n",
+			want:  []bool{false, true, true, true},
+		},
+		"quit": {
+			input: "y\nq\ny\n",
+			want:  []bool{true, false, false, false},
+		},
+		"invalid answers are asked again": {
+			input: "maybe\nY\n",
+			want:  []bool{true, false},
+		},
+		"end of input": {
+			input: "y",
+			want:  []bool{true, false, false},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var out bytes.Buffer
+			confirm := confirmPrompt(strings.NewReader(tt.input), &out)
+			var got []bool
+			for range tt.want {
+				got = append(got, confirm(processor.Confirmation{
+					Package:   "example.com/app/service",
+					File:      "service/service.go",
+					Func:      "(*Service).Get",
+					Header:    "func (s *Service) Get(ctx context.Context) error",
+					Op:        "insert",
+					Statement: "defer trace(ctx)",
+				}))
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("answers mismatch (-want +got):\n%s", diff)
+			}
+			for _, s := range []string{"func (s *Service) Get(ctx context.Context) error", "+ \tdefer trace(ctx)", "insert (*Service).Get? [y/N/a/q]"} {
+				if !strings.Contains(out.String(), s) {
+					t.Errorf("output should contain %q:\n%s", s, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mpyw/ctxweaver/internal/lsp"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+// runLSP serves the Language Server Protocol on stdin and stdout, offering
+// diagnostics for functions needing changes and code actions weaving or
+// removing the generated statements of a function. Hooks are not run.
+func runLSP(args []string) error {
+	opts := parseFlags(args)
+	if opts.dryRun || opts.remove || opts.output != "" || opts.verify {
+		return fmt.Errorf("lsp cannot be combined with -dry-run, -remove, -output or -verify")
+	}
+	// Stdout carries the protocol
+	opts.verbose = false
+	opts.quiet = true
+
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	if opts.baseline, err = resolveBaseline(opts, cfg.Packages.Patterns, cfg.Test); err != nil {
+		return err
+	}
+
+	tmplContent, err := cfg.Template.Content()
+	if err != nil {
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+	tmpl, err := parseTemplate(tmplContent)
+	if err != nil {
+		return err
+	}
+
+	// Validate the options once; processors are then created per request
+	if _, err := createProcessor(cfg, tmpl, opts); err != nil {
+		return err
+	}
+	server := lsp.NewServer(func(extra ...processor.Option) *processor.Processor {
+		proc, _ := createProcessor(cfg, tmpl, opts, extra...)
+		return proc
+	})
+	return server.Serve(os.Stdin, os.Stdout)
+}
@@ -2,15 +2,27 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"go/token"
+	"io/fs"
+	"maps"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime/pprof"
+	"slices"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/mpyw/ctxweaver/internal"
 	"github.com/mpyw/ctxweaver/pkg/config"
 	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/scaffold"
 	"github.com/mpyw/ctxweaver/pkg/template"
 )
 
@@ -20,13 +32,168 @@ func ce(color string) string { return internal.StderrColor(color) }
 
 // options holds the parsed command-line flags.
 type options struct {
-	configFile string
-	dryRun     bool
-	verbose    bool
-	silent     bool
-	test       bool
-	remove     bool
-	noHooks    bool
+	configFile    string
+	dryRun        bool
+	verbose       bool
+	silent        bool
+	quiet         bool
+	test          bool
+	remove        bool
+	noHooks       bool
+	failFast      bool
+	allowExternal bool
+	dedupe        bool
+	toMarker      bool
+	verify        bool
+	rollback      bool
+	check         bool
+	detectDrift   bool
+	tidy          bool
+	interactive   bool
+	renames       map[string]string // Variables renamed by the template (old to new)
+	addParam      bool              // Add a ctx parameter to functions without carrier (set by refactor add-param)
+	output        string            // With dry run, directory receiving a patch file per modified file
+	outputDir     string            // Directory receiving a copy of the processed files instead of modifying them in place
+	format        string            // Output format of check (text or github), coverage, export and propagation (text or json)
+	overlay       string            // JSON file replacing the contents of files, as for go build -overlay
+	funcKey       string            // Only weave this function, as pkg/path.Func or pkg/path.Type.Method
+	line          string            // Only weave the function spanning this line, as file.go:123
+	file          string            // Only weave this file (set by generate)
+	patterns      []string          // Patterns overriding the arguments and config (set by generate)
+	profile       string            // File receiving a CPU profile of the run
+	batchSize     int               // Packages loaded at a time; all at once if zero
+	labels        labelsFlag        // Labels of the run, recorded in JSON reports and passed to hooks
+	sample        sampleFlag        // Percentage of the functions receiving insertions; 0 for all
+	runID         string            // Run recorded by the generated markers, or undone with -remove
+	requireClean  requireCleanFlag  // How files with uncommitted changes are handled
+
+	// Config overrides
+	template     string
+	templateFile string
+	imports      stringsFlag
+
+	// Only weave new functions
+	baselineFile string
+	since        string
+	baseline     *processor.Baseline // Resolved from baselineFile or since
+}
+
+// stringsFlag is a flag.Value collecting the values of a repeatable flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// labelKey matches the keys of run labels, which become environment variable names.
+var labelKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// labelsFlag is a flag.Value collecting the key=value labels of a repeatable flag.
+type labelsFlag map[string]string
+
+func (f labelsFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for _, k := range slices.Sorted(maps.Keys(f)) {
+		pairs = append(pairs, k+"="+f[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (f labelsFlag) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok || !labelKey.MatchString(k) {
+		return fmt.Errorf("label %q must be key=value with a key of letters, digits and underscores", v)
+	}
+	if strings.Contains(val, ",") {
+		// The comma separates the labels in CTXWEAVER_LABELS
+		return fmt.Errorf("label %q must not contain a comma in its value", v)
+	}
+	for existing := range f {
+		// Both would be passed to hooks as the same CTXWEAVER_LABEL_<KEY>
+		if existing != k && strings.EqualFold(existing, k) {
+			return fmt.Errorf("label key %q collides with %q: keys must differ in more than case", k, existing)
+		}
+	}
+	f[k] = val
+	return nil
+}
+
+// env returns the labels as environment variables for hooks:
+// CTXWEAVER_LABELS with all of them as key=value,... and CTXWEAVER_LABEL_<KEY>
+// for each, with the key in upper case.
+func (f labelsFlag) env() []string {
+	if len(f) == 0 {
+		return nil
+	}
+	env := []string{"CTXWEAVER_LABELS=" + f.String()}
+	for _, k := range slices.Sorted(maps.Keys(f)) {
+		env = append(env, "CTXWEAVER_LABEL_"+strings.ToUpper(k)+"="+f[k])
+	}
+	return env
+}
+
+// sampleFlag is a flag.Value holding a percentage, as 10% or 10.
+type sampleFlag float64
+
+func (f *sampleFlag) String() string {
+	if *f == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*f), 'f', -1, 64) + "%"
+}
+
+func (f *sampleFlag) Set(v string) error {
+	n, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+	if err != nil || n <= 0 || n > 100 {
+		return fmt.Errorf("sample %q must be a percentage greater than 0 and at most 100, e.g. 10%%", v)
+	}
+	*f = sampleFlag(n)
+	return nil
+}
+
+// requireCleanFlag is a flag.Value holding a processor.RequireClean: a boolean
+// flag refusing files with uncommitted changes, also accepting warn.
+type requireCleanFlag processor.RequireClean
+
+func (f *requireCleanFlag) String() string { return string(*f) }
+
+func (f *requireCleanFlag) IsBoolFlag() bool { return true }
+
+func (f *requireCleanFlag) Set(v string) error {
+	switch v {
+	case "true", string(processor.RequireCleanRefuse):
+		*f = requireCleanFlag(processor.RequireCleanRefuse)
+	case string(processor.RequireCleanWarn):
+		*f = requireCleanFlag(processor.RequireCleanWarn)
+	case "false":
+		*f = requireCleanFlag(processor.RequireCleanOff)
+	default:
+		return fmt.Errorf("require-clean %q must be true, false, refuse or warn", v)
+	}
+	return nil
+}
+
+// subcommands maps subcommand names to their entry points.
+// Any other first argument is treated as the default weave command.
+var subcommands = map[string]func(args []string) error{
+	"baseline":    runBaseline,
+	"check":       runCheck,
+	"coverage":    runCoverage,
+	"dedupe":      runDedupe,
+	"doctor":      runDoctor,
+	"export":      runExport,
+	"generate":    runGenerate,
+	"lsp":         runLSP,
+	"migrate":     runMigrate,
+	"propagation": runPropagation,
+	"refactor":    runRefactor,
+	"schema":      runSchema,
+	"self-update": runSelfUpdate,
+	"serve":       runServe,
+	"undo":        runUndo,
 }
 
 func main() {
@@ -37,19 +204,110 @@ func main() {
 }
 
 // parseFlags parses command-line flags and returns the options.
-func parseFlags() *options {
-	opts := &options{}
+func parseFlags(args []string) *options {
+	opts := &options{labels: labelsFlag{}}
 	flag.StringVar(&opts.configFile, "config", "ctxweaver.yaml", "path to configuration file")
 	flag.BoolVar(&opts.dryRun, "dry-run", false, "print changes without writing files")
-	flag.BoolVar(&opts.verbose, "verbose", false, "print processed files")
+	flag.StringVar(&opts.output, "output", "", "with -dry-run, write a .patch file per modified file into this directory")
+	flag.StringVar(&opts.outputDir, "output-dir", "", "write the processed files into this directory, at their path relative to the working directory, instead of modifying them in place")
+	flag.BoolVar(&opts.verbose, "verbose", false, "print every changed function with its location")
 	flag.BoolVar(&opts.silent, "silent", false, "suppress all output except errors")
+	flag.BoolVar(&opts.quiet, "quiet", false, "only print the final counts, without progress and per-package summary")
 	flag.BoolVar(&opts.test, "test", false, "process test files")
+	flag.StringVar(&opts.overlay, "overlay", "", "JSON file replacing the contents of files, in the format of go build -overlay (e.g. unsaved editor buffers)")
+	flag.StringVar(&opts.format, "format", "text", "output format: text or json, or text or github for check")
 	flag.BoolVar(&opts.remove, "remove", false, "remove generated statements instead of adding them")
 	flag.BoolVar(&opts.noHooks, "no-hooks", false, "skip pre/post hooks")
-	flag.Parse()
+	flag.BoolVar(&opts.failFast, "fail-fast", false, "stop at the first package or file error instead of processing the others")
+	flag.BoolVar(&opts.allowExternal, "allow-external", false, "allow modifying files outside the main modules (dependencies, symbolic links out of the module)")
+	flag.BoolVar(&opts.detectDrift, "detect-drift", false, "report statements generated from an outdated template without modifying anything (matching: marker only)")
+	flag.BoolVar(&opts.verify, "verify", false, "type-check modified packages after writing")
+	flag.BoolVar(&opts.rollback, "rollback", false, "with -verify, restore the files of packages that fail to type-check")
+	flag.BoolVar(&opts.interactive, "interactive", false, "ask before modifying every function, showing its statements: y(es), n(o), a(ll) or q(uit)")
+	flag.BoolVar(&opts.tidy, "tidy", false, "run go mod tidy in the modules given new dependencies by the inserted imports")
+	flag.StringVar(&opts.template, "template", "", "inline template overriding the config template")
+	flag.StringVar(&opts.templateFile, "template-file", "", "template file overriding the config template")
+	flag.Var(&opts.imports, "import", "import overriding the config imports, as path or alias=path (repeatable)")
+	flag.StringVar(&opts.baselineFile, "baseline", "", "only insert into functions not recorded in this baseline file")
+	flag.StringVar(&opts.since, "since", "", "only insert into functions added since this git revision")
+	flag.StringVar(&opts.funcKey, "func", "", "only weave this function, as pkg/path.Func or pkg/path.Type.Method")
+	flag.StringVar(&opts.line, "line", "", "only weave the function spanning this line, as file.go:123")
+	flag.StringVar(&opts.profile, "profile", "", "write a CPU profile of the run to this file, for go tool pprof")
+	flag.IntVar(&opts.batchSize, "batch-size", 0, "load at most this many packages at a time, to bound memory on large repositories (0: all at once)")
+	flag.Var(&opts.sample, "sample", "only insert into this percentage of the functions, sampled by a hash of their name (e.g. 10%)")
+	flag.StringVar(&opts.runID, "run", "", "record this run identifier in the generated markers (matching: marker only), so that ctxweaver undo -run removes the statements of the run")
+	flag.Var(&opts.requireClean, "require-clean", "refuse to modify files with uncommitted changes in git, or with -require-clean=warn, only warn about them")
+	flag.Var(opts.labels, "label", "label of the run as key=value, recorded in JSON reports and passed to hooks as CTXWEAVER_LABEL_<KEY> (repeatable)")
+	_ = flag.CommandLine.Parse(args) // flag.CommandLine exits on error
 	return opts
 }
 
+// startProfile starts writing a CPU profile to filename, for go tool pprof.
+// The returned function stops it and closes the file.
+func startProfile(filename string) (func(), error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create profile: %w", err)
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to start profile: %w", err)
+	}
+	return func() {
+		pprof.StopCPUProfile()
+		_ = f.Close()
+	}, nil
+}
+
+// loadConfig loads the configuration file and applies the command-line overrides.
+// When a template is given on the command line, the default config file may be absent.
+func loadConfig(opts *options) (*config.Config, error) {
+	if opts.template != "" && opts.templateFile != "" {
+		return nil, fmt.Errorf("-template and -template-file are mutually exclusive")
+	}
+
+	var cfg *config.Config
+	var err error
+	switch {
+	case opts.template != "":
+		cfg, err = config.LoadConfigWithTemplate(opts.configFile, config.Template{Inline: opts.template}, checkTemplates)
+	case opts.templateFile != "":
+		cfg, err = config.LoadConfigWithTemplate(opts.configFile, config.Template{File: opts.templateFile}, checkTemplates)
+	default:
+		cfg, err = config.LoadConfig(opts.configFile, checkTemplates)
+	}
+	if err != nil {
+		hasTemplate := opts.template != "" || opts.templateFile != ""
+		if !hasTemplate || isFlagPassed("config") || !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to load config: %w", err)
+		}
+		cfg = &config.Config{}
+		cfg.SetDefaults()
+	}
+	if err := cfg.CheckMinVersion(binaryVersion()); err != nil {
+		return nil, err
+	}
+
+	if isFlagPassed("test") {
+		cfg.Test = opts.test
+	}
+	if opts.template != "" {
+		cfg.Template = config.Template{Inline: opts.template}
+	}
+	if opts.templateFile != "" {
+		cfg.Template = config.Template{File: opts.templateFile}
+	}
+	if len(opts.imports) > 0 {
+		for _, s := range opts.imports {
+			if imp := config.ParseImport(s); imp.Path == "" || imp.Alias != "" && !token.IsIdentifier(imp.Alias) {
+				return nil, fmt.Errorf("invalid -import %q: want path or alias=path", s)
+			}
+		}
+		cfg.Imports = opts.imports
+	}
+	return cfg, nil
+}
+
 // getPatterns returns the package patterns from CLI args or config.
 func getPatterns(cfg *config.Config) ([]string, error) {
 	patterns := flag.Args()
@@ -62,104 +320,776 @@ func getPatterns(cfg *config.Config) ([]string, error) {
 	return patterns, nil
 }
 
-// createProcessor creates a new processor with the given configuration.
-func createProcessor(cfg *config.Config, tmpl *template.Template, opts *options) *processor.Processor {
-	registry := config.NewCarrierRegistry(cfg.Carriers.UseDefault())
-	for _, c := range cfg.Carriers.Custom {
-		registry.Register(c)
+// parseTemplate parses the template content and validates its rendered output.
+func parseTemplate(content string) (*template.Template, error) {
+	tmpl, err := template.Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	if err := tmpl.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid template: %w", err)
 	}
-	return processor.New(
-		registry,
-		tmpl,
-		cfg.Imports,
+	return tmpl, nil
+}
+
+// parseEpilogue parses the epilogue template, if any.
+func parseEpilogue(t *config.Template) (*template.Template, error) {
+	if t == nil {
+		return nil, nil
+	}
+	content, err := t.Content()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get epilogue: %w", err)
+	}
+	tmpl, err := parseTemplate(content)
+	if err != nil {
+		return nil, fmt.Errorf("epilogue: %w", err)
+	}
+	return tmpl, nil
+}
+
+// parseHotTemplate parses the template of the hot paths, if any.
+func parseHotTemplate(t *config.Template) (*template.Template, error) {
+	if t == nil {
+		return nil, nil
+	}
+	content, err := t.Content()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hot template: %w", err)
+	}
+	tmpl, err := parseTemplate(content)
+	if err != nil {
+		return nil, fmt.Errorf("hot template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// parseFieldInits parses the value templates of the field initializations.
+func parseFieldInits(fields []config.FieldInit) ([]processor.FieldInit, error) {
+	inits := make([]processor.FieldInit, 0, len(fields))
+	for i, f := range fields {
+		value, err := template.Parse(f.Value)
+		if err != nil {
+			return nil, fmt.Errorf("fields[%d]: failed to parse value: %w", i, err)
+		}
+		inits = append(inits, processor.FieldInit{Type: f.Type, Field: f.Field, Value: value})
+	}
+	return inits, nil
+}
+
+// checkTemplates checks the templates of the loaded configurations.
+var checkTemplates = config.WithTemplateChecker(template.Check)
+
+// specialFuncNames maps the option names of special functions to their
+// processor names.
+var specialFuncNames = map[string]string{
+	"main":      processor.SpecialMain,
+	"init":      processor.SpecialInit,
+	"test_main": processor.SpecialTestMain,
+}
+
+// parseSpecialFuncs parses the templates of the special functions.
+func parseSpecialFuncs(s *config.SpecialFuncs) (map[string]processor.SpecialFunc, error) {
+	funcs := make(map[string]processor.SpecialFunc)
+	for name, sf := range s.All() {
+		def := processor.SpecialFunc{Exclude: sf.Mode == config.SpecialFuncExclude}
+		if sf.Template != nil {
+			content, err := sf.Template.Content()
+			if err != nil {
+				return nil, fmt.Errorf("special_functions.%s: failed to get template: %w", name, err)
+			}
+			if def.Template, err = parseTemplate(content); err != nil {
+				return nil, fmt.Errorf("special_functions.%s: %w", name, err)
+			}
+		}
+		funcs[specialFuncNames[name]] = def
+	}
+	return funcs, nil
+}
+
+// createProcessor creates a new processor with the given configuration,
+// applying the extra options last.
+func createProcessor(cfg *config.Config, tmpl *template.Template, opts *options, extra ...processor.Option) (*processor.Processor, error) {
+	overrides, err := packageOverrides(cfg)
+	if err != nil {
+		return nil, err
+	}
+	epilogue, err := parseEpilogue(cfg.Epilogue)
+	if err != nil {
+		return nil, err
+	}
+	fieldInits, err := parseFieldInits(cfg.Fields)
+	if err != nil {
+		return nil, err
+	}
+	specialFuncs, err := parseSpecialFuncs(&cfg.SpecialFuncs)
+	if err != nil {
+		return nil, err
+	}
+	hotTmpl, err := parseHotTemplate(cfg.HotTemplate)
+	if err != nil {
+		return nil, err
+	}
+	overlay, err := loadOverlay(opts.overlay)
+	if err != nil {
+		return nil, err
+	}
+	procOpts := []processor.Option{
 		processor.WithTest(cfg.Test),
+		processor.WithTestdata(!cfg.Packages.SkipsTestdata()),
 		processor.WithDryRun(opts.dryRun),
+		processor.WithPatchDir(opts.output),
+		processor.WithOutputDir(opts.outputDir),
+		processor.WithBuildTag(cfg.Emit.BuildTag()),
+		processor.WithHotBuildTag(cfg.Emit.HotBuildTag()),
+		processor.WithOverlay(overlay),
 		processor.WithVerbose(opts.verbose && !opts.silent),
+		processor.WithVerify(opts.verify),
+		processor.WithRollback(opts.rollback),
+		processor.WithFailFast(opts.failFast),
+		processor.WithAllowExternal(opts.allowExternal),
 		processor.WithRemove(opts.remove),
+		processor.WithDedupe(opts.dedupe),
+		processor.WithMarkerMigration(opts.toMarker),
+		processor.WithDriftDetection(opts.detectDrift),
+		processor.WithRenames(opts.renames),
+		processor.WithAddParam(opts.addParam),
 		processor.WithPackageRegexps(cfg.Packages.Regexps),
+		processor.WithIncludeGenerated(cfg.Packages.IncludeGenerated),
 		processor.WithFunctions(cfg.Functions),
-	)
+		processor.WithMatching(cfg.Matching),
+		processor.WithMarker(cfg.Marker),
+		processor.WithRunID(opts.runID),
+		processor.WithRequireClean(processor.RequireClean(opts.requireClean)),
+		processor.WithRefresh(cfg.Refresh),
+		processor.WithLoadMode(cfg.Load),
+		processor.WithFormat(cfg.Format.Tool),
+		processor.WithBatchSize(opts.batchSize),
+		processor.WithCtxRewrite(cfg.CtxRewrite),
+		processor.WithBanner(cfg.Banner),
+		processor.WithEpilogue(epilogue),
+		processor.WithFieldInits(fieldInits...),
+		processor.WithConflicts(cfg.Conflicts),
+		processor.WithContextCreators(cfg.ContextCreators == config.ContextCreatorsReport),
+		processor.WithSpecialFuncs(specialFuncs),
+		processor.WithHotTemplate(hotTmpl),
+		processor.WithOverrides(overrides...),
+		processor.WithBaseline(opts.baseline),
+		processor.WithSample(float64(opts.sample)),
+		processor.WithCarrierPriority(cfg.Carriers.Priority),
+		processor.WithProgress(progressPrinter(opts)),
+	}
+	for _, plugin := range cfg.Plugins {
+		procOpts = append(procOpts, processor.WithPlugins(processor.ExecPlugin{Command: plugin.Exec}))
+	}
+	procOpts = append(procOpts, processor.WithImports(config.ParseImports(cfg.Imports)))
+	return processor.New(cfg.Carriers.Registry(), tmpl, nil, append(procOpts, extra...)...), nil
+}
+
+// loadOverlay reads an overlay file in the format of go build -overlay:
+// {"Replace": {"file.go": "replacement.go"}}, where relative paths are
+// relative to the working directory. Returns the replacement contents by
+// absolute file path, or nil if path is empty.
+func loadOverlay(path string) (map[string][]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overlay: %w", err)
+	}
+	var file struct {
+		Replace map[string]string
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse overlay %s: %w", path, err)
+	}
+
+	overlay := make(map[string][]byte, len(file.Replace))
+	for from, to := range file.Replace {
+		if to == "" {
+			return nil, fmt.Errorf("overlay %s: deleting %s is not supported", path, from)
+		}
+		abs, err := filepath.Abs(from)
+		if err != nil {
+			return nil, fmt.Errorf("overlay %s: %w", path, err)
+		}
+		if overlay[abs], err = os.ReadFile(to); err != nil {
+			return nil, fmt.Errorf("overlay %s: failed to read replacement of %s: %w", path, from, err)
+		}
+	}
+	return overlay, nil
+}
+
+// packageOverrides compiles the per-package overrides of the configuration.
+func packageOverrides(cfg *config.Config) ([]processor.PackageOverride, error) {
+	overrides := make([]processor.PackageOverride, 0, len(cfg.Overrides))
+	for i, o := range cfg.Overrides {
+		var po processor.PackageOverride
+		for _, pattern := range o.Packages {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("overrides[%d]: invalid package regexp %q: %w", i, pattern, err)
+			}
+			po.Packages = append(po.Packages, re)
+		}
+		if o.Template != nil {
+			content, err := o.Template.Content()
+			if err != nil {
+				return nil, fmt.Errorf("overrides[%d]: failed to get template: %w", i, err)
+			}
+			if po.Template, err = parseTemplate(content); err != nil {
+				return nil, fmt.Errorf("overrides[%d]: %w", i, err)
+			}
+		}
+		epilogue, err := parseEpilogue(o.Epilogue)
+		if err != nil {
+			return nil, fmt.Errorf("overrides[%d]: %w", i, err)
+		}
+		po.Epilogue = epilogue
+		po.AliasedImports = config.ParseImports(o.Imports)
+		if o.Functions != nil {
+			po.Functions = processor.NewFuncFilter(cfg.Functions.Merge(*o.Functions))
+		}
+		overrides = append(overrides, po)
+	}
+	return overrides, nil
 }
 
 // printHeader prints the ctxweaver execution header.
-func printHeader(patterns []string, remove, silent bool) {
-	if silent {
+func printHeader(patterns []string, opts *options) {
+	if opts.silent {
 		return
 	}
 	action := "weaving"
-	if remove {
+	switch {
+	case opts.remove:
 		action = "removing"
+	case opts.dedupe:
+		action = "deduplicating"
+	case opts.toMarker:
+		action = "migrating"
+	case opts.detectDrift:
+		action = "detecting drift"
+	case len(opts.renames) > 0:
+		action = "renaming"
+	case opts.addParam:
+		action = "adding ctx parameters"
+	case opts.check:
+		action = "checking"
 	}
 	fmt.Printf("%s▶ ctxweaver%s %s%s %s%s\n", co(internal.ColorCyan), co(internal.ColorReset), co(internal.ColorDim), action, strings.Join(patterns, " "), co(internal.ColorReset))
 }
 
+// showProgress reports whether a progress line is drawn on stderr: only on a
+// terminal outside CI, and not when output is reduced or verbose.
+func showProgress(opts *options) bool {
+	return internal.StderrIsInteractive() && !opts.quiet && !opts.silent && !opts.verbose && !opts.interactive
+}
+
+// showDiffs reports whether a dry run prints the diffs of modified files:
+// not when they are written as patches, reported otherwise (check and drift
+// detection) or when output is reduced.
+func showDiffs(opts *options) bool {
+	return opts.dryRun && opts.output == "" && !opts.check && !opts.detectDrift && !opts.quiet && !opts.silent
+}
+
+// printDiffs prints the diffs of the modified files, colored on a terminal.
+// Files shared by a package and its test variant are printed once.
+func printDiffs(result *processor.ProcessResult) {
+	printed := make(map[string]bool)
+	for _, f := range result.ModifiedFiles {
+		if diff, ok := result.Diffs[f]; ok && !printed[f] {
+			printed[f] = true
+			fmt.Print(internal.ColorDiff(string(diff), co))
+		}
+	}
+}
+
+// progressPrinter returns a function redrawing the progress line,
+// or nil if no progress is shown.
+func progressPrinter(opts *options) func(processor.Progress) {
+	if !showProgress(opts) {
+		return nil
+	}
+	return func(p processor.Progress) {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		if p.Packages == 0 {
+			fmt.Fprintf(os.Stderr, "  %sloading packages...%s", ce(internal.ColorDim), ce(internal.ColorReset))
+			return
+		}
+		fmt.Fprintf(os.Stderr, "  %s%d/%d packages, %d files processed%s", ce(internal.ColorDim), p.PackagesDone, p.Packages, p.FilesProcessed, ce(internal.ColorReset))
+	}
+}
+
+// clearProgress erases the progress line, if any.
+func clearProgress(opts *options) {
+	if showProgress(opts) {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+}
+
+// printPackageSummary prints a table of the files processed and modified per package.
+func printPackageSummary(packages []processor.PackageResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  PACKAGE\tFILES\tMODIFIED")
+	for _, pr := range packages {
+		fmt.Fprintf(w, "  %s\t%d\t%d\n", pr.PkgPath, pr.FilesProcessed, pr.FilesModified)
+	}
+	_ = w.Flush()
+}
+
+// printModuleSummary prints a table of the packages and files processed and modified per module.
+func printModuleSummary(modules []processor.ModuleResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  MODULE\tPACKAGES\tFILES\tMODIFIED")
+	for _, m := range modules {
+		path := m.Path
+		if path == "" {
+			path = "(unknown)"
+		}
+		fmt.Fprintf(w, "  %s\t%d\t%d\t%d\n", path, m.Packages, m.FilesProcessed, m.FilesModified)
+	}
+	_ = w.Flush()
+}
+
+// weaveJSON is the JSON output of a weave run.
+type weaveJSON struct {
+	Labels         labelsFlag `json:"labels,omitempty"`
+	FilesProcessed int        `json:"files_processed"`
+	FilesModified  int        `json:"files_modified"`
+	ModifiedFiles  []string   `json:"modified_files"`
+	RolledBack     []string   `json:"rolled_back,omitempty"`
+	Errors         []string   `json:"errors,omitempty"`
+}
+
+// printWeaveJSON prints the processing results as JSON, with files relative
+// to the working directory. Errors are still reported by reportResults.
+func printWeaveJSON(result *processor.ProcessResult, labels labelsFlag) error {
+	out := weaveJSON{
+		Labels:         labels,
+		FilesProcessed: result.FilesProcessed,
+		FilesModified:  result.FilesModified,
+		ModifiedFiles:  make([]string, 0, len(result.ModifiedFiles)),
+	}
+	for _, f := range result.ModifiedFiles {
+		out.ModifiedFiles = append(out.ModifiedFiles, relPath(f))
+	}
+	for _, f := range result.RolledBack {
+		out.RolledBack = append(out.RolledBack, relPath(f))
+	}
+	for _, e := range slices.Concat(result.Errors, result.VerifyErrors) {
+		out.Errors = append(out.Errors, e.Error())
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
 // reportResults prints the processing results and returns an error if there were any.
-func reportResults(result *processor.ProcessResult, verbose, dryRun, silent bool) error {
+// Unless quiet, the counts are preceded by a per-package summary, and a
+// per-module one when several modules were processed (e.g. in a workspace).
+func reportResults(result *processor.ProcessResult, verbose, dryRun, silent, quiet bool) error {
 	if !silent {
+		if !quiet && len(result.Packages) > 0 {
+			printPackageSummary(result.Packages)
+		}
+		if !quiet && len(result.Modules) > 1 {
+			printModuleSummary(result.Modules)
+		}
 		if verbose || dryRun {
 			fmt.Printf("  Files processed: %d\n", result.FilesProcessed)
 			fmt.Printf("  Files modified: %d\n", result.FilesModified)
 		} else {
 			fmt.Printf("  %s✓%s %d files processed, %d modified\n", co(internal.ColorGreen), co(internal.ColorReset), result.FilesProcessed, result.FilesModified)
 		}
+		if result.DuplicatesRemoved > 0 {
+			fmt.Printf("  Duplicates removed: %d\n", result.DuplicatesRemoved)
+		}
+		if len(result.Patches) > 0 {
+			fmt.Printf("  Patches written: %d\n", len(result.Patches))
+		}
+		if len(result.RolledBack) > 0 {
+			fmt.Printf("  %sRolled back: %d files%s\n", co(internal.ColorYellow), len(result.RolledBack), co(internal.ColorReset))
+		}
+	}
+	if !silent && len(result.VetoedFuncs) > 0 {
+		printLeftAlone("Vetoed by plugins", result.VetoedFuncs, verbose)
+	}
+	if !silent && len(result.DeclinedFuncs) > 0 {
+		printLeftAlone("Declined", result.DeclinedFuncs, verbose)
+	}
+	if !silent && len(result.UnsampledFuncs) > 0 {
+		printLeftAlone("Out of the sample", result.UnsampledFuncs, verbose)
+	}
+	if !silent && len(result.ConflictingFuncs) > 0 {
+		printLeftAlone("Manually instrumented", result.ConflictingFuncs, verbose)
+	}
+	if !silent && len(result.ContextCreators) > 0 {
+		// Listed even without -verbose, since reporting them is the point
+		printLeftAlone("Creating their own context (candidates for a context parameter)", result.ContextCreators, true)
+	}
+	if !silent && len(result.ParamSkippedFuncs) > 0 {
+		printLeftAlone("Left without a ctx parameter", result.ParamSkippedFuncs, verbose)
+	}
+	for _, f := range result.DirtyFiles {
+		fmt.Fprintf(os.Stderr, "%swarning:%s modified %s despite its uncommitted changes\n",
+			internal.StderrColor(internal.ColorYellow), internal.StderrColor(internal.ColorReset), relPath(f))
+	}
+	for _, c := range result.NameCollisions {
+		fmt.Fprintf(os.Stderr, "%swarning:%s name %q of %s is taken by %s: renamed to %q\n",
+			internal.StderrColor(internal.ColorYellow), internal.StderrColor(internal.ColorReset), c.Name, c.Func, c.Owner, c.Unique)
 	}
 	if len(result.Errors) > 0 {
 		fmt.Fprintln(os.Stderr, "Errors:")
 		for _, e := range result.Errors {
 			fmt.Fprintf(os.Stderr, "  %v\n", e)
 		}
-		return fmt.Errorf("%d error(s) occurred", len(result.Errors))
+	}
+	if len(result.VerifyErrors) > 0 {
+		fmt.Fprintln(os.Stderr, "Verification errors:")
+		for _, e := range result.VerifyErrors {
+			fmt.Fprintf(os.Stderr, "  %v\n", e)
+		}
+	}
+	if n := len(result.Errors) + len(result.VerifyErrors); n > 0 {
+		return fmt.Errorf("%d error(s) occurred", n)
 	}
 	return nil
 }
 
 func run() error {
-	opts := parseFlags()
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			return cmd(os.Args[2:])
+		}
+	}
+
+	return weave(parseFlags(os.Args[1:]))
+}
+
+// runDedupe runs the weave in dedupe mode: functions containing the generated
+// statements more than once are collapsed into a single up-to-date occurrence.
+func runDedupe(args []string) error {
+	opts := parseFlags(args)
+	if opts.remove {
+		return fmt.Errorf("dedupe cannot be combined with -remove")
+	}
+	opts.dedupe = true
+	return weave(opts)
+}
+
+// runCheck reports the files that a weave would modify without writing them,
+// failing if there are any. Hooks are not run. Combined with -baseline,
+// grandfathered functions are not reported.
+func runCheck(args []string) error {
+	opts := parseFlags(args)
+	if opts.remove {
+		return fmt.Errorf("check cannot be combined with -remove")
+	}
+	if opts.format != "text" && opts.format != "github" {
+		return fmt.Errorf("unknown format %q: use text or github", opts.format)
+	}
+	opts.check = true
+	opts.dryRun = true
+	opts.noHooks = true
+	return weave(opts)
+}
+
+// runMigrate rewrites existing generated statements for a different matching mode.
+// Currently only --to-marker is supported: statements detected by skeleton
+// matching get the //ctxweaver:generated marker appended.
+func runMigrate(args []string) error {
+	toMarker := flag.Bool("to-marker", false, "append the //ctxweaver:generated marker to existing generated statements")
+	opts := parseFlags(args)
+	if !*toMarker {
+		return fmt.Errorf("migrate requires a target mode (--to-marker)")
+	}
+	if opts.remove {
+		return fmt.Errorf("migrate cannot be combined with -remove")
+	}
+	opts.toMarker = true
+	if err := weave(opts); err != nil {
+		return err
+	}
+	if !opts.silent && !opts.dryRun {
+		fmt.Printf("  %sSet `matching: marker` in %s to keep using the markers%s\n", co(internal.ColorDim), opts.configFile, co(internal.ColorReset))
+	}
+	return nil
+}
 
-	cfg, err := config.LoadConfig(opts.configFile)
+// runUndo removes the generated statements whose marker records the run given
+// by -run, with the epilogue of their functions, leaving the statements of the
+// other runs alone (matching: marker only).
+func runUndo(args []string) error {
+	opts := parseFlags(args)
+	if opts.runID == "" {
+		return fmt.Errorf("undo requires -run")
+	}
+	opts.remove = true
+	return weave(opts)
+}
+
+// runRefactor updates existing generated statements after a template change,
+// or with add-param as first argument, runs runAddParam. Only -rename is
+// supported: with the template already using the new variable names,
+// statements binding the old names are rewritten along with the references
+// following them.
+func runRefactor(args []string) error {
+	if len(args) > 0 && args[0] == "add-param" {
+		return runAddParam(args[1:])
+	}
+	var renames stringsFlag
+	flag.Var(&renames, "rename", "rename a variable of the generated statements, as old=new (repeatable)")
+	opts := parseFlags(args)
+	if len(renames) == 0 {
+		return fmt.Errorf("refactor requires at least one -rename old=new")
+	}
+	if opts.remove {
+		return fmt.Errorf("refactor cannot be combined with -remove")
+	}
+
+	opts.renames = make(map[string]string, len(renames))
+	for _, r := range renames {
+		from, to, ok := strings.Cut(r, "=")
+		if !ok || !token.IsIdentifier(from) || !token.IsIdentifier(to) || from == to {
+			return fmt.Errorf("invalid -rename %q: want old=new with two different identifiers", r)
+		}
+		if _, dup := opts.renames[from]; dup {
+			return fmt.Errorf("invalid -rename %q: %s is renamed more than once", r, from)
+		}
+		opts.renames[from] = to
+	}
+	return weave(opts)
+}
+
+// runAddParam gives the functions passing the filters that have no carrier a
+// ctx context.Context first parameter, and passes a context at their call sites.
+func runAddParam(args []string) error {
+	opts := parseFlags(args)
+	if opts.remove || opts.funcKey != "" || opts.line != "" {
+		return fmt.Errorf("refactor add-param cannot be combined with -remove, -func or -line")
+	}
+	if opts.batchSize > 0 {
+		return fmt.Errorf("refactor add-param cannot be combined with -batch-size")
+	}
+	opts.addParam = true
+	return weave(opts)
+}
+
+// scaffoldFiles writes the missing or outdated scaffold files when weaving,
+// and returns their paths.
+func scaffoldFiles(cfg *config.Config, opts *options) ([]string, error) {
+	if len(cfg.Scaffold) == 0 || opts.remove || opts.dedupe || opts.toMarker || opts.detectDrift || len(opts.renames) > 0 || opts.addParam {
+		return nil, nil
+	}
+	files := cfg.Scaffold
+	if opts.outputDir != "" {
+		// Leave the source tree alone, as the processed files
+		files = make([]config.ScaffoldFile, len(cfg.Scaffold))
+		for i, f := range cfg.Scaffold {
+			f.Path = filepath.Join(opts.outputDir, f.Path)
+			files[i] = f
+		}
+	}
+	results, err := scaffold.Apply(files, opts.dryRun)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return nil, err
 	}
 
-	if isFlagPassed("test") {
-		cfg.Test = opts.test
+	var paths []string
+	for _, r := range results {
+		if !r.Changed() {
+			continue
+		}
+		paths = append(paths, r.Path)
+		if !opts.silent && !opts.check {
+			verb := "Scaffolded"
+			if opts.dryRun {
+				verb = "Would scaffold"
+			}
+			fmt.Printf("  %s%s:%s %s\n", co(internal.ColorGreen), verb, co(internal.ColorReset), r.Path)
+		}
+	}
+	return paths, nil
+}
+
+// weave loads the configuration and processes the target packages.
+func weave(opts *options) error {
+	if opts.rollback && !opts.verify {
+		return fmt.Errorf("-rollback requires -verify")
+	}
+	if opts.output != "" && !opts.dryRun {
+		return fmt.Errorf("-output requires -dry-run")
+	}
+	if opts.outputDir != "" && (opts.dryRun || opts.check || opts.detectDrift || opts.verify) {
+		return fmt.Errorf("-output-dir cannot be combined with -dry-run, -detect-drift, -verify or check")
+	}
+	switch {
+	case opts.format == "json" && !opts.check:
+		// The report is the only output, so that stdout can be parsed
+		opts.silent = true
+	case opts.format == "github" && !opts.check:
+		return fmt.Errorf("-format github is only supported by check")
+	case opts.format != "text" && !opts.check:
+		return fmt.Errorf("unknown format %q: use text or json", opts.format)
+	}
+	if opts.sample != 0 && opts.remove {
+		return fmt.Errorf("-sample cannot be combined with -remove")
+	}
+	if opts.runID != "" && len(strings.Fields(opts.runID)) != 1 {
+		return fmt.Errorf("invalid -run %q: must not be empty or contain spaces", opts.runID)
+	}
+	if opts.interactive && (opts.check || opts.detectDrift || opts.silent) {
+		return fmt.Errorf("-interactive cannot be combined with -detect-drift, -silent or check")
+	}
+	if opts.tidy && (opts.dryRun || opts.outputDir != "" || opts.detectDrift) {
+		return fmt.Errorf("-tidy cannot be combined with -dry-run, -output-dir, -detect-drift or check")
+	}
+	if opts.detectDrift {
+		if opts.remove {
+			return fmt.Errorf("-detect-drift cannot be combined with -remove")
+		}
+		opts.dryRun = true
+		opts.noHooks = true
+	}
+
+	if opts.profile != "" {
+		stop, err := startProfile(opts.profile)
+		if err != nil {
+			return err
+		}
+		defer stop()
 	}
 
-	patterns, err := getPatterns(cfg)
+	selection, selectionPattern, err := parseSelection(opts)
 	if err != nil {
 		return err
 	}
 
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	if opts.detectDrift && cfg.Matching != config.MatchingMarker {
+		return fmt.Errorf("-detect-drift requires matching: marker")
+	}
+	if opts.runID != "" && cfg.Matching != config.MatchingMarker {
+		return fmt.Errorf("-run requires matching: marker")
+	}
+	// Variants are regenerated from whole files, so every function is woven in one pass
+	if (cfg.Emit.BuildTag() != "" || cfg.Emit.HotBuildTag() != "") && (opts.output != "" || opts.outputDir != "" || opts.verify || opts.detectDrift ||
+		opts.funcKey != "" || opts.line != "" || opts.dedupe || opts.toMarker || len(opts.renames) > 0 || opts.addParam) {
+		return fmt.Errorf("emit mode %s cannot be combined with -output, -output-dir, -verify, -detect-drift, -func, -line, dedupe, migrate or refactor", cfg.Emit.Mode)
+	}
+
+	// A selected function is looked up in its package unless patterns are given
+	var patterns []string
+	switch {
+	case len(opts.patterns) > 0:
+		patterns = opts.patterns
+	case selection != nil && flag.NArg() == 0:
+		patterns = []string{selectionPattern}
+	default:
+		if patterns, err = getPatterns(cfg); err != nil {
+			return err
+		}
+	}
+
+	if opts.baseline, err = resolveBaseline(opts, patterns, cfg.Test); err != nil {
+		return err
+	}
+
 	tmplContent, err := cfg.Template.Content()
 	if err != nil {
 		return fmt.Errorf("failed to get template: %w", err)
 	}
 
 	if !opts.noHooks && len(cfg.Hooks.Pre) > 0 {
-		if err := runHooks("pre", cfg.Hooks.Pre, opts.silent); err != nil {
+		if err := runHooks("pre", cfg.Hooks.Pre, opts.labels, opts.silent); err != nil {
 			return err
 		}
 	}
 
-	tmpl, err := template.Parse(tmplContent)
+	if err := applyImportsPolicy(cfg, opts); err != nil {
+		return err
+	}
+
+	tmpl, err := parseTemplate(tmplContent)
 	if err != nil {
-		return fmt.Errorf("failed to parse template: %w", err)
+		return err
 	}
 
-	proc := createProcessor(cfg, tmpl, opts)
-	printHeader(patterns, opts.remove, opts.silent)
+	var extra []processor.Option
+	if selection != nil {
+		extra = append(extra, selection)
+	}
+	if showDiffs(opts) {
+		extra = append(extra, processor.WithKeepDiffs(true))
+	}
+	if opts.interactive {
+		extra = append(extra, processor.WithConfirm(confirmPrompt(os.Stdin, os.Stderr)))
+	}
+	proc, err := createProcessor(cfg, tmpl, opts, extra...)
+	if err != nil {
+		return err
+	}
+	printHeader(patterns, opts)
+
+	scaffolded, err := scaffoldFiles(cfg, opts)
+	if err != nil {
+		return err
+	}
 
 	result, err := proc.Process(patterns)
+	clearProgress(opts)
 	if err != nil {
 		return err
 	}
+	switch {
+	case opts.funcKey != "" && !result.Selected:
+		return fmt.Errorf("function %s not found in %s", opts.funcKey, strings.Join(patterns, " "))
+	case opts.line != "" && !result.Selected:
+		return fmt.Errorf("no function found at %s", opts.line)
+	}
 
-	if err := reportResults(result, opts.verbose, opts.dryRun, opts.silent); err != nil {
+	if showDiffs(opts) {
+		printDiffs(result)
+	}
+	if opts.remove && opts.dryRun && !opts.silent {
+		printRemovalReport(result)
+	}
+	if opts.format == "json" && !opts.check {
+		if err := printWeaveJSON(result, opts.labels); err != nil {
+			return err
+		}
+	}
+	if err := reportResults(result, opts.verbose, opts.dryRun, opts.silent, opts.quiet); err != nil {
 		return err
 	}
+	if err := reportDependencies(result, opts); err != nil {
+		return err
+	}
+
+	if opts.detectDrift && len(result.ModifiedFuncs) > 0 {
+		return reportDrift(result)
+	}
+
+	if opts.check && len(scaffolded)+len(result.ModifiedFiles) > 0 {
+		result.ModifiedFiles = append(scaffolded, result.ModifiedFiles...)
+		if opts.format == "github" {
+			printGitHubAnnotations(result)
+		} else {
+			fmt.Fprintln(os.Stderr, "Files needing changes:")
+			for _, f := range result.ModifiedFiles {
+				fmt.Fprintf(os.Stderr, "  %s\n", f)
+			}
+		}
+		return fmt.Errorf("%d file(s) need changes: run ctxweaver to apply them", len(result.ModifiedFiles))
+	}
 
 	if !opts.noHooks && len(cfg.Hooks.Post) > 0 {
-		if err := runHooks("post", cfg.Hooks.Post, opts.silent); err != nil {
+		if err := runHooks("post", cfg.Hooks.Post, opts.labels, opts.silent); err != nil {
 			return err
 		}
 	}
@@ -167,9 +1097,258 @@ func run() error {
 	return nil
 }
 
-// runHooks executes a list of shell commands sequentially.
+// parseSelection returns the processor option selecting the function given by
+// -func or -line (or the file set by generate), and the package pattern of
+// the function; nil without them.
+func parseSelection(opts *options) (processor.Option, string, error) {
+	switch {
+	case opts.funcKey != "" && opts.line != "":
+		return nil, "", fmt.Errorf("-func and -line are mutually exclusive")
+	case opts.file != "":
+		return processor.WithFileSelection(opts.file), "file=" + opts.file, nil
+	case opts.funcKey != "":
+		// The package path ends at the first dot after the last slash
+		slash := strings.LastIndex(opts.funcKey, "/")
+		dot := strings.Index(opts.funcKey[slash+1:], ".")
+		if dot <= 0 || strings.HasSuffix(opts.funcKey, ".") {
+			return nil, "", fmt.Errorf("invalid -func %q: want pkg/path.Func or pkg/path.Type.Method", opts.funcKey)
+		}
+		return processor.WithFuncSelection(opts.funcKey), opts.funcKey[:slash+1+dot], nil
+	case opts.line != "":
+		// The last colon, as Windows paths may contain one
+		i := strings.LastIndex(opts.line, ":")
+		file := opts.line[:max(i, 0)]
+		line, err := strconv.Atoi(opts.line[i+1:])
+		if file == "" || err != nil || line < 1 {
+			return nil, "", fmt.Errorf("invalid -line %q: want file.go:123", opts.line)
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid -line %q: %w", opts.line, err)
+		}
+		return processor.WithSelection(abs, line), "file=" + abs, nil
+	default:
+		return nil, "", nil
+	}
+}
+
+// printRemovalReport lists, per function, the generated statements a remove
+// dry run would remove and those protected by a //ctxweaver:skip directive.
+func printRemovalReport(result *processor.ProcessResult) {
+	for _, section := range []struct {
+		title string
+		funcs []processor.FuncChange
+	}{
+		{"Would remove", result.ModifiedFuncs},
+		{"Protected by //ctxweaver:skip", result.ProtectedFuncs},
+	} {
+		if len(section.funcs) == 0 {
+			continue
+		}
+		fmt.Printf("  %s:\n", section.title)
+		seen := make(map[processor.FuncChange]bool)
+		for _, fc := range section.funcs {
+			if seen[fc] {
+				continue
+			}
+			seen[fc] = true
+			fmt.Printf("    %s:%d %s\n", relPath(fc.File), fc.Line, fc.Func)
+		}
+	}
+}
+
+// printLeftAlone prints the number of functions left alone for the reason
+// described by title, such as a plugin veto, and in verbose mode each of them
+// with its own reason.
+func printLeftAlone(title string, funcs []processor.FuncChange, verbose bool) {
+	seen := make(map[processor.FuncChange]bool)
+	var unique []processor.FuncChange
+	for _, fc := range funcs {
+		if !seen[fc] {
+			seen[fc] = true
+			unique = append(unique, fc)
+		}
+	}
+	fmt.Printf("  %s: %d\n", title, len(unique))
+	if !verbose {
+		return
+	}
+	for _, fc := range unique {
+		fmt.Printf("    %s:%d %s: %s\n", relPath(fc.File), fc.Line, fc.Func, fc.Reason)
+	}
+}
+
+// reportDrift lists the functions whose generated statements come from another
+// version of the template, and returns an error counting them.
+func reportDrift(result *processor.ProcessResult) error {
+	fmt.Fprintln(os.Stderr, "Functions generated from an outdated template:")
+	seen := make(map[processor.FuncChange]bool)
+	for _, fc := range result.ModifiedFuncs {
+		if seen[fc] {
+			continue
+		}
+		seen[fc] = true
+		fmt.Fprintf(os.Stderr, "  %s:%d %s: %s\n", relPath(fc.File), fc.Line, fc.Func, fc.Reason)
+	}
+	return fmt.Errorf("%d function(s) generated from an outdated template: run ctxweaver to update them", len(seen))
+}
+
+// printGitHubAnnotations prints a GitHub Actions warning command for every
+// function needing changes, so that they are annotated in the pull request.
+// Files needing changes outside functions (e.g. a banner) are annotated as a whole.
+// Files shared by a package and its test variant are annotated once.
+func printGitHubAnnotations(result *processor.ProcessResult) {
+	annotated := make(map[string]bool)
+	seen := make(map[processor.FuncChange]bool)
+	for _, fc := range result.ModifiedFuncs {
+		if seen[fc] {
+			continue
+		}
+		seen[fc] = true
+		annotated[fc.File] = true
+		fmt.Printf("::warning file=%s,line=%d::%s\n",
+			githubProperty(relPath(fc.File)), fc.Line, githubMessage("function "+fc.Func+" "+fc.Reason))
+	}
+	for _, f := range result.ModifiedFiles {
+		if !annotated[f] {
+			annotated[f] = true
+			fmt.Printf("::warning file=%s::%s\n", githubProperty(relPath(f)), githubMessage("file needs changes"))
+		}
+	}
+}
+
+// relPath returns filename relative to the working directory, where workflows
+// run from the repository root, or filename itself if it is outside.
+func relPath(filename string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return filename
+	}
+	rel, err := filepath.Rel(wd, filename)
+	if err != nil || !filepath.IsLocal(rel) {
+		return filename
+	}
+	return filepath.ToSlash(rel)
+}
+
+// githubMessage escapes the message of a workflow command.
+func githubMessage(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// githubProperty escapes a property value of a workflow command.
+func githubProperty(s string) string {
+	return strings.NewReplacer(":", "%3A", ",", "%2C").Replace(githubMessage(s))
+}
+
+// applyImportsPolicy resolves the imports of the base and override
+// configurations in the module of the working directory, and applies the
+// imports policy to those the module cannot import: fails with
+// require-existing, or adds their modules with go get with auto. Nothing is
+// checked without a policy or in remove mode, and go get is not run in a dry
+// run.
+func applyImportsPolicy(cfg *config.Config, opts *options) error {
+	if cfg.ImportsPolicy == "" || opts.remove {
+		return nil
+	}
+	var paths []string
+	for _, imp := range config.ParseImports(cfg.Imports) {
+		paths = append(paths, imp.Path)
+	}
+	for _, o := range cfg.Overrides {
+		for _, imp := range config.ParseImports(o.Imports) {
+			paths = append(paths, imp.Path)
+		}
+	}
+	unresolved, err := processor.UnresolvedImports("", paths)
+	if err != nil {
+		return fmt.Errorf("failed to resolve imports: %w", err)
+	}
+	if len(unresolved) == 0 {
+		return nil
+	}
+
+	switch {
+	case cfg.ImportsPolicy == config.ImportsPolicyRequireExisting:
+		return fmt.Errorf("imports not provided by any module required by go.mod: %s (run go get, or set imports_policy: auto)", strings.Join(unresolved, ", "))
+	case opts.dryRun:
+		return nil
+	}
+	if !opts.silent {
+		fmt.Printf("  %s$ go get %s%s\n", co(internal.ColorDim), strings.Join(unresolved, " "), co(internal.ColorReset))
+	}
+	cmd := exec.Command("go", append([]string{"get"}, unresolved...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go get failed: %w", err)
+	}
+	return nil
+}
+
+// reportDependencies prints the imports inserted by the weave and the new
+// module dependencies they introduce, and with -tidy runs go mod tidy in the
+// modules given new dependencies. Nothing is done if no import was inserted.
+func reportDependencies(result *processor.ProcessResult, opts *options) error {
+	if len(result.AddedImports) == 0 || (opts.silent && !opts.tidy) {
+		return nil
+	}
+	missing, err := processor.MissingDependencies(result.AddedImports)
+	if err != nil {
+		return fmt.Errorf("failed to resolve dependencies: %w", err)
+	}
+
+	if !opts.silent {
+		fmt.Printf("  Imports added: %d\n", len(result.AddedImports))
+		if opts.verbose {
+			for _, imp := range result.AddedImports {
+				fmt.Printf("    %s%s%s\n", imp.Path, co(internal.ColorDim), moduleSuffix(imp.Module)+co(internal.ColorReset))
+			}
+		}
+		if len(missing) > 0 {
+			fmt.Printf("  %sNew dependencies: %d%s\n", co(internal.ColorYellow), len(missing), co(internal.ColorReset))
+			for _, imp := range missing {
+				fmt.Printf("    %s%s%s\n", imp.Path, co(internal.ColorDim), moduleSuffix(imp.Module)+co(internal.ColorReset))
+			}
+		}
+	}
+
+	if !opts.tidy {
+		return nil
+	}
+	var tidied []string // Module directories
+	for _, imp := range missing {
+		if slices.Contains(tidied, imp.Dir) {
+			continue
+		}
+		tidied = append(tidied, imp.Dir)
+		if !opts.silent {
+			fmt.Printf("  %s$ go mod tidy%s\n", co(internal.ColorDim), moduleSuffix(imp.Module)+co(internal.ColorReset))
+		}
+		cmd := exec.Command("go", "mod", "tidy")
+		cmd.Dir = imp.Dir
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("go mod tidy failed in %s: %w", imp.Dir, err)
+		}
+	}
+	return nil
+}
+
+// moduleSuffix returns " (in module)" naming the module importing a package,
+// or an empty string if unknown.
+func moduleSuffix(module string) string {
+	if module == "" {
+		return ""
+	}
+	return " (in " + module + ")"
+}
+
+// runHooks executes a list of shell commands sequentially, with the labels of
+// the run in their environment.
 // If any command fails (non-zero exit code), execution stops and an error is returned.
-func runHooks(phase string, commands []string, silent bool) error {
+func runHooks(phase string, commands []string, labels labelsFlag, silent bool) error {
 	if !silent {
 		fmt.Printf("%s▶ %s%s\n", co(internal.ColorYellow), phase, co(internal.ColorReset))
 	}
@@ -180,6 +1359,7 @@ func runHooks(phase string, commands []string, silent bool) error {
 		}
 
 		cmd := exec.Command("sh", "-c", cmdStr)
+		cmd.Env = append(os.Environ(), labels.env()...)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 
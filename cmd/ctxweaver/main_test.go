@@ -2,12 +2,19 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/scaffold"
 )
 
 func TestIsFlagPassed(t *testing.T) {
@@ -100,7 +107,7 @@ func TestRunHooks(t *testing.T) {
 
 	for name, tt := range tests {
 		t.Run(name, func(t *testing.T) {
-			err := runHooks("test", tt.commands, tt.silent)
+			err := runHooks("test", tt.commands, nil, tt.silent)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("runHooks() error = %v, wantErr %v", err, tt.wantErr)
 			}
@@ -108,8 +115,140 @@ func TestRunHooks(t *testing.T) {
 	}
 }
 
+func TestRunHooks_Labels(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "env")
+	labels := labelsFlag{"team": "payments", "wave": "2"}
+	if err := runHooks("pre", []string{`echo "$CTXWEAVER_LABELS $CTXWEAVER_LABEL_TEAM" > ` + out}, labels, true); err != nil {
+		t.Fatalf("runHooks() error = %v", err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "team=payments,wave=2 payments\n"; string(got) != want {
+		t.Errorf("hook environment = %q, want %q", got, want)
+	}
+}
+
+func TestLabelsFlag(t *testing.T) {
+	tests := map[string]struct {
+		values  []string
+		want    labelsFlag
+		wantErr bool
+	}{
+		"labels": {
+			values: []string{"team=payments", "region=eu_west", "empty="},
+			want:   labelsFlag{"team": "payments", "region": "eu_west", "empty": ""},
+		},
+		"later value wins": {
+			values: []string{"team=payments", "team=billing"},
+			want:   labelsFlag{"team": "billing"},
+		},
+		"value with equals sign": {
+			values: []string{"query=a=b"},
+			want:   labelsFlag{"query": "a=b"},
+		},
+		"missing value": {
+			values:  []string{"team"},
+			wantErr: true,
+		},
+		"invalid key": {
+			values:  []string{"team-name=payments"},
+			wantErr: true,
+		},
+		"comma in value": {
+			values:  []string{"teams=payments,billing"},
+			wantErr: true,
+		},
+		"keys differing in case": {
+			values:  []string{"team=payments", "TEAM=billing"},
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got := labelsFlag{}
+			var err error
+			for _, v := range tt.values {
+				if err = got.Set(v); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("labels mismatch (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestSampleFlag(t *testing.T) {
+	tests := map[string]struct {
+		value   string
+		want    sampleFlag
+		wantErr bool
+	}{
+		"percentage":      {value: "10%", want: 10},
+		"without percent": {value: "25", want: 25},
+		"fraction":        {value: "0.5%", want: 0.5},
+		"all":             {value: "100%", want: 100},
+		"zero":            {value: "0%", wantErr: true},
+		"over 100":        {value: "150%", wantErr: true},
+		"not a number":    {value: "half", wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got sampleFlag
+			err := got.Set(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Set() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireCleanFlag(t *testing.T) {
+	tests := map[string]struct {
+		args    []string
+		want    processor.RequireClean
+		wantErr bool
+	}{
+		"unset":    {want: processor.RequireCleanOff},
+		"boolean":  {args: []string{"-require-clean"}, want: processor.RequireCleanRefuse},
+		"refuse":   {args: []string{"-require-clean=refuse"}, want: processor.RequireCleanRefuse},
+		"warn":     {args: []string{"-require-clean=warn"}, want: processor.RequireCleanWarn},
+		"disabled": {args: []string{"-require-clean=false"}, want: processor.RequireCleanOff},
+		"unknown":  {args: []string{"-require-clean=ask"}, wantErr: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got requireCleanFlag
+			fs := flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+			fs.SetOutput(&bytes.Buffer{})
+			fs.Var(&got, "require-clean", "")
+			err := fs.Parse(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if processor.RequireClean(got) != tt.want {
+				t.Errorf("-require-clean = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestRunHooks_ErrorMessage(t *testing.T) {
-	err := runHooks("pre", []string{"exit 42"}, true)
+	err := runHooks("pre", []string{"exit 42"}, nil, true)
 	if err == nil {
 		t.Fatal("expected error")
 	}
@@ -223,6 +362,35 @@ func Foo(ctx context.Context) {
 		}
 	})
 
+	t.Run("per-package summary", func(t *testing.T) {
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		cmd := exec.Command(binPath, "-config", configPath, "-dry-run", "./...")
+		cmd.Dir = tmpDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("unexpected error: %v\n%s", err, out)
+		}
+		if !strings.Contains(string(out), "PACKAGE") || !strings.Contains(string(out), "\n  test ") {
+			t.Errorf("output should contain the per-package summary: %s", out)
+		}
+	})
+
+	t.Run("quiet mode", func(t *testing.T) {
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		cmd := exec.Command(binPath, "-config", configPath, "-dry-run", "-quiet", "./...")
+		cmd.Dir = tmpDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("unexpected error: %v\n%s", err, out)
+		}
+		if strings.Contains(string(out), "PACKAGE") {
+			t.Errorf("quiet mode should not show the per-package summary: %s", out)
+		}
+		if !strings.Contains(string(out), "Files processed") {
+			t.Errorf("quiet mode should show files processed: %s", out)
+		}
+	})
+
 	t.Run("pre hook failure", func(t *testing.T) {
 		configPath := filepath.Join(tmpDir, "hook_fail.yaml")
 		config := `template: "defer trace({{.Ctx}})"
@@ -315,6 +483,52 @@ packages:
 		}
 	})
 
+	t.Run("template with unknown variable", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		config := `template: "defer trace({{.Ctx}}, {{.FunName | quote}})"
+imports: []
+packages:
+  patterns:
+    - ./...
+`
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		setup("-config", configPath, "-silent")
+		err := run()
+		if err == nil {
+			t.Fatal("expected error for unknown template variable")
+		}
+		if !strings.Contains(err.Error(), "did you mean {{.FuncName}}?") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("template renders invalid Go", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		config := `template: "defer trace({{.Ctx}}"
+imports: []
+packages:
+  patterns:
+    - ./...
+`
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		setup("-config", configPath, "-silent")
+		err := run()
+		if err == nil {
+			t.Fatal("expected error for invalid rendered template")
+		}
+		if !strings.Contains(err.Error(), "invalid config: template: rendered template is not valid Go statements") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
 	t.Run("successful run with patterns from config", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
@@ -358,10 +572,10 @@ func Foo(ctx context.Context) {
 		}
 	})
 
-	t.Run("successful run with dry-run and verbose", func(t *testing.T) {
+	t.Run("template flag overrides config template", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
-		config := `template: "defer trace({{.Ctx}})"
+		config := `template: "defer trace({{.Ctx}}"
 imports: []
 packages:
   patterns:
@@ -381,6 +595,8 @@ packages:
 
 import "context"
 
+func trace(context.Context) {}
+
 func Foo(ctx context.Context) {
 }
 `
@@ -392,40 +608,39 @@ func Foo(ctx context.Context) {
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(oldWd) }()
 
-		setup("-config", configPath, "-dry-run", "-verbose", "./...")
-		err := run()
+		setup("-config", configPath, "-silent", "-template", "defer trace({{.Ctx}})")
+		if err := run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, err := os.ReadFile(goFile)
 		if err != nil {
-			t.Errorf("unexpected error: %v", err)
+			t.Fatalf("failed to read go file: %v", err)
+		}
+		if !strings.Contains(string(got), "defer trace(ctx)") {
+			t.Errorf("template flag not applied:\n%s", got)
 		}
 	})
 
-	t.Run("successful run with remove mode", func(t *testing.T) {
+	t.Run("template and import flags without config file", func(t *testing.T) {
 		tmpDir := t.TempDir()
-		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
-		config := `template: "defer trace({{.Ctx}})"
-imports: []
-packages:
-  patterns:
-    - ./...
-`
-		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
-			t.Fatalf("failed to write config: %v", err)
-		}
 
 		goMod := filepath.Join(tmpDir, "go.mod")
 		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
 			t.Fatalf("failed to write go.mod: %v", err)
 		}
 
+		tmplPath := filepath.Join(tmpDir, "tmpl.txt")
+		if err := os.WriteFile(tmplPath, []byte("defer trace.Start({{.Ctx}})"), 0o644); err != nil {
+			t.Fatalf("failed to write template: %v", err)
+		}
+
 		goFile := filepath.Join(tmpDir, "test.go")
 		goCode := `package test
 
 import "context"
 
-func trace(context.Context) {}
-
 func Foo(ctx context.Context) {
-	defer trace(ctx)
 }
 `
 		if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
@@ -436,14 +651,35 @@ func Foo(ctx context.Context) {
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(oldWd) }()
 
-		setup("-config", configPath, "-remove", "-silent", "./...")
+		setup("-silent", "-dry-run", "-template-file", tmplPath, "-import", "example.com/trace", "-import", "example.com/other", "./...")
+		if err := run(); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("template and template-file are mutually exclusive", func(t *testing.T) {
+		setup("-silent", "-template", "defer trace({{.Ctx}})", "-template-file", "tmpl.txt", "./...")
 		err := run()
-		if err != nil {
+		if err == nil {
+			t.Fatal("expected error for conflicting template flags")
+		}
+		if !strings.Contains(err.Error(), "mutually exclusive") {
 			t.Errorf("unexpected error: %v", err)
 		}
 	})
 
-	t.Run("with post hooks", func(t *testing.T) {
+	t.Run("explicit missing config is an error even with template flag", func(t *testing.T) {
+		setup("-config", "nonexistent.yaml", "-silent", "-template", "defer trace({{.Ctx}})", "./...")
+		err := run()
+		if err == nil {
+			t.Fatal("expected error for missing config")
+		}
+		if !strings.Contains(err.Error(), "failed to load config") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("successful run with dry-run and verbose", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
 		config := `template: "defer trace({{.Ctx}})"
@@ -451,9 +687,6 @@ imports: []
 packages:
   patterns:
     - ./...
-hooks:
-  post:
-    - "echo done"
 `
 		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
 			t.Fatalf("failed to write config: %v", err)
@@ -464,18 +697,36 @@ hooks:
 			t.Fatalf("failed to write go.mod: %v", err)
 		}
 
+		goFile := filepath.Join(tmpDir, "test.go")
+		goCode := `package test
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`
+		if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
+			t.Fatalf("failed to write go file: %v", err)
+		}
+
 		oldWd, _ := os.Getwd()
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(oldWd) }()
 
-		setup("-config", configPath, "-silent", "./...")
-		err := run()
+		setup("-config", configPath, "-dry-run", "-verbose", "./...")
+		var err error
+		out := captureStdout(t, func() { err = run() })
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
+		for _, want := range []string{"modified: test.go:5 Foo (insert)\n", "+++ b/test.go\n", "+\tdefer trace(ctx)\n"} {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("output does not contain %q:\n%s", want, out)
+			}
+		}
 	})
 
-	t.Run("with pre hooks (no-hooks flag)", func(t *testing.T) {
+	t.Run("successful run with remove mode", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
 		config := `template: "defer trace({{.Ctx}})"
@@ -483,9 +734,6 @@ imports: []
 packages:
   patterns:
     - ./...
-hooks:
-  pre:
-    - "exit 1"
 `
 		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
 			t.Fatalf("failed to write config: %v", err)
@@ -496,18 +744,33 @@ hooks:
 			t.Fatalf("failed to write go.mod: %v", err)
 		}
 
+		goFile := filepath.Join(tmpDir, "test.go")
+		goCode := `package test
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+}
+`
+		if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
+			t.Fatalf("failed to write go file: %v", err)
+		}
+
 		oldWd, _ := os.Getwd()
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(oldWd) }()
 
-		setup("-config", configPath, "-no-hooks", "-silent", "./...")
+		setup("-config", configPath, "-remove", "-silent", "./...")
 		err := run()
 		if err != nil {
-			t.Errorf("unexpected error (no-hooks should skip failing hook): %v", err)
+			t.Errorf("unexpected error: %v", err)
 		}
 	})
 
-	t.Run("pre hook failure", func(t *testing.T) {
+	t.Run("dedupe subcommand", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
 		config := `template: "defer trace({{.Ctx}})"
@@ -515,9 +778,6 @@ imports: []
 packages:
   patterns:
     - ./...
-hooks:
-  pre:
-    - "exit 1"
 `
 		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
 			t.Fatalf("failed to write config: %v", err)
@@ -528,21 +788,49 @@ hooks:
 			t.Fatalf("failed to write go.mod: %v", err)
 		}
 
+		goFile := filepath.Join(tmpDir, "test.go")
+		goCode := `package test
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+	defer trace(ctx)
+}
+`
+		if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
+			t.Fatalf("failed to write go file: %v", err)
+		}
+
 		oldWd, _ := os.Getwd()
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(oldWd) }()
 
-		setup("-config", configPath, "-silent", "./...")
-		err := run()
-		if err == nil {
-			t.Fatal("expected error for pre hook failure")
+		setup("dedupe", "-config", configPath, "-silent", "./...")
+		if err := run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
-		if !strings.Contains(err.Error(), "pre hook failed") {
+
+		got, err := os.ReadFile(goFile)
+		if err != nil {
+			t.Fatalf("failed to read go file: %v", err)
+		}
+		if n := strings.Count(string(got), "defer trace(ctx)"); n != 1 {
+			t.Errorf("expected 1 trace statement after dedupe, got %d:\n%s", n, got)
+		}
+	})
+
+	t.Run("dedupe with remove is rejected", func(t *testing.T) {
+		setup("dedupe", "-remove", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "dedupe cannot be combined with -remove") {
 			t.Errorf("unexpected error: %v", err)
 		}
 	})
 
-	t.Run("post hook failure", func(t *testing.T) {
+	t.Run("migrate to marker", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
 		config := `template: "defer trace({{.Ctx}})"
@@ -550,9 +838,6 @@ imports: []
 packages:
   patterns:
     - ./...
-hooks:
-  post:
-    - "exit 1"
 `
 		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
 			t.Fatalf("failed to write config: %v", err)
@@ -563,29 +848,57 @@ hooks:
 			t.Fatalf("failed to write go.mod: %v", err)
 		}
 
+		goFile := filepath.Join(tmpDir, "test.go")
+		goCode := `package test
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+}
+`
+		if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
+			t.Fatalf("failed to write go file: %v", err)
+		}
+
 		oldWd, _ := os.Getwd()
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(oldWd) }()
 
-		setup("-config", configPath, "-silent", "./...")
-		err := run()
-		if err == nil {
-			t.Fatal("expected error for post hook failure")
+		setup("migrate", "--to-marker", "-config", configPath, "-silent", "./...")
+		if err := run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
 		}
-		if !strings.Contains(err.Error(), "post hook failed") {
+
+		got, err := os.ReadFile(goFile)
+		if err != nil {
+			t.Fatalf("failed to read go file: %v", err)
+		}
+		if !strings.Contains(string(got), "defer trace(ctx) //ctxweaver:generated") {
+			t.Errorf("expected marker to be appended:\n%s", got)
+		}
+	})
+
+	t.Run("migrate without target mode", func(t *testing.T) {
+		setup("migrate", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "--to-marker") {
 			t.Errorf("unexpected error: %v", err)
 		}
 	})
 
-	t.Run("test flag override", func(t *testing.T) {
+	t.Run("refactor renames generated variables", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
-		config := `template: "defer trace({{.Ctx}})"
+		config := `template: |
+  traceSpan := start({{.Ctx}})
+  defer traceSpan.End()
 imports: []
 packages:
   patterns:
     - ./...
-test: false
 `
 		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
 			t.Fatalf("failed to write config: %v", err)
@@ -596,47 +909,102 @@ test: false
 			t.Fatalf("failed to write go.mod: %v", err)
 		}
 
+		goFile := filepath.Join(tmpDir, "test.go")
+		goCode := `package test
+
+import "context"
+
+type Span struct{ Name string }
+
+func (*Span) End() {}
+func (*Span) Tag(string) {}
+
+func start(context.Context) *Span { return &Span{} }
+
+func Foo(ctx context.Context) {
+	span := start(ctx)
+	defer span.End()
+
+	span.Tag(span.Name)
+}
+
+func Bar(ctx context.Context) {
+}
+`
+		if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
+			t.Fatalf("failed to write go file: %v", err)
+		}
+
 		oldWd, _ := os.Getwd()
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(oldWd) }()
 
-		setup("-config", configPath, "-test=true", "-silent", "./...")
-		err := run()
+		setup("refactor", "-rename", "span=traceSpan", "-config", configPath, "-silent", "./...")
+		if err := run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, err := os.ReadFile(goFile)
 		if err != nil {
+			t.Fatalf("failed to read go file: %v", err)
+		}
+		for _, want := range []string{
+			"traceSpan := start(ctx)\n\tdefer traceSpan.End()\n\n\ttraceSpan.Tag(traceSpan.Name)",
+			"func Bar(ctx context.Context) {\n}", // Nothing is inserted
+		} {
+			if !strings.Contains(string(got), want) {
+				t.Errorf("expected %q in:\n%s", want, got)
+			}
+		}
+	})
+
+	t.Run("refactor without rename", func(t *testing.T) {
+		setup("refactor", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "refactor requires at least one -rename") {
 			t.Errorf("unexpected error: %v", err)
 		}
 	})
 
-	t.Run("with custom carriers", func(t *testing.T) {
+	t.Run("refactor with invalid rename", func(t *testing.T) {
+		setup("refactor", "-rename", "span", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), `invalid -rename "span"`) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("refactor add-param", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
-		config := `template: "defer trace({{.Ctx}})"
+		config := `template: |
+  defer trace({{.Ctx}})
 imports: []
 packages:
   patterns:
     - ./...
-carriers:
-  - package: "net/http"
-    type: "Request"
-    accessor: ".Context()"
+functions:
+  regexps:
+    only:
+      - ^load$
 `
 		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
 			t.Fatalf("failed to write config: %v", err)
 		}
-
-		goMod := filepath.Join(tmpDir, "go.mod")
-		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+		if err := os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
 			t.Fatalf("failed to write go.mod: %v", err)
 		}
-
 		goFile := filepath.Join(tmpDir, "test.go")
 		goCode := `package test
 
-import "net/http"
+import "context"
 
-func trace(interface{}) {}
+func Get(ctx context.Context) string {
+	return load()
+}
 
-func Handler(r *http.Request) {
+func load() string {
+	return ""
 }
 `
 		if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
@@ -647,46 +1015,270 @@ func Handler(r *http.Request) {
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(oldWd) }()
 
-		setup("-config", configPath, "-silent", "./...")
-		err := run()
+		setup("refactor", "add-param", "-config", configPath, "-silent", "./...")
+		if err := run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got, err := os.ReadFile(goFile)
 		if err != nil {
+			t.Fatalf("failed to read go file: %v", err)
+		}
+		for _, want := range []string{
+			"return load(ctx)",
+			"func load(ctx context.Context) string {\n\treturn \"\"", // Nothing is inserted
+		} {
+			if !strings.Contains(string(got), want) {
+				t.Errorf("expected %q in:\n%s", want, got)
+			}
+		}
+	})
+
+	t.Run("refactor add-param with func", func(t *testing.T) {
+		setup("refactor", "add-param", "-func", "test.Get", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "refactor add-param cannot be combined with -remove, -func or -line") {
 			t.Errorf("unexpected error: %v", err)
 		}
 	})
 
-	t.Run("no patterns error", func(t *testing.T) {
+	t.Run("rollback without verify is rejected", func(t *testing.T) {
+		setup("-rollback", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "-rollback requires -verify") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("single function", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+			t.Fatalf("failed to write go.mod: %v", err)
+		}
+		goCode := `package test
+
+import "context"
+
+type Service struct{}
+
+func (s *Service) Get(ctx context.Context) {
+}
+
+func Foo(ctx context.Context) {
+}
+`
+		goFile := filepath.Join(tmpDir, "test.go")
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		tests := map[string]struct {
+			args    []string
+			want    string // Woven function
+			wantErr string
+		}{
+			"by function": {
+				args: []string{"-func", "test.Service.Get"},
+				want: "func (s *Service) Get(ctx context.Context) {\n\tdefer trace(ctx)\n",
+			},
+			"by line": {
+				args: []string{"-line", "test.go:11"},
+				want: "func Foo(ctx context.Context) {\n\tdefer trace(ctx)\n",
+			},
+			"unknown function": {
+				args:    []string{"-func", "test.Bar"},
+				wantErr: "function test.Bar not found in test",
+			},
+			"line outside functions": {
+				args:    []string{"-line", "test.go:2"},
+				wantErr: "no function found at test.go:2",
+			},
+			"invalid line": {
+				args:    []string{"-line", "test.go"},
+				wantErr: `invalid -line "test.go"`,
+			},
+			"invalid function": {
+				args:    []string{"-func", "Foo"},
+				wantErr: `invalid -func "Foo"`,
+			},
+			"function and line": {
+				args:    []string{"-func", "test.Foo", "-line", "test.go:11"},
+				wantErr: "-func and -line are mutually exclusive",
+			},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
+					t.Fatalf("failed to write go file: %v", err)
+				}
+
+				setup(append([]string{"-template", "defer trace({{.Ctx}})", "-silent"}, tt.args...)...)
+				err := run()
+				if tt.wantErr != "" {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Errorf("unexpected error: %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("run() error: %v", err)
+				}
+
+				got, _ := os.ReadFile(goFile)
+				if !strings.Contains(string(got), tt.want) || strings.Count(string(got), "defer trace(ctx)") != 1 {
+					t.Errorf("expected only the selected function to be woven, got:\n%s", got)
+				}
+			})
+		}
+	})
+
+	t.Run("lsp with dry-run is rejected", func(t *testing.T) {
+		setup("lsp", "-dry-run")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "lsp cannot be combined with -dry-run") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("output without dry-run is rejected", func(t *testing.T) {
+		setup("-output", "patches", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "-output requires -dry-run") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("output-dir with dry-run is rejected", func(t *testing.T) {
+		setup("-output-dir", "out", "-dry-run", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "-output-dir cannot be combined with -dry-run") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("sample with remove is rejected", func(t *testing.T) {
+		setup("-sample", "10%", "-remove", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "-sample cannot be combined with -remove") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("interactive with check is rejected", func(t *testing.T) {
+		setup("check", "-interactive")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "-interactive cannot be combined with") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("undo without run is rejected", func(t *testing.T) {
+		setup("undo", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "undo requires -run") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("run with spaces is rejected", func(t *testing.T) {
+		setup("-run", "2024-06-01 12:00", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "invalid -run") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("tidy with dry-run is rejected", func(t *testing.T) {
+		setup("-tidy", "-dry-run", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "-tidy cannot be combined with -dry-run") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("emit mode build_tag with dedupe is rejected", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
-		// Config without packages.patterns - should error
 		config := `template: "defer trace({{.Ctx}})"
-imports: []
 packages:
-  patterns: []
+  patterns:
+    - ./...
+emit:
+  mode: build_tag
 `
 		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
 			t.Fatalf("failed to write config: %v", err)
 		}
 
-		goMod := filepath.Join(tmpDir, "go.mod")
-		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+		setup("dedupe", "-config", configPath, "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "emit mode build_tag cannot be combined with") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("overlay", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
 			t.Fatalf("failed to write go.mod: %v", err)
 		}
+		goFile := filepath.Join(tmpDir, "test.go")
+		if err := os.WriteFile(goFile, []byte("package test\n\nfunc Foo() {\n}\n"), 0o644); err != nil {
+			t.Fatalf("failed to write go file: %v", err)
+		}
+
+		// An unsaved buffer adding a context parameter
+		buffer := filepath.Join(t.TempDir(), "buffer.go")
+		if err := os.WriteFile(buffer, []byte("package test\n\nimport \"context\"\n\nfunc Foo(ctx context.Context) {\n}\n"), 0o644); err != nil {
+			t.Fatalf("failed to write buffer: %v", err)
+		}
+		overlay := filepath.Join(t.TempDir(), "overlay.json")
+		data := fmt.Sprintf(`{"Replace": {"test.go": %q}}`, buffer)
+		if err := os.WriteFile(overlay, []byte(data), 0o644); err != nil {
+			t.Fatalf("failed to write overlay: %v", err)
+		}
 
 		oldWd, _ := os.Getwd()
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(oldWd) }()
 
-		setup("-config", configPath, "-silent")
+		setup("-template", "defer trace({{.Ctx}})", "-overlay", overlay, "-silent", "./...")
+		if err := run(); err != nil {
+			t.Fatalf("run() error: %v", err)
+		}
+
+		got, _ := os.ReadFile(goFile)
+		if !strings.Contains(string(got), "func Foo(ctx context.Context) {\n\tdefer trace(ctx)\n") {
+			t.Errorf("expected the buffer to be woven, got:\n%s", got)
+		}
+	})
+
+	t.Run("overlay deleting a file is rejected", func(t *testing.T) {
+		overlay := filepath.Join(t.TempDir(), "overlay.json")
+		if err := os.WriteFile(overlay, []byte(`{"Replace": {"test.go": ""}}`), 0o644); err != nil {
+			t.Fatalf("failed to write overlay: %v", err)
+		}
+
+		setup("-template", "defer trace({{.Ctx}})", "-overlay", overlay, "-silent", "./...")
 		err := run()
-		if err == nil {
-			t.Error("expected error for empty patterns")
+		if err == nil || !strings.Contains(err.Error(), "deleting test.go is not supported") {
+			t.Errorf("unexpected error: %v", err)
 		}
 	})
 
-	t.Run("non-silent output", func(t *testing.T) {
+	t.Run("invalid import alias is rejected", func(t *testing.T) {
+		setup("-template", "defer trace({{.Ctx}})", "-import", "otel-trace=go.opentelemetry.io/otel/trace", "-silent", "./...")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "want path or alias=path") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("verify with rollback", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
-		config := `template: "defer trace({{.Ctx}})"
+		config := `template: "defer undefinedTrace({{.Ctx}})"
 imports: []
 packages:
   patterns:
@@ -706,8 +1298,6 @@ packages:
 
 import "context"
 
-func trace(context.Context) {}
-
 func Foo(ctx context.Context) {
 }
 `
@@ -719,75 +1309,434 @@ func Foo(ctx context.Context) {
 		_ = os.Chdir(tmpDir)
 		defer func() { _ = os.Chdir(oldWd) }()
 
-		// Without -silent, output will be printed
-		setup("-config", configPath, "./...")
-		err := run()
+		setup("-config", configPath, "-verify", "-rollback", "-silent", "./...")
+		if err := run(); err == nil {
+			t.Error("expected verification error")
+		}
+
+		got, err := os.ReadFile(goFile)
 		if err != nil {
-			t.Errorf("unexpected error: %v", err)
+			t.Fatalf("failed to read go file: %v", err)
+		}
+		if string(got) != goCode {
+			t.Errorf("expected file to be rolled back, got:\n%s", got)
 		}
 	})
-}
-
-func TestCLI_ConfigOverride(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping integration test in short mode")
-	}
-
-	tmpDir := t.TempDir()
-	binPath := filepath.Join(tmpDir, "ctxweaver")
-	buildCmd := exec.Command("go", "build", "-o", binPath, ".")
-	if out, err := buildCmd.CombinedOutput(); err != nil {
-		t.Fatalf("failed to build: %v\n%s", err, out)
-	}
 
-	// Create config with test: true
-	configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
-	config := `template: "defer trace({{.Ctx}})"
+	t.Run("with post hooks", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		config := `template: "defer trace({{.Ctx}})"
 imports: []
 packages:
   patterns:
     - ./...
-test: true
+hooks:
+  post:
+    - "echo done"
 `
-	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
-		t.Fatalf("failed to write config: %v", err)
-	}
-
-	// Create go.mod
-	goMod := filepath.Join(tmpDir, "go.mod")
-	if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
-		t.Fatalf("failed to write go.mod: %v", err)
-	}
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
 
-	t.Run("--test=false flag is accepted", func(t *testing.T) {
-		// Just verify the flag is parsed without error
-		cmd := exec.Command(binPath, "-config", configPath, "-silent", "-test=false", "./...")
-		cmd.Dir = tmpDir
-		out, err := cmd.CombinedOutput()
-		if err != nil {
-			t.Errorf("--test=false should be accepted: %v\n%s", err, out)
+		goMod := filepath.Join(tmpDir, "go.mod")
+		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+			t.Fatalf("failed to write go.mod: %v", err)
 		}
-	})
 
-	t.Run("--test=true flag is accepted", func(t *testing.T) {
-		cmd := exec.Command(binPath, "-config", configPath, "-silent", "-test=true", "./...")
-		cmd.Dir = tmpDir
-		out, err := cmd.CombinedOutput()
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		setup("-config", configPath, "-silent", "./...")
+		err := run()
 		if err != nil {
-			t.Errorf("--test=true should be accepted: %v\n%s", err, out)
+			t.Errorf("unexpected error: %v", err)
 		}
 	})
-}
 
-func TestRun_PackageWithSyntaxError(t *testing.T) {
-	// Helper to reset flags and set args
-	setup := func(args ...string) {
-		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
-		flag.CommandLine.SetOutput(&bytes.Buffer{})
-		os.Args = append([]string{"ctxweaver"}, args...)
-	}
+	t.Run("scaffold", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		config := `template: "defer trace.Start({{.Ctx}})"
+imports:
+  - test/internal/trace
+packages:
+  patterns:
+    - ./...
+scaffold:
+  - path: internal/trace/trace.go
+    template: |
+      package {{.PackageName}}
 
-	tmpDir := t.TempDir()
+      import "context"
+
+      func Start(ctx context.Context) {}
+`
+		files := map[string]string{
+			"ctxweaver.yaml": config,
+			"go.mod":         "module test\n\ngo 1.21\n",
+			"main.go":        "package main\n\nimport \"context\"\n\nfunc Foo(ctx context.Context) {\n}\n",
+		}
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+				t.Fatalf("failed to write %s: %v", name, err)
+			}
+		}
+		helper := filepath.Join(tmpDir, "internal", "trace", "trace.go")
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		// check reports the missing helper without writing it
+		setup("check", "-config", configPath, "-silent", "./...")
+		if err := run(); err == nil || !strings.Contains(err.Error(), "2 file(s) need changes") {
+			t.Errorf("check error = %v, want 2 files needing changes", err)
+		}
+		if _, err := os.Stat(helper); !os.IsNotExist(err) {
+			t.Errorf("check should not write the helper: %v", err)
+		}
+
+		setup("-config", configPath, "-silent", "./...")
+		if err := run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		content, err := os.ReadFile(helper)
+		if err != nil {
+			t.Fatalf("helper not written: %v", err)
+		}
+		if !strings.HasPrefix(string(content), scaffold.Marker) {
+			t.Errorf("helper should start with the marker:\n%s", content)
+		}
+		woven, _ := os.ReadFile("main.go")
+		if !strings.Contains(string(woven), "defer trace.Start(ctx)") {
+			t.Errorf("main.go not woven:\n%s", woven)
+		}
+		if strings.Contains(string(content), "trace.Start") {
+			t.Errorf("helper should not be woven:\n%s", content)
+		}
+
+		// Once written, nothing needs changes
+		setup("check", "-config", configPath, "-silent", "./...")
+		if err := run(); err != nil {
+			t.Errorf("check error = %v", err)
+		}
+	})
+
+	t.Run("with pre hooks (no-hooks flag)", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		config := `template: "defer trace({{.Ctx}})"
+imports: []
+packages:
+  patterns:
+    - ./...
+hooks:
+  pre:
+    - "exit 1"
+`
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		goMod := filepath.Join(tmpDir, "go.mod")
+		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+			t.Fatalf("failed to write go.mod: %v", err)
+		}
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		setup("-config", configPath, "-no-hooks", "-silent", "./...")
+		err := run()
+		if err != nil {
+			t.Errorf("unexpected error (no-hooks should skip failing hook): %v", err)
+		}
+	})
+
+	t.Run("pre hook failure", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		config := `template: "defer trace({{.Ctx}})"
+imports: []
+packages:
+  patterns:
+    - ./...
+hooks:
+  pre:
+    - "exit 1"
+`
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		goMod := filepath.Join(tmpDir, "go.mod")
+		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+			t.Fatalf("failed to write go.mod: %v", err)
+		}
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		setup("-config", configPath, "-silent", "./...")
+		err := run()
+		if err == nil {
+			t.Fatal("expected error for pre hook failure")
+		}
+		if !strings.Contains(err.Error(), "pre hook failed") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("post hook failure", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		config := `template: "defer trace({{.Ctx}})"
+imports: []
+packages:
+  patterns:
+    - ./...
+hooks:
+  post:
+    - "exit 1"
+`
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		goMod := filepath.Join(tmpDir, "go.mod")
+		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+			t.Fatalf("failed to write go.mod: %v", err)
+		}
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		setup("-config", configPath, "-silent", "./...")
+		err := run()
+		if err == nil {
+			t.Fatal("expected error for post hook failure")
+		}
+		if !strings.Contains(err.Error(), "post hook failed") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("test flag override", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		config := `template: "defer trace({{.Ctx}})"
+imports: []
+packages:
+  patterns:
+    - ./...
+test: false
+`
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		goMod := filepath.Join(tmpDir, "go.mod")
+		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+			t.Fatalf("failed to write go.mod: %v", err)
+		}
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		setup("-config", configPath, "-test=true", "-silent", "./...")
+		err := run()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("with custom carriers", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		config := `template: "defer trace({{.Ctx}})"
+imports: []
+packages:
+  patterns:
+    - ./...
+carriers:
+  - package: "net/http"
+    type: "Request"
+    accessor: ".Context()"
+`
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		goMod := filepath.Join(tmpDir, "go.mod")
+		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+			t.Fatalf("failed to write go.mod: %v", err)
+		}
+
+		goFile := filepath.Join(tmpDir, "test.go")
+		goCode := `package test
+
+import "net/http"
+
+func trace(interface{}) {}
+
+func Handler(r *http.Request) {
+}
+`
+		if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
+			t.Fatalf("failed to write go file: %v", err)
+		}
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		setup("-config", configPath, "-silent", "./...")
+		err := run()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("no patterns error", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		// Config without packages.patterns - should error
+		config := `template: "defer trace({{.Ctx}})"
+imports: []
+packages:
+  patterns: []
+`
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		goMod := filepath.Join(tmpDir, "go.mod")
+		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+			t.Fatalf("failed to write go.mod: %v", err)
+		}
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		setup("-config", configPath, "-silent")
+		err := run()
+		if err == nil {
+			t.Error("expected error for empty patterns")
+		}
+	})
+
+	t.Run("non-silent output", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		config := `template: "defer trace({{.Ctx}})"
+imports: []
+packages:
+  patterns:
+    - ./...
+`
+		if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+
+		goMod := filepath.Join(tmpDir, "go.mod")
+		if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+			t.Fatalf("failed to write go.mod: %v", err)
+		}
+
+		goFile := filepath.Join(tmpDir, "test.go")
+		goCode := `package test
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+}
+`
+		if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
+			t.Fatalf("failed to write go file: %v", err)
+		}
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		// Without -silent, output will be printed
+		setup("-config", configPath, "./...")
+		err := run()
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestCLI_ConfigOverride(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping integration test in short mode")
+	}
+
+	tmpDir := t.TempDir()
+	binPath := filepath.Join(tmpDir, "ctxweaver")
+	buildCmd := exec.Command("go", "build", "-o", binPath, ".")
+	if out, err := buildCmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build: %v\n%s", err, out)
+	}
+
+	// Create config with test: true
+	configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+	config := `template: "defer trace({{.Ctx}})"
+imports: []
+packages:
+  patterns:
+    - ./...
+test: true
+`
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	// Create go.mod
+	goMod := filepath.Join(tmpDir, "go.mod")
+	if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatalf("failed to write go.mod: %v", err)
+	}
+
+	t.Run("--test=false flag is accepted", func(t *testing.T) {
+		// Just verify the flag is parsed without error
+		cmd := exec.Command(binPath, "-config", configPath, "-silent", "-test=false", "./...")
+		cmd.Dir = tmpDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("--test=false should be accepted: %v\n%s", err, out)
+		}
+	})
+
+	t.Run("--test=true flag is accepted", func(t *testing.T) {
+		cmd := exec.Command(binPath, "-config", configPath, "-silent", "-test=true", "./...")
+		cmd.Dir = tmpDir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Errorf("--test=true should be accepted: %v\n%s", err, out)
+		}
+	})
+}
+
+func TestRun_PackageWithSyntaxError(t *testing.T) {
+	// Helper to reset flags and set args
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
 	config := `template: "defer trace({{.Ctx}})"
 imports: []
@@ -811,7 +1760,60 @@ packages:
 import "context"
 
 func Foo(ctx context.Context) {
-	// missing closing brace
+	// missing closing brace
+`
+	if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
+		t.Fatalf("failed to write go file: %v", err)
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	setup("-config", configPath, "-silent", "./...")
+	err := run()
+	if err == nil {
+		t.Fatal("expected error for package with syntax error")
+	}
+	if !strings.Contains(err.Error(), "error") {
+		t.Errorf("unexpected error message: %v", err)
+	}
+}
+
+func TestRun_PackageWithTypeError(t *testing.T) {
+	// Helper to reset flags and set args
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+	config := `template: "defer trace({{.Ctx}})"
+imports: []
+packages:
+  patterns:
+    - ./...
+`
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	goMod := filepath.Join(tmpDir, "go.mod")
+	if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatalf("failed to write go.mod: %v", err)
+	}
+
+	// Create Go file with type error (undefined variable)
+	goFile := filepath.Join(tmpDir, "typeerror.go")
+	goCode := `package test
+
+import "context"
+
+func Foo(ctx context.Context) {
+	_ = undefinedVariable
+}
 `
 	if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
 		t.Fatalf("failed to write go file: %v", err)
@@ -824,15 +1826,338 @@ func Foo(ctx context.Context) {
 	setup("-config", configPath, "-silent", "./...")
 	err := run()
 	if err == nil {
-		t.Fatal("expected error for package with syntax error")
+		t.Fatal("expected error for package with type error")
 	}
 	if !strings.Contains(err.Error(), "error") {
 		t.Errorf("unexpected error message: %v", err)
 	}
 }
 
-func TestRun_PackageWithTypeError(t *testing.T) {
-	// Helper to reset flags and set args
+func TestRun_Workspace(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	src := `package %s
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+}
+`
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"ctxweaver.yaml": `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`,
+		"go.work":  "go 1.21\n\nuse (\n\t./a\n\t./b\n)\n",
+		"a/go.mod": "module example.com/a\n\ngo 1.21\n",
+		"a/a.go":   fmt.Sprintf(src, "a"),
+		"b/go.mod": "module example.com/b\n\ngo 1.21\n",
+		"b/b.go":   fmt.Sprintf(src, "b"),
+		"b/c/c.go": fmt.Sprintf(src, "c"),
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	setup("-dry-run")
+	var err error
+	out := captureStdout(t, func() { err = run() })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"MODULE", "example.com/a  1         1      1", "example.com/b  2         2      2"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output should contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestRun_RemoveDryRunReport(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"ctxweaver.yaml": `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`,
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"app.go": `package app
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func Bar(ctx context.Context) {
+	defer trace(ctx) //ctxweaver:skip
+}
+
+func Baz(ctx context.Context) {
+}
+
+func trace(context.Context) {}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	setup("-remove", "-dry-run")
+	var err error
+	out := captureStdout(t, func() { err = run() })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "  Would remove:\n    app.go:5 Foo\n  Protected by //ctxweaver:skip:\n    app.go:9 Bar\n"
+	if !strings.Contains(string(out), want) {
+		t.Errorf("output should contain %q:\n%s", want, out)
+	}
+	if strings.Contains(string(out), "Baz") {
+		t.Errorf("output should not mention Baz:\n%s", out)
+	}
+}
+
+func TestRun_WeaveJSON(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"ctxweaver.yaml": `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`,
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"app.go": `package app
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func Bar(ctx context.Context) {
+}
+
+func trace(context.Context) {}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	t.Run("json output", func(t *testing.T) {
+		setup("-dry-run", "-format", "json", "-label", "team=payments")
+		var err error
+		out := captureStdout(t, func() { err = run() })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got weaveJSON
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatalf("invalid JSON output: %v\n%s", err, out)
+		}
+		want := weaveJSON{
+			Labels:         labelsFlag{"team": "payments"},
+			FilesProcessed: 1,
+			FilesModified:  1,
+			ModifiedFiles:  []string{"app.go"},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("report mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		setup("-dry-run", "-format", "xml")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), `unknown format "xml"`) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestRun_DetectDrift(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	const src = `package app
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx) //ctxweaver:generated sha=00000000
+}
+
+func Bar(ctx context.Context) {
+}
+
+func trace(context.Context) {}
+`
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"ctxweaver.yaml": `template: "defer trace({{.Ctx}})"
+matching: marker
+packages:
+  patterns:
+    - ./...
+`,
+		"skeleton.yaml": `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`,
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"app.go": src,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	t.Run("outdated marker is reported without modifying files", func(t *testing.T) {
+		setup("-detect-drift", "-silent")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), "1 function(s) generated from an outdated template") {
+			t.Fatalf("error = %v, want drift of 1 function", err)
+		}
+		content, _ := os.ReadFile(filepath.Join(tmpDir, "app.go"))
+		if string(content) != src {
+			t.Errorf("app.go should not be modified:\n%s", content)
+		}
+	})
+
+	t.Run("cannot be combined with remove", func(t *testing.T) {
+		setup("-detect-drift", "-remove")
+		if err := run(); err == nil || !strings.Contains(err.Error(), "cannot be combined with -remove") {
+			t.Errorf("error = %v, want -remove rejection", err)
+		}
+	})
+
+	t.Run("requires marker matching", func(t *testing.T) {
+		setup("-detect-drift", "-config", "skeleton.yaml")
+		if err := run(); err == nil || !strings.Contains(err.Error(), "requires matching: marker") {
+			t.Errorf("error = %v, want matching rejection", err)
+		}
+	})
+}
+
+func TestRun_Undo(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"ctxweaver.yaml": `template: "defer trace({{.Ctx}})"
+matching: marker
+packages:
+  patterns:
+    - ./...
+`,
+		"skeleton.yaml": `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`,
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"app.go": "package app\n\nimport \"context\"\n\nfunc Foo(ctx context.Context) {\n}\n\nfunc trace(context.Context) {}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	t.Run("removes only the statements of the run", func(t *testing.T) {
+		setup("-run", "r1", "-silent")
+		if err := run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		content, _ := os.ReadFile("app.go")
+		content = append(content, "\nfunc Bar(ctx context.Context) {\n}\n"...)
+		if err := os.WriteFile("app.go", content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		setup("-run", "r2", "-silent")
+		if err := run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		setup("undo", "-run", "r2", "-silent")
+		if err := run(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		content, _ = os.ReadFile("app.go")
+		if !strings.Contains(string(content), "run=r1") || strings.Contains(string(content), "run=r2") {
+			t.Errorf("only the statements of r2 should be removed:\n%s", content)
+		}
+	})
+
+	t.Run("requires marker matching", func(t *testing.T) {
+		setup("undo", "-run", "r1", "-config", "skeleton.yaml")
+		if err := run(); err == nil || !strings.Contains(err.Error(), "-run requires matching: marker") {
+			t.Errorf("error = %v, want matching rejection", err)
+		}
+	})
+}
+
+func TestRun_MinVersion(t *testing.T) {
 	setup := func(args ...string) {
 		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
 		flag.CommandLine.SetOutput(&bytes.Buffer{})
@@ -841,8 +2166,8 @@ func TestRun_PackageWithTypeError(t *testing.T) {
 
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
-	config := `template: "defer trace({{.Ctx}})"
-imports: []
+	config := `min_version: v1.4.0
+template: "defer trace({{.Ctx}})"
 packages:
   patterns:
     - ./...
@@ -851,35 +2176,144 @@ packages:
 		t.Fatalf("failed to write config: %v", err)
 	}
 
-	goMod := filepath.Join(tmpDir, "go.mod")
-	if err := os.WriteFile(goMod, []byte("module test\n\ngo 1.21\n"), 0o644); err != nil {
-		t.Fatalf("failed to write go.mod: %v", err)
+	oldVersion := version
+	defer func() { version = oldVersion }()
+	version = "v1.3.0"
+
+	setup("-config", configPath, "-dry-run")
+	err := run()
+	if err == nil || !strings.Contains(err.Error(), "config requires ctxweaver v1.4.0 or later, but this is v1.3.0") {
+		t.Errorf("unexpected error: %v", err)
 	}
+}
 
-	// Create Go file with type error (undefined variable)
-	goFile := filepath.Join(tmpDir, "typeerror.go")
-	goCode := `package test
+func TestRun_Profile(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"ctxweaver.yaml": `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`,
+		"go.mod": "module example.com/app\n\ngo 1.21\n",
+		"app.go": `package app
 
 import "context"
 
 func Foo(ctx context.Context) {
-	_ = undefinedVariable
 }
-`
-	if err := os.WriteFile(goFile, []byte(goCode), 0o644); err != nil {
-		t.Fatalf("failed to write go file: %v", err)
+
+func trace(context.Context) {}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
 	}
 
 	oldWd, _ := os.Getwd()
 	_ = os.Chdir(tmpDir)
 	defer func() { _ = os.Chdir(oldWd) }()
 
-	setup("-config", configPath, "-silent", "./...")
-	err := run()
-	if err == nil {
-		t.Fatal("expected error for package with type error")
+	profile := filepath.Join(t.TempDir(), "cpu.pprof")
+	setup("-dry-run", "-silent", "-profile", profile)
+	if err := run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if !strings.Contains(err.Error(), "error") {
-		t.Errorf("unexpected error message: %v", err)
+	info, err := os.Stat(profile)
+	if err != nil {
+		t.Fatalf("profile not written: %v", err)
+	}
+	if info.Size() == 0 {
+		t.Error("profile is empty")
+	}
+}
+
+func TestRun_ImportsPolicy(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+	t.Setenv("GOPROXY", "off") // The dependency is only reachable through its replace directive
+	t.Setenv("GOFLAGS", "")
+
+	for name, tt := range map[string]struct {
+		policy  string
+		wantErr string
+	}{
+		"require-existing fails": {
+			policy:  "require-existing",
+			wantErr: "imports not provided by any module required by go.mod: example.com/trace",
+		},
+		"auto adds the dependency": {
+			policy: "auto",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			files := map[string]string{
+				"app/ctxweaver.yaml": `template: "defer trace.Start({{.Ctx}})"
+imports:
+  - example.com/trace
+imports_policy: ` + tt.policy + `
+packages:
+  patterns:
+    - ./...
+`,
+				"app/go.mod":   "module example.com/app\n\ngo 1.21\n\nreplace example.com/trace => ../trace\n",
+				"app/app.go":   "package app\n\nimport \"context\"\n\nfunc Foo(ctx context.Context) {\n}\n",
+				"trace/go.mod": "module example.com/trace\n\ngo 1.21\n",
+				"trace/trace.go": `package trace
+
+import "context"
+
+func Start(context.Context) {}
+`,
+			}
+			for name, content := range files {
+				path := filepath.Join(tmpDir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+
+			oldWd, _ := os.Getwd()
+			_ = os.Chdir(filepath.Join(tmpDir, "app"))
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			setup("-silent")
+			err := run()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if woven, _ := os.ReadFile("app.go"); strings.Contains(string(woven), "trace.Start") {
+					t.Errorf("app.go should be left alone:\n%s", woven)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			goMod, _ := os.ReadFile("go.mod")
+			if !strings.Contains(string(goMod), "require example.com/trace") {
+				t.Errorf("go.mod should require example.com/trace:\n%s", goMod)
+			}
+			woven, _ := os.ReadFile("app.go")
+			if !strings.Contains(string(woven), "defer trace.Start(ctx)") {
+				t.Errorf("app.go not woven:\n%s", woven)
+			}
+		})
 	}
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+// propagationJSON is the JSON output of the propagation subcommand.
+type propagationJSON struct {
+	Labels labelsFlag                   `json:"labels,omitempty"`
+	Issues []processor.PropagationIssue `json:"issues"`
+}
+
+// runPropagation reports the places of eligible functions where the context is
+// dropped instead of propagated, and fails if there are any. No file is
+// modified and hooks are not run.
+func runPropagation(args []string) error {
+	opts := parseFlags(args)
+	if opts.format != "text" && opts.format != "json" {
+		return fmt.Errorf("unknown format %q: use text or json", opts.format)
+	}
+
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+
+	patterns, err := getPatterns(cfg)
+	if err != nil {
+		return err
+	}
+
+	if opts.baseline, err = resolveBaseline(opts, patterns, cfg.Test); err != nil {
+		return err
+	}
+
+	tmplContent, err := cfg.Template.Content()
+	if err != nil {
+		return fmt.Errorf("failed to get template: %w", err)
+	}
+	tmpl, err := parseTemplate(tmplContent)
+	if err != nil {
+		return err
+	}
+
+	proc, err := createProcessor(cfg, tmpl, opts)
+	if err != nil {
+		return err
+	}
+
+	result, err := proc.Propagation(patterns)
+	if err != nil {
+		return err
+	}
+
+	// File paths relative to the working directory, as in the other reports
+	out := propagationJSON{Labels: opts.labels, Issues: make([]processor.PropagationIssue, 0, len(result.Issues))}
+	for _, issue := range result.Issues {
+		issue.File = relPath(issue.File)
+		out.Issues = append(out.Issues, issue)
+	}
+
+	if opts.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	} else if !opts.silent {
+		printPropagation(out.Issues)
+	}
+
+	if len(result.Errors) > 0 {
+		fmt.Fprintln(os.Stderr, "Errors:")
+		for _, e := range result.Errors {
+			fmt.Fprintf(os.Stderr, "  %v\n", e)
+		}
+		return fmt.Errorf("%d error(s) occurred", len(result.Errors))
+	}
+	if len(result.Issues) > 0 {
+		return fmt.Errorf("%d place(s) drop the context", len(result.Issues))
+	}
+	return nil
+}
+
+// printPropagation prints the issues as an aligned table.
+func printPropagation(issues []processor.PropagationIssue) {
+	if len(issues) == 0 {
+		fmt.Println("The context is propagated everywhere.")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LOCATION\tFUNC\tISSUE")
+	for _, issue := range issues {
+		fmt.Fprintf(w, "%s:%d\t%s.%s\t%s\n", issue.File, issue.Line, issue.Package, issue.Func, issue.Reason)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+func TestRun_Propagation(t *testing.T) {
+	setup := func(args ...string) {
+		flag.CommandLine = flag.NewFlagSet("ctxweaver", flag.ContinueOnError)
+		flag.CommandLine.SetOutput(&bytes.Buffer{})
+		os.Args = append([]string{"ctxweaver"}, args...)
+	}
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+	config := `template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`
+	files := map[string]string{
+		"ctxweaver.yaml": config,
+		"go.mod":         "module test\n\ngo 1.21\n",
+		"test.go": `package test
+
+import "context"
+
+func trace(context.Context) {}
+
+func fetch(context.Context) {}
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+
+	fetch(context.Background())
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	t.Run("json output", func(t *testing.T) {
+		setup("propagation", "-config", configPath, "-format", "json")
+		var err error
+		out := captureStdout(t, func() { err = run() })
+		if err == nil || !strings.Contains(err.Error(), "1 place(s) drop the context") {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		var got propagationJSON
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatalf("invalid JSON output: %v\n%s", err, out)
+		}
+		want := []processor.PropagationIssue{
+			{Package: "test", File: "test.go", Line: 12, Func: "Foo", Reason: "context.Background() passed to fetch"},
+		}
+		if diff := cmp.Diff(want, got.Issues); diff != "" {
+			t.Errorf("Issues mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("text output", func(t *testing.T) {
+		setup("propagation", "-config", configPath)
+		out := captureStdout(t, func() { _ = run() })
+		if want := "test.go:12  test.Foo  context.Background() passed to fetch"; !strings.Contains(string(out), want) {
+			t.Errorf("output does not contain %q:\n%s", want, out)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		setup("propagation", "-config", configPath, "-format", "github")
+		err := run()
+		if err == nil || !strings.Contains(err.Error(), `unknown format "github"`) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
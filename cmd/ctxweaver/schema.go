@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+// yamlLanguageServerPrefix is the modeline recognized by yaml-language-server
+// (used by the VS Code YAML extension and other LSP clients).
+const yamlLanguageServerPrefix = "# yaml-language-server: $schema="
+
+// runSchema prints or writes the JSON Schema for the config file.
+func runSchema(args []string) error {
+	fs := flag.NewFlagSet("ctxweaver schema", flag.ContinueOnError)
+	output := fs.String("o", "", "write the schema to this file instead of stdout")
+	associate := fs.String("associate", "", "add a yaml-language-server modeline pointing at the -o file to this config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	schema := config.Schema()
+
+	if *output == "" {
+		if *associate != "" {
+			return fmt.Errorf("-associate requires -o")
+		}
+		_, err := os.Stdout.Write(schema)
+		return err
+	}
+
+	if err := os.WriteFile(*output, schema, 0o644); err != nil {
+		return fmt.Errorf("failed to write schema: %w", err)
+	}
+
+	if *associate != "" {
+		if err := associateSchema(*associate, *output); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// associateSchema prepends a yaml-language-server modeline to the config file
+// so that editors validate and complete it against the schema file.
+// An existing modeline is replaced.
+func associateSchema(configPath, schemaPath string) error {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	absConfig, err := filepath.Abs(configPath)
+	if err != nil {
+		return err
+	}
+	absSchema, err := filepath.Abs(schemaPath)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(filepath.Dir(absConfig), absSchema)
+	if err != nil {
+		rel = absSchema // different volumes on Windows
+	}
+	rel = filepath.ToSlash(rel)
+	if !filepath.IsAbs(rel) && !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	modeline := yamlLanguageServerPrefix + rel
+
+	if bytes.HasPrefix(data, []byte(yamlLanguageServerPrefix)) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		} else {
+			data = nil
+		}
+	}
+
+	result := append([]byte(modeline+"\n"), data...)
+	if err := os.WriteFile(configPath, result, 0o644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+func TestRunSchema(t *testing.T) {
+	t.Run("write schema file", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		out := filepath.Join(tmpDir, "ctxweaver.schema.json")
+
+		if err := runSchema([]string{"-o", out}); err != nil {
+			t.Fatalf("runSchema() error = %v", err)
+		}
+
+		got, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("failed to read schema: %v", err)
+		}
+		if !bytes.Equal(got, config.Schema()) {
+			t.Error("written schema differs from embedded schema")
+		}
+	})
+
+	t.Run("associate requires output", func(t *testing.T) {
+		err := runSchema([]string{"-associate", "ctxweaver.yaml"})
+		if err == nil || !strings.Contains(err.Error(), "-associate requires -o") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("associate adds and replaces modeline", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		configPath := filepath.Join(tmpDir, "ctxweaver.yaml")
+		if err := os.WriteFile(configPath, []byte("template: x\n"), 0o644); err != nil {
+			t.Fatalf("failed to write config: %v", err)
+		}
+		out := filepath.Join(tmpDir, "schemas", "ctxweaver.json")
+		if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+
+		for range 2 {
+			if err := runSchema([]string{"-o", out, "-associate", configPath}); err != nil {
+				t.Fatalf("runSchema() error = %v", err)
+			}
+		}
+
+		got, err := os.ReadFile(configPath)
+		if err != nil {
+			t.Fatalf("failed to read config: %v", err)
+		}
+		want := "# yaml-language-server: $schema=./schemas/ctxweaver.json\ntemplate: x\n"
+		if string(got) != want {
+			t.Errorf("config = %q, want %q", got, want)
+		}
+	})
+}
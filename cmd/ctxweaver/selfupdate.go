@@ -0,0 +1,238 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+
+	"github.com/mpyw/ctxweaver/internal"
+)
+
+// Release locations, replaced in tests.
+var (
+	releaseAPIURL      = "https://api.github.com/repos/mpyw/ctxweaver/releases/latest"
+	releaseDownloadURL = "https://github.com/mpyw/ctxweaver/releases/download"
+	executablePath     = os.Executable
+)
+
+// checksumsFile is the release asset listing the SHA-256 checksum of every archive.
+const checksumsFile = "checksums.txt"
+
+// httpClient downloads releases.
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+// runSelfUpdate replaces the running binary with the released one for the
+// current platform: the latest release, or the one given by -version. The
+// archive is verified against the checksums published with the release.
+func runSelfUpdate(args []string) error {
+	fs := flag.NewFlagSet("ctxweaver self-update", flag.ContinueOnError)
+	target := fs.String("version", "", "release to install, e.g. v1.4.0 (default: the latest release)")
+	dryRun := fs.Bool("dry-run", false, "print the release that would be installed without installing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	tag := *target
+	if tag == "" {
+		latest, err := latestRelease()
+		if err != nil {
+			return err
+		}
+		tag = latest
+	}
+	if !strings.HasPrefix(tag, "v") {
+		tag = "v" + tag
+	}
+	if !semver.IsValid(tag) {
+		return fmt.Errorf("invalid -version %q: want a release such as v1.4.0", *target)
+	}
+
+	current := binaryVersion()
+	if current == tag {
+		fmt.Printf("  %s✓%s ctxweaver %s is up to date\n", co(internal.ColorGreen), co(internal.ColorReset), current)
+		return nil
+	}
+	asset := releaseAsset(tag, runtime.GOOS, runtime.GOARCH)
+	if *dryRun {
+		fmt.Printf("  Would update ctxweaver %s to %s (%s)\n", current, tag, asset)
+		return nil
+	}
+
+	exe, err := executablePath()
+	if err != nil {
+		return fmt.Errorf("failed to locate the running binary: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return fmt.Errorf("failed to locate the running binary: %w", err)
+	}
+
+	checksums, err := download(releaseDownloadURL + "/" + tag + "/" + checksumsFile)
+	if err != nil {
+		return err
+	}
+	want, ok := findChecksum(checksums, asset)
+	if !ok {
+		return fmt.Errorf("release %s has no binary for %s/%s", tag, runtime.GOOS, runtime.GOARCH)
+	}
+	archive, err := download(releaseDownloadURL + "/" + tag + "/" + asset)
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(archive); hex.EncodeToString(sum[:]) != want {
+		return fmt.Errorf("checksum mismatch for %s: the download is corrupted or tampered with", asset)
+	}
+
+	binary, err := extractBinary(asset, archive)
+	if err != nil {
+		return err
+	}
+	if err := replaceExecutable(exe, binary); err != nil {
+		return err
+	}
+	fmt.Printf("  %s✓%s Updated ctxweaver %s to %s\n", co(internal.ColorGreen), co(internal.ColorReset), current, tag)
+	return nil
+}
+
+// latestRelease returns the tag of the latest release.
+func latestRelease() (string, error) {
+	data, err := download(releaseAPIURL)
+	if err != nil {
+		return "", err
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.Unmarshal(data, &release); err != nil || release.TagName == "" {
+		return "", fmt.Errorf("failed to read the latest release from %s", releaseAPIURL)
+	}
+	return release.TagName, nil
+}
+
+// releaseAsset returns the name of the release archive for a platform, as
+// published by GoReleaser (see .goreleaser.yaml).
+func releaseAsset(tag, goos, goarch string) string {
+	ext := "tar.gz"
+	if goos == "windows" {
+		ext = "zip"
+	}
+	return fmt.Sprintf("ctxweaver_%s_%s_%s.%s", strings.TrimPrefix(tag, "v"), goos, goarch, ext)
+}
+
+// download returns the content at url.
+func download(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	return data, nil
+}
+
+// findChecksum returns the hex SHA-256 checksum of name in a checksums file
+// in the format of sha256sum ("<checksum>  <name>" per line).
+func findChecksum(checksums []byte, name string) (string, bool) {
+	sc := bufio.NewScanner(bytes.NewReader(checksums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+	return "", false
+}
+
+// extractBinary returns the ctxweaver binary contained in a release archive.
+func extractBinary(asset string, archive []byte) ([]byte, error) {
+	if strings.HasSuffix(asset, ".zip") {
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", asset, err)
+		}
+		for _, f := range zr.File {
+			if path.Base(f.Name) != "ctxweaver.exe" {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", asset, err)
+			}
+			defer func() { _ = rc.Close() }()
+			return io.ReadAll(rc)
+		}
+		return nil, fmt.Errorf("%s does not contain ctxweaver.exe", asset)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", asset, err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s does not contain ctxweaver", asset)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", asset, err)
+		}
+		if h.Typeflag == tar.TypeReg && path.Base(h.Name) == "ctxweaver" {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// replaceExecutable atomically replaces the binary at exe with binary.
+func replaceExecutable(exe string, binary []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".ctxweaver-*")
+	if err != nil {
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(binary); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return fmt.Errorf("failed to write the new binary: %w", err)
+	}
+
+	// A running executable cannot be overwritten on Windows, but it can be renamed
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", exe, err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// releaseArchive returns a release archive for the current platform containing binary.
+func releaseArchive(t *testing.T, binary []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if runtime.GOOS == "windows" {
+		zw := zip.NewWriter(&buf)
+		w, err := zw.Create("ctxweaver.exe")
+		if err != nil {
+			t.Fatalf("failed to create archive: %v", err)
+		}
+		_, _ = w.Write(binary)
+		if err := zw.Close(); err != nil {
+			t.Fatalf("failed to create archive: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range map[string][]byte{"README.md": []byte("# ctxweaver\n"), "ctxweaver": binary} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("failed to create archive: %v", err)
+		}
+		_, _ = tw.Write(content)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRunSelfUpdate(t *testing.T) {
+	archive := releaseArchive(t, []byte("new binary"))
+	sum := sha256.Sum256(archive)
+	asset := releaseAsset("v1.4.0", runtime.GOOS, runtime.GOARCH)
+
+	tests := map[string]struct {
+		args      []string
+		version   string
+		checksums string
+		wantErr   string
+		want      string
+	}{
+		"latest release is installed": {
+			version:   "v1.3.0",
+			checksums: fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), asset),
+			want:      "new binary",
+		},
+		"given release is installed": {
+			args:      []string{"-version", "1.4.0"},
+			version:   "v1.3.0",
+			checksums: fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), asset),
+			want:      "new binary",
+		},
+		"up to date": {
+			version: "v1.4.0",
+			want:    "old binary",
+		},
+		"dry run": {
+			args:    []string{"-dry-run"},
+			version: "v1.3.0",
+			want:    "old binary",
+		},
+		"checksum mismatch": {
+			version:   "v1.3.0",
+			checksums: fmt.Sprintf("%s  %s\n", strings.Repeat("0", 64), asset),
+			wantErr:   "checksum mismatch",
+			want:      "old binary",
+		},
+		"no binary for the platform": {
+			version:   "v1.3.0",
+			checksums: fmt.Sprintf("%s  ctxweaver_1.4.0_plan9_mips.tar.gz\n", hex.EncodeToString(sum[:])),
+			wantErr:   "has no binary for",
+			want:      "old binary",
+		},
+		"invalid version": {
+			args:    []string{"-version", "latest"},
+			version: "v1.3.0",
+			wantErr: "invalid -version",
+			want:    "old binary",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/latest":
+					_, _ = w.Write([]byte(`{"tag_name": "v1.4.0"}`))
+				case "/download/v1.4.0/" + checksumsFile:
+					_, _ = w.Write([]byte(tt.checksums))
+				case "/download/v1.4.0/" + asset:
+					_, _ = w.Write(archive)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer srv.Close()
+
+			exe := filepath.Join(t.TempDir(), "ctxweaver")
+			if err := os.WriteFile(exe, []byte("old binary"), 0o755); err != nil {
+				t.Fatalf("failed to write binary: %v", err)
+			}
+
+			oldAPI, oldDownload, oldExecutable, oldVersion := releaseAPIURL, releaseDownloadURL, executablePath, version
+			defer func() {
+				releaseAPIURL, releaseDownloadURL, executablePath, version = oldAPI, oldDownload, oldExecutable, oldVersion
+			}()
+			releaseAPIURL = srv.URL + "/latest"
+			releaseDownloadURL = srv.URL + "/download"
+			executablePath = func() (string, error) { return exe, nil }
+			version = tt.version
+
+			var err error
+			captureStdout(t, func() { err = runSelfUpdate(tt.args) })
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			content, _ := os.ReadFile(exe)
+			if string(content) != tt.want {
+				t.Errorf("binary = %q, want %q", content, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mpyw/ctxweaver/internal"
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+// maxTransformRequest is the largest request body accepted by POST /transform.
+const maxTransformRequest = 10 << 20
+
+// transformRequest is the body of POST /transform. The fields other than
+// Source and Config are those of processor.TransformOptions.
+type transformRequest struct {
+	Source      string            `json:"source"`
+	Config      string            `json:"config,omitempty"` // YAML or JSON contents replacing the server config
+	Filename    string            `json:"filename,omitempty"`
+	PackagePath string            `json:"package_path"` // Required
+	PackageName string            `json:"package_name,omitempty"`
+	ModulePath  string            `json:"module_path,omitempty"`
+	Imports     map[string]string `json:"imports,omitempty"`
+}
+
+// transformResponse is the body of a successful POST /transform.
+type transformResponse struct {
+	Source  string `json:"source"`
+	Changed bool   `json:"changed"`
+}
+
+// errorResponse is the body of a failed request.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// runServe serves an HTTP API transforming single source files, for code-mod
+// platforms calling ctxweaver as a service: POST /transform takes the source
+// and optionally a config replacing the one of the server, and returns the
+// transformed source. Packages are not loaded: carriers are resolved from the
+// imports of the file (see processor.TransformFile). Hooks are not run.
+func runServe(args []string) error {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	opts := parseFlags(args)
+	if opts.dryRun || opts.output != "" || opts.verify {
+		return fmt.Errorf("serve cannot be combined with -dry-run, -output or -verify")
+	}
+	opts.verbose = false
+	opts.quiet = true
+
+	cfg, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	if opts.baseline, err = resolveBaseline(opts, cfg.Packages.Patterns, cfg.Test); err != nil {
+		return err
+	}
+	// Validate the server config once; processors are then created per request
+	if _, err := newServeProcessor(cfg, opts); err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           serveHandler(cfg, opts),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if !opts.silent {
+		fmt.Printf("%s▶ serving on %s%s\n", co(internal.ColorYellow), *addr, co(internal.ColorReset))
+	}
+	return server.ListenAndServe()
+}
+
+// serveHandler returns the handler of the HTTP API, transforming files with
+// cfg unless a request carries its own config.
+func serveHandler(cfg *config.Config, opts *options) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /transform", func(w http.ResponseWriter, r *http.Request) {
+		var req transformRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxTransformRequest)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+			return
+		}
+		if req.PackagePath == "" {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request: package_path is required"})
+			return
+		}
+
+		reqCfg := cfg
+		if req.Config != "" {
+			var err error
+			if reqCfg, err = requestConfig(req.Config); err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+				return
+			}
+		}
+		proc, err := newServeProcessor(reqCfg, opts)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+			return
+		}
+
+		out, changed, err := proc.TransformFile([]byte(req.Source), processor.TransformOptions{
+			PkgPath:    req.PackagePath,
+			PkgName:    req.PackageName,
+			Imports:    req.Imports,
+			ModulePath: req.ModulePath,
+			Filename:   req.Filename,
+		})
+		if err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, transformResponse{Source: string(out), Changed: changed})
+	})
+	return mux
+}
+
+// requestConfig parses the config of a request, rejecting the options that
+// would read or run anything on the server: files and plugins.
+func requestConfig(content string) (*config.Config, error) {
+	cfg, err := config.ParseConfig("request.yaml", []byte(content), checkTemplates)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.CheckMinVersion(binaryVersion()); err != nil {
+		return nil, err
+	}
+	if len(cfg.Plugins) > 0 {
+		return nil, errors.New("invalid config: plugins are not allowed in requests")
+	}
+	if cfg.Carriers.File != "" {
+		return nil, errors.New("invalid config: carriers.file is not allowed in requests")
+	}
+	templates := []*config.Template{&cfg.Template, cfg.Epilogue, cfg.HotTemplate}
+	for _, o := range cfg.Overrides {
+		templates = append(templates, o.Template, o.Epilogue)
+	}
+	for _, sf := range cfg.SpecialFuncs.All() {
+		templates = append(templates, sf.Template)
+	}
+	for _, t := range templates {
+		if t != nil && t.File != "" {
+			return nil, errors.New("invalid config: template files are not allowed in requests")
+		}
+	}
+	return cfg, nil
+}
+
+// newServeProcessor creates the processor transforming a file with cfg.
+func newServeProcessor(cfg *config.Config, opts *options) (*processor.Processor, error) {
+	tmplContent, err := cfg.Template.Content()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get template: %w", err)
+	}
+	tmpl, err := parseTemplate(tmplContent)
+	if err != nil {
+		return nil, err
+	}
+	return createProcessor(cfg, tmpl, opts)
+}
+
+// writeJSON writes v as the JSON body of a response with the status code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
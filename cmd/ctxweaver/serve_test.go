@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+func TestServeHandler(t *testing.T) {
+	cfg, err := config.ParseConfig("ctxweaver.yaml", []byte(`template: "defer trace({{.Ctx}})"
+packages:
+  patterns:
+    - ./...
+`))
+	if err != nil {
+		t.Fatalf("ParseConfig() error = %v", err)
+	}
+	server := httptest.NewServer(serveHandler(cfg, &options{}))
+	defer server.Close()
+
+	src := `package service
+
+import "context"
+
+func Get(ctx context.Context) {
+}
+`
+
+	tests := map[string]struct {
+		body     string
+		wantCode int
+		want     transformResponse
+		wantErr  string
+	}{
+		"server config": {
+			body:     mustJSON(t, transformRequest{Source: src, PackagePath: "example.com/app/service"}),
+			wantCode: http.StatusOK,
+			want: transformResponse{Changed: true, Source: `package service
+
+import "context"
+
+func Get(ctx context.Context) {
+	defer trace(ctx)
+
+}
+`},
+		},
+		"request config": {
+			body: mustJSON(t, transformRequest{
+				Source:      src,
+				PackagePath: "example.com/app/service",
+				Config:      "template: \"defer span({{.Ctx}}, {{.FuncName | quote}})\"\npackages:\n  patterns:\n    - ./...\n",
+			}),
+			wantCode: http.StatusOK,
+			want: transformResponse{Changed: true, Source: `package service
+
+import "context"
+
+func Get(ctx context.Context) {
+	defer span(ctx, "service.Get")
+
+}
+`},
+		},
+		"unchanged source": {
+			body:     mustJSON(t, transformRequest{Source: "package service\n", PackagePath: "example.com/app/service"}),
+			wantCode: http.StatusOK,
+			want:     transformResponse{Source: "package service\n"},
+		},
+		"malformed request": {
+			body:     "{",
+			wantCode: http.StatusBadRequest,
+			wantErr:  "invalid request",
+		},
+		"missing package path": {
+			body:     mustJSON(t, transformRequest{Source: src}),
+			wantCode: http.StatusBadRequest,
+			wantErr:  "package_path is required",
+		},
+		"invalid request config": {
+			body:     mustJSON(t, transformRequest{Source: src, PackagePath: "example.com/app/service", Config: "template: 1\n"}),
+			wantCode: http.StatusBadRequest,
+			wantErr:  "invalid config",
+		},
+		"template file in request config": {
+			body:     mustJSON(t, transformRequest{Source: src, PackagePath: "example.com/app/service", Config: "template:\n  file: /etc/passwd\npackages:\n  patterns:\n    - ./...\n"}),
+			wantCode: http.StatusBadRequest,
+			wantErr:  "template files are not allowed in requests",
+		},
+		"plugins in request config": {
+			body:     mustJSON(t, transformRequest{Source: src, PackagePath: "example.com/app/service", Config: "template: \"trace()\"\nplugins:\n  - exec: ./veto.sh\npackages:\n  patterns:\n    - ./...\n"}),
+			wantCode: http.StatusBadRequest,
+			wantErr:  "plugins are not allowed in requests",
+		},
+		"invalid source": {
+			body:     mustJSON(t, transformRequest{Source: "package", PackagePath: "example.com/app/service"}),
+			wantCode: http.StatusUnprocessableEntity,
+			wantErr:  "failed to parse source",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+"/transform", "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("POST /transform error = %v", err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantCode)
+			}
+			if tt.wantErr != "" {
+				var got errorResponse
+				if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+					t.Fatalf("invalid error response: %v", err)
+				}
+				if !strings.Contains(got.Error, tt.wantErr) {
+					t.Errorf("error = %q, want containing %q", got.Error, tt.wantErr)
+				}
+				return
+			}
+			var got transformResponse
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatalf("invalid response: %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("response mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("other methods", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/transform")
+		if err != nil {
+			t.Fatalf("GET /transform error = %v", err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func mustJSON(t *testing.T, v any) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
@@ -0,0 +1,20 @@
+package main
+
+import "runtime/debug"
+
+// version is the version of ctxweaver, set at build time with
+// -ldflags "-X main.version=v1.2.3". When empty, the version of the main
+// module recorded by go install is used instead.
+var version string
+
+// binaryVersion returns the version of the running ctxweaver, or "(devel)"
+// for builds from a source checkout.
+func binaryVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
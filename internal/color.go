@@ -3,6 +3,7 @@ package internal
 
 import (
 	"os"
+	"strings"
 
 	"golang.org/x/term"
 )
@@ -22,18 +23,88 @@ var (
 	stderrIsTTY = term.IsTerminal(int(os.Stderr.Fd()))
 )
 
-// StdoutColor returns the color code if stdout is a TTY, otherwise empty string.
+// ciVars are environment variables set by CI services, in addition to CI.
+var ciVars = []string{"GITHUB_ACTIONS", "GITLAB_CI", "BUILDKITE", "CIRCLECI", "TF_BUILD", "JENKINS_URL"}
+
+// StdoutColor returns the color code if colors are enabled on stdout, otherwise empty string.
 func StdoutColor(color string) string {
-	if stdoutIsTTY {
+	if colorEnabled(stdoutIsTTY) {
 		return color
 	}
 	return ""
 }
 
-// StderrColor returns the color code if stderr is a TTY, otherwise empty string.
+// StderrColor returns the color code if colors are enabled on stderr, otherwise empty string.
 func StderrColor(color string) string {
-	if stderrIsTTY {
+	if colorEnabled(stderrIsTTY) {
 		return color
 	}
 	return ""
 }
+
+// colorEnabled reports whether colors are enabled on a stream. NO_COLOR
+// disables them and FORCE_COLOR enables them (see no-color.org and
+// force-color.org); otherwise the stream must be a terminal other than TERM=dumb.
+func colorEnabled(isTTY bool) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if force := os.Getenv("FORCE_COLOR"); force != "" {
+		return force != "0" && !strings.EqualFold(force, "false")
+	}
+	return isTTY && os.Getenv("TERM") != "dumb"
+}
+
+// IsCI reports whether ctxweaver runs in a CI service, whose logs are
+// captured even when attached to a pseudo-terminal.
+func IsCI() bool {
+	if ci := os.Getenv("CI"); ci != "" {
+		return ci != "0" && !strings.EqualFold(ci, "false")
+	}
+	for _, v := range ciVars {
+		if os.Getenv(v) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// StderrIsInteractive reports whether stderr is a terminal watched by a user,
+// where transient output such as a progress line can be shown: a terminal
+// outside CI.
+func StderrIsInteractive() bool {
+	return stderrIsTTY && !IsCI()
+}
+
+// ColorDiff colors the lines of a unified diff with color, which is
+// StdoutColor or StderrColor depending on where the diff is written:
+// additions in green, removals in red and hunk headers in cyan.
+func ColorDiff(diff string, color func(string) string) string {
+	if color(ColorReset) == "" {
+		return diff
+	}
+	var sb strings.Builder
+	for line := range strings.Lines(diff) {
+		var c string
+		switch {
+		case strings.HasPrefix(line, "+++ "), strings.HasPrefix(line, "--- "), strings.HasPrefix(line, "diff "):
+			c = ColorDim
+		case strings.HasPrefix(line, "+"):
+			c = ColorGreen
+		case strings.HasPrefix(line, "-"):
+			c = ColorRed
+		case strings.HasPrefix(line, "@@"):
+			c = ColorCyan
+		}
+		if c == "" {
+			sb.WriteString(line)
+			continue
+		}
+		text, newline := strings.CutSuffix(line, "\n")
+		sb.WriteString(c + text + ColorReset)
+		if newline {
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
@@ -4,11 +4,20 @@ import (
 	"testing"
 )
 
+// clearColorEnv unsets the environment variables affecting colors.
+func clearColorEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("FORCE_COLOR", "")
+	t.Setenv("TERM", "xterm-256color")
+}
+
 func TestStdoutColor(t *testing.T) {
 	orig := stdoutIsTTY
 	defer func() { stdoutIsTTY = orig }()
 
 	t.Run("TTY", func(t *testing.T) {
+		clearColorEnv(t)
 		stdoutIsTTY = true
 		if got := StdoutColor(ColorGreen); got != ColorGreen {
 			t.Errorf("StdoutColor(ColorGreen) = %q, want %q", got, ColorGreen)
@@ -16,6 +25,7 @@ func TestStdoutColor(t *testing.T) {
 	})
 
 	t.Run("not TTY", func(t *testing.T) {
+		clearColorEnv(t)
 		stdoutIsTTY = false
 		if got := StdoutColor(ColorGreen); got != "" {
 			t.Errorf("StdoutColor(ColorGreen) = %q, want empty", got)
@@ -28,6 +38,7 @@ func TestStderrColor(t *testing.T) {
 	defer func() { stderrIsTTY = orig }()
 
 	t.Run("TTY", func(t *testing.T) {
+		clearColorEnv(t)
 		stderrIsTTY = true
 		if got := StderrColor(ColorRed); got != ColorRed {
 			t.Errorf("StderrColor(ColorRed) = %q, want %q", got, ColorRed)
@@ -35,9 +46,90 @@ func TestStderrColor(t *testing.T) {
 	})
 
 	t.Run("not TTY", func(t *testing.T) {
+		clearColorEnv(t)
 		stderrIsTTY = false
 		if got := StderrColor(ColorRed); got != "" {
 			t.Errorf("StderrColor(ColorRed) = %q, want empty", got)
 		}
 	})
 }
+
+func TestColorEnabled(t *testing.T) {
+	tests := map[string]struct {
+		env   map[string]string
+		isTTY bool
+		want  bool
+	}{
+		"terminal":                     {isTTY: true, want: true},
+		"not a terminal":               {isTTY: false, want: false},
+		"NO_COLOR":                     {env: map[string]string{"NO_COLOR": "1"}, isTTY: true, want: false},
+		"FORCE_COLOR":                  {env: map[string]string{"FORCE_COLOR": "1"}, isTTY: false, want: true},
+		"FORCE_COLOR=0":                {env: map[string]string{"FORCE_COLOR": "0"}, isTTY: true, want: false},
+		"NO_COLOR wins":                {env: map[string]string{"NO_COLOR": "1", "FORCE_COLOR": "1"}, isTTY: true, want: false},
+		"dumb terminal":                {env: map[string]string{"TERM": "dumb"}, isTTY: true, want: false},
+		"FORCE_COLOR on dumb terminal": {env: map[string]string{"TERM": "dumb", "FORCE_COLOR": "true"}, isTTY: true, want: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			clearColorEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if got := colorEnabled(tt.isTTY); got != tt.want {
+				t.Errorf("colorEnabled(%v) = %v, want %v", tt.isTTY, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCI(t *testing.T) {
+	tests := map[string]struct {
+		env  map[string]string
+		want bool
+	}{
+		"local":          {want: false},
+		"CI":             {env: map[string]string{"CI": "true"}, want: true},
+		"CI=false":       {env: map[string]string{"CI": "false", "GITHUB_ACTIONS": "true"}, want: false},
+		"GitHub Actions": {env: map[string]string{"GITHUB_ACTIONS": "true"}, want: true},
+		"Jenkins":        {env: map[string]string{"JENKINS_URL": "https://ci.example.com/"}, want: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("CI", "")
+			for _, v := range ciVars {
+				t.Setenv(v, "")
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if got := IsCI(); got != tt.want {
+				t.Errorf("IsCI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestColorDiff(t *testing.T) {
+	const diff = "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n@@ -1,2 +1,2 @@\n package a\n-old\n+new\n"
+
+	t.Run("colored", func(t *testing.T) {
+		want := ColorDim + "diff --git a/a.go b/a.go" + ColorReset + "\n" +
+			ColorDim + "--- a/a.go" + ColorReset + "\n" +
+			ColorDim + "+++ b/a.go" + ColorReset + "\n" +
+			ColorCyan + "@@ -1,2 +1,2 @@" + ColorReset + "\n" +
+			" package a\n" +
+			ColorRed + "-old" + ColorReset + "\n" +
+			ColorGreen + "+new" + ColorReset + "\n"
+		if got := ColorDiff(diff, func(c string) string { return c }); got != want {
+			t.Errorf("ColorDiff() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("colors disabled", func(t *testing.T) {
+		if got := ColorDiff(diff, func(string) string { return "" }); got != diff {
+			t.Errorf("ColorDiff() = %q, want %q", got, diff)
+		}
+	})
+}
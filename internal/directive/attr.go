@@ -0,0 +1,39 @@
+package directive
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+const attrDirective = "ctxweaver:attr"
+
+// attrKey matches the keys of attributes, usable as {{.Attrs.key}}.
+var attrKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Attrs returns the attributes given by //ctxweaver:attr directives among the
+// decorations above a function, each holding space-separated key=value pairs
+// (e.g. "//ctxweaver:attr tier=critical slo=99.9"), or nil if there are none.
+// Pairs whose key is not an identifier are ignored; later pairs win.
+func Attrs(decs *dst.NodeDecs) map[string]string {
+	var attrs map[string]string
+	for _, c := range decs.Start.All() {
+		text := strings.TrimSpace(strings.TrimPrefix(c, "//"))
+		rest, ok := strings.CutPrefix(text, attrDirective+" ")
+		if !ok {
+			continue
+		}
+		for _, pair := range strings.Fields(rest) {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || !attrKey.MatchString(key) {
+				continue
+			}
+			if attrs == nil {
+				attrs = make(map[string]string)
+			}
+			attrs[key] = value
+		}
+	}
+	return attrs
+}
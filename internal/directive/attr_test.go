@@ -0,0 +1,57 @@
+package directive
+
+import (
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAttrs(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		start []string
+		want  map[string]string
+	}{
+		"none": {
+			start: []string{"// Foo does things."},
+			want:  nil,
+		},
+		"single pair": {
+			start: []string{"// Foo does things.", "//ctxweaver:attr tier=critical"},
+			want:  map[string]string{"tier": "critical"},
+		},
+		"several pairs and directives": {
+			start: []string{"//ctxweaver:attr tier=critical slo=99.9", "// ctxweaver:attr owner=payments"},
+			want:  map[string]string{"tier": "critical", "slo": "99.9", "owner": "payments"},
+		},
+		"empty value": {
+			start: []string{"//ctxweaver:attr tier="},
+			want:  map[string]string{"tier": ""},
+		},
+		"invalid pairs": {
+			start: []string{"//ctxweaver:attr tier 1st=x my-key=y ok=z"},
+			want:  map[string]string{"ok": "z"},
+		},
+		"later wins": {
+			start: []string{"//ctxweaver:attr tier=low", "//ctxweaver:attr tier=critical"},
+			want:  map[string]string{"tier": "critical"},
+		},
+		"partial match": {
+			start: []string{"//ctxweaver:attrs tier=critical"},
+			want:  nil,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			decs := &dst.NodeDecs{Start: tt.start}
+			if diff := cmp.Diff(tt.want, Attrs(decs)); diff != "" {
+				t.Errorf("Attrs() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
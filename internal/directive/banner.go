@@ -0,0 +1,36 @@
+package directive
+
+import "github.com/dave/dst"
+
+// Banner is the comment written at the top of files containing generated
+// statements when the banner option is enabled.
+const Banner = "// Instrumented by ctxweaver; DO NOT EDIT generated statements."
+
+// AddBanner adds the banner, followed by an empty line, at the top of the file.
+// Returns false if the file already starts with it.
+func AddBanner(f *dst.File) bool {
+	if hasBanner(f) {
+		return false
+	}
+	f.Decs.Start.Prepend(Banner, "\n")
+	return true
+}
+
+// RemoveBanner removes the banner at the top of the file along with the empty
+// line following it. Returns false if the file does not start with it.
+func RemoveBanner(f *dst.File) bool {
+	if !hasBanner(f) {
+		return false
+	}
+	n := 1
+	if len(f.Decs.Start) > 1 && f.Decs.Start[1] == "\n" {
+		n = 2
+	}
+	f.Decs.Start.Replace(f.Decs.Start[n:]...)
+	return true
+}
+
+// hasBanner reports whether the file starts with the banner.
+func hasBanner(f *dst.File) bool {
+	return len(f.Decs.Start) > 0 && f.Decs.Start[0] == Banner
+}
@@ -0,0 +1,92 @@
+package directive
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestAddBanner(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		start []string
+		want  []string
+		added bool
+	}{
+		"empty start": {
+			start: nil,
+			want:  []string{Banner, "\n"},
+			added: true,
+		},
+		"before package doc": {
+			start: []string{"// Package foo does things."},
+			want:  []string{Banner, "\n", "// Package foo does things."},
+			added: true,
+		},
+		"already present": {
+			start: []string{Banner, "\n", "// Package foo does things."},
+			want:  []string{Banner, "\n", "// Package foo does things."},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := &dst.File{}
+			f.Decs.Start = tt.start
+			if got := AddBanner(f); got != tt.added {
+				t.Errorf("AddBanner() = %v, want %v", got, tt.added)
+			}
+			if !slices.Equal(f.Decs.Start.All(), tt.want) {
+				t.Errorf("Start = %q, want %q", f.Decs.Start.All(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveBanner(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		start   []string
+		want    []string
+		removed bool
+	}{
+		"with empty line": {
+			start:   []string{Banner, "\n", "//go:build linux"},
+			want:    []string{"//go:build linux"},
+			removed: true,
+		},
+		"without empty line": {
+			start:   []string{Banner},
+			want:    []string{},
+			removed: true,
+		},
+		"absent": {
+			start: []string{"// Copyright"},
+			want:  []string{"// Copyright"},
+		},
+		"not at the top": {
+			start: []string{"// Copyright", "\n", Banner},
+			want:  []string{"// Copyright", "\n", Banner},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f := &dst.File{}
+			f.Decs.Start = tt.start
+			if got := RemoveBanner(f); got != tt.removed {
+				t.Errorf("RemoveBanner() = %v, want %v", got, tt.removed)
+			}
+			if !slices.Equal(f.Decs.Start.All(), tt.want) {
+				t.Errorf("Start = %q, want %q", f.Decs.Start.All(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package directive
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+const generatedDirective = "ctxweaver:generated"
+
+// GeneratedMarker is the default trailing comment appended to the last
+// generated statement in marker matching mode.
+const GeneratedMarker = "//" + generatedDirective
+
+// hashPrefix precedes the template hash recorded by the generated marker.
+const hashPrefix = "sha="
+
+// countPrefix precedes the number of statements recorded by the generated marker.
+const countPrefix = "n="
+
+// runPrefix precedes the identifier of the run recorded by the generated marker.
+const runPrefix = "run="
+
+// NormalizeMarker returns the comment of a generated marker configured as
+// marker, with or without the leading "//" (e.g. "managed-by: obs-platform"
+// gives "// managed-by: obs-platform"). An empty marker gives GeneratedMarker.
+func NormalizeMarker(marker string) string {
+	marker = strings.TrimSpace(marker)
+	switch {
+	case marker == "":
+		return GeneratedMarker
+	case strings.HasPrefix(marker, "//"):
+		return marker
+	default:
+		return "// " + marker
+	}
+}
+
+// GeneratedMarkerWithHash returns the generated marker recording the hash of
+// the template the statements were generated from (e.g. "//ctxweaver:generated sha=3f2a9c1e").
+// marker is the configured marker, or empty for GeneratedMarker.
+func GeneratedMarkerWithHash(marker, hash string) string {
+	return NormalizeMarker(marker) + " " + hashPrefix + hash
+}
+
+// GeneratedMarkerWithAttrs returns the generated marker recording the hash of
+// the template, the number of generated statements it ends and the identifier
+// of the run that inserted them, if any
+// (e.g. "//ctxweaver:generated sha=3f2a9c1e n=2 run=2024-06-01T12:00").
+func GeneratedMarkerWithAttrs(marker, hash string, count int, run string) string {
+	text := GeneratedMarkerWithHash(marker, hash) + " " + countPrefix + strconv.Itoa(count)
+	if run != "" {
+		text += " " + runPrefix + run
+	}
+	return text
+}
+
+// markerDirective returns the text of a marker comment without the comment
+// prefix, e.g. "ctxweaver:generated".
+func markerDirective(marker string) string {
+	return strings.TrimSpace(strings.TrimPrefix(NormalizeMarker(marker), "//"))
+}
+
+// generatedAttrs are the attributes recorded by a generated marker; empty
+// if it has none.
+type generatedAttrs struct {
+	hash  string
+	count int
+	run   string
+}
+
+// parseGeneratedComment checks if a comment text is the generated marker
+// marker, and returns the attributes it records.
+// Supports both "//ctxweaver:generated" and "// ctxweaver:generated".
+func parseGeneratedComment(text, marker string) (generatedAttrs, bool) {
+	text = strings.TrimPrefix(text, "//")
+	text = strings.TrimSpace(text)
+	rest, ok := strings.CutPrefix(text, markerDirective(marker))
+	if !ok || rest != "" && rest[0] != ' ' {
+		return generatedAttrs{}, false
+	}
+	var attrs generatedAttrs
+	for _, field := range strings.Fields(rest) {
+		if hash, ok := strings.CutPrefix(field, hashPrefix); ok {
+			attrs.hash = hash
+		} else if count, ok := strings.CutPrefix(field, countPrefix); ok {
+			n, err := strconv.Atoi(count)
+			if err != nil || n < 1 {
+				return generatedAttrs{}, false
+			}
+			attrs.count = n
+		} else if run, ok := strings.CutPrefix(field, runPrefix); ok {
+			attrs.run = run
+		} else {
+			return generatedAttrs{}, false
+		}
+	}
+	return attrs, true
+}
+
+// isGeneratedComment checks if a comment text is the default generated marker.
+func isGeneratedComment(text string) bool {
+	_, ok := parseGeneratedComment(text, "")
+	return ok
+}
+
+// HasGeneratedMarker checks if a statement carries a trailing generated
+// marker: marker, or the default one for backward compatibility.
+func HasGeneratedMarker(stmt dst.Stmt, marker string) bool {
+	_, ok := GeneratedHash(stmt, marker)
+	return ok
+}
+
+// GeneratedHash returns the template hash recorded by the trailing generated
+// marker of a statement: marker, or the default one for backward compatibility.
+// The hash is empty for markers without one.
+func GeneratedHash(stmt dst.Stmt, marker string) (hash string, ok bool) {
+	attrs, ok := generatedMarkerAttrs(stmt, marker)
+	return attrs.hash, ok
+}
+
+// GeneratedCount returns the number of generated statements ended by the
+// statement, as recorded by its trailing generated marker: marker, or the
+// default one for backward compatibility. It is 0 for statements without one,
+// such as those marked before the count was recorded.
+func GeneratedCount(stmt dst.Stmt, marker string) int {
+	attrs, _ := generatedMarkerAttrs(stmt, marker)
+	return attrs.count
+}
+
+// GeneratedRun returns the identifier of the run recorded by the trailing
+// generated marker of a statement: marker, or the default one for backward
+// compatibility. It is empty for statements without one.
+func GeneratedRun(stmt dst.Stmt, marker string) string {
+	attrs, _ := generatedMarkerAttrs(stmt, marker)
+	return attrs.run
+}
+
+// generatedMarkerAttrs returns the attributes recorded by the trailing
+// generated marker of a statement: marker, or the default one.
+func generatedMarkerAttrs(stmt dst.Stmt, marker string) (generatedAttrs, bool) {
+	for _, c := range stmt.Decorations().End.All() {
+		if attrs, ok := parseGeneratedComment(c, marker); ok {
+			return attrs, true
+		}
+		if attrs, ok := parseGeneratedComment(c, ""); ok {
+			return attrs, true
+		}
+	}
+	return generatedAttrs{}, false
+}
+
+// MarkedWith reports whether a statement carries marker itself as a trailing
+// generated marker, as opposed to the default one recognized in its place.
+func MarkedWith(stmt dst.Stmt, marker string) bool {
+	for _, c := range stmt.Decorations().End.All() {
+		if _, ok := parseGeneratedComment(c, marker); ok {
+			return true
+		}
+	}
+	return false
+}
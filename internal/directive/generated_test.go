@@ -0,0 +1,324 @@
+package directive
+
+import (
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestIsGeneratedComment(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		input string
+		want  bool
+	}{
+		"exact match without space": {
+			input: "//ctxweaver:generated",
+			want:  true,
+		},
+		"exact match with space": {
+			input: "// ctxweaver:generated",
+			want:  true,
+		},
+		"with template hash": {
+			input: "//ctxweaver:generated sha=3f2a9c1e",
+			want:  true,
+		},
+		"with trailing content": {
+			input: "//ctxweaver:generated by hand",
+			want:  false,
+		},
+		"skip directive": {
+			input: "//ctxweaver:skip",
+			want:  false,
+		},
+		"empty comment": {
+			input: "//",
+			want:  false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := isGeneratedComment(tt.input); got != tt.want {
+				t.Errorf("isGeneratedComment(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasGeneratedMarker(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		stmt dst.Stmt
+		want bool
+	}{
+		"marker in End decoration": {
+			stmt: &dst.ExprStmt{
+				X: &dst.Ident{Name: "foo"},
+				Decs: dst.ExprStmtDecorations{
+					NodeDecs: dst.NodeDecs{
+						End: dst.Decorations{GeneratedMarker},
+					},
+				},
+			},
+			want: true,
+		},
+		"marker in Start decoration is ignored": {
+			stmt: &dst.ExprStmt{
+				X: &dst.Ident{Name: "foo"},
+				Decs: dst.ExprStmtDecorations{
+					NodeDecs: dst.NodeDecs{
+						Start: dst.Decorations{GeneratedMarker},
+					},
+				},
+			},
+			want: false,
+		},
+		"empty decorations": {
+			stmt: &dst.ExprStmt{
+				X: &dst.Ident{Name: "foo"},
+			},
+			want: false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := HasGeneratedMarker(tt.stmt, ""); got != tt.want {
+				t.Errorf("HasGeneratedMarker() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratedHash(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		marker   string
+		wantHash string
+		wantOK   bool
+	}{
+		"marker with hash": {
+			marker:   GeneratedMarkerWithHash("", "3f2a9c1e"),
+			wantHash: "3f2a9c1e",
+			wantOK:   true,
+		},
+		"marker without hash": {
+			marker: GeneratedMarker,
+			wantOK: true,
+		},
+		"other comment": {
+			marker: "// trace",
+			wantOK: false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			stmt := &dst.ExprStmt{
+				X: &dst.Ident{Name: "foo"},
+				Decs: dst.ExprStmtDecorations{
+					NodeDecs: dst.NodeDecs{
+						End: dst.Decorations{tt.marker},
+					},
+				},
+			}
+			hash, ok := GeneratedHash(stmt, "")
+			if hash != tt.wantHash || ok != tt.wantOK {
+				t.Errorf("GeneratedHash() = (%q, %v), want (%q, %v)", hash, ok, tt.wantHash, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGeneratedRun(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		marker   string
+		wantHash string
+		wantRun  string
+		wantOK   bool
+	}{
+		"marker with hash and run": {
+			marker:   GeneratedMarkerWithAttrs("", "3f2a9c1e", 1, "2024-06-01T12:00"),
+			wantHash: "3f2a9c1e",
+			wantRun:  "2024-06-01T12:00",
+			wantOK:   true,
+		},
+		"marker without run": {
+			marker:   GeneratedMarkerWithAttrs("", "3f2a9c1e", 1, ""),
+			wantHash: "3f2a9c1e",
+			wantOK:   true,
+		},
+		"marker with run only": {
+			marker:  "//ctxweaver:generated run=r1",
+			wantRun: "r1",
+			wantOK:  true,
+		},
+		"marker with unknown attribute": {
+			marker: "//ctxweaver:generated sha=3f2a9c1e by=someone",
+			wantOK: false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			stmt := &dst.ExprStmt{
+				X: &dst.Ident{Name: "foo"},
+				Decs: dst.ExprStmtDecorations{
+					NodeDecs: dst.NodeDecs{
+						End: dst.Decorations{tt.marker},
+					},
+				},
+			}
+			hash, ok := GeneratedHash(stmt, "")
+			if hash != tt.wantHash || ok != tt.wantOK {
+				t.Errorf("GeneratedHash() = (%q, %v), want (%q, %v)", hash, ok, tt.wantHash, tt.wantOK)
+			}
+			if run := GeneratedRun(stmt, ""); run != tt.wantRun {
+				t.Errorf("GeneratedRun() = %q, want %q", run, tt.wantRun)
+			}
+		})
+	}
+}
+
+func TestGeneratedCount(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		marker    string
+		wantCount int
+		wantOK    bool
+	}{
+		"marker with count": {
+			marker:    GeneratedMarkerWithAttrs("", "3f2a9c1e", 3, "r1"),
+			wantCount: 3,
+			wantOK:    true,
+		},
+		"marker without count": {
+			marker: GeneratedMarkerWithHash("", "3f2a9c1e"),
+			wantOK: true,
+		},
+		"invalid count": {
+			marker: "//ctxweaver:generated sha=3f2a9c1e n=0",
+			wantOK: false,
+		},
+		"malformed count": {
+			marker: "//ctxweaver:generated n=two",
+			wantOK: false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			stmt := &dst.ExprStmt{
+				X: &dst.Ident{Name: "foo"},
+				Decs: dst.ExprStmtDecorations{
+					NodeDecs: dst.NodeDecs{
+						End: dst.Decorations{tt.marker},
+					},
+				},
+			}
+			if ok := HasGeneratedMarker(stmt, ""); ok != tt.wantOK {
+				t.Errorf("HasGeneratedMarker() = %v, want %v", ok, tt.wantOK)
+			}
+			if count := GeneratedCount(stmt, ""); count != tt.wantCount {
+				t.Errorf("GeneratedCount() = %d, want %d", count, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestNormalizeMarker(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		marker string
+		want   string
+	}{
+		"default":              {marker: "", want: GeneratedMarker},
+		"comment":              {marker: "// managed-by: obs-platform", want: "// managed-by: obs-platform"},
+		"directive":            {marker: "//obs:managed", want: "//obs:managed"},
+		"without comment mark": {marker: "managed-by: obs-platform", want: "// managed-by: obs-platform"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := NormalizeMarker(tt.marker); got != tt.want {
+				t.Errorf("NormalizeMarker(%q) = %q, want %q", tt.marker, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratedHash_CustomMarker(t *testing.T) {
+	t.Parallel()
+
+	const custom = "// managed-by: obs-platform"
+
+	tests := map[string]struct {
+		comment    string
+		wantHash   string
+		wantOK     bool
+		wantMarked bool
+	}{
+		"custom marker with hash": {
+			comment:    GeneratedMarkerWithHash(custom, "3f2a9c1e"),
+			wantHash:   "3f2a9c1e",
+			wantOK:     true,
+			wantMarked: true,
+		},
+		"custom marker without space": {
+			comment:    "//managed-by: obs-platform",
+			wantOK:     true,
+			wantMarked: true,
+		},
+		"default marker is recognized": {
+			comment:  GeneratedMarkerWithHash("", "3f2a9c1e"),
+			wantHash: "3f2a9c1e",
+			wantOK:   true,
+		},
+		"other owner": {
+			comment: "// managed-by: obs-platform-v2",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			stmt := &dst.ExprStmt{
+				X: &dst.Ident{Name: "foo"},
+				Decs: dst.ExprStmtDecorations{
+					NodeDecs: dst.NodeDecs{
+						End: dst.Decorations{tt.comment},
+					},
+				},
+			}
+			hash, ok := GeneratedHash(stmt, custom)
+			if hash != tt.wantHash || ok != tt.wantOK {
+				t.Errorf("GeneratedHash() = (%q, %v), want (%q, %v)", hash, ok, tt.wantHash, tt.wantOK)
+			}
+			if got := MarkedWith(stmt, custom); got != tt.wantMarked {
+				t.Errorf("MarkedWith() = %v, want %v", got, tt.wantMarked)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package directive
+
+import (
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+const nameDirective = "ctxweaver:name"
+
+// FuncName returns the name given by a //ctxweaver:name directive among the
+// decorations above a function (e.g. "checkout.CreateOrder" for
+// "//ctxweaver:name checkout.CreateOrder"), or an empty string if there is
+// none. The last directive wins.
+func FuncName(decs *dst.NodeDecs) string {
+	var name string
+	for _, c := range decs.Start.All() {
+		text := strings.TrimSpace(strings.TrimPrefix(c, "//"))
+		rest, ok := strings.CutPrefix(text, nameDirective+" ")
+		if !ok {
+			continue
+		}
+		if fields := strings.Fields(rest); len(fields) > 0 {
+			name = fields[0]
+		}
+	}
+	return name
+}
@@ -0,0 +1,56 @@
+package directive
+
+import (
+	"testing"
+
+	"github.com/dave/dst"
+)
+
+func TestFuncName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		start []string
+		want  string
+	}{
+		"none": {
+			start: []string{"// Foo does things."},
+			want:  "",
+		},
+		"without space": {
+			start: []string{"// Foo does things.", "//ctxweaver:name checkout.CreateOrder"},
+			want:  "checkout.CreateOrder",
+		},
+		"with space": {
+			start: []string{"// ctxweaver:name checkout.CreateOrder"},
+			want:  "checkout.CreateOrder",
+		},
+		"with trailing content": {
+			start: []string{"//ctxweaver:name checkout.CreateOrder pinned for dashboards"},
+			want:  "checkout.CreateOrder",
+		},
+		"without name": {
+			start: []string{"//ctxweaver:name"},
+			want:  "",
+		},
+		"partial match": {
+			start: []string{"//ctxweaver:names checkout.CreateOrder"},
+			want:  "",
+		},
+		"last wins": {
+			start: []string{"//ctxweaver:name first", "//ctxweaver:name second"},
+			want:  "second",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			decs := &dst.NodeDecs{Start: tt.start}
+			if got := FuncName(decs); got != tt.want {
+				t.Errorf("FuncName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
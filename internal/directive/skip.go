@@ -7,7 +7,11 @@ import (
 	"github.com/dave/dst"
 )
 
-const skipDirective = "ctxweaver:skip"
+const (
+	skipDirective = "ctxweaver:skip"
+	offDirective  = "ctxweaver:off"
+	onDirective   = "ctxweaver:on"
+)
 
 // isSkipComment checks if a comment text is a skip directive.
 // Supports both "//ctxweaver:skip" and "// ctxweaver:skip".
@@ -44,3 +48,43 @@ func HasStmtSkipDirective(stmt dst.Stmt) bool {
 	}
 	return false
 }
+
+// isRegionComment checks if a comment text is the region directive d,
+// optionally followed by an explanation (e.g. "//ctxweaver:off generated code").
+func isRegionComment(text, d string) bool {
+	text = strings.TrimPrefix(text, "//")
+	text = strings.TrimSpace(text)
+	return text == d || strings.HasPrefix(text, d+" ")
+}
+
+// OffDecls returns the top-level declarations of f inside a region starting
+// with a //ctxweaver:off directive and ending with a //ctxweaver:on directive,
+// or the end of the file. The directives are comments between declarations,
+// read in source order; a declaration preceded by //ctxweaver:off is inside
+// the region.
+func OffDecls(f *dst.File) map[dst.Decl]bool {
+	off := false
+	update := func(decs dst.Decorations) {
+		for _, c := range decs.All() {
+			switch {
+			case isRegionComment(c, offDirective):
+				off = true
+			case isRegionComment(c, onDirective):
+				off = false
+			}
+		}
+	}
+
+	update(f.Decs.Name)
+
+	decls := make(map[dst.Decl]bool)
+	for _, decl := range f.Decls {
+		decs := decl.Decorations()
+		update(decs.Start)
+		if off {
+			decls[decl] = true
+		}
+		update(decs.End)
+	}
+	return decls
+}
@@ -1,9 +1,11 @@
 package directive
 
 import (
+	"slices"
 	"testing"
 
 	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
 )
 
 func TestIsSkipComment(t *testing.T) {
@@ -227,3 +229,95 @@ func TestHasStmtSkipDirective(t *testing.T) {
 		})
 	}
 }
+
+func TestOffDecls(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		src  string
+		want []string
+	}{
+		"region between off and on": {
+			src: `package p
+
+func A() {}
+
+//ctxweaver:off generated
+func B() {}
+
+func C() {}
+
+//ctxweaver:on
+
+// D is documented.
+func D() {}
+`,
+			want: []string{"B", "C"},
+		},
+		"region to the end of the file": {
+			src: `package p
+
+func A() {}
+
+// ctxweaver:off
+func B() {}
+
+var c = 1
+`,
+			want: []string{"B", "c"},
+		},
+		"off after the package clause": {
+			src: `package p // import "example.com/p"
+//ctxweaver:off
+
+func A() {}
+`,
+			want: []string{"A"},
+		},
+		"off trailing a declaration": {
+			src: `package p
+
+func A() {} //ctxweaver:off
+
+func B() {}
+`,
+			want: []string{"B"},
+		},
+		"directive prefix of another word": {
+			src: `package p
+
+//ctxweaver:offline
+func A() {}
+`,
+			want: nil,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := decorator.Parse(tt.src)
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			off := OffDecls(f)
+
+			var got []string
+			for _, decl := range f.Decls {
+				if !off[decl] {
+					continue
+				}
+				switch d := decl.(type) {
+				case *dst.FuncDecl:
+					got = append(got, d.Name.Name)
+				case *dst.GenDecl:
+					got = append(got, d.Specs[0].(*dst.ValueSpec).Names[0].Name)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("OffDecls() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -116,6 +116,168 @@ func (caseClauseComparer) Compare(a, b dst.Node, path string, exact bool, c *Com
 	return true
 }
 
+type goStmtComparer struct{}
+
+func (goStmtComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.GoStmt), b.(*dst.GoStmt)
+	return c.Compare(nodeA.Call, nodeB.Call, path+".Call", exact)
+}
+
+type forStmtComparer struct{}
+
+func (forStmtComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.ForStmt), b.(*dst.ForStmt)
+	return c.Compare(nodeA.Init, nodeB.Init, path+".Init", exact) &&
+		c.Compare(nodeA.Cond, nodeB.Cond, path+".Cond", exact) &&
+		c.Compare(nodeA.Post, nodeB.Post, path+".Post", exact) &&
+		c.Compare(nodeA.Body, nodeB.Body, path+".Body", exact)
+}
+
+type rangeStmtComparer struct{}
+
+func (rangeStmtComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.RangeStmt), b.(*dst.RangeStmt)
+	if nodeA.Tok != nodeB.Tok {
+		return false
+	}
+	return c.Compare(nodeA.Key, nodeB.Key, path+".Key", exact) &&
+		c.Compare(nodeA.Value, nodeB.Value, path+".Value", exact) &&
+		c.Compare(nodeA.X, nodeB.X, path+".X", exact) &&
+		c.Compare(nodeA.Body, nodeB.Body, path+".Body", exact)
+}
+
+type selectStmtComparer struct{}
+
+func (selectStmtComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.SelectStmt), b.(*dst.SelectStmt)
+	return c.Compare(nodeA.Body, nodeB.Body, path+".Body", exact)
+}
+
+type commClauseComparer struct{}
+
+func (commClauseComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.CommClause), b.(*dst.CommClause)
+	if !c.Compare(nodeA.Comm, nodeB.Comm, path+".Comm", exact) {
+		return false
+	}
+	if len(nodeA.Body) != len(nodeB.Body) {
+		return false
+	}
+	for i := range nodeA.Body {
+		if !c.Compare(nodeA.Body[i], nodeB.Body[i], fmt.Sprintf("%s.Body[%d]", path, i), exact) {
+			return false
+		}
+	}
+	return true
+}
+
+type typeSwitchStmtComparer struct{}
+
+func (typeSwitchStmtComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.TypeSwitchStmt), b.(*dst.TypeSwitchStmt)
+	return c.Compare(nodeA.Init, nodeB.Init, path+".Init", exact) &&
+		c.Compare(nodeA.Assign, nodeB.Assign, path+".Assign", exact) &&
+		c.Compare(nodeA.Body, nodeB.Body, path+".Body", exact)
+}
+
+type labeledStmtComparer struct{}
+
+func (labeledStmtComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.LabeledStmt), b.(*dst.LabeledStmt)
+	return compareIdents(nodeA.Label, nodeB.Label) &&
+		c.Compare(nodeA.Stmt, nodeB.Stmt, path+".Stmt", exact)
+}
+
+type branchStmtComparer struct{}
+
+func (branchStmtComparer) Compare(a, b dst.Node, _ string, _ bool, _ *Comparator) bool {
+	nodeA, nodeB := a.(*dst.BranchStmt), b.(*dst.BranchStmt)
+	return nodeA.Tok == nodeB.Tok && compareIdents(nodeA.Label, nodeB.Label)
+}
+
+type sendStmtComparer struct{}
+
+func (sendStmtComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.SendStmt), b.(*dst.SendStmt)
+	return c.Compare(nodeA.Chan, nodeB.Chan, path+".Chan", exact) &&
+		c.Compare(nodeA.Value, nodeB.Value, path+".Value", exact)
+}
+
+type incDecStmtComparer struct{}
+
+func (incDecStmtComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.IncDecStmt), b.(*dst.IncDecStmt)
+	if nodeA.Tok != nodeB.Tok {
+		return false
+	}
+	return c.Compare(nodeA.X, nodeB.X, path+".X", exact)
+}
+
+type emptyStmtComparer struct{}
+
+func (emptyStmtComparer) Compare(_, _ dst.Node, _ string, _ bool, _ *Comparator) bool {
+	return true
+}
+
+type declStmtComparer struct{}
+
+func (declStmtComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.DeclStmt), b.(*dst.DeclStmt)
+	return c.Compare(nodeA.Decl, nodeB.Decl, path+".Decl", exact)
+}
+
+// ============================================================================
+// Declaration Comparers
+// ============================================================================
+
+type genDeclComparer struct{}
+
+func (genDeclComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.GenDecl), b.(*dst.GenDecl)
+	if nodeA.Tok != nodeB.Tok || len(nodeA.Specs) != len(nodeB.Specs) {
+		return false
+	}
+	for i := range nodeA.Specs {
+		if !c.Compare(nodeA.Specs[i], nodeB.Specs[i], fmt.Sprintf("%s.Specs[%d]", path, i), exact) {
+			return false
+		}
+	}
+	return true
+}
+
+type valueSpecComparer struct{}
+
+func (valueSpecComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.ValueSpec), b.(*dst.ValueSpec)
+	if len(nodeA.Names) != len(nodeB.Names) || len(nodeA.Values) != len(nodeB.Values) {
+		return false
+	}
+	for i := range nodeA.Names {
+		if !compareIdents(nodeA.Names[i], nodeB.Names[i]) {
+			return false
+		}
+	}
+	if !c.Compare(nodeA.Type, nodeB.Type, path+".Type", exact) {
+		return false
+	}
+	for i := range nodeA.Values {
+		if !c.Compare(nodeA.Values[i], nodeB.Values[i], fmt.Sprintf("%s.Values[%d]", path, i), exact) {
+			return false
+		}
+	}
+	return true
+}
+
+type typeSpecComparer struct{}
+
+func (typeSpecComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.TypeSpec), b.(*dst.TypeSpec)
+	return compareIdents(nodeA.Name, nodeB.Name) &&
+		nodeA.Assign == nodeB.Assign &&
+		compareFieldLists(nodeA.TypeParams, nodeB.TypeParams, path+".TypeParams", exact, c) &&
+		c.Compare(nodeA.Type, nodeB.Type, path+".Type", exact)
+}
+
 // ============================================================================
 // Expression Comparers
 // ============================================================================
@@ -260,3 +422,85 @@ func (typeAssertExprComparer) Compare(a, b dst.Node, path string, exact bool, c
 	return c.Compare(nodeA.X, nodeB.X, path+".X", exact) &&
 		c.Compare(nodeA.Type, nodeB.Type, path+".Type", exact)
 }
+
+type sliceExprComparer struct{}
+
+func (sliceExprComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.SliceExpr), b.(*dst.SliceExpr)
+	if nodeA.Slice3 != nodeB.Slice3 {
+		return false
+	}
+	return c.Compare(nodeA.X, nodeB.X, path+".X", exact) &&
+		c.Compare(nodeA.Low, nodeB.Low, path+".Low", exact) &&
+		c.Compare(nodeA.High, nodeB.High, path+".High", exact) &&
+		c.Compare(nodeA.Max, nodeB.Max, path+".Max", exact)
+}
+
+type indexListExprComparer struct{}
+
+func (indexListExprComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.IndexListExpr), b.(*dst.IndexListExpr)
+	if !c.Compare(nodeA.X, nodeB.X, path+".X", exact) {
+		return false
+	}
+	if len(nodeA.Indices) != len(nodeB.Indices) {
+		return false
+	}
+	for i := range nodeA.Indices {
+		if !c.Compare(nodeA.Indices[i], nodeB.Indices[i], fmt.Sprintf("%s.Indices[%d]", path, i), exact) {
+			return false
+		}
+	}
+	return true
+}
+
+type ellipsisComparer struct{}
+
+func (ellipsisComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.Ellipsis), b.(*dst.Ellipsis)
+	return c.Compare(nodeA.Elt, nodeB.Elt, path+".Elt", exact)
+}
+
+// ============================================================================
+// Type Comparers
+// ============================================================================
+
+type arrayTypeComparer struct{}
+
+func (arrayTypeComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.ArrayType), b.(*dst.ArrayType)
+	return c.Compare(nodeA.Len, nodeB.Len, path+".Len", exact) &&
+		c.Compare(nodeA.Elt, nodeB.Elt, path+".Elt", exact)
+}
+
+type mapTypeComparer struct{}
+
+func (mapTypeComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.MapType), b.(*dst.MapType)
+	return c.Compare(nodeA.Key, nodeB.Key, path+".Key", exact) &&
+		c.Compare(nodeA.Value, nodeB.Value, path+".Value", exact)
+}
+
+type chanTypeComparer struct{}
+
+func (chanTypeComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.ChanType), b.(*dst.ChanType)
+	if nodeA.Dir != nodeB.Dir {
+		return false
+	}
+	return c.Compare(nodeA.Value, nodeB.Value, path+".Value", exact)
+}
+
+type structTypeComparer struct{}
+
+func (structTypeComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.StructType), b.(*dst.StructType)
+	return compareFieldLists(nodeA.Fields, nodeB.Fields, path+".Fields", exact, c)
+}
+
+type interfaceTypeComparer struct{}
+
+func (interfaceTypeComparer) Compare(a, b dst.Node, path string, exact bool, c *Comparator) bool {
+	nodeA, nodeB := a.(*dst.InterfaceType), b.(*dst.InterfaceType)
+	return compareFieldLists(nodeA.Methods, nodeB.Methods, path+".Methods", exact, c)
+}
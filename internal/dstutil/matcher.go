@@ -3,7 +3,11 @@ package dstutil
 
 import (
 	"fmt"
+	"go/token"
+	"maps"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/dave/dst"
 )
@@ -16,13 +20,13 @@ import (
 // It returns true if both statements have the same "skeleton" - same node types
 // and static identifiers, but potentially different dynamic values (variables, literals).
 func MatchesSkeleton(a, b dst.Stmt) bool {
-	return defaultComparator.Compare(a, b, "root", false)
+	return defaultComparator.MatchesSkeleton(a, b)
 }
 
 // MatchesExact compares two statements for exact equality.
 // Unlike MatchesSkeleton, this also compares literal values.
 func MatchesExact(a, b dst.Stmt) bool {
-	return defaultComparator.Compare(a, b, "root", true)
+	return defaultComparator.MatchesExact(a, b)
 }
 
 // ============================================================================
@@ -41,8 +45,14 @@ type NodeComparer interface {
 
 // Comparator manages NodeComparer implementations and performs comparisons.
 // It acts as a registry for node-specific comparers and handles dispatch.
+//
+// Each Comparator owns its registry, so registering comparers on one returned
+// by NewComparator or Clone never affects the package-level matchers.
+// Register must not be called concurrently with Compare.
 type Comparator struct {
-	comparers map[reflect.Type]NodeComparer
+	comparers      map[reflect.Type]NodeComparer
+	wildcardPrefix string            // Identifiers and literals in a containing this match anything
+	bindings       map[string]string // Values of wildcards inside literals; see WithBindings
 }
 
 // NewComparator creates a new Comparator with the default set of comparers.
@@ -55,10 +65,49 @@ func NewComparator() *Comparator {
 }
 
 // Register adds a NodeComparer for a specific node type.
+// Registering a type that already has a comparer replaces it.
 func (c *Comparator) Register(nodeType reflect.Type, comparer NodeComparer) {
 	c.comparers[nodeType] = comparer
 }
 
+// Clone returns a copy of the Comparator with an independent registry.
+func (c *Comparator) Clone() *Comparator {
+	return &Comparator{
+		comparers:      maps.Clone(c.comparers),
+		wildcardPrefix: c.wildcardPrefix,
+		bindings:       c.bindings,
+	}
+}
+
+// WithWildcards returns a Comparator sharing this registry that treats any
+// identifier or literal in the first operand containing prefix as a wildcard
+// matching any node. An empty prefix disables wildcards.
+func (c *Comparator) WithWildcards(prefix string) *Comparator {
+	cc := *c
+	cc.wildcardPrefix = prefix
+	return &cc
+}
+
+// WithBindings returns a Comparator sharing this registry that no longer treats
+// wildcard literals as matching anything: each wildcard inside a literal is
+// replaced by its value in bindings, and the result must equal the other literal.
+// Wildcard identifiers still match any node.
+func (c *Comparator) WithBindings(bindings map[string]string) *Comparator {
+	cc := *c
+	cc.bindings = bindings
+	return &cc
+}
+
+// MatchesSkeleton is like the package-level MatchesSkeleton but uses this Comparator's registry.
+func (c *Comparator) MatchesSkeleton(a, b dst.Stmt) bool {
+	return c.Compare(a, b, "root", false)
+}
+
+// MatchesExact is like the package-level MatchesExact but uses this Comparator's registry.
+func (c *Comparator) MatchesExact(a, b dst.Stmt) bool {
+	return c.Compare(a, b, "root", true)
+}
+
 // Compare compares two DST nodes using the registered comparers.
 func (c *Comparator) Compare(a, b dst.Node, path string, exact bool) bool {
 	if a == nil && b == nil {
@@ -68,6 +117,10 @@ func (c *Comparator) Compare(a, b dst.Node, path string, exact bool) bool {
 		return false
 	}
 
+	if c.isWildcard(a) {
+		return c.matchesBinding(a, b)
+	}
+
 	// Handle SelectorExpr vs Ident with Path (import resolution difference)
 	// If types differ but are import-equivalent, comparison is complete
 	if reflect.TypeOf(a) != reflect.TypeOf(b) {
@@ -79,8 +132,53 @@ func (c *Comparator) Compare(a, b dst.Node, path string, exact bool) bool {
 		return comparer.Compare(a, b, path, exact, c)
 	}
 
-	// Fallback: unsupported node types pass by default
-	return true
+	// Fallback: unsupported node types never match, so an unknown construct
+	// cannot cause a false-positive match
+	return false
+}
+
+// isWildcard reports whether n is a placeholder that matches any node.
+func (c *Comparator) isWildcard(n dst.Node) bool {
+	if c.wildcardPrefix == "" {
+		return false
+	}
+	switch n := n.(type) {
+	case *dst.Ident:
+		return strings.Contains(n.Name, c.wildcardPrefix)
+	case *dst.BasicLit:
+		return strings.Contains(n.Value, c.wildcardPrefix)
+	}
+	return false
+}
+
+// matchesBinding reports whether b matches the wildcard a. Without bindings,
+// any node matches. With bindings, a wildcard literal must equal b once its
+// wildcards are replaced by their values.
+func (c *Comparator) matchesBinding(a, b dst.Node) bool {
+	lit, ok := a.(*dst.BasicLit)
+	if !ok || c.bindings == nil {
+		return true
+	}
+	other, ok := b.(*dst.BasicLit)
+	if !ok || other.Kind != lit.Kind {
+		return false
+	}
+
+	want, got := lit.Value, other.Value
+	if lit.Kind == token.STRING {
+		// Compare unquoted values so that "a" and `a` are equal
+		var err error
+		if want, err = strconv.Unquote(want); err != nil {
+			return false
+		}
+		if got, err = strconv.Unquote(got); err != nil {
+			return false
+		}
+	}
+	for placeholder, value := range c.bindings {
+		want = strings.ReplaceAll(want, placeholder, value)
+	}
+	return want == got
 }
 
 // importEquivalent checks if two nodes of different types are equivalent
@@ -111,6 +209,23 @@ func (c *Comparator) registerDefaults() {
 	c.Register(reflect.TypeOf((*dst.AssignStmt)(nil)), &assignStmtComparer{})
 	c.Register(reflect.TypeOf((*dst.ReturnStmt)(nil)), &returnStmtComparer{})
 	c.Register(reflect.TypeOf((*dst.CaseClause)(nil)), &caseClauseComparer{})
+	c.Register(reflect.TypeOf((*dst.GoStmt)(nil)), &goStmtComparer{})
+	c.Register(reflect.TypeOf((*dst.ForStmt)(nil)), &forStmtComparer{})
+	c.Register(reflect.TypeOf((*dst.RangeStmt)(nil)), &rangeStmtComparer{})
+	c.Register(reflect.TypeOf((*dst.SelectStmt)(nil)), &selectStmtComparer{})
+	c.Register(reflect.TypeOf((*dst.CommClause)(nil)), &commClauseComparer{})
+	c.Register(reflect.TypeOf((*dst.TypeSwitchStmt)(nil)), &typeSwitchStmtComparer{})
+	c.Register(reflect.TypeOf((*dst.LabeledStmt)(nil)), &labeledStmtComparer{})
+	c.Register(reflect.TypeOf((*dst.BranchStmt)(nil)), &branchStmtComparer{})
+	c.Register(reflect.TypeOf((*dst.SendStmt)(nil)), &sendStmtComparer{})
+	c.Register(reflect.TypeOf((*dst.IncDecStmt)(nil)), &incDecStmtComparer{})
+	c.Register(reflect.TypeOf((*dst.EmptyStmt)(nil)), &emptyStmtComparer{})
+	c.Register(reflect.TypeOf((*dst.DeclStmt)(nil)), &declStmtComparer{})
+
+	// Declarations
+	c.Register(reflect.TypeOf((*dst.GenDecl)(nil)), &genDeclComparer{})
+	c.Register(reflect.TypeOf((*dst.ValueSpec)(nil)), &valueSpecComparer{})
+	c.Register(reflect.TypeOf((*dst.TypeSpec)(nil)), &typeSpecComparer{})
 
 	// Expressions
 	c.Register(reflect.TypeOf((*dst.CallExpr)(nil)), &callExprComparer{})
@@ -127,6 +242,16 @@ func (c *Comparator) registerDefaults() {
 	c.Register(reflect.TypeOf((*dst.KeyValueExpr)(nil)), &keyValueExprComparer{})
 	c.Register(reflect.TypeOf((*dst.StarExpr)(nil)), &starExprComparer{})
 	c.Register(reflect.TypeOf((*dst.TypeAssertExpr)(nil)), &typeAssertExprComparer{})
+	c.Register(reflect.TypeOf((*dst.SliceExpr)(nil)), &sliceExprComparer{})
+	c.Register(reflect.TypeOf((*dst.IndexListExpr)(nil)), &indexListExprComparer{})
+	c.Register(reflect.TypeOf((*dst.Ellipsis)(nil)), &ellipsisComparer{})
+
+	// Types
+	c.Register(reflect.TypeOf((*dst.ArrayType)(nil)), &arrayTypeComparer{})
+	c.Register(reflect.TypeOf((*dst.MapType)(nil)), &mapTypeComparer{})
+	c.Register(reflect.TypeOf((*dst.ChanType)(nil)), &chanTypeComparer{})
+	c.Register(reflect.TypeOf((*dst.StructType)(nil)), &structTypeComparer{})
+	c.Register(reflect.TypeOf((*dst.InterfaceType)(nil)), &interfaceTypeComparer{})
 }
 
 // defaultComparator is the singleton instance used by public API.
@@ -155,6 +280,14 @@ func compareFieldLists(a, b *dst.FieldList, path string, exact bool, c *Comparat
 	return true
 }
 
+// compareIdents compares two optional identifiers (labels, declared names) by name.
+func compareIdents(a, b *dst.Ident) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Name == b.Name
+}
+
 // compareFields compares two fields for structural equality.
 func compareFields(a, b *dst.Field, path string, exact bool, c *Comparator) bool {
 	// Compare types only (names are dynamic)
@@ -1,6 +1,7 @@
 package dstutil
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/dave/dst"
@@ -536,3 +537,285 @@ func TestCompareNodes_SelectorExprVsIdentWithPath(t *testing.T) {
 		}
 	})
 }
+
+// alwaysMatch is a NodeComparer that treats all nodes of its type as equal.
+type alwaysMatch struct{}
+
+func (alwaysMatch) Compare(_, _ dst.Node, _ string, _ bool, _ *Comparator) bool {
+	return true
+}
+
+func TestComparator_Register(t *testing.T) {
+	t.Parallel()
+
+	a, _ := ParseStatements(`defer trace(ctx)`)
+	b, _ := ParseStatements(`defer trace(c)`)
+
+	c := NewComparator()
+	if c.MatchesSkeleton(a[0], b[0]) {
+		t.Fatal("expected different identifiers to not match with default comparers")
+	}
+
+	c.Register(reflect.TypeOf((*dst.Ident)(nil)), alwaysMatch{})
+	if !c.MatchesSkeleton(a[0], b[0]) {
+		t.Error("expected registered comparer to override identifier comparison")
+	}
+	if !c.MatchesExact(a[0], b[0]) {
+		t.Error("expected registered comparer to apply to exact matching")
+	}
+
+	// Package-level matchers are unaffected
+	if MatchesSkeleton(a[0], b[0]) {
+		t.Error("registering on a new Comparator must not affect MatchesSkeleton")
+	}
+}
+
+func TestComparator_Clone(t *testing.T) {
+	t.Parallel()
+
+	a, _ := ParseStatements(`defer trace(ctx)`)
+	b, _ := ParseStatements(`defer trace(c)`)
+
+	original := NewComparator()
+	clone := original.Clone()
+	clone.Register(reflect.TypeOf((*dst.Ident)(nil)), alwaysMatch{})
+
+	if !clone.MatchesSkeleton(a[0], b[0]) {
+		t.Error("expected clone to use its registered comparer")
+	}
+	if original.MatchesSkeleton(a[0], b[0]) {
+		t.Error("registering on a clone must not affect the original")
+	}
+}
+
+func TestMatchesSkeleton_StatementTypes(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		a    string
+		b    string
+		want bool
+	}{
+		"go statement": {
+			a:    `go worker(ctx, "a")`,
+			b:    `go worker(ctx, "b")`,
+			want: true,
+		},
+		"go statement different callee": {
+			a:    `go worker(ctx)`,
+			b:    `go other(ctx)`,
+			want: false,
+		},
+		"for statement": {
+			a:    `for i := 0; i < 3; i++ { println(i) }`,
+			b:    `for i := 0; i < 5; i++ { println(i) }`,
+			want: true,
+		},
+		"for statement different post": {
+			a:    `for i := 0; i < 3; i++ {}`,
+			b:    `for i := 0; i < 3; i-- {}`,
+			want: false,
+		},
+		"range statement": {
+			a:    `for k, v := range m { use(k, v) }`,
+			b:    `for k, v := range m { use(k, v) }`,
+			want: true,
+		},
+		"range statement different token": {
+			a:    `for k = range m {}`,
+			b:    `for k := range m {}`,
+			want: false,
+		},
+		"range statement different body": {
+			a:    `for range m { a() }`,
+			b:    `for range m { b() }`,
+			want: false,
+		},
+		"select statement": {
+			a:    `select { case <-ctx.Done(): return; case ch <- 1: }`,
+			b:    `select { case <-ctx.Done(): return; case ch <- 2: }`,
+			want: true,
+		},
+		"select statement different clause count": {
+			a:    `select { case <-ctx.Done(): }`,
+			b:    `select { case <-ctx.Done(): ; default: }`,
+			want: false,
+		},
+		"send statement different channel": {
+			a:    `a <- 1`,
+			b:    `b <- 1`,
+			want: false,
+		},
+		"labeled statement with break": {
+			a:    `loop: for { break loop }`,
+			b:    `loop: for { break loop }`,
+			want: true,
+		},
+		"labeled statement different label": {
+			a:    `loop: for { break loop }`,
+			b:    `outer: for { break outer }`,
+			want: false,
+		},
+		"branch statement different token": {
+			a:    `for { break }`,
+			b:    `for { continue }`,
+			want: false,
+		},
+		"type switch": {
+			a:    `switch v := x.(type) { case int: use(v) }`,
+			b:    `switch v := x.(type) { case int: use(v) }`,
+			want: true,
+		},
+		"var declaration": {
+			a:    `var start = time.Now()`,
+			b:    `var start = time.Now()`,
+			want: true,
+		},
+		"var declaration different name": {
+			a:    `var start = time.Now()`,
+			b:    `var begin = time.Now()`,
+			want: false,
+		},
+		"map and slice types": {
+			a:    `m := map[string][]int{"a": {1}}`,
+			b:    `m := map[string][]int{"b": {2}}`,
+			want: true,
+		},
+		"different map value type": {
+			a:    `m := map[string]int{}`,
+			b:    `m := map[string]string{}`,
+			want: false,
+		},
+		"channel direction": {
+			a:    `var ch chan<- int`,
+			b:    `var ch <-chan int`,
+			want: false,
+		},
+		"slice expression": {
+			a:    `s = s[1:2]`,
+			b:    `s = s[3:4]`,
+			want: true,
+		},
+		"full slice expression vs simple": {
+			a:    `s = s[1:2:3]`,
+			b:    `s = s[1:2]`,
+			want: false,
+		},
+		"variadic call": {
+			a:    `f(args...)`,
+			b:    `f(args...)`,
+			want: true,
+		},
+		"increment vs decrement": {
+			a:    `n++`,
+			b:    `n--`,
+			want: false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			a, err := ParseStatements(tt.a)
+			if err != nil {
+				t.Fatalf("failed to parse a: %v", err)
+			}
+			b, err := ParseStatements(tt.b)
+			if err != nil {
+				t.Fatalf("failed to parse b: %v", err)
+			}
+
+			if got := MatchesSkeleton(a[0], b[0]); got != tt.want {
+				t.Errorf("MatchesSkeleton(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComparator_UnsupportedNodeTypeIsStrict(t *testing.T) {
+	t.Parallel()
+
+	a, _ := ParseStatements(`go worker()`)
+	b, _ := ParseStatements(`go worker()`)
+
+	empty := &Comparator{comparers: map[reflect.Type]NodeComparer{}}
+	if empty.MatchesSkeleton(a[0], b[0]) {
+		t.Error("expected nodes without a registered comparer to not match")
+	}
+}
+
+func TestComparator_WithWildcards(t *testing.T) {
+	t.Parallel()
+
+	pattern, _ := ParseStatements(`defer trace(__w_Ctx__, "__w_Name__")`)
+	existing, _ := ParseStatements(`defer trace(c.Request().Context(), "pkg.Foo")`)
+	otherFunc, _ := ParseStatements(`defer other(c.Request().Context(), "pkg.Foo")`)
+
+	c := NewComparator()
+	if c.MatchesSkeleton(pattern[0], existing[0]) {
+		t.Error("expected no match without wildcards")
+	}
+
+	w := c.WithWildcards("__w_")
+	if !w.MatchesSkeleton(pattern[0], existing[0]) {
+		t.Error("expected placeholder positions to match any node")
+	}
+	if w.MatchesSkeleton(pattern[0], otherFunc[0]) {
+		t.Error("expected static positions to still be compared")
+	}
+	if w.MatchesSkeleton(existing[0], pattern[0]) {
+		t.Error("expected wildcards to apply only to the first operand")
+	}
+}
+
+func TestComparator_WithBindings(t *testing.T) {
+	t.Parallel()
+
+	pattern, _ := ParseStatements(`defer trace(__w_Ctx__, "__w_Pkg__.__w_Name__", "static")`)
+	bound := NewComparator().WithWildcards("__w_").WithBindings(map[string]string{
+		"__w_Pkg__":  "pkg",
+		"__w_Name__": "Foo",
+		"__w_Ctx__":  "ctx",
+	})
+
+	tests := map[string]struct {
+		existing string
+		want     bool
+	}{
+		"bound literal matches": {
+			existing: `defer trace(c.Request().Context(), "pkg.Foo", "static")`,
+			want:     true,
+		},
+		"raw string literal matches": {
+			existing: "defer trace(ctx, `pkg.Foo`, \"static\")",
+			want:     true,
+		},
+		"stale bound literal does not match": {
+			existing: `defer trace(ctx, "pkg.OldName", "static")`,
+			want:     false,
+		},
+		"static literal differences are ignored": {
+			existing: `defer trace(ctx, "pkg.Foo", "edited")`,
+			want:     true,
+		},
+		"non-literal at bound position does not match": {
+			existing: `defer trace(ctx, name, "static")`,
+			want:     false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			existing, err := ParseStatements(tt.existing)
+			if err != nil {
+				t.Fatalf("ParseStatements() error = %v", err)
+			}
+			if got := bound.MatchesSkeleton(pattern[0], existing[0]); got != tt.want {
+				t.Errorf("MatchesSkeleton() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,8 +1,11 @@
 package dstutil
 
 import (
+	"fmt"
 	"go/parser"
 	"go/token"
+	"slices"
+	"strings"
 
 	"github.com/dave/dst"
 	"github.com/dave/dst/decorator"
@@ -16,7 +19,19 @@ func InsertStatements(body *dst.BlockStmt, stmtStr string) bool {
 	}
 
 	// Add empty line after the last inserted statement
-	stmts[len(stmts)-1].Decorations().After = dst.EmptyLine
+	last := stmts[len(stmts)-1].Decorations()
+	last.After = dst.EmptyLine
+
+	// Comments on their own lines in an empty body (e.g. //nolint:all) stay at
+	// its end instead of binding to the inserted statements
+	if len(body.List) == 0 {
+		if i := slices.Index(body.Decs.Lbrace, "\n"); i >= 0 {
+			last.End.Append("\n")
+			last.End.Append(body.Decs.Lbrace[i:]...)
+			last.After = dst.NewLine
+			body.Decs.Lbrace = body.Decs.Lbrace[:i]
+		}
+	}
 
 	body.List = append(stmts, body.List...)
 	return true
@@ -34,10 +49,28 @@ func UpdateStatements(body *dst.BlockStmt, index, count int, stmtStr string) boo
 		return false
 	}
 
+	first, last := stmts[0].Decorations(), stmts[len(stmts)-1].Decorations()
+	oldFirst, oldLast := body.List[index].Decorations(), body.List[index+count-1].Decorations()
+
 	// Preserve Before decoration from the first old statement
-	stmts[0].Decorations().Before = body.List[index].Decorations().Before
+	first.Before = oldFirst.Before
 	// Preserve After decoration from the last old statement
-	stmts[len(stmts)-1].Decorations().After = body.List[index+count-1].Decorations().After
+	last.After = oldLast.After
+
+	// Keep the directives bound to the old statements (e.g. //nolint:errcheck)
+	// and the comments on their own lines after them
+	for _, c := range slices.Backward(oldFirst.Start) {
+		if isDirective(c) && !slices.Contains(first.Start, c) {
+			first.Start.Prepend(c)
+		}
+	}
+	sameLine, ownLines := splitTrailing(oldLast.End)
+	for _, c := range sameLine {
+		if isDirective(c) && !slices.Contains(last.End, c) {
+			last.End.Append(c)
+		}
+	}
+	last.End.Append(ownLines...)
 
 	// Replace: body.List[:index] + stmts + body.List[index+count:]
 	newList := make([]dst.Stmt, 0, len(body.List)-count+len(stmts))
@@ -55,10 +88,66 @@ func RemoveStatements(body *dst.BlockStmt, index, count int) bool {
 		return false
 	}
 
+	// Keep the directives bound to the removed statements (e.g. //nolint:errcheck)
+	// and the comments on their own lines after them
+	var kept []string
+	for _, c := range body.List[index].Decorations().Start {
+		if isDirective(c) {
+			kept = append(kept, c)
+		}
+	}
+	_, ownLines := splitTrailing(body.List[index+count-1].Decorations().End)
+	for _, c := range ownLines {
+		if c != "\n" {
+			kept = append(kept, c)
+		}
+	}
+
 	body.List = append(body.List[:index], body.List[index+count:]...)
+
+	switch {
+	case len(kept) == 0:
+	case index < len(body.List):
+		body.List[index].Decorations().Start.Prepend(kept...)
+	case index > 0:
+		end := &body.List[index-1].Decorations().End
+		for _, c := range kept {
+			end.Append("\n", c)
+		}
+	default:
+		for _, c := range kept {
+			body.Decs.Lbrace.Append("\n", c)
+		}
+	}
 	return true
 }
 
+// directivePrefixes are the prefixes of the comments that tools bind to the
+// statement they precede or trail.
+var directivePrefixes = []string{"//nolint", "//lint:", "//line ", "//go:"}
+
+// isDirective reports whether a comment is a directive for a tool, such as
+// //nolint:errcheck, rather than documentation.
+func isDirective(comment string) bool {
+	for _, prefix := range directivePrefixes {
+		if strings.HasPrefix(comment, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTrailing splits the decorations following a statement into the
+// comments on its line and those on the following lines, starting with a line
+// break.
+func splitTrailing(end dst.Decorations) (sameLine, ownLines []string) {
+	i := slices.Index(end, "\n")
+	if i < 0 {
+		return end, nil
+	}
+	return end[:i], end[i:]
+}
+
 // ParseStatements parses a statement string into DST statements.
 // Supports multiple statements separated by newlines.
 func ParseStatements(stmtStr string) ([]dst.Stmt, error) {
@@ -84,3 +173,19 @@ func ParseStatements(stmtStr string) ([]dst.Stmt, error) {
 
 	return funcDecl.Body.List, nil
 }
+
+// ParseExpr parses an expression string into a DST expression.
+func ParseExpr(exprStr string) (dst.Expr, error) {
+	stmts, err := ParseStatements(exprStr)
+	if err != nil {
+		return nil, err
+	}
+	if len(stmts) != 1 {
+		return nil, fmt.Errorf("expected a single expression, got %d statements", len(stmts))
+	}
+	exprStmt, ok := stmts[0].(*dst.ExprStmt)
+	if !ok {
+		return nil, fmt.Errorf("expected an expression, got %T", stmts[0])
+	}
+	return exprStmt.X, nil
+}
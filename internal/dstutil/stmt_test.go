@@ -1,6 +1,7 @@
 package dstutil
 
 import (
+	"fmt"
 	"go/ast"
 	"go/format"
 	"go/parser"
@@ -112,6 +113,55 @@ defer seg.End()`,
 	}
 }
 
+func TestParseExpr(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		input    string
+		wantType string
+		wantErr  bool
+	}{
+		"identifier": {
+			input:    `ctx`,
+			wantType: "*dst.Ident",
+		},
+		"method call chain": {
+			input:    `c.Request().Context()`,
+			wantType: "*dst.CallExpr",
+		},
+		"statement": {
+			input:   `x := ctx`,
+			wantErr: true,
+		},
+		"multiple expressions": {
+			input:   "ctx\nctx",
+			wantErr: true,
+		},
+		"invalid expression": {
+			input:   `ctx.(`,
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			expr, err := ParseExpr(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseExpr() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := fmt.Sprintf("%T", expr); got != tt.wantType {
+				t.Errorf("ParseExpr() = %s, want %s", got, tt.wantType)
+			}
+		})
+	}
+}
+
 func TestInsertStatements(t *testing.T) {
 	t.Parallel()
 
@@ -401,3 +451,92 @@ func mustParseStmt(t *testing.T, code string) dst.Stmt {
 	}
 	return funcDecl.Body.List[0]
 }
+
+func TestStatements_Directives(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		src  string
+		edit func(body *dst.BlockStmt) bool
+		want string
+	}{
+		"insert keeps directive bound to first statement": {
+			src: "func f() {\n\t//nolint:errcheck\n\twork()\n}",
+			edit: func(body *dst.BlockStmt) bool {
+				return InsertStatements(body, "defer trace(ctx)")
+			},
+			want: "func f() {\n\tdefer trace(ctx)\n\n\t//nolint:errcheck\n\twork()\n}",
+		},
+		"insert keeps comment of empty body at its end": {
+			src: "func f() {\n\t//nolint:all\n}",
+			edit: func(body *dst.BlockStmt) bool {
+				return InsertStatements(body, "defer trace(ctx)")
+			},
+			want: "func f() {\n\tdefer trace(ctx)\n\n\t//nolint:all\n}",
+		},
+		"insert keeps comment on brace line": {
+			src: "func f() { //nolint:gocyclo\n}",
+			edit: func(body *dst.BlockStmt) bool {
+				return InsertStatements(body, "defer trace(ctx)")
+			},
+			want: "func f() { //nolint:gocyclo\n\tdefer trace(ctx)\n\n}",
+		},
+		"update keeps directives": {
+			src: "func f() {\n\t//nolint:errcheck\n\tdefer trace(old) //lint:ignore SA1019 deprecated\n\twork()\n}",
+			edit: func(body *dst.BlockStmt) bool {
+				return UpdateStatements(body, 0, 1, "defer trace(ctx)")
+			},
+			want: "func f() {\n\t//nolint:errcheck\n\tdefer trace(ctx) //lint:ignore SA1019 deprecated\n\twork()\n}",
+		},
+		"update drops documentation of old statements": {
+			src: "func f() {\n\t// Old comment.\n\tdefer trace(old) // old\n\twork()\n}",
+			edit: func(body *dst.BlockStmt) bool {
+				return UpdateStatements(body, 0, 1, "defer trace(ctx)")
+			},
+			want: "func f() {\n\tdefer trace(ctx)\n\twork()\n}",
+		},
+		"remove moves directive to next statement": {
+			src: "func f() {\n\t//nolint:errcheck\n\tdefer trace(ctx)\n\twork()\n}",
+			edit: func(body *dst.BlockStmt) bool {
+				return RemoveStatements(body, 0, 1)
+			},
+			want: "func f() {\n\t//nolint:errcheck\n\twork()\n}",
+		},
+		"remove keeps trailing comment of last statement": {
+			src: "func f() {\n\tdefer trace(ctx)\n\n\t//nolint:all\n}",
+			edit: func(body *dst.BlockStmt) bool {
+				return RemoveStatements(body, 0, 1)
+			},
+			want: "func f() {\n\t//nolint:all\n}",
+		},
+		"remove keeps trailing comment after previous statement": {
+			src: "func f() {\n\twork()\n\tdefer trace(ctx)\n\t//nolint:all\n}",
+			edit: func(body *dst.BlockStmt) bool {
+				return RemoveStatements(body, 1, 1)
+			},
+			want: "func f() {\n\twork()\n\t//nolint:all\n}",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			f, err := decorator.Parse("package p\n\n" + tt.src + "\n")
+			if err != nil {
+				t.Fatalf("failed to parse: %v", err)
+			}
+			if !tt.edit(f.Decls[0].(*dst.FuncDecl).Body) {
+				t.Fatal("edit returned false")
+			}
+
+			var buf strings.Builder
+			if err := decorator.Fprint(&buf, f); err != nil {
+				t.Fatalf("failed to print: %v", err)
+			}
+			if got := strings.TrimSpace(strings.TrimPrefix(buf.String(), "package p\n")); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Package ignore implements .ctxweaverignore handling.
+package ignore
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is the name of ignore files.
+const FileName = ".ctxweaverignore"
+
+// pattern is a single gitignore-style pattern.
+type pattern struct {
+	segments []string // slash-separated glob segments; "**" matches any number of segments
+	negate   bool     // "!" prefix: re-include paths excluded by earlier patterns
+	dirOnly  bool     // "/" suffix: only match directories
+	anchored bool     // contains "/": matched relative to the ignore file's directory
+}
+
+// parsePattern parses a line of an ignore file. Returns false for blank lines and comments.
+func parsePattern(line string) (pattern, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+
+	var p pattern
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\#`) || strings.HasPrefix(line, `\!`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		p.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return pattern{}, false
+	}
+	p.segments = strings.Split(line, "/")
+	return p, true
+}
+
+// match reports whether the pattern matches rel, a slash-separated path
+// relative to the directory of the ignore file.
+func (p pattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !p.anchored {
+		ok, _ := path.Match(p.segments[0], path.Base(rel))
+		return ok
+	}
+	return matchSegments(p.segments, strings.Split(rel, "/"))
+}
+
+// matchSegments matches glob segments against path segments.
+func matchSegments(pat, name []string) bool {
+	if len(pat) == 0 {
+		return len(name) == 0
+	}
+	if pat[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pat[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pat[0], name[0]); !ok {
+		return false
+	}
+	return matchSegments(pat[1:], name[1:])
+}
+
+// parse parses the contents of an ignore file.
+func parse(data []byte) []pattern {
+	var patterns []pattern
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if p, ok := parsePattern(sc.Text()); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// Matcher reports whether files are excluded by .ctxweaverignore files.
+// Like .gitignore, an ignore file applies to the directory containing it and
+// all its subdirectories, and patterns of deeper files take precedence.
+// Ignore files are looked up from the file's directory upwards to the
+// enclosing module root (the nearest directory containing go.mod).
+type Matcher struct {
+	dirs map[string][]pattern // key: absolute directory; nil if it has no ignore file
+}
+
+// NewMatcher creates a Matcher. Ignore files are read lazily and cached.
+func NewMatcher() *Matcher {
+	return &Matcher{dirs: make(map[string][]pattern)}
+}
+
+// patterns returns the patterns of the ignore file in dir.
+func (m *Matcher) patterns(dir string) []pattern {
+	if ps, ok := m.dirs[dir]; ok {
+		return ps
+	}
+	var ps []pattern
+	if data, err := os.ReadFile(filepath.Join(dir, FileName)); err == nil {
+		ps = parse(data)
+	}
+	m.dirs[dir] = ps
+	return ps
+}
+
+// ancestors returns dir and its parent directories up to the module root,
+// ordered from the root down to dir.
+func ancestors(dir string) []string {
+	var dirs []string
+	for {
+		dirs = append(dirs, dir)
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	for i, j := 0, len(dirs)-1; i < j; i, j = i+1, j-1 {
+		dirs[i], dirs[j] = dirs[j], dirs[i]
+	}
+	return dirs
+}
+
+// Match reports whether filename is excluded by an ignore file.
+// A file is excluded if it or any of its parent directories is matched.
+func (m *Matcher) Match(filename string) bool {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return false
+	}
+	dirs := ancestors(filepath.Dir(abs))
+
+	// Check each directory below the root and finally the file itself
+	// against the ignore files of its ancestors
+	for i := 1; i <= len(dirs); i++ {
+		target, isDir := abs, false
+		if i < len(dirs) {
+			target, isDir = dirs[i], true
+		}
+		ignored := false
+		for _, dir := range dirs[:i] {
+			rel, err := filepath.Rel(dir, target)
+			if err != nil {
+				continue
+			}
+			for _, p := range m.patterns(dir) {
+				if p.match(filepath.ToSlash(rel), isDir) {
+					ignored = !p.negate
+				}
+			}
+		}
+		if ignored {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPattern_Match(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		"basename at any depth": {
+			pattern: "*_gen.go",
+			rel:     "handler/user_gen.go",
+			want:    true,
+		},
+		"basename mismatch": {
+			pattern: "*_gen.go",
+			rel:     "handler/user.go",
+			want:    false,
+		},
+		"anchored path": {
+			pattern: "handler/giant_generated_handler.go",
+			rel:     "handler/giant_generated_handler.go",
+			want:    true,
+		},
+		"anchored path does not match deeper": {
+			pattern: "handler/giant_generated_handler.go",
+			rel:     "api/handler/giant_generated_handler.go",
+			want:    false,
+		},
+		"leading slash anchors": {
+			pattern: "/main.go",
+			rel:     "cmd/main.go",
+			want:    false,
+		},
+		"double star": {
+			pattern: "**/mock/*.go",
+			rel:     "internal/service/mock/service.go",
+			want:    true,
+		},
+		"directory only matches directory": {
+			pattern: "legacy/",
+			rel:     "legacy",
+			isDir:   true,
+			want:    true,
+		},
+		"directory only does not match file": {
+			pattern: "legacy/",
+			rel:     "legacy",
+			want:    false,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p, ok := parsePattern(tt.pattern)
+			if !ok {
+				t.Fatalf("parsePattern(%q) returned no pattern", tt.pattern)
+			}
+			if got := p.match(tt.rel, tt.isDir); got != tt.want {
+				t.Errorf("match(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePattern_Skipped(t *testing.T) {
+	t.Parallel()
+
+	for _, line := range []string{"", "   ", "# comment", "/"} {
+		if _, ok := parsePattern(line); ok {
+			t.Errorf("parsePattern(%q) should be skipped", line)
+		}
+	}
+}
+
+func TestMatcher_Match(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	files := map[string]string{
+		"go.mod":                   "module example.com/app\n",
+		FileName:                   "# generated handlers\nhandler/giant_generated_handler.go\nlegacy/\n*_mock.go\n!keep_mock.go\n",
+		"internal/" + FileName:     "service.go\n",
+		"internal/svc/" + FileName: "!service.go\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]bool{
+		"main.go":                            false,
+		"handler/giant_generated_handler.go": true,
+		"handler/handler.go":                 false,
+		"legacy/old.go":                      true,
+		"legacy/deep/old.go":                 true,
+		"repo/user_mock.go":                  true,
+		"repo/keep_mock.go":                  false,
+		"internal/service.go":                true,
+		"internal/other/service.go":          true,
+		"internal/svc/service.go":            false,
+		"service.go":                         false,
+	}
+
+	m := NewMatcher()
+	for name, want := range tests {
+		if got := m.Match(filepath.Join(root, name)); got != want {
+			t.Errorf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
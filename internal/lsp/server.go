@@ -0,0 +1,254 @@
+// Package lsp implements a minimal language server exposing ctxweaver to
+// editors: diagnostics for functions needing changes, and code actions
+// weaving or removing the generated statements of a function.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+
+	"github.com/mpyw/ctxweaver/internal/patch"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+// Code action titles
+const (
+	TitleWeave  = "Weave instrumentation into this function"
+	TitleRemove = "Remove generated statement"
+)
+
+// NewProcessor creates a processor for the configuration of the server,
+// with the given options added.
+type NewProcessor func(opts ...processor.Option) *processor.Processor
+
+// Server is a language server working on the files of the working directory.
+// Open documents are processed with their unsaved contents.
+type Server struct {
+	newProcessor NewProcessor
+	documents    map[string][]byte // Contents of the open documents, by path
+	out          io.Writer
+	shutdown     bool
+}
+
+// NewServer creates a server processing documents with processors created by newProcessor.
+func NewServer(newProcessor NewProcessor) *Server {
+	return &Server{newProcessor: newProcessor, documents: make(map[string][]byte)}
+}
+
+// Serve handles the messages read from r, writing responses and notifications
+// to w, until the exit notification or the end of r.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.out = w
+	br := bufio.NewReader(r)
+	for {
+		m, err := readMessage(br)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var re *responseError
+		if errors.As(err, &re) {
+			if err := writeMessage(w, response(json.RawMessage("null"), nil, re)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if m.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit before shutdown")
+			}
+			return nil
+		}
+		result, err := s.handle(m)
+		if !m.isRequest() {
+			if err != nil {
+				s.logError(err)
+			}
+			continue
+		}
+		if err := writeMessage(w, response(m.ID, result, err)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a message and returns the result of requests.
+func (s *Server) handle(m *message) (any, error) {
+	switch m.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync": map[string]any{
+					"openClose": true,
+					"change":    1, // Full
+					"save":      true,
+				},
+				"codeActionProvider": true,
+			},
+			"serverInfo": map[string]any{"name": "ctxweaver"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := unmarshalParams(m, &params); err != nil {
+			return nil, err
+		}
+		if path, ok := uriToPath(params.TextDocument.URI); ok {
+			s.documents[path] = []byte(params.TextDocument.Text)
+		}
+		return nil, s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := unmarshalParams(m, &params); err != nil {
+			return nil, err
+		}
+		path, ok := uriToPath(params.TextDocument.URI)
+		if ok && len(params.ContentChanges) > 0 {
+			s.documents[path] = []byte(params.ContentChanges[len(params.ContentChanges)-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didSave":
+		var params documentParams
+		if err := unmarshalParams(m, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.publishDiagnostics(params.TextDocument.URI)
+	case "textDocument/didClose":
+		var params documentParams
+		if err := unmarshalParams(m, &params); err != nil {
+			return nil, err
+		}
+		if path, ok := uriToPath(params.TextDocument.URI); ok {
+			delete(s.documents, path)
+		}
+		return nil, writeMessage(s.out, notification("textDocument/publishDiagnostics",
+			publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []diagnostic{}}))
+	case "textDocument/codeAction":
+		var params codeActionParams
+		if err := unmarshalParams(m, &params); err != nil {
+			return nil, err
+		}
+		return s.codeActions(params)
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil, nil
+	default:
+		return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + m.Method}
+	}
+}
+
+// unmarshalParams decodes the parameters of m into v.
+func unmarshalParams(m *message, v any) error {
+	if err := json.Unmarshal(m.Params, v); err != nil {
+		return &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("%s: %v", m.Method, err)}
+	}
+	return nil
+}
+
+// publishDiagnostics reports the functions of the document needing changes.
+func (s *Server) publishDiagnostics(uri string) error {
+	path, ok := uriToPath(uri)
+	if !ok {
+		return nil
+	}
+	result, err := s.process(path)
+	if err != nil {
+		return err
+	}
+
+	diagnostics := []diagnostic{}
+	for _, fc := range result.ModifiedFuncs {
+		if fc.File != path {
+			continue
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    lspRange{Start: position{Line: fc.Line - 1}, End: position{Line: fc.Line}},
+			Severity: severityWarning,
+			Source:   "ctxweaver",
+			Message:  fmt.Sprintf("%s: %s", fc.Func, fc.Reason),
+		})
+	}
+	return writeMessage(s.out, notification("textDocument/publishDiagnostics",
+		publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics}))
+}
+
+// codeActions returns the actions available for the function at the start of
+// the range: weaving it when it needs changes, removing its generated statements.
+func (s *Server) codeActions(params codeActionParams) ([]codeAction, error) {
+	actions := []codeAction{}
+	path, ok := uriToPath(params.TextDocument.URI)
+	if !ok {
+		return actions, nil
+	}
+	line := params.Range.Start.Line + 1
+
+	for _, a := range []struct {
+		title, kind string
+		remove      bool
+	}{
+		{TitleWeave, "quickfix", false},
+		{TitleRemove, "refactor.rewrite", true},
+	} {
+		result, err := s.process(path, processor.WithSelection(path, line), processor.WithRemove(a.remove))
+		if err != nil {
+			return nil, err
+		}
+		content, ok := result.Contents[path]
+		if !ok {
+			continue
+		}
+		old, err := s.content(path)
+		if err != nil {
+			return nil, err
+		}
+		// The exact lines inserted or removed, so that the rest of the buffer
+		// (e.g. the cursor and the undo history) is left alone
+		var edits []textEdit
+		for _, e := range patch.Edits(old, content) {
+			edits = append(edits, textEdit{
+				Range:   lspRange{Start: position{Line: e.Start}, End: position{Line: e.End}},
+				NewText: e.Text,
+			})
+		}
+		actions = append(actions, codeAction{
+			Title:       a.title,
+			Kind:        a.kind,
+			IsPreferred: a.kind == "quickfix",
+			Edit:        workspaceEdit{Changes: map[string][]textEdit{params.TextDocument.URI: edits}},
+		})
+	}
+	return actions, nil
+}
+
+// process runs the processor in dry run mode on the package of the file at path,
+// with the contents of the open documents.
+func (s *Server) process(path string, opts ...processor.Option) (*processor.ProcessResult, error) {
+	opts = append(opts,
+		processor.WithDryRun(true),
+		processor.WithKeepContents(true),
+		processor.WithOverlay(maps.Clone(s.documents)),
+	)
+	return s.newProcessor(opts...).Process([]string{"file=" + path})
+}
+
+// content returns the content of the file at path, as open in the editor if it is.
+func (s *Server) content(path string) ([]byte, error) {
+	if content, ok := s.documents[path]; ok {
+		return content, nil
+	}
+	return os.ReadFile(path)
+}
+
+// logError reports an error of a notification to the client.
+func (s *Server) logError(err error) {
+	_ = writeMessage(s.out, notification("window/logMessage", logMessageParams{Type: messageError, Message: err.Error()}))
+}
@@ -0,0 +1,264 @@
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/internal/lsp"
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+// session encodes client messages framed by Content-Length headers.
+type session struct {
+	bytes.Buffer
+	id int
+}
+
+func (s *session) request(method string, params any) {
+	s.id++
+	s.write(map[string]any{"jsonrpc": "2.0", "id": s.id, "method": method, "params": params})
+}
+
+func (s *session) notify(method string, params any) {
+	s.write(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+func (s *session) write(v any) {
+	body, _ := json.Marshal(v)
+	fmt.Fprintf(s, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// serverMessage is a response or notification sent by the server.
+type serverMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// readMessages decodes the messages written by the server.
+func readMessages(t *testing.T, out []byte) []serverMessage {
+	t.Helper()
+	r := bufio.NewReader(bytes.NewReader(out))
+	var messages []serverMessage
+	for {
+		header, err := textproto.NewReader(r).ReadMIMEHeader()
+		if err == io.EOF {
+			return messages
+		}
+		if err != nil {
+			t.Fatalf("failed to read header: %v", err)
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		var m serverMessage
+		if err := json.Unmarshal(body, &m); err != nil {
+			t.Fatalf("failed to decode %s: %v", body, err)
+		}
+		messages = append(messages, m)
+	}
+}
+
+type diagnostic struct {
+	Range struct {
+		Start struct{ Line int } `json:"start"`
+	} `json:"range"`
+	Message string `json:"message"`
+}
+
+type textEdit struct {
+	Range struct {
+		Start struct{ Line int } `json:"start"`
+		End   struct{ Line int } `json:"end"`
+	} `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type codeAction struct {
+	Title string `json:"title"`
+	Edit  struct {
+		Changes map[string][]textEdit `json:"changes"`
+	} `json:"edit"`
+}
+
+func edit(start, end int, text string) textEdit {
+	var e textEdit
+	e.Range.Start.Line, e.Range.End.Line, e.NewText = start, end, text
+	return e
+}
+
+func TestServer(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module testmod\n\ngo 1.21\n",
+		// The saved file has no carrier yet
+		"main.go": "package main\n\nfunc Foo() {\n}\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	path := filepath.Join(tmpDir, "main.go")
+	uri := "file://" + filepath.ToSlash(path)
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	server := lsp.NewServer(func(opts ...processor.Option) *processor.Processor {
+		return processor.New(config.NewCarrierRegistry(true), tmpl, nil, opts...)
+	})
+
+	buffer := `package main
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func Bar(ctx context.Context) {
+}
+
+func trace(context.Context) {}
+`
+	var in session
+	in.request("initialize", map[string]any{"capabilities": map[string]any{}})
+	in.notify("initialized", map[string]any{})
+	in.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "go", "version": 1, "text": buffer},
+	})
+	at := func(line int) map[string]any {
+		pos := map[string]any{"line": line, "character": 0}
+		return map[string]any{"textDocument": map[string]any{"uri": uri}, "range": map[string]any{"start": pos, "end": pos}}
+	}
+	in.request("textDocument/codeAction", at(8)) // Bar
+	in.request("textDocument/codeAction", at(5)) // Foo
+	in.request("textDocument/hover", at(5))
+	in.request("shutdown", nil)
+	in.notify("exit", nil)
+
+	var out bytes.Buffer
+	if err := server.Serve(&in, &out); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+	messages := readMessages(t, out.Bytes())
+	if len(messages) != 6 {
+		t.Fatalf("got %d messages, want 6: %s", len(messages), out.Bytes())
+	}
+
+	t.Run("initialize", func(t *testing.T) {
+		if !strings.Contains(string(messages[0].Result), `"codeActionProvider":true`) {
+			t.Errorf("unexpected capabilities: %s", messages[0].Result)
+		}
+	})
+
+	t.Run("diagnostics of the open buffer", func(t *testing.T) {
+		m := messages[1]
+		if m.Method != "textDocument/publishDiagnostics" {
+			t.Fatalf("Method = %q, want textDocument/publishDiagnostics", m.Method)
+		}
+		var params struct {
+			URI         string       `json:"uri"`
+			Diagnostics []diagnostic `json:"diagnostics"`
+		}
+		_ = json.Unmarshal(m.Params, &params)
+		if params.URI != uri || len(params.Diagnostics) != 1 {
+			t.Fatalf("unexpected diagnostics: %s", m.Params)
+		}
+		if d := params.Diagnostics[0]; d.Range.Start.Line != 8 || d.Message != "Bar: missing instrumentation" {
+			t.Errorf("unexpected diagnostic: %+v", d)
+		}
+	})
+
+	t.Run("code actions", func(t *testing.T) {
+		// Edits are the exact lines inserted or removed
+		tests := map[string]struct {
+			index    int
+			want     []string
+			wantEdit textEdit
+		}{
+			"weave": {
+				index:    2,
+				want:     []string{lsp.TitleWeave},
+				wantEdit: edit(9, 9, "\tdefer trace(ctx)\n\n"),
+			},
+			"remove": {
+				index:    3,
+				want:     []string{lsp.TitleRemove},
+				wantEdit: edit(4, 7, "func Foo(ctx context.Context) {}\n"),
+			},
+		}
+		for name, tt := range tests {
+			t.Run(name, func(t *testing.T) {
+				var actions []codeAction
+				if err := json.Unmarshal(messages[tt.index].Result, &actions); err != nil {
+					t.Fatalf("failed to decode %s: %v", messages[tt.index].Result, err)
+				}
+				var titles []string
+				for _, a := range actions {
+					titles = append(titles, a.Title)
+				}
+				if diff := cmp.Diff(tt.want, titles); diff != "" {
+					t.Fatalf("titles mismatch (-want +got):\n%s", diff)
+				}
+				if diff := cmp.Diff([]textEdit{tt.wantEdit}, actions[0].Edit.Changes[uri]); diff != "" {
+					t.Errorf("edits mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		if e := messages[4].Error; e == nil || e.Code != -32601 {
+			t.Errorf("Error = %+v, want method not found", e)
+		}
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		if m := messages[5]; m.ID == nil || *m.ID != 5 || string(m.Result) != "null" {
+			t.Errorf("unexpected shutdown response: %+v", m)
+		}
+	})
+
+	t.Run("saved file is left alone", func(t *testing.T) {
+		content, _ := os.ReadFile(path)
+		if string(content) != files["main.go"] {
+			t.Errorf("file modified:\n%s", content)
+		}
+	})
+}
+
+func TestServer_ExitBeforeShutdown(t *testing.T) {
+	t.Parallel()
+
+	var in session
+	in.notify("exit", nil)
+
+	server := lsp.NewServer(nil)
+	if err := server.Serve(&in, io.Discard); err == nil {
+		t.Error("expected error for exit before shutdown")
+	}
+}
@@ -0,0 +1,230 @@
+// Package patch formats file modifications as unified diffs accepted by git
+// apply, and as line edits for editors.
+package patch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around changes.
+const contextLines = 3
+
+// noNewline marks a line without a trailing newline.
+const noNewline = "\\ No newline at end of file\n"
+
+// edit is a line of the diff: kept (' '), removed ('-') or added ('+').
+type edit struct {
+	kind byte
+	line string
+}
+
+// Unified returns the modification of the file at path from old to new as a
+// git-style unified diff, or nil if the contents are equal.
+// path is slash-separated and relative to the repository root.
+func Unified(path string, old, new []byte) []byte {
+	edits := diffLines(splitLines(string(old)), splitLines(string(new)))
+
+	var sb strings.Builder
+	for _, h := range hunks(edits) {
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n", path, path, path, path)
+		}
+		h.write(&sb, edits)
+	}
+	if sb.Len() == 0 {
+		return nil
+	}
+	return []byte(sb.String())
+}
+
+// LineEdit replaces the lines of the old content in [Start, End), 0-based,
+// with Text. An insertion has Start equal to End.
+type LineEdit struct {
+	Start, End int
+	Text       string
+}
+
+// Edits returns the modification from old to new as the replacements of the
+// runs of changed lines, in order, or nil if the contents are equal.
+func Edits(old, new []byte) []LineEdit {
+	var result []LineEdit
+	var line int
+	var cur *LineEdit
+	for _, e := range diffLines(splitLines(string(old)), splitLines(string(new))) {
+		if e.kind == ' ' {
+			if cur != nil {
+				result = append(result, *cur)
+				cur = nil
+			}
+			line++
+			continue
+		}
+		if cur == nil {
+			cur = &LineEdit{Start: line, End: line}
+		}
+		if e.kind == '-' {
+			cur.End++
+			line++
+		} else {
+			cur.Text += e.line
+		}
+	}
+	if cur != nil {
+		result = append(result, *cur)
+	}
+	return result
+}
+
+// splitLines splits s into lines, keeping their trailing newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script from a to b (Myers' algorithm).
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back from the end through the recorded frontiers
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY && x > 0 && y > 0 {
+			x, y = x-1, y-1
+			edits = append(edits, edit{' ', a[x]})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			edits = append(edits, edit{'+', b[y]})
+		} else {
+			x--
+			edits = append(edits, edit{'-', a[x]})
+		}
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// hunk is a range of edits shown with its surrounding context.
+type hunk struct {
+	start, end       int // Range of edits
+	oldLine, newLine int // 1-based line numbers of the first edit
+}
+
+// hunks groups the changes of edits into hunks, merging those whose contexts overlap.
+func hunks(edits []edit) []hunk {
+	var result []hunk
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(edits); {
+		if edits[i].kind == ' ' {
+			oldLine, newLine = oldLine+1, newLine+1
+			i++
+			continue
+		}
+
+		// Include the leading context
+		start := max(i-contextLines, 0)
+		h := hunk{start: start, oldLine: oldLine - (i - start), newLine: newLine - (i - start)}
+
+		// Extend while the next change is within the trailing and leading contexts
+		end, kept := i, 0
+		for end < len(edits) && kept <= 2*contextLines {
+			if edits[end].kind == ' ' {
+				kept++
+			} else {
+				kept = 0
+			}
+			end++
+		}
+		h.end = min(end-kept+contextLines, len(edits))
+		result = append(result, h)
+
+		for ; i < h.end; i++ {
+			switch edits[i].kind {
+			case ' ':
+				oldLine, newLine = oldLine+1, newLine+1
+			case '-':
+				oldLine++
+			case '+':
+				newLine++
+			}
+		}
+	}
+	return result
+}
+
+// write writes the header and lines of the hunk.
+func (h hunk) write(sb *strings.Builder, edits []edit) {
+	var oldCount, newCount int
+	for _, e := range edits[h.start:h.end] {
+		if e.kind != '+' {
+			oldCount++
+		}
+		if e.kind != '-' {
+			newCount++
+		}
+	}
+	fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(h.oldLine, oldCount), hunkRange(h.newLine, newCount))
+	for _, e := range edits[h.start:h.end] {
+		sb.WriteByte(e.kind)
+		sb.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			sb.WriteString("\n" + noNewline)
+		}
+	}
+}
+
+// hunkRange formats the start line and line count of a hunk side.
+// An empty side starts at the line preceding it.
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line-1)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
@@ -0,0 +1,136 @@
+package patch_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/internal/patch"
+)
+
+func TestUnified(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		old  string
+		new  string
+		want string
+	}{
+		"equal": {
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		"insertion with context": {
+			old: "package main\n\nfunc Foo(ctx context.Context) {\n\tdo()\n}\n",
+			new: "package main\n\nfunc Foo(ctx context.Context) {\n\tdefer trace(ctx)\n\n\tdo()\n}\n",
+			want: "diff --git a/main.go b/main.go\n--- a/main.go\n+++ b/main.go\n" +
+				"@@ -1,5 +1,7 @@\n package main\n \n func Foo(ctx context.Context) {\n+\tdefer trace(ctx)\n+\n \tdo()\n }\n",
+		},
+		"distant changes in separate hunks": {
+			old: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new: "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			want: `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -1,3 +1,4 @@
++0
+ 1
+ 2
+ 3
+@@ -9,4 +10,3 @@
+ 9
+ 10
+ 11
+-12
+`,
+		},
+		"close changes merged": {
+			old: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			new: "1\nx\n3\n4\n5\n6\n7\ny\n",
+			want: `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -1,8 +1,8 @@
+ 1
+-2
++x
+ 3
+ 4
+ 5
+ 6
+ 7
+-8
++y
+`,
+		},
+		"missing trailing newline": {
+			old: "a\nb",
+			new: "a\nb\n",
+			want: `diff --git a/main.go b/main.go
+--- a/main.go
++++ b/main.go
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := string(patch.Unified("main.go", []byte(tt.old), []byte(tt.new)))
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Unified() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEdits(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		old  string
+		new  string
+		want []patch.LineEdit
+	}{
+		"equal": {
+			old: "a\nb\n",
+			new: "a\nb\n",
+		},
+		"insertion": {
+			old:  "func Foo(ctx context.Context) {\n\tdo()\n}\n",
+			new:  "func Foo(ctx context.Context) {\n\tdefer trace(ctx)\n\n\tdo()\n}\n",
+			want: []patch.LineEdit{{Start: 1, End: 1, Text: "\tdefer trace(ctx)\n\n"}},
+		},
+		"removal": {
+			old:  "func Foo(ctx context.Context) {\n\tdefer trace(ctx)\n\n\tdo()\n}\n",
+			new:  "func Foo(ctx context.Context) {\n\tdo()\n}\n",
+			want: []patch.LineEdit{{Start: 1, End: 3}},
+		},
+		"replacements of separate runs": {
+			old: "1\n2\n3\n4\n",
+			new: "1\nx\n3\ny\nz\n",
+			want: []patch.LineEdit{
+				{Start: 1, End: 2, Text: "x\n"},
+				{Start: 3, End: 4, Text: "y\nz\n"},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := patch.Edits([]byte(tt.old), []byte(tt.new))
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Edits() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
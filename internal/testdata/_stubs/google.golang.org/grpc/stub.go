@@ -0,0 +1,10 @@
+// Package grpc is a stub for testing.
+package grpc
+
+import "context"
+
+type ServerStream interface {
+	Context() context.Context
+	SendMsg(m any) error
+	RecvMsg(m any) error
+}
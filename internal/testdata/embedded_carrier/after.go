@@ -0,0 +1,36 @@
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+// AppContext extends echo.Context with application state.
+type AppContext struct {
+	echo.Context
+	UserID string
+}
+
+// Handler embeds echo.Context in an interface.
+type Handler interface {
+	echo.Context
+	User() string
+}
+
+func GetUser(c AppContext) error {
+	defer newrelic.FromContext(c.Context.Request().Context()).StartSegment("api.GetUser").End()
+
+	return nil
+}
+
+func GetUserPtr(c *AppContext) error {
+	defer newrelic.FromContext(c.Context.Request().Context()).StartSegment("api.GetUserPtr").End()
+
+	return nil
+}
+
+func CreateUser(h Handler) error {
+	defer newrelic.FromContext(h.Request().Context()).StartSegment("api.CreateUser").End()
+
+	return nil
+}
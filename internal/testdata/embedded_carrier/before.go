@@ -0,0 +1,32 @@
+package api
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// AppContext extends echo.Context with application state.
+type AppContext struct {
+	echo.Context
+	UserID string
+}
+
+// Handler embeds echo.Context in an interface.
+type Handler interface {
+	echo.Context
+	User() string
+}
+
+func GetUser(c AppContext) error {
+
+	return nil
+}
+
+func GetUserPtr(c *AppContext) error {
+
+	return nil
+}
+
+func CreateUser(h Handler) error {
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package greeter
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+	"google.golang.org/grpc"
+)
+
+type Request struct{}
+
+type Reply struct{}
+
+// Greeter_ListServer is a generated server-streaming type.
+type Greeter_ListServer interface {
+	Send(*Reply) error
+	grpc.ServerStream
+}
+
+// Greeter_ChatServer is a generated bidirectional-streaming type.
+type Greeter_ChatServer interface {
+	Send(*Reply) error
+	Recv() (*Request, error)
+	grpc.ServerStream
+}
+
+type Server struct{}
+
+func (s *Server) Hello(ctx context.Context, req *Request) (*Reply, error) {
+	defer newrelic.FromContext(ctx).StartSegment("greeter.(*Server).Hello").End()
+
+	return &Reply{}, nil
+}
+
+func (s *Server) List(req *Request, stream Greeter_ListServer) error {
+	defer newrelic.FromContext(stream.Context()).StartSegment("greeter.(*Server).List").End()
+
+	return stream.Send(&Reply{})
+}
+
+func (s *Server) Chat(stream Greeter_ChatServer) error {
+	defer newrelic.FromContext(stream.Context()).StartSegment("greeter.(*Server).Chat").End()
+
+	return nil
+}
+
+func (s *Server) Raw(req *Request, stream grpc.ServerStream) error {
+	defer newrelic.FromContext(stream.Context()).StartSegment("greeter.(*Server).Raw").End()
+
+	return nil
+}
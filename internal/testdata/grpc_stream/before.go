@@ -0,0 +1,46 @@
+package greeter
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+type Request struct{}
+
+type Reply struct{}
+
+// Greeter_ListServer is a generated server-streaming type.
+type Greeter_ListServer interface {
+	Send(*Reply) error
+	grpc.ServerStream
+}
+
+// Greeter_ChatServer is a generated bidirectional-streaming type.
+type Greeter_ChatServer interface {
+	Send(*Reply) error
+	Recv() (*Request, error)
+	grpc.ServerStream
+}
+
+type Server struct{}
+
+func (s *Server) Hello(ctx context.Context, req *Request) (*Reply, error) {
+
+	return &Reply{}, nil
+}
+
+func (s *Server) List(req *Request, stream Greeter_ListServer) error {
+
+	return stream.Send(&Reply{})
+}
+
+func (s *Server) Chat(stream Greeter_ChatServer) error {
+
+	return nil
+}
+
+func (s *Server) Raw(req *Request, stream grpc.ServerStream) error {
+
+	return nil
+}
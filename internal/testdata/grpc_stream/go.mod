@@ -0,0 +1,11 @@
+module test
+
+go 1.21
+
+require google.golang.org/grpc v0.0.0
+
+require github.com/newrelic/go-agent/v3/newrelic v0.0.0
+
+replace google.golang.org/grpc => ../_stubs/google.golang.org/grpc
+
+replace github.com/newrelic/go-agent/v3/newrelic => ../_stubs/github.com/newrelic/go-agent/v3/newrelic
@@ -0,0 +1,26 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	defer newrelic.FromContext(r.Context()).StartSegment("web.ListUsers").End()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type Server struct{}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	defer newrelic.FromContext(req.Context()).StartSegment("web.(*Server).ServeHTTP").End()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Not a handler shape: the writer is missing
+func Render(name string, r *http.Request) string {
+	return name
+}
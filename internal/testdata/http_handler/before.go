@@ -0,0 +1,22 @@
+package web
+
+import (
+	"net/http"
+)
+
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type Server struct{}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// Not a handler shape: the writer is missing
+func Render(name string, r *http.Request) string {
+	return name
+}
@@ -0,0 +1,7 @@
+module test
+
+go 1.21
+
+require github.com/newrelic/go-agent/v3/newrelic v0.0.0
+
+replace github.com/newrelic/go-agent/v3/newrelic => ../_stubs/github.com/newrelic/go-agent/v3/newrelic
@@ -0,0 +1,16 @@
+package test
+
+import (
+	"context"
+	"runtime/trace"
+
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func Foo(ctx context.Context) error {
+	ctx, span := oteltrace.Tracer{}.Start(ctx, "test.Foo")
+	defer span.End()
+
+	trace.Log(ctx, "test", "Foo")
+	return nil
+}
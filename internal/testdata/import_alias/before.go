@@ -0,0 +1,12 @@
+package test
+
+import (
+	"context"
+	"runtime/trace"
+)
+
+func Foo(ctx context.Context) error {
+
+	trace.Log(ctx, "test", "Foo")
+	return nil
+}
@@ -0,0 +1,7 @@
+module test
+
+go 1.21
+
+require go.opentelemetry.io/otel/trace v0.0.0
+
+replace go.opentelemetry.io/otel/trace => ../_stubs/go.opentelemetry.io/otel/trace
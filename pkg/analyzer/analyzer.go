@@ -0,0 +1,125 @@
+// Package analyzer exposes ctxweaver as a go/analysis analyzer, so that
+// analysis drivers (gopls, go vet -vettool, golangci-lint, singlechecker)
+// report the functions needing instrumentation with a suggested fix weaving it.
+package analyzer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/analysis"
+
+	"github.com/mpyw/ctxweaver/internal/patch"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+)
+
+// Doc is the documentation of the analyzer.
+const Doc = `report functions missing the statements woven by ctxweaver
+
+Every function the processor would insert or update statements in is
+reported, with a suggested fix applying the exact lines of the weave.`
+
+// New returns an analyzer reporting the functions proc would modify.
+// Files are transformed with proc.TransformFile, without loading their
+// packages again; the processor is not used otherwise. Drivers analyze
+// packages concurrently, which TransformFile is safe for.
+func New(proc *processor.Processor) *analysis.Analyzer {
+	return &analysis.Analyzer{
+		Name: "ctxweaver",
+		Doc:  Doc,
+		URL:  "https://github.com/mpyw/ctxweaver",
+		Run: func(pass *analysis.Pass) (any, error) {
+			for _, file := range pass.Files {
+				if err := run(pass, proc, file); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		},
+	}
+}
+
+// run reports the functions of file proc would modify.
+func run(pass *analysis.Pass, proc *processor.Processor, file *ast.File) error {
+	tf := pass.Fset.File(file.Pos())
+	if tf == nil {
+		return nil
+	}
+	src, err := pass.ReadFile(tf.Name())
+	if err != nil {
+		return err
+	}
+
+	imports := make(map[string]string)
+	for _, imp := range pass.Pkg.Imports() {
+		imports[imp.Path()] = imp.Name()
+	}
+	opts := processor.TransformOptions{
+		PkgPath:  pass.Pkg.Path(),
+		PkgName:  pass.Pkg.Name(),
+		Imports:  imports,
+		Filename: tf.Name(),
+	}
+	if pass.Module != nil {
+		opts.ModulePath = pass.Module.Path
+	}
+	out, changed, err := proc.TransformFile(src, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", tf.Name(), err)
+	}
+	if !changed {
+		return nil
+	}
+
+	// Edits within a function belong to its fix, and the others (e.g. imports)
+	// to the fix of every function
+	var shared []analysis.TextEdit
+	var funcs []*ast.FuncDecl
+	byFunc := make(map[*ast.FuncDecl][]analysis.TextEdit)
+	for _, e := range patch.Edits(src, out) {
+		edit := analysis.TextEdit{Pos: lineStart(tf, e.Start), End: lineStart(tf, e.End), NewText: []byte(e.Text)}
+		decl := enclosingFunc(file, edit.Pos)
+		if decl == nil {
+			shared = append(shared, edit)
+			continue
+		}
+		if _, ok := byFunc[decl]; !ok {
+			funcs = append(funcs, decl)
+		}
+		byFunc[decl] = append(byFunc[decl], edit)
+	}
+
+	for _, decl := range funcs {
+		pass.Report(analysis.Diagnostic{
+			Pos:     decl.Name.Pos(),
+			End:     decl.Name.End(),
+			Message: fmt.Sprintf("%s needs woven instrumentation", decl.Name.Name),
+			SuggestedFixes: []analysis.SuggestedFix{{
+				Message:   "Weave instrumentation into this function",
+				TextEdits: append(byFunc[decl], shared...),
+			}},
+		})
+	}
+	return nil
+}
+
+// lineStart returns the position of the given 0-based line of tf, or the end
+// of the file past its last line.
+func lineStart(tf *token.File, line int) token.Pos {
+	if line >= tf.LineCount() {
+		return token.Pos(tf.Base() + tf.Size())
+	}
+	return tf.LineStart(line + 1)
+}
+
+// enclosingFunc returns the function declaration of file containing pos, or
+// nil if there is none.
+func enclosingFunc(file *ast.File, pos token.Pos) *ast.FuncDecl {
+	for _, decl := range file.Decls {
+		if fd, ok := decl.(*ast.FuncDecl); ok && fd.Pos() <= pos && pos < fd.End() {
+			return fd
+		}
+	}
+	return nil
+}
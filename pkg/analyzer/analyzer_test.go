@@ -0,0 +1,68 @@
+package analyzer_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+
+	"github.com/mpyw/ctxweaver/pkg/analyzer"
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestAnalyzer(t *testing.T) {
+	files := map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.25\n",
+		"service/service.go": `package service
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) { // want "Foo needs woven instrumentation"
+	println("foo")
+}
+
+func Bar(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func helper() {}
+`,
+		// The fix applied to the file
+		"service/service.go.golden": `package service
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) { // want "Foo needs woven instrumentation"
+	defer trace(ctx)
+
+	println("foo")
+}
+
+func Bar(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func helper() {}
+`,
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	proc := processor.New(config.NewCarrierRegistry(true), template.MustParse(`defer trace({{.Ctx}})`), nil)
+	analysistest.RunWithSuggestedFixes(t, dir, analyzer.New(proc), "./...")
+}
@@ -0,0 +1,89 @@
+package carrier
+
+import (
+	"go/types"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+// MatchEmbedded finds a registered carrier embedded in typ and returns a
+// MatchResult whose accessor reaches the context through the embedding.
+// It returns nil if typ is itself a carrier or embeds none.
+//
+// Embedded struct fields are selected explicitly (e.g. "c.Context.Request().Context()"
+// for a struct embedding echo.Context), so the accessor stays correct even if the
+// outer type shadows a promoted method. Carriers embedded in interfaces are reached
+// through their promoted methods. The shallowest carrier wins, following Go's
+// promotion rules; when several are at the same depth, the first declared wins.
+func MatchEmbedded(varName string, typ types.Type, registry *config.CarrierRegistry) *MatchResult {
+	if varName == "" || varName == "_" || typ == nil {
+		return nil
+	}
+
+	type candidate struct {
+		typ      types.Type
+		path     string // Accessor prefix selecting this embedded value
+		embedded bool   // False only for typ itself
+	}
+
+	visited := make(map[types.Type]bool)
+	level := []candidate{{typ: typ}}
+	for len(level) > 0 {
+		var next []candidate
+		for _, c := range level {
+			named := namedOf(c.typ)
+			if named != nil {
+				if visited[named] {
+					continue
+				}
+				visited[named] = true
+
+				if c.embedded {
+					if def, ok := lookupNamed(named, registry); ok {
+						def.Accessor = c.path + def.Accessor
+						return &MatchResult{Carrier: def, VarName: varName}
+					}
+				}
+			}
+
+			switch u := types.Unalias(deref(c.typ)).Underlying().(type) {
+			case *types.Struct:
+				for i := range u.NumFields() {
+					if f := u.Field(i); f.Embedded() {
+						next = append(next, candidate{typ: f.Type(), path: c.path + "." + f.Name(), embedded: true})
+					}
+				}
+			case *types.Interface:
+				for i := range u.NumEmbeddeds() {
+					// Methods of embedded interfaces are promoted; no selector is needed
+					next = append(next, candidate{typ: u.EmbeddedType(i), path: c.path, embedded: true})
+				}
+			}
+		}
+		level = next
+	}
+	return nil
+}
+
+// deref strips a single pointer indirection.
+func deref(typ types.Type) types.Type {
+	if ptr, ok := types.Unalias(typ).(*types.Pointer); ok {
+		return ptr.Elem()
+	}
+	return typ
+}
+
+// namedOf returns the named type of typ or *typ, or nil.
+func namedOf(typ types.Type) *types.Named {
+	named, _ := types.Unalias(deref(typ)).(*types.Named)
+	return named
+}
+
+// lookupNamed looks up a named type in the registry.
+func lookupNamed(named *types.Named, registry *config.CarrierRegistry) (config.CarrierDef, bool) {
+	obj := named.Obj()
+	if obj.Pkg() == nil {
+		return config.CarrierDef{}, false
+	}
+	return registry.Lookup(obj.Pkg().Path(), obj.Name())
+}
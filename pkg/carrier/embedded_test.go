@@ -0,0 +1,104 @@
+package carrier_test
+
+import (
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/mpyw/ctxweaver/pkg/carrier"
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+func TestMatchEmbedded(t *testing.T) {
+	t.Parallel()
+
+	registry := config.NewCarrierRegistry(true)
+
+	echoPkg := types.NewPackage("github.com/labstack/echo/v4", "echo")
+	echoContext := types.NewNamed(types.NewTypeName(token.NoPos, echoPkg, "Context", nil), types.NewInterfaceType(nil, nil), nil)
+	httpPkg := types.NewPackage("net/http", "http")
+	httpRequest := types.NewNamed(types.NewTypeName(token.NoPos, httpPkg, "Request", nil), types.NewStruct(nil, nil), nil)
+
+	appPkg := types.NewPackage("example.com/app", "app")
+	named := func(name string, underlying types.Type) *types.Named {
+		return types.NewNamed(types.NewTypeName(token.NoPos, appPkg, name, nil), underlying, nil)
+	}
+	embed := func(name string, typ types.Type) *types.Var {
+		return types.NewField(token.NoPos, appPkg, name, typ, true)
+	}
+	field := func(name string, typ types.Type) *types.Var {
+		return types.NewField(token.NoPos, appPkg, name, typ, false)
+	}
+
+	appContext := named("AppContext", types.NewStruct([]*types.Var{embed("Context", echoContext)}, nil))
+	appInterface := named("AppInterface", types.NewInterfaceType(nil, []types.Type{echoContext}))
+	wrapped := named("Wrapped", types.NewStruct([]*types.Var{embed("AppContext", types.NewPointer(appContext))}, nil))
+	withRequest := named("WithRequest", types.NewStruct([]*types.Var{field("Name", types.Typ[types.String]), embed("Request", types.NewPointer(httpRequest))}, nil))
+	notEmbedded := named("NotEmbedded", types.NewStruct([]*types.Var{field("Ctx", echoContext)}, nil))
+	self := named("Self", nil)
+	self.SetUnderlying(types.NewStruct([]*types.Var{embed("Self", types.NewPointer(self))}, nil))
+
+	tests := map[string]struct {
+		typ          types.Type
+		wantAccessor string
+		wantMatch    bool
+	}{
+		"struct embedding interface carrier": {
+			typ:          appContext,
+			wantAccessor: ".Context.Request().Context()",
+			wantMatch:    true,
+		},
+		"pointer to struct embedding carrier": {
+			typ:          types.NewPointer(appContext),
+			wantAccessor: ".Context.Request().Context()",
+			wantMatch:    true,
+		},
+		"interface embedding carrier": {
+			typ:          appInterface,
+			wantAccessor: ".Request().Context()",
+			wantMatch:    true,
+		},
+		"nested embedding": {
+			typ:          wrapped,
+			wantAccessor: ".AppContext.Context.Request().Context()",
+			wantMatch:    true,
+		},
+		"embedded pointer carrier": {
+			typ:          withRequest,
+			wantAccessor: ".Request.Context()",
+			wantMatch:    true,
+		},
+		"named field is not promoted": {
+			typ: notEmbedded,
+		},
+		"carrier itself is not an embedded match": {
+			typ: echoContext,
+		},
+		"recursive embedding terminates": {
+			typ: self,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			result := carrier.MatchEmbedded("c", tt.typ, registry)
+			if !tt.wantMatch {
+				if result != nil {
+					t.Errorf("MatchEmbedded() = %+v, want nil", result)
+				}
+				return
+			}
+			if result == nil {
+				t.Fatal("MatchEmbedded() = nil, want match")
+			}
+			if result.Carrier.Accessor != tt.wantAccessor {
+				t.Errorf("Accessor = %q, want %q", result.Carrier.Accessor, tt.wantAccessor)
+			}
+			if result.VarName != "c" {
+				t.Errorf("VarName = %q, want %q", result.VarName, "c")
+			}
+		})
+	}
+}
@@ -33,20 +33,37 @@ func Match(param *dst.Field, registry *config.CarrierRegistry) *MatchResult {
 
 	varName := param.Names[0].Name
 
+	pkgPath, typeName, ok := typeRef(param.Type)
+	if !ok {
+		return nil
+	}
+
+	carrier, found := registry.Lookup(pkgPath, typeName)
+	if !found {
+		return nil
+	}
+
+	return &MatchResult{
+		Carrier: carrier,
+		VarName: varName,
+	}
+}
+
+// typeRef returns the package path and name of a type expression,
+// stripping a single pointer indirection.
+// It returns false if the expression is not a resolved named type.
+func typeRef(typ dst.Expr) (pkgPath, typeName string, ok bool) {
 	// Handle pointer types
-	typ := param.Type
 	if star, ok := typ.(*dst.StarExpr); ok {
 		typ = star.X
 	}
 
-	var pkgPath, typeName string
-
 	switch t := typ.(type) {
 	case *dst.SelectorExpr:
 		// SelectorExpr: pkg.Type with path set by NewDecoratorFromPackage
 		pkgIdent, ok := t.X.(*dst.Ident)
 		if !ok {
-			return nil
+			return "", "", false
 		}
 		pkgPath = pkgIdent.Path
 		typeName = t.Sel.Name
@@ -57,20 +74,11 @@ func Match(param *dst.Field, registry *config.CarrierRegistry) *MatchResult {
 		typeName = t.Name
 
 	default:
-		return nil
+		return "", "", false
 	}
 
 	if pkgPath == "" {
-		return nil
-	}
-
-	carrier, found := registry.Lookup(pkgPath, typeName)
-	if !found {
-		return nil
-	}
-
-	return &MatchResult{
-		Carrier: carrier,
-		VarName: varName,
+		return "", "", false
 	}
+	return pkgPath, typeName, true
 }
@@ -0,0 +1,58 @@
+package carrier
+
+import (
+	"github.com/dave/dst"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+// MatchShape matches function parameters against the registered function shapes.
+// For the first shape whose parameter types all match, it returns the match of
+// the shape's carrier parameter, or nil if no shape matches.
+//
+// Parameters sharing a type (e.g. "a, b T") count as separate parameters.
+// Like Match, this requires type-resolved DST.
+func MatchShape(params *dst.FieldList, registry *config.CarrierRegistry) *MatchResult {
+	if params == nil {
+		return nil
+	}
+
+	// Flatten grouped parameters so that indices follow the signature
+	var fields []*dst.Field
+	for _, f := range params.List {
+		if len(f.Names) <= 1 {
+			fields = append(fields, f)
+			continue
+		}
+		for _, name := range f.Names {
+			fields = append(fields, &dst.Field{Names: []*dst.Ident{name}, Type: f.Type})
+		}
+	}
+
+	for _, shape := range registry.Shapes() {
+		if len(shape.Params) != len(fields) || shape.Carrier < 0 || shape.Carrier >= len(fields) {
+			continue
+		}
+		if !matchesParams(fields, shape.Params) {
+			continue
+		}
+		if result := Match(fields[shape.Carrier], registry); result != nil {
+			return result
+		}
+	}
+	return nil
+}
+
+// matchesParams reports whether the parameter types match the shape's type names.
+func matchesParams(fields []*dst.Field, names []string) bool {
+	for i, f := range fields {
+		pkgPath, typeName, ok := typeRef(f.Type)
+		if !ok {
+			return false
+		}
+		if !(config.CarrierDef{Package: pkgPath, Type: typeName}).MatchesName(names[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package carrier_test
+
+import (
+	"testing"
+
+	"github.com/dave/dst"
+
+	"github.com/mpyw/ctxweaver/pkg/carrier"
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+func TestMatchShape(t *testing.T) {
+	t.Parallel()
+
+	registry := config.NewCarrierRegistry(true)
+	registry.RegisterShape(config.FuncShape{
+		Params:  []string{"http.ResponseWriter", "http.ResponseWriter", "http.Request"},
+		Carrier: 2,
+	})
+
+	writer := func() dst.Expr { return &dst.Ident{Name: "ResponseWriter", Path: "net/http"} }
+	request := func() dst.Expr { return &dst.StarExpr{X: &dst.Ident{Name: "Request", Path: "net/http"}} }
+	field := func(typ dst.Expr, names ...string) *dst.Field {
+		f := &dst.Field{Type: typ}
+		for _, name := range names {
+			f.Names = append(f.Names, &dst.Ident{Name: name})
+		}
+		return f
+	}
+
+	tests := map[string]struct {
+		params      []*dst.Field
+		wantVarName string
+		wantMatch   bool
+	}{
+		"http handler": {
+			params:      []*dst.Field{field(writer(), "w"), field(request(), "r")},
+			wantVarName: "r",
+			wantMatch:   true,
+		},
+		"grouped params are flattened": {
+			params:      []*dst.Field{field(writer(), "a", "b"), field(request(), "req")},
+			wantVarName: "req",
+			wantMatch:   true,
+		},
+		"unresolved type": {
+			params: []*dst.Field{field(&dst.Ident{Name: "string"}, "w"), field(request(), "r")},
+		},
+		"params in another order": {
+			params: []*dst.Field{field(request(), "r"), field(writer(), "w")},
+		},
+		"extra param": {
+			params: []*dst.Field{field(writer(), "w"), field(request(), "r"), field(writer(), "x")},
+		},
+		"blank carrier param": {
+			params: []*dst.Field{field(writer(), "w"), field(request(), "_")},
+		},
+		"no params": {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			result := carrier.MatchShape(&dst.FieldList{List: tt.params}, registry)
+			if !tt.wantMatch {
+				if result != nil {
+					t.Errorf("MatchShape() = %+v, want nil", result)
+				}
+				return
+			}
+			if result == nil {
+				t.Fatal("MatchShape() = nil, want match")
+			}
+			if result.VarName != tt.wantVarName {
+				t.Errorf("VarName = %q, want %q", result.VarName, tt.wantVarName)
+			}
+			if result.Carrier.Accessor != ".Context()" {
+				t.Errorf("Accessor = %q, want %q", result.Carrier.Accessor, ".Context()")
+			}
+		})
+	}
+}
@@ -4,9 +4,17 @@ package config
 import (
 	"bytes"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
 
+	"github.com/BurntSushi/toml"
 	"github.com/santhosh-tekuri/jsonschema/v6"
 	"gopkg.in/yaml.v3"
 
@@ -22,13 +30,15 @@ var schemaJSON []byte
 // Parsed at init time - failure here means corrupted embedded files.
 var (
 	defaultCarriers []CarrierDef
+	defaultShapes   []FuncShape
 	configSchema    *jsonschema.Schema
 )
 
 func init() {
 	// Parse embedded carriers.yaml
 	var carriersFile CarriersFile
-	defaultCarriers = internal.Must(carriersFile, yaml.Unmarshal(defaultCarriersYAML, &carriersFile)).Carriers
+	internal.Must(struct{}{}, yaml.Unmarshal(defaultCarriersYAML, &carriersFile))
+	defaultCarriers, defaultShapes = carriersFile.Carriers, carriersFile.Shapes
 
 	// Parse and compile embedded schema.json
 	schemaDoc := internal.Must(jsonschema.UnmarshalJSON(bytes.NewReader(schemaJSON)))
@@ -38,15 +48,91 @@ func init() {
 }
 
 // LoadConfig loads a configuration file.
-func LoadConfig(path string) (*Config, error) {
+// The format is decided by the file extension: ".json" for JSON, ".toml" for TOML,
+// and YAML otherwise. All formats are validated against the same JSON Schema,
+// and the templates are checked if a checker is given (see WithTemplateChecker).
+func LoadConfig(path string, opts ...LoadOption) (*Config, error) {
+	return loadConfig(path, nil, opts)
+}
+
+// LoadConfigWithTemplate loads a configuration file as LoadConfig does, with
+// its template replaced by t (e.g. given on the command line), so that the
+// template of the file is neither read nor checked.
+func LoadConfigWithTemplate(path string, t Template, opts ...LoadOption) (*Config, error) {
+	return loadConfig(path, &t, opts)
+}
+
+// LoadOption configures how a configuration is loaded.
+type LoadOption func(*loadOptions)
+
+// loadOptions holds the options of a load.
+type loadOptions struct {
+	checkTemplate TemplateChecker // Checks every template; nil to leave them unchecked
+}
+
+// TemplateChecker checks the content of a template, which is marked with
+// the generated marker in marker matching mode if marked is set.
+type TemplateChecker func(content string, marked bool) error
+
+// WithTemplateChecker checks every template of the configuration with check:
+// the template, epilogue and hot template, those of the overrides and of the
+// special functions. Package template provides its validation as
+// template.Check.
+func WithTemplateChecker(check TemplateChecker) LoadOption {
+	return func(o *loadOptions) {
+		o.checkTemplate = check
+	}
+}
+
+// loadConfig loads a configuration file, with its template replaced by tmpl
+// unless nil.
+func loadConfig(path string, tmpl *Template, opts []LoadOption) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
+	cfg, err := parseConfig(path, data, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkTemplates(newLoadOptions(opts).checkTemplate, true); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
 
-	// Parse YAML to generic interface for schema validation
-	var raw any
-	if err := yaml.Unmarshal(data, &raw); err != nil {
+// ParseConfig parses configuration contents, e.g. received over the network,
+// as LoadConfig does for the file at path: the format is decided by the
+// extension of path, which is not read. Relative paths in the configuration
+// (template files, carriers file) are relative to the working directory.
+// Template files are not read, so only inline and preset templates are
+// checked.
+func ParseConfig(path string, data []byte, opts ...LoadOption) (*Config, error) {
+	cfg, err := parseConfig(path, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.checkTemplates(newLoadOptions(opts).checkTemplate, false); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// newLoadOptions applies opts.
+func newLoadOptions(opts []LoadOption) loadOptions {
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// parseConfig parses and validates configuration contents, with the template
+// replaced by tmpl unless nil, without checking the templates.
+func parseConfig(path string, data []byte, tmpl *Template) (*Config, error) {
+	// Parse to generic interface for schema validation
+	raw, err := decodeRaw(path, data)
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
 
@@ -55,6 +141,13 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
+	// Non-YAML formats are converted to YAML so that the custom
+	// UnmarshalYAML implementations apply regardless of the source format,
+	// and so are the configurations importing packages under aliases
+	if flattenImports(raw) || !isYAML(path) {
+		data = internal.Must(yaml.Marshal(raw)) // unreachable failure: raw consists of plain decoded values
+	}
+
 	// Unmarshal directly into struct
 	// This error is unreachable in normal flow: if schema validation passes,
 	// struct unmarshaling should succeed. Only reachable if schema and struct diverge.
@@ -62,13 +155,283 @@ func LoadConfig(path string) (*Config, error) {
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
+	if tmpl != nil {
+		cfg.Template = *tmpl
+	}
+
+	// Merge carriers from the shared carriers file
+	if cfg.Carriers.File != "" {
+		file, err := LoadCarriersFile(cfg.Carriers.File)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Carriers.Custom = append(file.Carriers, cfg.Carriers.Custom...)
+		cfg.Carriers.Shapes = append(file.Shapes, cfg.Carriers.Shapes...)
+	}
+	for i, shape := range cfg.Carriers.Shapes {
+		if err := shape.validate(); err != nil {
+			return nil, fmt.Errorf("invalid config: carriers.shapes[%d]: %w", i, err)
+		}
+	}
 
 	// Set defaults
 	cfg.SetDefaults()
 
+	// The marker trails the last generated statement, where a block comment would
+	// swallow the rest of the line
+	if strings.HasPrefix(strings.TrimSpace(cfg.Marker), "/*") {
+		return nil, fmt.Errorf("invalid config: marker must be a line comment")
+	}
+
+	if err := validatePatterns("conflicts", cfg.Conflicts); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	for name, sf := range cfg.SpecialFuncs.All() {
+		if sf.Mode == SpecialFuncExclude && sf.Template != nil {
+			return nil, fmt.Errorf("invalid config: special_functions.%s: template cannot be combined with mode exclude", name)
+		}
+	}
+
+	if cfg.HotTemplate == nil && cfg.usesHotPaths() {
+		return nil, fmt.Errorf("invalid config: functions.hot_paths requires hot_template")
+	}
+	if cfg.HotTemplate == nil && cfg.Emit.Mode == EmitHotBuildTag {
+		return nil, fmt.Errorf("invalid config: emit mode hot_build_tag requires hot_template")
+	}
+
+	if err := cfg.checkPresetPackages(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	// References to the context rewritten in the epilogue would never match it again
+	if cfg.Epilogue != nil && cfg.CtxRewrite != "" {
+		return nil, fmt.Errorf("invalid config: epilogue cannot be combined with ctx_rewrite")
+	}
+
 	return &cfg, nil
 }
 
+// TemplateError reports a template of the configuration rejected by the
+// template checker, or whose file could not be read.
+type TemplateError struct {
+	Name string // Option of the template, e.g. "template" or "overrides[0].epilogue"
+	Err  error
+}
+
+func (e *TemplateError) Error() string { return fmt.Sprintf("%s: %v", e.Name, e.Err) }
+
+func (e *TemplateError) Unwrap() error { return e.Err }
+
+// checkTemplates checks every template of the configuration with
+// checkTemplate, if any. Template files are only read if readFiles is set.
+func (c *Config) checkTemplates(checkTemplate TemplateChecker, readFiles bool) error {
+	if checkTemplate == nil {
+		return nil
+	}
+	// The generated marker is appended to the statements of the templates, not
+	// to those of the epilogues
+	marked := c.Matching == MatchingMarker
+	check := func(name string, t *Template, marked bool) error {
+		if t == nil || t.Inline == "" && t.File == "" && t.Preset == "" || t.File != "" && !readFiles {
+			return nil
+		}
+		content, err := t.Content()
+		if err == nil {
+			err = checkTemplate(content, marked)
+		}
+		if err != nil {
+			return &TemplateError{Name: name, Err: err}
+		}
+		return nil
+	}
+
+	if err := check("template", &c.Template, marked); err != nil {
+		return err
+	}
+	if err := check("epilogue", c.Epilogue, false); err != nil {
+		return err
+	}
+	if err := check("hot_template", c.HotTemplate, marked); err != nil {
+		return err
+	}
+	for i, o := range c.Overrides {
+		if err := check(fmt.Sprintf("overrides[%d].template", i), o.Template, marked); err != nil {
+			return err
+		}
+		if err := check(fmt.Sprintf("overrides[%d].epilogue", i), o.Epilogue, false); err != nil {
+			return err
+		}
+	}
+	for name, sf := range c.SpecialFuncs.All() {
+		if err := check("special_functions."+name+".template", sf.Template, marked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkPresetPackages checks that the packages the preset templates refer to
+// are imported.
+func (c *Config) checkPresetPackages() error {
+	imports := ParseImports(c.Imports)
+	if err := checkPresetPackages("template", &c.Template, imports); err != nil {
+		return err
+	}
+	if err := checkPresetPackages("hot_template", c.HotTemplate, imports); err != nil {
+		return err
+	}
+	for i, o := range c.Overrides {
+		if err := checkPresetPackages(fmt.Sprintf("overrides[%d].template", i), o.Template, slices.Concat(imports, ParseImports(o.Imports))); err != nil {
+			return err
+		}
+	}
+	for name, sf := range c.SpecialFuncs.All() {
+		if err := checkPresetPackages("special_functions."+name+".template", sf.Template, imports); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// usesHotPaths reports whether the base function filter or that of an
+// override has hot paths.
+func (c *Config) usesHotPaths() bool {
+	if len(c.Functions.HotPaths) > 0 {
+		return true
+	}
+	for _, o := range c.Overrides {
+		if o.Functions != nil && len(o.Functions.HotPaths) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePatterns returns an error for the first malformed path.Match
+// pattern of patterns, the value of the named option.
+func validatePatterns(option string, patterns []string) error {
+	for i, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s[%d]: %w", option, i, err)
+		}
+	}
+	return nil
+}
+
+// LoadCarriersFile loads carrier definitions and function shapes from a file with
+// the same structure as the embedded carriers.yaml (a top-level "carriers" list
+// and an optional "shapes" list).
+// Like config files, JSON and TOML are accepted by file extension.
+func LoadCarriersFile(path string) (*CarriersFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read carriers file: %w", err)
+	}
+
+	raw, err := decodeRaw(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse carriers file: %w", err)
+	}
+	if !isYAML(path) {
+		data = internal.Must(yaml.Marshal(raw)) // unreachable failure: raw consists of plain decoded values
+	}
+
+	var file CarriersFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid carriers file %s: %w", path, err)
+	}
+	for i, c := range file.Carriers {
+		if c.Package == "" || c.Type == "" {
+			return nil, fmt.Errorf("invalid carriers file %s: carriers[%d]: package and type are required", path, i)
+		}
+	}
+	for i, shape := range file.Shapes {
+		if err := shape.validate(); err != nil {
+			return nil, fmt.Errorf("invalid carriers file %s: shapes[%d]: %w", path, i, err)
+		}
+	}
+	return &file, nil
+}
+
+// flattenImports rewrites the imports given as objects in raw, the decoded
+// configuration, to the alias=path form of Config.Imports, and reports
+// whether there were any.
+func flattenImports(raw any) bool {
+	m, ok := raw.(map[string]any)
+	if !ok {
+		return false
+	}
+	flattened := flattenImportList(m["imports"])
+	overrides, _ := m["overrides"].([]any)
+	for _, o := range overrides {
+		if o, ok := o.(map[string]any); ok && flattenImportList(o["imports"]) {
+			flattened = true
+		}
+	}
+	return flattened
+}
+
+// flattenImportList rewrites the imports given as objects in list to the
+// alias=path form, and reports whether there were any.
+func flattenImportList(list any) bool {
+	imports, _ := list.([]any)
+	var flattened bool
+	for i, imp := range imports {
+		if obj, ok := imp.(map[string]any); ok {
+			path, _ := obj["path"].(string)
+			alias, _ := obj["alias"].(string)
+			imports[i] = Import{Path: path, Alias: alias}.String()
+			flattened = true
+		}
+	}
+	return flattened
+}
+
+// decodeRaw parses config file contents into a generic value according to the file extension.
+func decodeRaw(path string, data []byte) (any, error) {
+	var raw any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	case ".toml":
+		var m map[string]any
+		if _, err := toml.Decode(string(data), &m); err != nil {
+			return nil, err
+		}
+		// Array tables are decoded as []map[string]any, which the schema
+		// validator does not accept; round-trip through JSON to get plain values.
+		b := internal.Must(json.Marshal(m)) // unreachable failure: m consists of plain decoded values
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return nil, err
+		}
+	default:
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, err
+		}
+	}
+	return raw, nil
+}
+
+// isYAML reports whether the config file at path is parsed as YAML.
+func isYAML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".toml":
+		return false
+	default:
+		return true
+	}
+}
+
+// Schema returns the JSON Schema used to validate configuration files.
+func Schema() []byte {
+	return bytes.Clone(schemaJSON)
+}
+
 // validateSchema validates data against the embedded JSON Schema.
 func validateSchema(data any) error {
 	return configSchema.Validate(data)
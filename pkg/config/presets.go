@@ -0,0 +1,117 @@
+package config
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/mpyw/ctxweaver/internal"
+)
+
+//go:embed presets/*.yaml
+var presetsFS embed.FS
+
+// Preset is a built-in template together with the imports it requires and,
+// for templates deriving a new context, the variable to rewrite references to.
+// Presets are selected with `template: {preset: <name>}`.
+type Preset struct {
+	Name        string   `yaml:"-"`
+	Description string   `yaml:"description"`
+	Template    string   `yaml:"template"`
+	Imports     []string `yaml:"imports"`
+	CtxRewrite  string   `yaml:"ctx_rewrite"`
+	// Packages are the packages declared by the user that the template refers
+	// to, imported from the paths set in the packages of the template
+	Packages []PresetPackage `yaml:"packages"`
+}
+
+// PresetPackage is a package declared by the user that a preset refers to.
+type PresetPackage struct {
+	Name        string `yaml:"name"`        // Name the template refers to the package by, e.g. "metrics"
+	Description string `yaml:"description"` // What the package declares, e.g. "declaring FunctionDuration"
+}
+
+// presetImports returns the imports required by the preset of t, if any: those
+// of the preset and the packages set in t under the names the preset refers to.
+func presetImports(t *Template) []Import {
+	if t == nil {
+		return nil
+	}
+	preset, ok := LookupPreset(t.Preset)
+	if !ok {
+		return nil
+	}
+	imports := ParseImports(preset.Imports)
+	for _, pkg := range preset.Packages {
+		p, ok := t.Packages[pkg.Name]
+		if !ok {
+			continue
+		}
+		imp := Import{Path: p}
+		if path.Base(p) != pkg.Name {
+			imp.Alias = pkg.Name
+		}
+		imports = append(imports, imp)
+	}
+	return imports
+}
+
+// checkPresetPackages checks that the packages the preset of t refers to are
+// imported, from the packages of t or from imports, and that t sets no others.
+func checkPresetPackages(option string, t *Template, imports []Import) error {
+	if t == nil {
+		return nil
+	}
+	preset, ok := LookupPreset(t.Preset)
+	if !ok {
+		return nil
+	}
+	for name := range t.Packages {
+		if !slices.ContainsFunc(preset.Packages, func(pkg PresetPackage) bool { return pkg.Name == name }) {
+			return fmt.Errorf("%s: preset %s refers to no package %s", option, t.Preset, name)
+		}
+	}
+	for _, pkg := range preset.Packages {
+		if !slices.ContainsFunc(imports, func(imp Import) bool { return imp.name() == pkg.Name }) {
+			return fmt.Errorf("%s: preset %s refers to package %s %s: set its import path with {preset: %s, packages: {%s: <path>}}",
+				option, t.Preset, pkg.Name, pkg.Description, t.Preset, pkg.Name)
+		}
+	}
+	return nil
+}
+
+// Parsed at init time - failure here means corrupted embedded files.
+var presets = loadPresets()
+
+func loadPresets() map[string]Preset {
+	entries := internal.Must(presetsFS.ReadDir("presets"))
+	m := make(map[string]Preset, len(entries))
+	for _, e := range entries {
+		data := internal.Must(presetsFS.ReadFile(path.Join("presets", e.Name())))
+		var p Preset
+		internal.Must(struct{}{}, yaml.Unmarshal(data, &p))
+		p.Name = strings.TrimSuffix(e.Name(), ".yaml")
+		m[p.Name] = p
+	}
+	return m
+}
+
+// LookupPreset returns the built-in template preset with the given name.
+func LookupPreset(name string) (Preset, bool) {
+	p, ok := presets[name]
+	return p, ok
+}
+
+// Presets returns all built-in template presets sorted by name.
+func Presets() []Preset {
+	result := make([]Preset, 0, len(presets))
+	for _, p := range presets {
+		result = append(result, p)
+	}
+	slices.SortFunc(result, func(a, b Preset) int { return strings.Compare(a.Name, b.Name) })
+	return result
+}
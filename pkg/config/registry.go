@@ -1,8 +1,11 @@
 package config
 
+import "slices"
+
 // CarrierRegistry holds all registered carriers for quick lookup.
 type CarrierRegistry struct {
 	carriers map[string]CarrierDef // key: "package.Type"
+	shapes   []FuncShape
 }
 
 // NewCarrierRegistry creates a registry, optionally loading default carriers.
@@ -14,6 +17,9 @@ func NewCarrierRegistry(includeDefaults bool) *CarrierRegistry {
 		for _, c := range defaultCarriers {
 			r.Register(c)
 		}
+		for _, s := range defaultShapes {
+			r.RegisterShape(s)
+		}
 	}
 	return r
 }
@@ -24,6 +30,34 @@ func (r *CarrierRegistry) Register(c CarrierDef) {
 	r.carriers[key] = c
 }
 
+// RegisterShape adds a function shape to the registry.
+// Shapes are matched in registration order.
+func (r *CarrierRegistry) RegisterShape(s FuncShape) {
+	r.shapes = append(r.shapes, s)
+}
+
+// Shapes returns all registered function shapes in registration order.
+func (r *CarrierRegistry) Shapes() []FuncShape {
+	return r.shapes
+}
+
+// Remove deletes a carrier from the registry.
+// Returns false if the carrier was not registered.
+func (r *CarrierRegistry) Remove(packagePath, typeName string) bool {
+	key := packagePath + "." + typeName
+	if _, ok := r.carriers[key]; !ok {
+		return false
+	}
+	delete(r.carriers, key)
+	return true
+}
+
+// Has reports whether a carrier is registered for the package path and type name.
+func (r *CarrierRegistry) Has(packagePath, typeName string) bool {
+	_, ok := r.Lookup(packagePath, typeName)
+	return ok
+}
+
 // Lookup finds a carrier by package path and type name.
 func (r *CarrierRegistry) Lookup(packagePath, typeName string) (CarrierDef, bool) {
 	key := packagePath + "." + typeName
@@ -39,3 +73,8 @@ func (r *CarrierRegistry) All() []CarrierDef {
 	}
 	return result
 }
+
+// DefaultCarriers returns the built-in carrier definitions.
+func DefaultCarriers() []CarrierDef {
+	return slices.Clone(defaultCarriers)
+}
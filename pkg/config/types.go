@@ -2,8 +2,13 @@ package config
 
 import (
 	"fmt"
+	"iter"
 	"os"
+	"path"
+	"slices"
+	"strings"
 
+	"golang.org/x/mod/semver"
 	"gopkg.in/yaml.v3"
 )
 
@@ -12,6 +17,12 @@ type CarrierDef struct {
 	Package  string `yaml:"package" json:"package"`
 	Type     string `yaml:"type" json:"type"`
 	Accessor string `yaml:"accessor" json:"accessor,omitempty"`
+	// AnyParam allows the carrier at any parameter position, not only the first
+	// (e.g. the stream of a server-streaming gRPC method follows the request)
+	AnyParam bool `yaml:"any_param,omitempty" json:"any_param,omitempty"`
+	// Embedded marks a carrier usually reached through a type embedding it
+	// (e.g. generated gRPC streams), which is only detected with type information
+	Embedded bool `yaml:"embedded,omitempty" json:"embedded,omitempty"`
 }
 
 // BuildContextExpr builds the expression to access context.Context from a variable.
@@ -19,9 +30,66 @@ func (c CarrierDef) BuildContextExpr(varName string) string {
 	return varName + c.Accessor
 }
 
+// MatchesName reports whether name refers to the carrier, either as
+// "package/path.Type" or as "name.Type" with the last element of the package
+// path, ignoring a major version suffix (e.g. "echo.Context" for
+// github.com/labstack/echo/v4). A leading "*" is ignored.
+func (c CarrierDef) MatchesName(name string) bool {
+	name = strings.TrimPrefix(name, "*")
+	if name == c.Package+"."+c.Type {
+		return true
+	}
+	elems := strings.Split(c.Package, "/")
+	last := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(last) {
+		last = elems[len(elems)-2]
+	}
+	return name == last+"."+c.Type
+}
+
+// isMajorVersion reports whether elem is a major version suffix such as "v2".
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' {
+		return false
+	}
+	for _, r := range elem[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FuncShape defines a function signature whose carrier is not the first
+// parameter, such as func(w http.ResponseWriter, r *http.Request).
+type FuncShape struct {
+	// Params name the parameter types in order (see CarrierDef.MatchesName)
+	Params []string `yaml:"params" json:"params"`
+	// Carrier is the index of the carrier parameter, which must match a registered carrier
+	Carrier int `yaml:"carrier" json:"carrier"`
+}
+
+// validate checks that the carrier index refers to one of the parameters.
+func (s FuncShape) validate() error {
+	if s.Carrier < 0 || s.Carrier >= len(s.Params) {
+		return fmt.Errorf("carrier index %d out of range for %d params", s.Carrier, len(s.Params))
+	}
+	return nil
+}
+
 // CarriersFile represents the structure of carriers.yaml.
 type CarriersFile struct {
 	Carriers []CarrierDef `yaml:"carriers"`
+	Shapes   []FuncShape  `yaml:"shapes,omitempty"`
+}
+
+// ScaffoldFile is a helper file written when missing, e.g. the package
+// providing the function called by the template.
+type ScaffoldFile struct {
+	// Path is the file to write, relative to the working directory
+	Path string `yaml:"path" json:"path"`
+	// Template is the Go template of the file content (inline or file)
+	Template Template `yaml:"template" json:"template"`
 }
 
 // Hooks defines shell commands to run before and after processing.
@@ -32,14 +100,35 @@ type Hooks struct {
 	Post []string `yaml:"post" json:"post,omitempty"`
 }
 
+// Plugin defines a plugin reviewing the statements rendered for every function.
+type Plugin struct {
+	// Exec is a shell command reading the function and its statements as JSON
+	// on standard input and writing its decision as JSON on standard output
+	Exec string `yaml:"exec" json:"exec"`
+}
+
+// FieldInit defines a field set in every composite literal of a struct type.
+type FieldInit struct {
+	// Type is the struct type, as "package/path.Type" or "name.Type"
+	Type string `yaml:"type" json:"type"`
+	// Field is the name of the field
+	Field string `yaml:"field" json:"field"`
+	// Value is the Go template for the expression of the field
+	Value string `yaml:"value" json:"value"`
+}
+
 // Template can be an inline string or a reference to a file.
 type Template struct {
 	Inline string
 	File   string
+	Preset string
+	// Packages are the import paths of the packages the preset refers to, by
+	// the name it refers to them by (e.g. "metrics" for the prometheus preset)
+	Packages map[string]string
 }
 
 // UnmarshalYAML implements custom unmarshaling for Template.
-// Accepts either a string (inline template) or an object with "file" field.
+// Accepts either a string (inline template) or an object with a "file" or "preset" field.
 func (t *Template) UnmarshalYAML(value *yaml.Node) error {
 	switch value.Kind {
 	case yaml.ScalarNode:
@@ -47,17 +136,21 @@ func (t *Template) UnmarshalYAML(value *yaml.Node) error {
 		t.Inline = value.Value
 		return nil
 	case yaml.MappingNode:
-		// Object with "file" field
+		// Object with "file" or "preset" field
 		var obj struct {
-			File string `yaml:"file"`
+			File     string            `yaml:"file"`
+			Preset   string            `yaml:"preset"`
+			Packages map[string]string `yaml:"packages"`
 		}
 		if err := value.Decode(&obj); err != nil {
 			return err // unreachable via LoadConfig: schema validation catches malformed objects first
 		}
 		t.File = obj.File
+		t.Preset = obj.Preset
+		t.Packages = obj.Packages
 		return nil
 	default:
-		return fmt.Errorf("template must be a string or an object with 'file' field")
+		return fmt.Errorf("template must be a string or an object with 'file' or 'preset' field")
 	}
 }
 
@@ -66,10 +159,16 @@ func (t Template) MarshalYAML() (any, error) {
 	if t.File != "" {
 		return map[string]string{"file": t.File}, nil
 	}
+	if t.Preset != "" {
+		if len(t.Packages) > 0 {
+			return map[string]any{"preset": t.Preset, "packages": t.Packages}, nil
+		}
+		return map[string]string{"preset": t.Preset}, nil
+	}
 	return t.Inline, nil
 }
 
-// Content returns the template content, loading from file if necessary.
+// Content returns the template content, loading from file or preset if necessary.
 func (t *Template) Content() (string, error) {
 	if t.Inline != "" {
 		return t.Inline, nil
@@ -81,17 +180,92 @@ func (t *Template) Content() (string, error) {
 		}
 		return string(data), nil
 	}
+	if t.Preset != "" {
+		preset, ok := LookupPreset(t.Preset)
+		if !ok {
+			return "", fmt.Errorf("unknown template preset %q", t.Preset)
+		}
+		return preset.Template, nil
+	}
 	return "", fmt.Errorf("template is empty")
 }
 
+// Import is an import added when the template is inserted, optionally under
+// an alias the template can refer to. Config.Imports lists them in the form
+// accepted by ParseImport.
+// Simple form: imports: [go.opentelemetry.io/otel]
+// Extended form: imports: [{ path: go.opentelemetry.io/otel/trace, alias: oteltrace }]
+type Import struct {
+	Path  string
+	Alias string
+}
+
+// ImportsPolicy selects what is done before processing with the configured
+// imports that the module cannot import yet.
+type ImportsPolicy string
+
+const (
+	// ImportsPolicyAuto adds the modules providing them to go.mod with go get.
+	ImportsPolicyAuto ImportsPolicy = "auto"
+	// ImportsPolicyRequireExisting fails without processing anything.
+	ImportsPolicyRequireExisting ImportsPolicy = "require-existing"
+)
+
+// ParseImport parses an import given as a path or as alias=path.
+func ParseImport(s string) Import {
+	if alias, path, ok := strings.Cut(s, "="); ok {
+		return Import{Path: path, Alias: alias}
+	}
+	return Import{Path: s}
+}
+
+// ParseImports parses imports given as paths or as alias=path (see
+// ParseImport), e.g. Config.Imports. It returns nil for nil imports.
+func ParseImports(imports []string) []Import {
+	if imports == nil {
+		return nil
+	}
+	parsed := make([]Import, 0, len(imports))
+	for _, s := range imports {
+		parsed = append(parsed, ParseImport(s))
+	}
+	return parsed
+}
+
+// name returns the name the import is referred to by: its alias, or the last
+// element of its path.
+func (i Import) name() string {
+	if i.Alias != "" {
+		return i.Alias
+	}
+	return path.Base(i.Path)
+}
+
+// String returns the import as accepted by ParseImport.
+func (i Import) String() string {
+	if i.Alias != "" {
+		return i.Alias + "=" + i.Path
+	}
+	return i.Path
+}
+
 // Carriers can be a simple array of CarrierDef or an object with custom/default fields.
 // Simple form: carriers: []
-// Extended form: carriers: { custom: [], default: true }
+// Extended form: carriers: { file: path, custom: [], default: true, exclude_default: [], priority: [], shapes: [] }
 type Carriers struct {
+	// File is a shared carriers file whose definitions are merged before Custom
+	File string
 	// Custom are user-defined carrier definitions
 	Custom []CarrierDef
 	// Default indicates whether to include default carriers (default: true)
 	Default *bool
+	// ExcludeDefault names default carriers to leave out (see CarrierDef.MatchesName)
+	ExcludeDefault []string
+	// Priority names carriers in priority order. If set, any parameter may be
+	// the carrier and the highest-ranked one wins (see CarrierDef.MatchesName)
+	Priority []string
+	// Shapes are user-defined function shapes
+	Shapes []FuncShape
 }
 
 // UseDefault returns whether default carriers should be used.
@@ -102,6 +276,28 @@ func (c *Carriers) UseDefault() bool {
 	return *c.Default
 }
 
+// Excludes reports whether the default carrier is excluded by ExcludeDefault.
+func (c *Carriers) Excludes(def CarrierDef) bool {
+	return slices.ContainsFunc(c.ExcludeDefault, def.MatchesName)
+}
+
+// Registry creates a registry with the enabled default carriers and the custom ones.
+func (c *Carriers) Registry() *CarrierRegistry {
+	r := NewCarrierRegistry(c.UseDefault())
+	for _, def := range defaultCarriers {
+		if c.Excludes(def) {
+			r.Remove(def.Package, def.Type)
+		}
+	}
+	for _, def := range c.Custom {
+		r.Register(def)
+	}
+	for _, shape := range c.Shapes {
+		r.RegisterShape(shape)
+	}
+	return r
+}
+
 // UnmarshalYAML implements custom unmarshaling for Carriers.
 // Accepts either an array (simple form) or an object with "custom" and "default" fields.
 func (c *Carriers) UnmarshalYAML(value *yaml.Node) error {
@@ -115,16 +311,24 @@ func (c *Carriers) UnmarshalYAML(value *yaml.Node) error {
 		c.Custom = arr
 		return nil
 	case yaml.MappingNode:
-		// Extended object form: carriers: { custom: [], default: true }
+		// Extended object form: carriers: { file: path, custom: [], default: true, exclude_default: [], priority: [], shapes: [] }
 		var obj struct {
-			Custom  []CarrierDef `yaml:"custom"`
-			Default *bool        `yaml:"default"`
+			File           string       `yaml:"file"`
+			Custom         []CarrierDef `yaml:"custom"`
+			Default        *bool        `yaml:"default"`
+			ExcludeDefault []string     `yaml:"exclude_default"`
+			Priority       []string     `yaml:"priority"`
+			Shapes         []FuncShape  `yaml:"shapes"`
 		}
 		if err := value.Decode(&obj); err != nil {
 			return err // unreachable via LoadConfig: schema validation catches malformed objects first
 		}
+		c.File = obj.File
 		c.Custom = obj.Custom
 		c.Default = obj.Default
+		c.ExcludeDefault = obj.ExcludeDefault
+		c.Priority = obj.Priority
+		c.Shapes = obj.Shapes
 		return nil
 	default:
 		return fmt.Errorf("carriers must be an array or an object with 'custom' and 'default' fields")
@@ -133,12 +337,25 @@ func (c *Carriers) UnmarshalYAML(value *yaml.Node) error {
 
 // MarshalYAML implements custom marshaling for Carriers.
 func (c Carriers) MarshalYAML() (any, error) {
-	// If Default is explicitly set (not nil), use object form
-	if c.Default != nil {
-		return map[string]any{
-			"custom":  c.Custom,
-			"default": *c.Default,
-		}, nil
+	// If any field other than Custom is set, use object form
+	if c.File != "" || c.Default != nil || len(c.ExcludeDefault) > 0 || len(c.Priority) > 0 || len(c.Shapes) > 0 {
+		m := map[string]any{"custom": c.Custom}
+		if c.File != "" {
+			m["file"] = c.File
+		}
+		if c.Default != nil {
+			m["default"] = *c.Default
+		}
+		if len(c.ExcludeDefault) > 0 {
+			m["exclude_default"] = c.ExcludeDefault
+		}
+		if len(c.Priority) > 0 {
+			m["priority"] = c.Priority
+		}
+		if len(c.Shapes) > 0 {
+			m["shapes"] = c.Shapes
+		}
+		return m, nil
 	}
 	// Otherwise use simple array form
 	return c.Custom, nil
@@ -158,6 +375,58 @@ type Packages struct {
 	Patterns []string `yaml:"patterns" json:"patterns"`
 	// Regexps for filtering packages by import path
 	Regexps Regexps `yaml:"regexps" json:"regexps,omitempty"`
+	// IncludeGenerated selects the generated files processed anyway
+	IncludeGenerated IncludeGenerated `yaml:"include_generated" json:"include_generated,omitempty"`
+	// SkipTestdata indicates whether files in testdata directories are skipped (default: true)
+	SkipTestdata *bool `yaml:"skip_testdata" json:"skip_testdata,omitempty"`
+}
+
+// SkipsTestdata returns whether files in testdata directories are skipped.
+func (p *Packages) SkipsTestdata() bool {
+	return p.SkipTestdata == nil || *p.SkipTestdata
+}
+
+// IncludeGenerated selects the generated files ("// Code generated ... DO NOT
+// EDIT.") processed anyway, which are otherwise left alone: all of them, or
+// those whose slash-separated path relative to the module root matches one of
+// the regexps.
+type IncludeGenerated struct {
+	All     bool
+	Regexps []string
+}
+
+// UnmarshalYAML implements custom unmarshaling for IncludeGenerated.
+// Accepts either a boolean or an array of regexps.
+func (g *IncludeGenerated) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return value.Decode(&g.All)
+	case yaml.SequenceNode:
+		return value.Decode(&g.Regexps)
+	default:
+		return fmt.Errorf("include_generated must be a boolean or an array of regexps")
+	}
+}
+
+// MarshalYAML implements custom marshaling for IncludeGenerated.
+func (g IncludeGenerated) MarshalYAML() (any, error) {
+	if len(g.Regexps) > 0 {
+		return g.Regexps, nil
+	}
+	return g.All, nil
+}
+
+// Receivers defines method filtering options on receiver types.
+type Receivers struct {
+	// Regexps for filtering methods by receiver type (e.g., "*Service", "Handler")
+	Regexps Regexps `yaml:"regexps" json:"regexps,omitempty"`
+}
+
+// Signatures defines function filtering options on signatures.
+type Signatures struct {
+	// Regexps for filtering functions by canonical signature
+	// (e.g., "(context.Context, int) (string, error)")
+	Regexps Regexps `yaml:"regexps" json:"regexps,omitempty"`
 }
 
 // FuncType represents function type for filtering.
@@ -176,6 +445,167 @@ const (
 	FuncScopeUnexported FuncScope = "unexported"
 )
 
+// MatchingMode selects how existing statements are detected.
+type MatchingMode string
+
+const (
+	// MatchingSkeleton compares statement structure and identifiers, ignoring literal values.
+	MatchingSkeleton MatchingMode = "skeleton"
+	// MatchingPlaceholder additionally treats positions filled by template variables as wildcards.
+	MatchingPlaceholder MatchingMode = "placeholder"
+	// MatchingMarker only treats statements carrying a trailing //ctxweaver:generated marker as generated.
+	MatchingMarker MatchingMode = "marker"
+)
+
+// RefreshMode selects when a detected statement is considered outdated.
+type RefreshMode string
+
+const (
+	// RefreshAll updates a statement whenever it differs from the rendered template.
+	RefreshAll RefreshMode = "all"
+	// RefreshVars only updates a statement when a literal filled by template
+	// variables (e.g. {{.FuncName | quote}} after a rename) differs.
+	RefreshVars RefreshMode = "vars"
+)
+
+// LoadMode selects the package information loaded for processing.
+type LoadMode string
+
+const (
+	// LoadTyped loads syntax and full type information.
+	LoadTyped LoadMode = "typed"
+	// LoadSyntax only loads syntax, unless type information is needed by
+	// functions.implements, functions.reachable_from or an embedded carrier.
+	LoadSyntax LoadMode = "syntax"
+)
+
+// ContextCreatorsMode selects what is done with the functions without
+// context carrier that create their own context with context.Background()
+// or context.TODO().
+type ContextCreatorsMode string
+
+const (
+	// ContextCreatorsIgnore leaves them alone, as any function without carrier.
+	ContextCreatorsIgnore ContextCreatorsMode = "ignore"
+	// ContextCreatorsReport lists them after processing, as candidates for a
+	// context.Context parameter.
+	ContextCreatorsReport ContextCreatorsMode = "report"
+)
+
+// SpecialFuncMode selects whether a special function is woven.
+type SpecialFuncMode string
+
+const (
+	// SpecialFuncInclude weaves the function like any other: only if it has a
+	// context carrier, unless it has a template of its own.
+	SpecialFuncInclude SpecialFuncMode = "include"
+	// SpecialFuncExclude leaves the function alone.
+	SpecialFuncExclude SpecialFuncMode = "exclude"
+)
+
+// SpecialFunc defines the weaving of a special function.
+type SpecialFunc struct {
+	// Mode selects whether the function is woven (default: include)
+	Mode SpecialFuncMode `yaml:"mode" json:"mode,omitempty"`
+	// Template replaces the template for the function, which is then woven
+	// even without a context carrier
+	Template *Template `yaml:"template" json:"template,omitempty"`
+}
+
+// SpecialFuncs defines the weaving of the functions called by the runtime or
+// the testing package rather than by the code.
+type SpecialFuncs struct {
+	// Main is the main function of main packages
+	Main SpecialFunc `yaml:"main" json:"main,omitempty"`
+	// Init are the init functions of every package
+	Init SpecialFunc `yaml:"init" json:"init,omitempty"`
+	// TestMain is the TestMain function of test files
+	TestMain SpecialFunc `yaml:"test_main" json:"test_main,omitempty"`
+}
+
+// All returns the special functions by option name: "main", "init" and
+// "test_main", in that order.
+func (s *SpecialFuncs) All() iter.Seq2[string, *SpecialFunc] {
+	return func(yield func(string, *SpecialFunc) bool) {
+		_ = yield("main", &s.Main) && yield("init", &s.Init) && yield("test_main", &s.TestMain)
+	}
+}
+
+// FormatTool selects the final formatting step of modified files.
+type FormatTool string
+
+const (
+	// FormatGofmt formats files with goimports, in the gofmt style.
+	FormatGofmt FormatTool = "gofmt"
+	// FormatGofumpt formats files with goimports, then with the gofumpt
+	// command, which must be in PATH.
+	FormatGofumpt FormatTool = "gofumpt"
+	// FormatNone only prints files in the canonical gofmt style, without
+	// goimports adding the imports a template needs beyond the configured ones.
+	FormatNone FormatTool = "none"
+)
+
+// Format defines the formatting of modified files.
+type Format struct {
+	// Tool is the final formatting step (default: gofmt)
+	Tool FormatTool `yaml:"tool" json:"tool,omitempty"`
+}
+
+// EmitMode selects where the woven code is written.
+type EmitMode string
+
+const (
+	// EmitInPlace modifies the processed files.
+	EmitInPlace EmitMode = "in_place"
+	// EmitBuildTag writes the woven copy of every processed file into a
+	// variant file behind a build tag, excluding the file from builds with it.
+	EmitBuildTag EmitMode = "build_tag"
+	// EmitHotBuildTag modifies the processed files in place, except for the
+	// functions on hot paths, woven in variant files behind a build tag.
+	EmitHotBuildTag EmitMode = "hot_build_tag"
+)
+
+// DefaultEmitTag is the default build tag of the variant files.
+const DefaultEmitTag = "instrumentation"
+
+// Emit defines where the woven code is written.
+type Emit struct {
+	// Mode selects where the woven code is written (default: in_place)
+	Mode EmitMode `yaml:"mode" json:"mode,omitempty"`
+	// Tag is the build tag of the variant files in build_tag and
+	// hot_build_tag modes (default: instrumentation)
+	Tag string `yaml:"tag" json:"tag,omitempty"`
+}
+
+// BuildTag returns the build tag of the variant files, or an empty string to
+// modify the files in place.
+func (e Emit) BuildTag() string {
+	if e.Mode != EmitBuildTag {
+		return ""
+	}
+	return e.Tag
+}
+
+// HotBuildTag returns the build tag of the variant files holding the
+// instrumentation of hot paths, or an empty string to weave them like the
+// other functions.
+func (e Emit) HotBuildTag() string {
+	if e.Mode != EmitHotBuildTag {
+		return ""
+	}
+	return e.Tag
+}
+
+// FileSet selects the files whose functions are processed.
+type FileSet string
+
+const (
+	// FilesAll processes source files, and test files if test is enabled.
+	FilesAll FileSet = "all"
+	// FilesTestsOnly processes test files only, leaving production code alone.
+	FilesTestsOnly FileSet = "tests_only"
+)
+
 // Functions defines function filtering options.
 type Functions struct {
 	// Types filters by function type (function, method). Default: both.
@@ -184,14 +614,117 @@ type Functions struct {
 	Scopes []FuncScope `yaml:"scopes" json:"scopes,omitempty"`
 	// Regexps for filtering functions by name
 	Regexps Regexps `yaml:"regexps" json:"regexps,omitempty"`
+	// Receivers filters methods by receiver type. Functions without receiver are not affected.
+	Receivers Receivers `yaml:"receivers" json:"receivers,omitempty"`
+	// Signatures filters functions by their canonical signature.
+	Signatures Signatures `yaml:"signatures" json:"signatures,omitempty"`
+	// ReachableFrom restricts processing to functions reachable in the call graph
+	// from these entrypoints (e.g. "main.main", "ServeHTTP"). Default: all functions.
+	ReachableFrom []string `yaml:"reachable_from" json:"reachable_from,omitempty"`
+	// MinStatements skips functions whose body has fewer statements, nested ones
+	// included (e.g. trivial getters). Default: 0 (no minimum).
+	MinStatements int `yaml:"min_statements" json:"min_statements,omitempty"`
+	// SkipDelegates skips functions whose body is a single call to another function
+	// of the same package that is instrumented, avoiding duplicated spans. Default: false.
+	SkipDelegates bool `yaml:"skip_delegates" json:"skip_delegates,omitempty"`
+	// Implements restricts processing to methods of these interfaces implemented
+	// by their receiver ("name.Type" or "package/path.Type"). Default: all functions.
+	Implements []string `yaml:"implements" json:"implements,omitempty"`
+	// Files selects the files processed (all, tests_only). tests_only processes
+	// _test.go files only, whether or not test is enabled. Default: all.
+	Files FileSet `yaml:"files" json:"files,omitempty"`
+	// HotPaths are regex patterns for the names of performance-critical
+	// functions, woven with HotTemplate instead of the template. Default: none.
+	HotPaths []string `yaml:"hot_paths" json:"hot_paths,omitempty"`
 }
 
+// Merge returns f with the non-empty fields of o replacing its own.
+func (f Functions) Merge(o Functions) Functions {
+	if len(o.Types) > 0 {
+		f.Types = o.Types
+	}
+	if len(o.Scopes) > 0 {
+		f.Scopes = o.Scopes
+	}
+	if len(o.Regexps.Only) > 0 {
+		f.Regexps.Only = o.Regexps.Only
+	}
+	if len(o.Regexps.Omit) > 0 {
+		f.Regexps.Omit = o.Regexps.Omit
+	}
+	if len(o.Receivers.Regexps.Only) > 0 {
+		f.Receivers.Regexps.Only = o.Receivers.Regexps.Only
+	}
+	if len(o.Receivers.Regexps.Omit) > 0 {
+		f.Receivers.Regexps.Omit = o.Receivers.Regexps.Omit
+	}
+	if len(o.Signatures.Regexps.Only) > 0 {
+		f.Signatures.Regexps.Only = o.Signatures.Regexps.Only
+	}
+	if len(o.Signatures.Regexps.Omit) > 0 {
+		f.Signatures.Regexps.Omit = o.Signatures.Regexps.Omit
+	}
+	if len(o.ReachableFrom) > 0 {
+		f.ReachableFrom = o.ReachableFrom
+	}
+	if o.MinStatements > 0 {
+		f.MinStatements = o.MinStatements
+	}
+	if o.SkipDelegates {
+		f.SkipDelegates = true
+	}
+	if len(o.Implements) > 0 {
+		f.Implements = o.Implements
+	}
+	if o.Files != "" {
+		f.Files = o.Files
+	}
+	if len(o.HotPaths) > 0 {
+		f.HotPaths = o.HotPaths
+	}
+	return f
+}
+
+// Override is partial configuration merged over the base configuration
+// for packages whose import path matches one of Packages.
+type Override struct {
+	// Packages are regex patterns matched against package import paths
+	Packages []string `yaml:"packages" json:"packages"`
+	// Template replaces the base template (if specified)
+	Template *Template `yaml:"template" json:"template,omitempty"`
+	// Epilogue replaces the base epilogue; an override replacing the template
+	// has no epilogue unless specified
+	Epilogue *Template `yaml:"epilogue" json:"epilogue,omitempty"`
+	// Imports replace the base imports (if specified), as in Config.Imports
+	Imports []string `yaml:"imports" json:"imports,omitempty"`
+	// Functions are merged field by field over the base function filter (if specified)
+	Functions *Functions `yaml:"functions" json:"functions,omitempty"`
+}
+
+// CtxRewriteAuto is the ctx_rewrite value that takes the variable from the
+// template: the first variable defined by `:=` from an expression using {{.Ctx}}.
+const CtxRewriteAuto = "auto"
+
 // Config represents the user configuration file.
 type Config struct {
+	// MinVersion is the oldest ctxweaver version allowed to process the
+	// configuration (e.g. "v1.4.0"), so that every run formats alike
+	MinVersion string `yaml:"min_version" json:"min_version,omitempty"`
 	// Template is the Go template for the statement to insert
 	Template Template `yaml:"template" json:"template"`
-	// Imports are the imports to add when the template is inserted
+	// Epilogue is the Go template for the statements inserted before every
+	// return of the function (if specified), managed along with Template
+	Epilogue *Template `yaml:"epilogue" json:"epilogue,omitempty"`
+	// HotTemplate is the lighter Go template replacing Template for the
+	// functions matching functions.hot_paths, which get no epilogue
+	HotTemplate *Template `yaml:"hot_template" json:"hot_template,omitempty"`
+	// Imports are the imports to add when the template is inserted: import
+	// paths, or alias=path to import a package under an alias (see ParseImport)
 	Imports []string `yaml:"imports" json:"imports,omitempty"`
+	// ImportsPolicy selects what is done with the imports of the base and
+	// override configurations that the module cannot import yet (default:
+	// none, leaving them to break the build)
+	ImportsPolicy ImportsPolicy `yaml:"imports_policy" json:"imports_policy,omitempty"`
 	// Carriers defines context carrier configuration (custom carriers and default toggle)
 	Carriers Carriers `yaml:"carriers" json:"carriers,omitempty"`
 	// Packages defines package filtering options
@@ -200,8 +733,68 @@ type Config struct {
 	Functions Functions `yaml:"functions" json:"functions,omitempty"`
 	// Test indicates whether to process test files
 	Test bool `yaml:"test" json:"test,omitempty"`
+	// Matching selects how existing statements are detected (default: skeleton)
+	Matching MatchingMode `yaml:"matching" json:"matching,omitempty"`
+	// Marker is the line comment marking generated statements in marker mode
+	// (default: //ctxweaver:generated)
+	Marker string `yaml:"marker" json:"marker,omitempty"`
+	// Refresh selects when a detected statement is updated (default: all)
+	Refresh RefreshMode `yaml:"refresh" json:"refresh,omitempty"`
+	// Load selects the package information loaded for processing (default: typed)
+	Load LoadMode `yaml:"load" json:"load,omitempty"`
+	// Format defines the formatting of modified files
+	Format Format `yaml:"format" json:"format,omitempty"`
+	// Emit selects where the woven code is written
+	Emit Emit `yaml:"emit" json:"emit,omitempty"`
+	// CtxRewrite is a variable declared by the template that replaces
+	// references to {{.Ctx}} in the rest of the function body, or
+	// CtxRewriteAuto to detect it from the template
+	CtxRewrite string `yaml:"ctx_rewrite" json:"ctx_rewrite,omitempty"`
+	// Banner writes a banner comment at the top of files containing generated statements
+	Banner bool `yaml:"banner" json:"banner,omitempty"`
 	// Hooks are shell commands to run before and after processing
 	Hooks Hooks `yaml:"hooks" json:"hooks,omitempty"`
+	// Plugins review the statements rendered for every function, in order
+	Plugins []Plugin `yaml:"plugins" json:"plugins,omitempty"`
+	// Conflicts are patterns of calls revealing manual instrumentation
+	// (e.g. "*.Start"); the template is not inserted into functions calling them
+	Conflicts []string `yaml:"conflicts" json:"conflicts,omitempty"`
+	// ContextCreators selects what is done with the functions without carrier
+	// creating their own context (default: ignore)
+	ContextCreators ContextCreatorsMode `yaml:"context_creators" json:"context_creators,omitempty"`
+	// Fields are set in every composite literal of their struct type
+	Fields []FieldInit `yaml:"fields" json:"fields,omitempty"`
+	// Scaffold are helper files written before processing when missing
+	Scaffold []ScaffoldFile `yaml:"scaffold" json:"scaffold,omitempty"`
+	// SpecialFuncs selects whether main, init and TestMain are woven, and with which template
+	SpecialFuncs SpecialFuncs `yaml:"special_functions" json:"special_functions,omitempty"`
+	// Overrides are per-package partial configurations; the first matching entry applies
+	Overrides []Override `yaml:"overrides" json:"overrides,omitempty"`
+}
+
+// CheckMinVersion returns an error if version, the version of the running
+// ctxweaver, is older than MinVersion. Development builds, whose version is
+// empty or "(devel)", are not checked.
+func (c *Config) CheckMinVersion(version string) error {
+	if c.MinVersion == "" || version == "" || version == "(devel)" {
+		return nil
+	}
+	minVersion, current := canonicalVersion(c.MinVersion), canonicalVersion(version)
+	if !semver.IsValid(current) {
+		return nil
+	}
+	if semver.Compare(current, minVersion) < 0 {
+		return fmt.Errorf("config requires ctxweaver %s or later, but this is %s: upgrade with go install github.com/mpyw/ctxweaver/cmd/ctxweaver@%s", minVersion, current, minVersion)
+	}
+	return nil
+}
+
+// canonicalVersion returns the semantic version v with its "v" prefix.
+func canonicalVersion(v string) string {
+	if !strings.HasPrefix(v, "v") {
+		v = "v" + v
+	}
+	return v
 }
 
 // SetDefaults sets default values for optional fields.
@@ -214,4 +807,60 @@ func (c *Config) SetDefaults() {
 	if len(c.Functions.Scopes) == 0 {
 		c.Functions.Scopes = []FuncScope{FuncScopeExported, FuncScopeUnexported}
 	}
+	if c.Functions.Files == "" {
+		c.Functions.Files = FilesAll
+	}
+	// Set default matching mode
+	if c.Matching == "" {
+		c.Matching = MatchingSkeleton
+	}
+	if c.Refresh == "" {
+		c.Refresh = RefreshAll
+	}
+	if c.Load == "" {
+		c.Load = LoadTyped
+	}
+	if c.Format.Tool == "" {
+		c.Format.Tool = FormatGofmt
+	}
+	if c.Emit.Mode == "" {
+		c.Emit.Mode = EmitInPlace
+	}
+	if c.Emit.Tag == "" {
+		c.Emit.Tag = DefaultEmitTag
+	}
+	if c.ContextCreators == "" {
+		c.ContextCreators = ContextCreatorsIgnore
+	}
+	for _, sf := range c.SpecialFuncs.All() {
+		if sf.Mode == "" {
+			sf.Mode = SpecialFuncInclude
+		}
+		// The processor has no imports per function: special function presets add theirs to the base
+		c.Imports = addImports(c.Imports, presetImports(sf.Template))
+	}
+	// The processor has no imports per function: the hot template preset adds its own to the base
+	c.Imports = addImports(c.Imports, presetImports(c.HotTemplate))
+	// Add the imports and context rewrite required by the template preset
+	c.Imports = addImports(c.Imports, presetImports(&c.Template))
+	if preset, ok := LookupPreset(c.Template.Preset); ok {
+		if c.CtxRewrite == "" {
+			c.CtxRewrite = preset.CtxRewrite
+		}
+	}
+	// Add the imports required by override template presets
+	for i := range c.Overrides {
+		o := &c.Overrides[i]
+		o.Imports = addImports(o.Imports, presetImports(o.Template))
+	}
+}
+
+// addImports appends the imports whose path is not yet contained in dst.
+func addImports(dst []string, imports []Import) []string {
+	for _, imp := range imports {
+		if !slices.ContainsFunc(dst, func(d string) bool { return ParseImport(d).Path == imp.Path }) {
+			dst = append(dst, imp.String())
+		}
+	}
+	return dst
 }
@@ -2,11 +2,14 @@ package processor
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/dave/dst"
 
 	"github.com/mpyw/ctxweaver/internal/directive"
 	"github.com/mpyw/ctxweaver/internal/dstutil"
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/template"
 )
 
 // Action represents an operation to apply to a function body.
@@ -24,6 +27,12 @@ func (skipAction) Apply(_ *dst.BlockStmt, _ string) bool {
 	return false
 }
 
+// protectedAction represents generated statements left alone because of a
+// skip directive.
+type protectedAction struct {
+	skipAction
+}
+
 // insertAction represents inserting new statements at the beginning.
 type insertAction struct{}
 
@@ -51,61 +60,346 @@ func (a removeAction) Apply(body *dst.BlockStmt, _ string) bool {
 	return dstutil.RemoveStatements(body, a.index, a.count)
 }
 
+// driftAction represents updating statements generated from another version
+// of the template, whose hash is recorded by their generated marker.
+type driftAction struct {
+	updateAction
+	hash string // Recorded hash; empty for markers without one
+}
+
+// dedupeAction represents collapsing repeated statement groups into one.
+// The group at first is kept and updated; the groups at duplicates are removed.
+type dedupeAction struct {
+	first      int
+	duplicates []int // Ascending start indices of groups to remove
+	count      int
+	update     bool // Whether the kept group needs updating
+}
+
+func (a dedupeAction) Apply(body *dst.BlockStmt, rendered string) bool {
+	// Remove from the end so that earlier indices stay valid
+	for i := len(a.duplicates) - 1; i >= 0; i-- {
+		dstutil.RemoveStatements(body, a.duplicates[i], a.count)
+	}
+	if a.update {
+		dstutil.UpdateStatements(body, a.first, a.count, rendered)
+	}
+	return true
+}
+
+// stmtMatch describes a group of existing statements matching the template.
+type stmtMatch struct {
+	index     int
+	exact     bool
+	protected bool // Has a skip directive; must not be touched
+}
+
+// renderedTemplate is the template rendered for a single function.
+type renderedTemplate struct {
+	stmt     string            // Rendered with the function's variables
+	pattern  string            // Rendered with placeholders; empty unless needed
+	bindings map[string]string // Placeholder values; used with refresh mode "vars"
+	ctx      string            // The {{.Ctx}} expression; used to rewrite context references
+	epilogue renderedEpilogue  // Empty without an epilogue
+	veto     string            // Reason of a plugin veto; empty unless vetoed
+}
+
+// renderedEpilogue is the epilogue rendered for a single function.
+type renderedEpilogue struct {
+	stmt    string // Rendered with the function's variables
+	pattern string // Rendered with placeholders; empty unless needed
+}
+
 // detectAction determines what action to take for a function body.
 // Uses skeleton matching to compare AST structure. Supports multi-statement templates.
-func (p *Processor) detectAction(body *dst.BlockStmt, renderedStmt string) (Action, error) {
-	// Parse the rendered statements for skeleton comparison
-	targetStmts, err := dstutil.ParseStatements(renderedStmt)
+// In placeholder matching mode, the template rendered with placeholders is used
+// for skeleton matching, so that positions filled by template variables match anything.
+func (p *Processor) detectAction(body *dst.BlockStmt, rt renderedTemplate) (Action, error) {
+	matches, stmtCount, err := p.matchTemplate(body, rt)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse rendered statement: %w", err)
+		return nil, err
 	}
-	if len(targetStmts) == 0 {
-		return nil, fmt.Errorf("rendered statement is empty")
+
+	if len(matches) == 0 {
+		// No matching statement found
+		if p.remove {
+			return skipAction{}, nil // Nothing to remove
+		}
+		return insertAction{}, nil
+	}
+
+	first := matches[0]
+
+	if p.dedupe && !p.remove && len(matches) > 1 {
+		var duplicates []int
+		for _, m := range matches[1:] {
+			if !m.protected {
+				duplicates = append(duplicates, m.index)
+			}
+		}
+		if len(duplicates) > 0 {
+			return dedupeAction{
+				first:      first.index,
+				duplicates: duplicates,
+				count:      stmtCount,
+				update:     !first.protected && !first.exact,
+			}, nil
+		}
+	}
+
+	// Check if first statement has skip directive (manually added, should not be touched)
+	if first.protected {
+		return protectedAction{}, nil
 	}
+	if p.remove {
+		// In remove mode, remove all matching statements
+		return removeAction{index: first.index, count: stmtCount}, nil
+	}
+	if first.exact {
+		return skipAction{}, nil
+	}
+	// Structure matches but content differs - needs update
+	return updateAction{index: first.index, count: stmtCount}, nil
+}
 
+// detectMarkedAction determines what action to take in marker matching mode.
+// Only a statement group whose last statement carries the generated marker is
+// treated as existing generated code; unmarked statements are never touched.
+// The group spans the number of statements recorded by the marker, so that a
+// template that grew or shrank replaces the statements generated before.
+func (p *Processor) detectMarkedAction(body *dst.BlockStmt, rt renderedTemplate) (Action, error) {
+	targetStmts, err := parseTemplateStatements(rt.stmt)
+	if err != nil {
+		return nil, err
+	}
 	stmtCount := len(targetStmts)
+	upToDate := p.upToDate(targetStmts, parsePattern(rt.pattern, stmtCount), rt.bindings)
 
-	for i := range body.List {
-		// Check if we have enough statements remaining to match
-		if i+stmtCount > len(body.List) {
-			break
+	index, count := p.findMarked(body, stmtCount)
+	if index < 0 {
+		if p.remove {
+			return skipAction{}, nil // Nothing to remove
+		}
+		return insertAction{}, nil
+	}
+	if directive.HasStmtSkipDirective(body.List[index]) {
+		return protectedAction{}, nil
+	}
+	last := body.List[index+count-1]
+	if p.remove {
+		if p.runID != "" && directive.GeneratedRun(last, p.marker) != p.runID {
+			return skipAction{}, nil // Inserted by another run
+		}
+		return removeAction{index: index, count: count}, nil
+	}
+	if count != stmtCount {
+		return updateAction{index: index, count: count}, nil
+	}
+	for j := range targetStmts {
+		if !upToDate(j, body.List[index+j]) {
+			return updateAction{index: index, count: count}, nil
 		}
+	}
+	// Statements generated from another version of the template get the current
+	// hash, and those marked with the default marker or without a count get the
+	// configured marker with one
+	hash, _ := directive.GeneratedHash(last, p.marker)
+	if hash != p.tmpl.Hash() || !directive.MarkedWith(last, p.marker) || directive.GeneratedCount(last, p.marker) == 0 {
+		return updateAction{index: index, count: count}, nil
+	}
+	return skipAction{}, nil
+}
+
+// hasGenerated reports whether body contains the generated statements: a group
+// matching the template (carrying the generated marker in marker mode) without
+// a skip directive.
+func (p *Processor) hasGenerated(body *dst.BlockStmt, rt renderedTemplate) (bool, error) {
+	if p.matching == config.MatchingMarker {
+		targetStmts, err := parseTemplateStatements(rt.stmt)
+		if err != nil {
+			return false, err
+		}
+		index, _ := p.findMarked(body, len(targetStmts))
+		return index >= 0 && !directive.HasStmtSkipDirective(body.List[index]), nil
+	}
+
+	matches, _, err := p.matchTemplate(body, rt)
+	if err != nil {
+		return false, err
+	}
+	for _, m := range matches {
+		if !m.protected {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
+// findMarked returns the start index and the length of the first statement
+// group whose last statement carries the generated marker, or -1 if there is
+// none. The length is the number of statements recorded by the marker, or
+// stmtCount, that of the rendered template, for markers recording none.
+func (p *Processor) findMarked(body *dst.BlockStmt, stmtCount int) (index, count int) {
+	for i, stmt := range body.List {
+		if !directive.HasGeneratedMarker(stmt, p.marker) {
+			continue
+		}
+		if count = directive.GeneratedCount(stmt, p.marker); count == 0 {
+			count = stmtCount
+		}
+		index = max(i-count+1, 0)
+		return index, i - index + 1
+	}
+	return -1, 0
+}
+
+// detectMigration determines what action to take when migrating to marker mode.
+// Existing statements are detected by skeleton (or placeholder) matching and
+// rewritten with the generated marker. Functions without a match are left alone.
+func (p *Processor) detectMigration(body *dst.BlockStmt, rt renderedTemplate) (Action, error) {
+	matches, stmtCount, err := p.matchTemplate(body, rt)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return skipAction{}, nil
+	}
+
+	first := matches[0]
+	if first.protected || directive.HasGeneratedMarker(body.List[first.index+stmtCount-1], p.marker) {
+		return skipAction{}, nil
+	}
+	return updateAction{index: first.index, count: stmtCount}, nil
+}
+
+// detectDriftAction determines what action to take in drift mode. Only a
+// statement group carrying the generated marker with a hash other than the
+// current template's is updated; the rest is left alone.
+func (p *Processor) detectDriftAction(body *dst.BlockStmt, rt renderedTemplate) (Action, error) {
+	targetStmts, err := parseTemplateStatements(rt.stmt)
+	if err != nil {
+		return nil, err
+	}
+
+	index, count := p.findMarked(body, len(targetStmts))
+	if index < 0 || directive.HasStmtSkipDirective(body.List[index]) {
+		return skipAction{}, nil
+	}
+	hash, _ := directive.GeneratedHash(body.List[index+count-1], p.marker)
+	if hash == p.tmpl.Hash() {
+		return skipAction{}, nil
+	}
+	return driftAction{updateAction: updateAction{index: index, count: count}, hash: hash}, nil
+}
+
+// matchTemplate parses the rendered template and finds the statement groups in
+// body that match it. Returns the matches and the number of template statements.
+func (p *Processor) matchTemplate(body *dst.BlockStmt, rt renderedTemplate) ([]stmtMatch, int, error) {
+	targetStmts, err := parseTemplateStatements(rt.stmt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stmtCount := len(targetStmts)
+	patternStmts := parsePattern(rt.pattern, stmtCount)
+
+	skeletonStmts, skeleton := targetStmts, p.comparator
+	if p.matching == config.MatchingPlaceholder && patternStmts != nil {
+		skeletonStmts, skeleton = patternStmts, p.comparator.WithWildcards(template.PlaceholderPrefix)
+	}
+
+	upToDate := p.upToDate(targetStmts, patternStmts, rt.bindings)
+	return p.findMatches(body, stmtCount, skeletonStmts, skeleton, upToDate), stmtCount, nil
+}
+
+// parsePattern parses the template rendered with placeholders.
+// Returns nil if there is no pattern or it has a different shape than the
+// rendered template, in which case callers fall back to the rendered statements.
+func parsePattern(patternStmt string, stmtCount int) []dst.Stmt {
+	if patternStmt == "" {
+		return nil
+	}
+	patternStmts, err := dstutil.ParseStatements(patternStmt)
+	if err != nil || len(patternStmts) != stmtCount {
+		return nil
+	}
+	return patternStmts
+}
+
+// upToDate returns a function reporting whether an existing statement matched
+// against the j-th template statement needs no update. In refresh mode "vars",
+// only literals filled by template variables are compared; otherwise the
+// statement must exactly match the rendered template.
+func (p *Processor) upToDate(targetStmts, patternStmts []dst.Stmt, bindings map[string]string) func(j int, existing dst.Stmt) bool {
+	if p.refresh == config.RefreshVars && patternStmts != nil {
+		bound := p.comparator.WithWildcards(template.PlaceholderPrefix).WithBindings(bindings)
+		return func(j int, existing dst.Stmt) bool {
+			return bound.MatchesSkeleton(patternStmts[j], existing)
+		}
+	}
+	return func(j int, existing dst.Stmt) bool {
+		return p.comparator.MatchesExact(targetStmts[j], existing)
+	}
+}
+
+// parseTemplateStatements parses the rendered template into statements.
+func parseTemplateStatements(renderedStmt string) ([]dst.Stmt, error) {
+	stmts, err := dstutil.ParseStatements(renderedStmt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rendered statement: %w", err)
+	}
+	if len(stmts) == 0 {
+		return nil, fmt.Errorf("rendered statement is empty")
+	}
+	return stmts, nil
+}
+
+// appendGeneratedMarker appends the generated marker, recording the template
+// hash, the number of statements and the run, if any, as a trailing comment to
+// the last line of the rendered statements. Statements ending with a comment
+// are rejected, since the marker would be appended to it and not recognized.
+func appendGeneratedMarker(renderedStmt, marker, hash, run string) (string, error) {
+	stmts, err := parseTemplateStatements(renderedStmt)
+	if err != nil {
+		return "", err
+	}
+	if len(stmts[len(stmts)-1].Decorations().End) > 0 {
+		return "", fmt.Errorf("the last rendered statement ends with a comment, which would hide the generated marker")
+	}
+	return strings.TrimRight(renderedStmt, " \t\n") + " " + directive.GeneratedMarkerWithAttrs(marker, hash, len(stmts), run), nil
+}
+
+// findMatches returns the non-overlapping statement groups in body that match
+// the template. Only the first match is searched for unless dedupe mode is enabled.
+func (p *Processor) findMatches(body *dst.BlockStmt, stmtCount int, skeletonStmts []dst.Stmt, skeleton *Comparator, upToDate func(int, dst.Stmt) bool) []stmtMatch {
+	var matches []stmtMatch
+	for i := 0; i+stmtCount <= len(body.List); i++ {
 		// Try to match all target statements starting at this index
 		allMatch := true
 		allExact := true
-		for j, targetStmt := range targetStmts {
+		for j, skeletonStmt := range skeletonStmts {
 			existingStmt := body.List[i+j]
-			if !dstutil.MatchesSkeleton(targetStmt, existingStmt) {
+			if !skeleton.MatchesSkeleton(skeletonStmt, existingStmt) {
 				allMatch = false
 				break
 			}
-			// Check if exact match (use skeleton match with exact mode)
-			if !dstutil.MatchesExact(targetStmt, existingStmt) {
+			if !upToDate(j, existingStmt) {
 				allExact = false
 			}
 		}
-
-		if allMatch {
-			// Check if first statement has skip directive (manually added, should not be touched)
-			if directive.HasStmtSkipDirective(body.List[i]) {
-				return skipAction{}, nil
-			}
-			if p.remove {
-				// In remove mode, remove all matching statements
-				return removeAction{index: i, count: stmtCount}, nil
-			}
-			if allExact {
-				return skipAction{}, nil
-			}
-			// Structure matches but content differs - needs update
-			return updateAction{index: i, count: stmtCount}, nil
+		if !allMatch {
+			continue
 		}
-	}
 
-	// No matching statement found
-	if p.remove {
-		return skipAction{}, nil // Nothing to remove
+		matches = append(matches, stmtMatch{
+			index:     i,
+			exact:     allExact,
+			protected: directive.HasStmtSkipDirective(body.List[i]),
+		})
+		if !p.dedupe {
+			break
+		}
+		i += stmtCount - 1 // Skip past the matched group
 	}
-	return insertAction{}, nil
+	return matches
 }
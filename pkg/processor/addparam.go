@@ -0,0 +1,352 @@
+package processor
+
+import (
+	"go/ast"
+	"go/types"
+	"slices"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/types/typeutil"
+
+	"github.com/mpyw/ctxweaver/internal/directive"
+	"github.com/mpyw/ctxweaver/internal/dstutil"
+)
+
+// WithAddParam switches to add-param mode: instead of weaving the template,
+// the functions passing the filters that have no context carrier get a
+// ctx context.Context first parameter, and their calls in the processed files
+// pass a context: ctx inside the functions getting the parameter, the context
+// of the carrier inside other functions having one, or context.TODO()
+// elsewhere. A later weave then instruments them.
+//
+// A function whose signature cannot change without breaking the build is
+// left alone and reported as ProcessResult.ParamSkippedFuncs: entry points
+// (main, init and test functions), functions used as values (callbacks,
+// handlers, method expressions), functions used in files left alone (e.g.
+// generated or ignored files), methods implementing an interface of the
+// loaded packages or their imports, and functions with unnamed parameters or
+// already using the name ctx. Calls are updated in every processed file,
+// including skipped functions, since they would not compile otherwise.
+//
+// Call sites are only known with all packages loaded at once: add-param mode
+// cannot be combined with a batch size or the selection of a function.
+func WithAddParam(addParam bool) Option {
+	return func(p *Processor) {
+		p.addParam = addParam
+	}
+}
+
+// resolveParamTargets maps the full name (see types.Func.FullName) of every
+// function passing the filters without carrier to the reason it is left
+// without a ctx parameter, or to an empty string if it gets one.
+func (p *Processor) resolveParamTargets(pkgs []*packages.Package) map[string]string {
+	// Files are checked again when processed: do not report them twice
+	quiet := *p
+	quiet.verbose = false
+
+	pinned := quiet.pinnedFuncs(pkgs)
+	ifaces := loadedInterfaces(pkgs)
+	targets := make(map[string]string)
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 || pkg.TypesInfo == nil || p.shouldExcludePackage(pkg.PkgPath) {
+			continue
+		}
+		pp := quiet.forPackage(pkg.PkgPath).withInterfaces(pkg)
+		dec := newDecorator(pkg)
+		typeOf := packageTypeResolver(pkg, dec)
+		for _, file := range pkg.Syntax {
+			filename := pkg.Fset.Position(file.Pos()).Filename
+			if !pp.shouldProcessFile(filename) || pp.skipsGenerated(file, moduleRelPath(pkg, filename)) {
+				continue
+			}
+			df, err := dec.DecorateFile(file)
+			if err != nil || directive.HasSkipDirective(df.Decorations()) {
+				continue
+			}
+			off := directive.OffDecls(df)
+			for _, decl := range df.Decls {
+				fn, ok := decl.(*dst.FuncDecl)
+				if !ok || shouldSkipDecl(fn) || off[fn] {
+					continue
+				}
+				if !pp.matchesFuncFilter(fn, pkg.PkgPath, typeOf) || pp.tryMatchCarrier(fn, typeOf) != nil || hasContextParam(fn) {
+					continue
+				}
+				obj := declaredFunc(pkg, dec, fn)
+				if obj == nil {
+					continue
+				}
+				targets[obj.FullName()] = paramSkipReason(fn, obj, filename, pinned, ifaces)
+			}
+		}
+	}
+	return targets
+}
+
+// paramSkipReason returns the reason fn is left without a ctx parameter, or
+// an empty string if it gets one.
+func paramSkipReason(fn *dst.FuncDecl, obj *types.Func, filename string, pinned map[string]string, ifaces []*types.TypeName) string {
+	recv := obj.Signature().Recv()
+	switch name := fn.Name.Name; {
+	case recv == nil && (name == "init" || name == "main" && obj.Pkg().Name() == "main"):
+		return "entry point"
+	case recv == nil && strings.HasSuffix(filename, "_test.go") && isTestFunc(name):
+		return "test function"
+	}
+	if reason := pinned[obj.FullName()]; reason != "" {
+		return reason
+	}
+	if recv != nil {
+		if iface := implementedInterface(obj, ifaces); iface != "" {
+			return "implements " + iface
+		}
+	}
+	if params := fn.Type.Params.List; len(params) > 0 && len(params[0].Names) == 0 {
+		return "unnamed parameters"
+	}
+	var usesCtx bool
+	dst.Inspect(fn, func(n dst.Node) bool {
+		if id, ok := n.(*dst.Ident); ok && id.Name == "ctx" && id.Path == "" {
+			usesCtx = true
+		}
+		return !usesCtx
+	})
+	if usesCtx {
+		return "uses the name ctx"
+	}
+	return ""
+}
+
+// hasContextParam reports whether fn has a context.Context parameter, e.g. an
+// unnamed one that is not a carrier.
+func hasContextParam(fn *dst.FuncDecl) bool {
+	for _, field := range fn.Type.Params.List {
+		if id, ok := field.Type.(*dst.Ident); ok && id.Path == "context" && id.Name == "Context" {
+			return true
+		}
+	}
+	return false
+}
+
+// isTestFunc reports whether name is the name of a function run by go test.
+func isTestFunc(name string) bool {
+	for _, prefix := range []string{"Test", "Benchmark", "Fuzz", "Example"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// pinnedFuncs maps the full names of the functions of pkgs whose signature
+// cannot change to the reason: functions referred to other than by a static
+// call, and functions referred to in files left alone.
+func (p *Processor) pinnedFuncs(pkgs []*packages.Package) map[string]string {
+	pinned := make(map[string]string)
+	for _, pkg := range pkgs {
+		if pkg.TypesInfo == nil {
+			continue
+		}
+		for _, file := range pkg.Syntax {
+			filename := pkg.Fset.Position(file.Pos()).Filename
+			leftAlone := !p.forPackage(pkg.PkgPath).shouldProcessFile(filename) || p.skipsGenerated(file, moduleRelPath(pkg, filename))
+			called := make(map[*ast.Ident]bool)
+			ast.Inspect(file, func(n ast.Node) bool {
+				if call, ok := n.(*ast.CallExpr); ok && typeutil.StaticCallee(pkg.TypesInfo, call) != nil {
+					if id := calleeIdent(pkg.TypesInfo, call.Fun); id != nil {
+						called[id] = true
+					}
+				}
+				return true
+			})
+			ast.Inspect(file, func(n ast.Node) bool {
+				id, ok := n.(*ast.Ident)
+				if !ok {
+					return true
+				}
+				fn, ok := pkg.TypesInfo.Uses[id].(*types.Func)
+				if !ok {
+					return true
+				}
+				name := fn.Origin().FullName()
+				switch {
+				case leftAlone:
+					pinned[name] = "used in a file left alone"
+				case !called[id] && pinned[name] == "":
+					pinned[name] = "used as a value"
+				}
+				return true
+			})
+		}
+	}
+	return pinned
+}
+
+// calleeIdent returns the identifier of the function of a static call, or nil
+// for a method expression such as T.Method(recv), whose first argument is the
+// receiver.
+func calleeIdent(info *types.Info, fun ast.Expr) *ast.Ident {
+	fun = ast.Unparen(fun)
+	switch f := fun.(type) {
+	case *ast.IndexExpr:
+		fun = f.X
+	case *ast.IndexListExpr:
+		fun = f.X
+	}
+	switch f := ast.Unparen(fun).(type) {
+	case *ast.Ident:
+		return f
+	case *ast.SelectorExpr:
+		if sel, ok := info.Selections[f]; ok && sel.Kind() == types.MethodExpr {
+			return nil
+		}
+		return f.Sel
+	}
+	return nil
+}
+
+// loadedInterfaces returns the interfaces with methods declared at the top
+// level of pkgs and of their imports, and the error interface.
+func loadedInterfaces(pkgs []*packages.Package) []*types.TypeName {
+	ifaces := []*types.TypeName{types.Universe.Lookup("error").(*types.TypeName)}
+	seen := make(map[*types.Package]bool)
+	add := func(tp *types.Package) {
+		if tp == nil || seen[tp] {
+			return
+		}
+		seen[tp] = true
+		scope := tp.Scope()
+		for _, name := range scope.Names() {
+			tn, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || tn.IsAlias() {
+				continue
+			}
+			named, ok := tn.Type().(*types.Named)
+			if !ok || named.TypeParams().Len() > 0 {
+				continue
+			}
+			if iface, ok := named.Underlying().(*types.Interface); ok && iface.NumMethods() > 0 && iface.IsMethodSet() {
+				ifaces = append(ifaces, tn)
+			}
+		}
+	}
+	for _, pkg := range pkgs {
+		if pkg.Types == nil {
+			continue
+		}
+		add(pkg.Types)
+		for _, imp := range pkg.Types.Imports() {
+			add(imp)
+		}
+	}
+	return ifaces
+}
+
+// implementedInterface returns the name of an interface of ifaces having the
+// method and implemented by its receiver, or an empty string if there is none.
+func implementedInterface(method *types.Func, ifaces []*types.TypeName) string {
+	recv := method.Signature().Recv().Type()
+	if _, ok := recv.(*types.Pointer); !ok {
+		recv = types.NewPointer(recv)
+	}
+	for _, tn := range ifaces {
+		iface := tn.Type().Underlying().(*types.Interface)
+		hasMethod := false
+		for m := range iface.Methods() {
+			hasMethod = hasMethod || m.Name() == method.Name()
+		}
+		if !hasMethod || !types.Implements(recv, iface) {
+			continue
+		}
+		if tn.Pkg() == nil {
+			return tn.Name()
+		}
+		return tn.Pkg().Name() + "." + tn.Name()
+	}
+	return ""
+}
+
+// declaredFunc returns the function declared by fn, or nil if unknown.
+func declaredFunc(pkg *packages.Package, dec *decorator.Decorator, fn *dst.FuncDecl) *types.Func {
+	n, ok := dec.Ast.Nodes[fn].(*ast.FuncDecl)
+	if !ok || pkg.TypesInfo == nil {
+		return nil
+	}
+	obj, _ := pkg.TypesInfo.Defs[n.Name].(*types.Func)
+	return obj
+}
+
+// addParams gives the target functions of df a ctx parameter and passes a
+// context to the calls of targets in every declaration, recording the
+// changed functions and the ones left without a parameter.
+func (p *Processor) addParams(df *dst.File, pkg *packages.Package, dec *decorator.Decorator) fileResult {
+	var fr fileResult
+	typeOf := packageTypeResolver(pkg, dec)
+	for _, decl := range df.Decls {
+		var reasons []string
+		var ctxExpr string // Context passed to calls; context.TODO() if empty
+		fn, _ := decl.(*dst.FuncDecl)
+		if fn != nil {
+			if obj := declaredFunc(pkg, dec, fn); obj != nil {
+				reason, ok := p.paramTargets[obj.FullName()]
+				switch {
+				case ok && reason == "":
+					ctx := &dst.Field{Names: []*dst.Ident{dst.NewIdent("ctx")}, Type: &dst.Ident{Name: "Context", Path: "context"}}
+					fn.Type.Params.List = slices.Insert(fn.Type.Params.List, 0, ctx)
+					reasons = append(reasons, "ctx parameter added")
+					ctxExpr = "ctx"
+				case ok:
+					fr.paramSkipped = append(fr.paramSkipped, changedFunc{decl: fn, reason: reason})
+				}
+			}
+			if c := p.tryMatchCarrier(fn, typeOf); c != nil && ctxExpr == "" && c.match.VarName != "" && c.match.VarName != "_" {
+				ctxExpr = c.match.Carrier.BuildContextExpr(c.match.VarName)
+			}
+		}
+
+		var callees []string
+		dst.Inspect(decl, func(n dst.Node) bool {
+			call, ok := n.(*dst.CallExpr)
+			if !ok {
+				return true
+			}
+			astCall, ok := dec.Ast.Nodes[call].(*ast.CallExpr)
+			if !ok || pkg.TypesInfo == nil || calleeIdent(pkg.TypesInfo, astCall.Fun) == nil {
+				return true
+			}
+			callee := typeutil.StaticCallee(pkg.TypesInfo, astCall)
+			if callee == nil {
+				return true
+			}
+			if reason, ok := p.paramTargets[callee.Origin().FullName()]; !ok || reason != "" {
+				return true
+			}
+			var arg dst.Expr = &dst.CallExpr{Fun: &dst.Ident{Name: "TODO", Path: "context"}}
+			if ctxExpr != "" {
+				if expr, err := dstutil.ParseExpr(ctxExpr); err == nil {
+					arg = expr
+				}
+			}
+			call.Args = slices.Insert(call.Args, 0, arg)
+			if name := callee.Name(); !slices.Contains(callees, name) {
+				callees = append(callees, name)
+			}
+			return true
+		})
+		if len(callees) > 0 {
+			reasons = append(reasons, "context passed to "+strings.Join(callees, ", "))
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+
+		fr.modified = true
+		if fn != nil {
+			fr.changed = append(fr.changed, changedFunc{decl: fn, op: "add-param", reason: strings.Join(reasons, ", ")})
+		}
+	}
+	return fr
+}
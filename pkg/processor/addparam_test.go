@@ -0,0 +1,168 @@
+package processor_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithAddParam(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"service.go": `package service
+
+import (
+	"context"
+	"fmt"
+)
+
+type Service struct{}
+
+func (s *Service) Get(id int) string {
+	return load(id)
+}
+
+func (s *Service) String() string {
+	return load(0)
+}
+
+func Handle(ctx context.Context, id int) {
+	fmt.Println(load(id))
+}
+
+func load(id int) string {
+	return fmt.Sprint(lookup(id))
+}
+
+func lookup(id int) int {
+	return id
+}
+
+func callback(id int) {}
+
+func uses(ctx string) {}
+
+var handlers = []func(int){callback}
+
+var initial = load(1)
+
+func trace(context.Context) {}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil, processor.WithAddParam(true))
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Process errors: %v", result.Errors)
+	}
+
+	got, err := os.ReadFile(filepath.Join(tmpDir, "service.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package service
+
+import (
+	"context"
+	"fmt"
+)
+
+type Service struct{}
+
+func (s *Service) Get(ctx context.Context, id int) string {
+	return load(ctx, id)
+}
+
+func (s *Service) String() string {
+	return load(context.TODO(), 0)
+}
+
+func Handle(ctx context.Context, id int) {
+	fmt.Println(load(ctx, id))
+}
+
+func load(ctx context.Context, id int) string {
+	return fmt.Sprint(lookup(ctx, id))
+}
+
+func lookup(ctx context.Context, id int) int {
+	return id
+}
+
+func callback(id int) {}
+
+func uses(ctx string) {}
+
+var handlers = []func(int){callback}
+
+var initial = load(context.TODO(), 1)
+
+func trace(context.Context) {}
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("service.go mismatch (-want +got):\n%s", diff)
+	}
+
+	modified := make(map[string]string)
+	for _, ch := range result.ModifiedFuncs {
+		modified[ch.Func] = ch.Op + ": " + ch.Reason
+	}
+	wantModified := map[string]string{
+		"(*Service).Get":    "add-param: ctx parameter added, context passed to load",
+		"(*Service).String": "add-param: context passed to load",
+		"Handle":            "add-param: context passed to load",
+		"load":              "add-param: ctx parameter added, context passed to lookup",
+		"lookup":            "add-param: ctx parameter added",
+	}
+	if diff := cmp.Diff(wantModified, modified); diff != "" {
+		t.Errorf("ModifiedFuncs mismatch (-want +got):\n%s", diff)
+	}
+
+	skipped := make(map[string]string)
+	for _, ch := range result.ParamSkippedFuncs {
+		skipped[ch.Func] = ch.Reason
+	}
+	wantSkipped := map[string]string{
+		"(*Service).String": "implements fmt.Stringer",
+		"callback":          "used as a value",
+		"uses":              "uses the name ctx",
+	}
+	if diff := cmp.Diff(wantSkipped, skipped); diff != "" {
+		t.Errorf("ParamSkippedFuncs mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithAddParam_BatchSize(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"service.go": "package service\n\nfunc Get() {}\n",
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil, processor.WithAddParam(true), processor.WithBatchSize(1))
+	_, err := proc.Process([]string{"./..."})
+	if err == nil || !strings.Contains(err.Error(), "cannot load them in batches") {
+		t.Fatalf("Process() error = %v, want a batch error", err)
+	}
+}
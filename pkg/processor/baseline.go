@@ -0,0 +1,231 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"golang.org/x/tools/go/packages"
+)
+
+// Baseline is a set of grandfathered functions, identified by package path,
+// receiver type and name (e.g. "example.com/app/handler.Server.Handle").
+// With WithBaseline, generated statements are only inserted into functions
+// not in the baseline, and Coverage does not count them; existing statements
+// are still updated everywhere.
+type Baseline struct {
+	functions map[string]bool
+}
+
+// baselineJSON is the on-disk format of a baseline.
+type baselineJSON struct {
+	Functions []string `json:"functions"`
+}
+
+// NewBaseline creates a baseline containing the given function keys.
+func NewBaseline(functions ...string) *Baseline {
+	b := &Baseline{functions: make(map[string]bool, len(functions))}
+	for _, f := range functions {
+		b.functions[f] = true
+	}
+	return b
+}
+
+// LoadBaseline reads a baseline written by Baseline.Save.
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline: %w", err)
+	}
+	var bj baselineJSON
+	if err := json.Unmarshal(data, &bj); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline: %w", err)
+	}
+	return NewBaseline(bj.Functions...), nil
+}
+
+// Save writes the baseline as JSON with the functions sorted.
+func (b *Baseline) Save(path string) error {
+	data, err := json.MarshalIndent(baselineJSON{Functions: b.Functions()}, "", "  ")
+	if err != nil {
+		return err // unreachable: baselineJSON only contains strings
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write baseline: %w", err)
+	}
+	return nil
+}
+
+// Functions returns the function keys in the baseline, sorted.
+func (b *Baseline) Functions() []string {
+	result := make([]string, 0, len(b.functions))
+	for f := range b.functions {
+		result = append(result, f)
+	}
+	slices.Sort(result)
+	return result
+}
+
+// Contains reports whether the function is in the baseline.
+// A nil baseline contains nothing.
+func (b *Baseline) Contains(key string) bool {
+	return b != nil && b.functions[key]
+}
+
+// addFile adds all function declarations of the file to the baseline.
+func (b *Baseline) addFile(df *dst.File, pkgPath string) {
+	for _, decl := range df.Decls {
+		if fd, ok := decl.(*dst.FuncDecl); ok {
+			b.functions[funcKey(pkgPath, fd)] = true
+		}
+	}
+}
+
+// funcKey identifies a function by package path, receiver type name and name.
+func funcKey(pkgPath string, decl *dst.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return pkgPath + "." + decl.Name.Name
+	}
+	return pkgPath + "." + recvTypeName(decl.Recv.List[0].Type) + "." + decl.Name.Name
+}
+
+// recvString returns the receiver type as written, without type parameters
+// (e.g. "*Service" for *Service[T]).
+func recvString(expr dst.Expr) string {
+	if star, ok := expr.(*dst.StarExpr); ok {
+		return "*" + recvTypeName(star.X)
+	}
+	return recvTypeName(expr)
+}
+
+// recvTypeName returns the receiver type name without pointer and type parameters.
+func recvTypeName(expr dst.Expr) string {
+	switch t := expr.(type) {
+	case *dst.StarExpr:
+		return recvTypeName(t.X)
+	case *dst.IndexExpr:
+		return recvTypeName(t.X)
+	case *dst.IndexListExpr:
+		return recvTypeName(t.X)
+	case *dst.Ident:
+		return t.Name
+	default:
+		return ""
+	}
+}
+
+// WithBaseline restricts insertion to functions not in the baseline,
+// leaving legacy code alone while new functions are instrumented.
+// A nil baseline disables the restriction.
+func WithBaseline(b *Baseline) Option {
+	return func(p *Processor) {
+		p.baseline = b
+	}
+}
+
+// CollectBaseline records the eligible functions of the given package
+// patterns that have no generated statement yet, so that they are
+// grandfathered while new functions are instrumented. No file is modified.
+// Errors of individual packages and files are returned as the second result.
+func (p *Processor) CollectBaseline(patterns []string) (*Baseline, []error, error) {
+	b := NewBaseline()
+	errs, err := p.inspectEligible(patterns, func(string) {}, func(fn eligibleFunc) {
+		if !fn.instrumented() {
+			b.functions[funcKey(fn.pkgPath, fn.decl)] = true
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, errs, nil
+}
+
+// GitBaseline records all functions declared at the given git revision in the
+// directories of the packages matching patterns. Files at the revision are
+// assigned to the current package of the same directory and package name.
+func GitBaseline(ref string, patterns []string, test bool) (*Baseline, error) {
+	cfg := &packages.Config{
+		Mode:  packages.NeedName | packages.NeedFiles,
+		Tests: test,
+	}
+	groups, err := loadModules(cfg, patterns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load packages: %w", err)
+	}
+	pkgs := slices.Concat(groups...)
+
+	// Package paths by directory and package name
+	dirs := make(map[string]map[string]string)
+	for _, pkg := range pkgs {
+		if len(pkg.GoFiles) == 0 {
+			continue
+		}
+		dir := filepath.Dir(pkg.GoFiles[0])
+		if dirs[dir] == nil {
+			dirs[dir] = make(map[string]string)
+		}
+		dirs[dir][pkg.Name] = pkg.PkgPath
+	}
+
+	b := NewBaseline()
+	for dir, pkgPaths := range dirs {
+		if err := b.addGitDir(ref, dir, pkgPaths); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// addGitDir adds the functions declared in the Go files of dir at the git revision.
+func (b *Baseline) addGitDir(ref, dir string, pkgPaths map[string]string) error {
+	out, err := git(dir, "ls-tree", "--name-only", ref, "./")
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(string(out), "\n") {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		src, err := git(dir, "show", ref+":./"+filepath.Base(name))
+		if err != nil {
+			return err
+		}
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, name, src, parser.SkipObjectResolution)
+		if err != nil {
+			continue // Files that did not parse at the revision have no usable functions
+		}
+		pkgPath, ok := pkgPaths[file.Name.Name]
+		if !ok {
+			continue
+		}
+		df, err := decorator.NewDecorator(fset).DecorateFile(file)
+		if err != nil {
+			return fmt.Errorf("%s at %s: failed to decorate file: %w", name, ref, err)
+		}
+		b.addFile(df, pkgPath)
+	}
+	return nil
+}
+
+// git runs a git command in dir and returns its standard output.
+func git(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
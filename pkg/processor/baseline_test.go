@@ -0,0 +1,138 @@
+package processor_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestBaseline(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	const legacy = `package main
+
+import "context"
+
+func trace(context.Context) {}
+
+type Server struct{}
+
+func (s *Server) Legacy(ctx context.Context) {
+}
+
+func (s *Server) Woven(ctx context.Context) {
+	defer trace(ctx)
+}
+`
+	tmpDir := setupTestModule(t, map[string]string{"main.go": legacy})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	// Record the unwoven functions and round-trip the baseline through a file
+	baseline, errs, err := processor.New(registry, tmpl, nil).CollectBaseline([]string{"./..."})
+	if err != nil || len(errs) > 0 {
+		t.Fatalf("CollectBaseline failed: %v %v", err, errs)
+	}
+	want := []string{"testmod.Server.Legacy"}
+	if got := baseline.Functions(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Functions() = %v, want %v", got, want)
+	}
+
+	baselinePath := filepath.Join(tmpDir, "baseline.json")
+	if err := baseline.Save(baselinePath); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	baseline, err = processor.LoadBaseline(baselinePath)
+	if err != nil {
+		t.Fatalf("LoadBaseline failed: %v", err)
+	}
+
+	// Add a new function
+	src := legacy + `
+func (s *Server) New(ctx context.Context) {
+}
+`
+	if err := os.WriteFile(filepath.Join(tmpDir, "main.go"), []byte(src), 0o644); err != nil {
+		t.Fatalf("failed to write main.go: %v", err)
+	}
+
+	proc := processor.New(registry, tmpl, nil, processor.WithBaseline(baseline))
+	if _, err := proc.Process([]string{"./..."}); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "main.go"))
+	if strings.Count(string(content), "defer trace(ctx)") != 2 {
+		t.Fatalf("expected exactly one insertion:\n%s", content)
+	}
+	if !strings.Contains(string(content), "New(ctx context.Context) {\n\tdefer trace(ctx)") {
+		t.Errorf("new function should be instrumented:\n%s", content)
+	}
+}
+
+func TestCoverage_Baseline(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go": `package main
+
+import "context"
+
+func Legacy(ctx context.Context) {
+}
+
+func New(ctx context.Context) {
+}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil, processor.WithBaseline(processor.NewBaseline("testmod.Legacy")))
+	result, err := proc.Coverage([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Coverage() error = %v", err)
+	}
+	if total := result.Total(); total.Eligible != 1 || total.Instrumented != 0 {
+		t.Errorf("Total() = %+v, want 1 eligible and 0 instrumented", total)
+	}
+}
+
+func TestLoadBaseline_Errors(t *testing.T) {
+	t.Parallel()
+
+	if _, err := processor.LoadBaseline(filepath.Join(t.TempDir(), "missing.json")); err == nil || !strings.Contains(err.Error(), "failed to read baseline") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "invalid.json")
+	if err := os.WriteFile(path, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := processor.LoadBaseline(path); err == nil || !strings.Contains(err.Error(), "failed to parse baseline") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBaseline_Contains(t *testing.T) {
+	t.Parallel()
+
+	var nilBaseline *processor.Baseline
+	if nilBaseline.Contains("testmod.Foo") {
+		t.Error("nil baseline should contain nothing")
+	}
+	if !processor.NewBaseline("testmod.Foo").Contains("testmod.Foo") {
+		t.Error("baseline should contain testmod.Foo")
+	}
+}
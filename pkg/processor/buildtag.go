@@ -0,0 +1,294 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/mpyw/ctxweaver/internal/patch"
+)
+
+// variantSuffix is appended to the name of a file to get the name of its
+// variant, before the _test suffix and the GOOS and GOARCH suffixes.
+const variantSuffix = "_instrumented"
+
+// WithBuildTag writes the woven code of every file into a variant file behind
+// the build tag instead of modifying the file in place: foo.go is left as is,
+// apart from a !tag term added to its build constraint, and its woven copy is
+// written to foo_instrumented.go with a tag term, so that builds with
+// -tags=tag are instrumented and the others are not. Variants are regenerated
+// from their file on every run, and deleted along with the !tag term when the
+// file has nothing to weave (e.g. in remove mode). An empty tag modifies files
+// in place.
+func WithBuildTag(tag string) Option {
+	return func(p *Processor) {
+		p.buildTag = tag
+	}
+}
+
+// emitVariant writes the result of processing filename, decorated as df, as
+// the variant of the file behind the tag (see WithBuildTag and
+// WithHotBuildTag). The file itself gets base, the content of the file if nil,
+// with a !tag term when the variant is written.
+func (p *Processor) emitVariant(pkg *packages.Package, df *dst.File, res *decorator.Restorer, filename, tag string, base []byte, fr fileResult) (fileResult, error) {
+	original, err := p.readFile(filename)
+	if err != nil {
+		return fileResult{}, &WriteError{File: filename, Err: fmt.Errorf("failed to read file: %w", err)}
+	}
+	if base == nil {
+		base = original
+	}
+	variant := variantFilename(filename)
+	existing, err := os.ReadFile(variant)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fileResult{}, &WriteError{File: variant, Err: fmt.Errorf("failed to read file: %w", err)}
+	}
+
+	// The variant to write, if any, and the term of the tag in the file
+	var content []byte
+	term := tagAbsent
+	if fr.modified && !p.remove {
+		result, err := p.restoreFile(df, res, filename)
+		if err != nil {
+			return fileResult{}, &WriteError{File: filename, Err: err}
+		}
+		result = restoreLineEndings(original, restoreLineDirectives(original, result))
+		if content, err = setBuildTag(result, tag, tagRequired); err != nil {
+			return fileResult{}, &WriteError{File: filename, Err: err}
+		}
+		term = tagExcluded
+	}
+	marked, err := setBuildTag(base, tag, term)
+	if err != nil {
+		return fileResult{}, &WriteError{File: filename, Err: err}
+	}
+
+	fr.modified = !bytes.Equal(existing, content) || !bytes.Equal(original, marked)
+	if !fr.modified {
+		fr.changed = nil
+		return fr, nil
+	}
+	if content != nil || !bytes.Equal(base, original) {
+		if err := p.checkWritable(pkg, filename); err != nil {
+			return fileResult{}, &WriteError{File: filename, Err: err}
+		}
+		if err := p.checkClean(filename, &fr); err != nil {
+			return fileResult{}, &WriteError{File: filename, Err: err}
+		}
+		if content != nil {
+			fr.addedImports = addedImports(original, content)
+		} else {
+			fr.addedImports = addedImports(original, marked)
+		}
+	}
+	if p.dryRun {
+		if p.keepContents {
+			fr.content = content
+		}
+		if p.keepDiffs {
+			fr.diff = append(patch.Unified(displayPath(filename), original, marked), patch.Unified(displayPath(variant), existing, content)...)
+		}
+		return fr, nil
+	}
+
+	if !bytes.Equal(original, marked) {
+		if err := os.WriteFile(filename, marked, 0o644); err != nil {
+			return fileResult{}, &WriteError{File: filename, Err: fmt.Errorf("failed to write file: %w", err)}
+		}
+	}
+	if content == nil {
+		// The variant may have been deleted by hand already
+		if err := os.Remove(variant); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fileResult{}, &WriteError{File: variant, Err: fmt.Errorf("failed to remove file: %w", err)}
+		}
+	} else if !bytes.Equal(existing, content) {
+		if err := os.WriteFile(variant, content, 0o644); err != nil {
+			return fileResult{}, &WriteError{File: variant, Err: fmt.Errorf("failed to write file: %w", err)}
+		}
+	}
+	return fr, nil
+}
+
+// splitFilename splits the name of a Go file into its stem and the suffixes
+// implying build constraints: "_test", GOOS and GOARCH (e.g. "foo" and
+// "_linux_amd64_test.go" for "foo_linux_amd64_test.go").
+func splitFilename(base string) (stem, suffix string) {
+	stem = strings.TrimSuffix(base, ".go")
+	suffix = ".go"
+	if s, ok := strings.CutSuffix(stem, "_test"); ok {
+		stem, suffix = s, "_test"+suffix
+	}
+	elems := strings.Split(stem, "_")
+	n := 0
+	if len(elems) > 1 && knownArch[elems[len(elems)-1]] {
+		n = 1
+		if len(elems) > 2 && knownOS[elems[len(elems)-2]] {
+			n = 2
+		}
+	} else if len(elems) > 1 && knownOS[elems[len(elems)-1]] {
+		n = 1
+	}
+	if n > 0 {
+		suffix = "_" + strings.Join(elems[len(elems)-n:], "_") + suffix
+		stem = strings.Join(elems[:len(elems)-n], "_")
+	}
+	return stem, suffix
+}
+
+// variantFilename returns the name of the variant of filename, keeping the
+// suffixes of the name implying build constraints (e.g.
+// "foo_instrumented_linux_test.go" for "foo_linux_test.go").
+func variantFilename(filename string) string {
+	stem, suffix := splitFilename(filepath.Base(filename))
+	return filepath.Join(filepath.Dir(filename), stem+variantSuffix+suffix)
+}
+
+// isVariant reports whether filename is the variant of another file.
+func isVariant(filename string) bool {
+	stem, _ := splitFilename(filepath.Base(filename))
+	return strings.HasSuffix(stem, variantSuffix)
+}
+
+// knownOS and knownArch are the values of GOOS and GOARCH implied by file names
+// (see go/build).
+var (
+	knownOS = map[string]bool{
+		"aix": true, "android": true, "darwin": true, "dragonfly": true, "freebsd": true,
+		"hurd": true, "illumos": true, "ios": true, "js": true, "linux": true, "nacl": true,
+		"netbsd": true, "openbsd": true, "plan9": true, "solaris": true, "wasip1": true,
+		"windows": true, "zos": true,
+	}
+	knownArch = map[string]bool{
+		"386": true, "amd64": true, "amd64p32": true, "arm": true, "armbe": true, "arm64": true,
+		"arm64be": true, "loong64": true, "mips": true, "mipsle": true, "mips64": true,
+		"mips64le": true, "mips64p32": true, "mips64p32le": true, "ppc": true, "ppc64": true,
+		"ppc64le": true, "riscv": true, "riscv64": true, "s390": true, "s390x": true,
+		"sparc": true, "sparc64": true, "wasm": true,
+	}
+)
+
+// tagTerm is the term of a build tag ANDed with the build constraint of a file.
+type tagTerm int
+
+const (
+	tagAbsent   tagTerm = iota // Neither tag nor !tag
+	tagRequired                // tag
+	tagExcluded                // !tag
+)
+
+// setBuildTag returns src with its build constraint ANDed with the term of the
+// tag, replacing any previous term of the tag. Legacy // +build lines are
+// merged into the //go:build line.
+func setBuildTag(src []byte, tag string, term tagTerm) ([]byte, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file: %w", err)
+	}
+	header := int(f.Package) - 1 // Constraints only appear before the package clause
+
+	newline := "\n"
+	if bytes.Contains(src, []byte("\r\n")) {
+		newline = "\r\n"
+	}
+
+	// Find the constraint lines; a //go:build line wins over // +build lines
+	lines := bytes.SplitAfter(src, []byte("\n"))
+	var goBuild, plusBuild constraint.Expr
+	var constraints []int // Indices of the lines
+	for i, offset := 0, 0; i < len(lines) && offset < header; i++ {
+		line := strings.TrimRight(string(lines[i]), "\r\n")
+		offset += len(lines[i])
+		if !constraint.IsGoBuild(line) && !constraint.IsPlusBuild(line) {
+			continue
+		}
+		e, err := constraint.Parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid build constraint: %w", err)
+		}
+		if constraint.IsGoBuild(line) {
+			goBuild = e
+		} else {
+			plusBuild = andExpr(plusBuild, e)
+		}
+		constraints = append(constraints, i)
+	}
+	expr := goBuild
+	if expr == nil {
+		expr = plusBuild
+	}
+
+	expr = withoutTag(expr, tag)
+	switch term {
+	case tagRequired:
+		expr = andExpr(expr, &constraint.TagExpr{Tag: tag})
+	case tagExcluded:
+		expr = andExpr(expr, &constraint.NotExpr{X: &constraint.TagExpr{Tag: tag}})
+	}
+
+	if len(constraints) == 0 {
+		if expr == nil {
+			return src, nil
+		}
+		return append([]byte("//go:build "+expr.String()+newline+newline), src...), nil
+	}
+
+	// Write the new constraint in place of the first line, dropping the others
+	// along with the blank line following a removed constraint
+	var out bytes.Buffer
+	dropBlank := false
+	for i, line := range lines {
+		switch {
+		case i == constraints[0] && expr != nil:
+			out.WriteString("//go:build " + expr.String() + newline)
+		case slices.Contains(constraints, i):
+			dropBlank = expr == nil
+		case dropBlank && len(bytes.TrimSpace(line)) == 0:
+			dropBlank = false
+		default:
+			dropBlank = false
+			out.Write(line)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// andExpr returns x && y, or the one that is not nil.
+func andExpr(x, y constraint.Expr) constraint.Expr {
+	switch {
+	case x == nil:
+		return y
+	case y == nil:
+		return x
+	}
+	return &constraint.AndExpr{X: x, Y: y}
+}
+
+// withoutTag returns expr without its terms ANDed at the top level that are
+// the tag or its negation, or nil if none remain.
+func withoutTag(expr constraint.Expr, tag string) constraint.Expr {
+	switch e := expr.(type) {
+	case *constraint.AndExpr:
+		return andExpr(withoutTag(e.X, tag), withoutTag(e.Y, tag))
+	case *constraint.TagExpr:
+		if e.Tag == tag {
+			return nil
+		}
+	case *constraint.NotExpr:
+		if t, ok := e.X.(*constraint.TagExpr); ok && t.Tag == tag {
+			return nil
+		}
+	}
+	return expr
+}
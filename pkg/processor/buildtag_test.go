@@ -0,0 +1,182 @@
+package processor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithBuildTag(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"service.go": `// Copyright 2026 Example
+
+package service
+
+import "context"
+
+func Get(ctx context.Context) {
+}
+
+func trace(context.Context) {}
+`,
+		"service_linux.go": `//go:build cgo
+
+package service
+
+import "context"
+
+func Load(ctx context.Context) {
+}
+`,
+		"service_test.go": `package service
+
+import "context"
+
+func helper(ctx context.Context) {
+}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	read := func(name string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(b)
+	}
+	process := func(opts ...processor.Option) *processor.ProcessResult {
+		t.Helper()
+		opts = append([]processor.Option{processor.WithTest(true), processor.WithBuildTag("instrumentation")}, opts...)
+		result, err := processor.New(registry, tmpl, nil, opts...).Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if len(result.Errors) > 0 {
+			t.Fatalf("Process errors: %v", result.Errors)
+		}
+		return result
+	}
+
+	process()
+	want := map[string]string{
+		"service.go": `//go:build !instrumentation
+
+// Copyright 2026 Example
+
+package service
+
+import "context"
+
+func Get(ctx context.Context) {
+}
+
+func trace(context.Context) {}
+`,
+		"service_instrumented.go": `//go:build instrumentation
+
+// Copyright 2026 Example
+
+package service
+
+import "context"
+
+func Get(ctx context.Context) {
+	defer trace(ctx)
+
+}
+
+func trace(context.Context) {}
+`,
+		"service_linux.go": `//go:build cgo && !instrumentation
+
+package service
+
+import "context"
+
+func Load(ctx context.Context) {
+}
+`,
+		"service_instrumented_linux.go": `//go:build cgo && instrumentation
+
+package service
+
+import "context"
+
+func Load(ctx context.Context) {
+	defer trace(ctx)
+
+}
+`,
+		"service_test.go": `//go:build !instrumentation
+
+package service
+
+import "context"
+
+func helper(ctx context.Context) {
+}
+`,
+		"service_instrumented_test.go": `//go:build instrumentation
+
+package service
+
+import "context"
+
+func helper(ctx context.Context) {
+	defer trace(ctx)
+
+}
+`,
+	}
+	for name, content := range want {
+		if diff := cmp.Diff(content, read(name)); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+
+	// Running again leaves the files alone
+	if result := process(); result.FilesModified != 0 {
+		t.Errorf("FilesModified = %d on the second run, want 0", result.FilesModified)
+	}
+
+	// Removing deletes the variants and restores the files
+	process(processor.WithRemove(true))
+	for name := range want {
+		if name == "service.go" || name == "service_linux.go" || name == "service_test.go" {
+			continue
+		}
+		if got := read(name); got != "<missing>" {
+			t.Errorf("%s still exists after removal", name)
+		}
+	}
+	if diff := cmp.Diff("//go:build cgo\n\npackage service\n\nimport \"context\"\n\nfunc Load(ctx context.Context) {\n}\n", read("service_linux.go")); diff != "" {
+		t.Errorf("service_linux.go mismatch after removal (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff("// Copyright 2026 Example\n\npackage service\n\nimport \"context\"\n\nfunc Get(ctx context.Context) {\n}\n\nfunc trace(context.Context) {}\n", read("service.go")); diff != "" {
+		t.Errorf("service.go mismatch after removal (-want +got):\n%s", diff)
+	}
+
+	// Removing a variant deleted by hand only removes the !tag term
+	process()
+	if err := os.Remove(filepath.Join(tmpDir, "service_instrumented.go")); err != nil {
+		t.Fatal(err)
+	}
+	process(processor.WithRemove(true))
+	if diff := cmp.Diff("// Copyright 2026 Example\n\npackage service\n\nimport \"context\"\n\nfunc Get(ctx context.Context) {\n}\n\nfunc trace(context.Context) {}\n", read("service.go")); diff != "" {
+		t.Errorf("service.go mismatch after removal of a deleted variant (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// RequireClean is how files with uncommitted changes are handled (see
+// WithRequireClean).
+type RequireClean string
+
+// Handling of files with uncommitted changes.
+const (
+	// RequireCleanOff modifies files regardless of their git status.
+	RequireCleanOff RequireClean = ""
+	// RequireCleanRefuse leaves files with uncommitted changes alone.
+	RequireCleanRefuse RequireClean = "refuse"
+	// RequireCleanWarn modifies files with uncommitted changes, reporting them.
+	RequireCleanWarn RequireClean = "warn"
+)
+
+// WithRequireClean checks git status for every file about to be modified in
+// place, so that generated changes are not mixed into work in progress. Files
+// with uncommitted changes (staged, unstaged or untracked) are left alone with
+// a WriteError with RequireCleanRefuse, or modified anyway and reported in
+// ProcessResult.DirtyFiles with RequireCleanWarn. Files outside a git
+// repository fail the check. Dry runs and output directories leave the files
+// alone, so nothing is checked.
+func WithRequireClean(mode RequireClean) Option {
+	return func(p *Processor) {
+		p.requireClean = mode
+	}
+}
+
+// checkClean checks that filename has no uncommitted changes before it is
+// modified in place, according to the RequireClean mode. With
+// RequireCleanWarn, a file with uncommitted changes is marked dirty in fr.
+// The status is read once per run, before the file is first written, so that
+// a file processed again (e.g. in the test variant of its package) is not
+// found dirty by the changes of the run itself.
+func (p *Processor) checkClean(filename string, fr *fileResult) error {
+	if p.requireClean == RequireCleanOff || p.dryRun || p.outputDir != "" {
+		return nil
+	}
+	dirty, ok := p.dirtyFiles[filename]
+	if !ok {
+		status, err := git(filepath.Dir(filename), "status", "--porcelain", "--untracked-files=all", "--", filepath.Base(filename))
+		if err != nil {
+			return fmt.Errorf("failed to check for uncommitted changes: %w", err)
+		}
+		dirty = len(status) > 0
+		if p.dirtyFiles != nil {
+			p.dirtyFiles[filename] = dirty
+		}
+	}
+	if !dirty {
+		return nil
+	}
+	if p.requireClean == RequireCleanWarn {
+		fr.dirty = true
+		return nil
+	}
+	return fmt.Errorf("refusing to modify a file with uncommitted changes: commit or stash them first")
+}
@@ -0,0 +1,151 @@
+package processor_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithRequireClean(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tests := map[string]struct {
+		mode          processor.RequireClean
+		wantModified  []string
+		wantDirty     []string
+		wantErrorFile string
+	}{
+		"refuse leaves dirty files alone": {
+			mode:          processor.RequireCleanRefuse,
+			wantModified:  []string{"a.go"},
+			wantErrorFile: "b.go",
+		},
+		"warn reports dirty files": {
+			mode:         processor.RequireCleanWarn,
+			wantModified: []string{"a.go", "b.go"},
+			wantDirty:    []string{"b.go"},
+		},
+		"off modifies every file": {
+			mode:         processor.RequireCleanOff,
+			wantModified: []string{"a.go", "b.go"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := setupTestModule(t, map[string]string{
+				"a.go": "package a\n\nimport \"context\"\n\nfunc trace(context.Context) {}\n\nfunc Foo(ctx context.Context) {\n}\n",
+				"b.go": "package a\n\nimport \"context\"\n\nfunc Bar(ctx context.Context) {\n}\n",
+			})
+			commitAll(t, tmpDir)
+			// Work in progress
+			if err := os.WriteFile(filepath.Join(tmpDir, "b.go"), []byte("package a\n\nimport \"context\"\n\n// Bar is in progress.\nfunc Bar(ctx context.Context) {\n}\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			oldWd, _ := os.Getwd()
+			_ = os.Chdir(tmpDir)
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			proc := processor.New(registry, tmpl, nil, processor.WithRequireClean(tt.mode))
+			result, err := proc.Process([]string{"./..."})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+
+			rel := func(files []string) []string {
+				var names []string
+				for _, f := range files {
+					names = append(names, filepath.Base(f))
+				}
+				return names
+			}
+			if diff := cmp.Diff(tt.wantModified, rel(result.ModifiedFiles)); diff != "" {
+				t.Errorf("ModifiedFiles mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantDirty, rel(result.DirtyFiles)); diff != "" {
+				t.Errorf("DirtyFiles mismatch (-want +got):\n%s", diff)
+			}
+
+			if tt.wantErrorFile == "" {
+				if len(result.Errors) > 0 {
+					t.Fatalf("Process errors: %v", result.Errors)
+				}
+				return
+			}
+			if len(result.Errors) != 1 || !strings.Contains(result.Errors[0].Error(), "uncommitted changes") {
+				t.Fatalf("Errors = %v, want a refusal of %s", result.Errors, tt.wantErrorFile)
+			}
+			var we *processor.WriteError
+			if !errors.As(result.Errors[0], &we) || filepath.Base(we.File) != tt.wantErrorFile {
+				t.Errorf("Errors[0] = %v, want a WriteError of %s", result.Errors[0], tt.wantErrorFile)
+			}
+			content, _ := os.ReadFile(filepath.Join(tmpDir, tt.wantErrorFile))
+			if strings.Contains(string(content), "defer trace") {
+				t.Errorf("%s should be left alone:\n%s", tt.wantErrorFile, content)
+			}
+		})
+	}
+}
+
+// TestWithRequireClean_Tests checks that files shared by a package and its
+// test variant are not found dirty by their own modification.
+func TestWithRequireClean_Tests(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"svc/svc.go":      "package svc\n\nimport \"context\"\n\nfunc trace(context.Context) {}\n\nfunc Get(ctx context.Context) {\n}\n",
+		"svc/svc_test.go": "package svc\n\nimport \"testing\"\n\nfunc TestGet(t *testing.T) {}\n",
+	})
+	commitAll(t, tmpDir)
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil, processor.WithTest(true), processor.WithRequireClean(processor.RequireCleanRefuse))
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Process errors: %v", result.Errors)
+	}
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "svc", "svc.go"))
+	if !strings.Contains(string(content), "defer trace(ctx)") {
+		t.Errorf("svc.go should be modified:\n%s", content)
+	}
+}
+
+// commitAll commits the files of dir to a new git repository.
+func commitAll(t *testing.T, dir string) {
+	t.Helper()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s failed: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package processor
+
+import (
+	"fmt"
+	"go/ast"
+	"strconv"
+
+	"github.com/dave/dst"
+
+	"github.com/mpyw/ctxweaver/internal/dstutil"
+)
+
+// fileScope describes the names declared at the scope of a file.
+type fileScope struct {
+	decls map[string]string // Import paths by name, or "" for the declarations of the package
+	names importNames       // Package names of import paths
+}
+
+// withPackageDecls returns a copy of p that knows the package-level
+// declarations of files, which may shadow the imports added by the template.
+func (p *Processor) withPackageDecls(files []*ast.File) *Processor {
+	decls := make(map[string]bool)
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if d.Recv == nil {
+					decls[d.Name.Name] = true
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.ValueSpec:
+						for _, id := range s.Names {
+							decls[id.Name] = true
+						}
+					case *ast.TypeSpec:
+						decls[s.Name.Name] = true
+					}
+				}
+			}
+		}
+	}
+	return p.withDecls(decls)
+}
+
+// withFileDecls is withPackageDecls for a single DST file, for callers
+// that hold no AST of the package.
+func (p *Processor) withFileDecls(df *dst.File) *Processor {
+	decls := make(map[string]bool)
+	for _, decl := range df.Decls {
+		switch d := decl.(type) {
+		case *dst.FuncDecl:
+			if d.Recv == nil {
+				decls[d.Name.Name] = true
+			}
+		case *dst.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *dst.ValueSpec:
+					for _, id := range s.Names {
+						decls[id.Name] = true
+					}
+				case *dst.TypeSpec:
+					decls[s.Name.Name] = true
+				}
+			}
+		}
+	}
+	return p.withDecls(decls)
+}
+
+// withDecls returns a copy of p with the package-level declarations decls.
+func (p *Processor) withDecls(decls map[string]bool) *Processor {
+	delete(decls, "_")
+	delete(decls, "init")
+
+	q := *p
+	q.pkgDecls = decls
+	return &q
+}
+
+// newFileScope returns the names declared at the scope of df: its imports,
+// named by names unless aliased, and the declarations of the package.
+func (p *Processor) newFileScope(df *dst.File, names importNames) fileScope {
+	scope := fileScope{decls: make(map[string]string, len(p.pkgDecls)+len(df.Imports)), names: names}
+	for name := range p.pkgDecls {
+		scope.decls[name] = ""
+	}
+	for _, spec := range df.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		var name string
+		if spec.Name != nil {
+			name = spec.Name.Name
+		} else {
+			name, _ = names.ResolvePackage(path)
+		}
+		if name != "_" && name != "." {
+			scope.decls[name] = path
+		}
+	}
+	return scope
+}
+
+// checkImportCollisions reports an error if a package the rendered template
+// refers to through a configured import is shadowed at the top of the function
+// by another import of the file, a declaration of the package or a parameter,
+// which would not compile or would silently call something else. Giving the
+// import an alias (see config.Import) resolves the collision.
+func (p *Processor) checkImportCollisions(decl *dst.FuncDecl, rendered string, scope fileScope) error {
+	stmts, err := dstutil.ParseStatements(rendered)
+	if err != nil {
+		return fmt.Errorf("failed to parse rendered statement: %w", err)
+	}
+	qualifiers := make(map[string]bool)
+	for _, stmt := range stmts {
+		dst.Inspect(stmt, func(n dst.Node) bool {
+			if sel, ok := n.(*dst.SelectorExpr); ok {
+				if id, ok := sel.X.(*dst.Ident); ok {
+					qualifiers[id.Name] = true
+				}
+			}
+			return true
+		})
+	}
+
+	params := signatureNames(decl)
+	for _, imp := range p.imports {
+		name := imp.Alias
+		if name == "" {
+			name, _ = scope.names.ResolvePackage(imp.Path)
+		}
+		if !qualifiers[name] {
+			continue
+		}
+
+		var taken string
+		switch path, ok := scope.decls[name]; {
+		case params[name]:
+			taken = "a parameter of the function"
+		case ok && path == "":
+			taken = "declared in the package"
+		case ok && path != imp.Path:
+			taken = fmt.Sprintf("the name of the import of %q", path)
+		default:
+			continue
+		}
+		return fmt.Errorf("template refers to %q as %s, which is %s (give the import an alias in imports: {path: %s, alias: ...})", imp.Path, name, taken, imp.Path)
+	}
+	return nil
+}
+
+// signatureNames returns the names declared by the signature of decl:
+// receiver, type parameters, parameters and results.
+func signatureNames(decl *dst.FuncDecl) map[string]bool {
+	names := make(map[string]bool)
+	for _, fl := range []*dst.FieldList{decl.Recv, decl.Type.TypeParams, decl.Type.Params, decl.Type.Results} {
+		if fl == nil {
+			continue
+		}
+		for _, field := range fl.List {
+			for _, id := range field.Names {
+				names[id.Name] = true
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,23 @@
+package processor
+
+import (
+	"github.com/mpyw/ctxweaver/internal/dstutil"
+)
+
+// Comparator compares DST nodes to detect existing statements.
+// See NewComparator for extending it with custom NodeComparer implementations.
+type Comparator = dstutil.Comparator
+
+// NodeComparer compares two DST nodes of a single registered type.
+type NodeComparer = dstutil.NodeComparer
+
+// NewComparator returns a Comparator preloaded with the built-in comparers.
+// Register additional comparers on it for node types the skeleton matcher
+// does not handle, then pass it to WithComparator:
+//
+//	c := processor.NewComparator()
+//	c.Register(reflect.TypeOf((*dst.RangeStmt)(nil)), myRangeComparer{})
+//	proc := processor.New(registry, tmpl, imports, processor.WithComparator(c))
+func NewComparator() *Comparator {
+	return dstutil.NewComparator()
+}
@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// Confirmation describes a function about to be modified, for WithConfirm.
+type Confirmation struct {
+	Package   string // Import path of the package
+	File      string // File relative to its module root; empty if unknown
+	Func      string // Name as in stack traces, e.g. "Foo" or "(*Service).Get"
+	Header    string // Declaration without body, e.g. "func (s *Service) Get(ctx context.Context) error"
+	Op        string // Operation, as FuncChange.Op
+	Statement string // Rendered template; the statements to remove in remove mode
+}
+
+// ConfirmFunc decides whether a function is modified.
+type ConfirmFunc func(Confirmation) bool
+
+// WithConfirm asks confirm before modifying every function with the template's
+// statements, once the modification is known: functions already up to date are
+// not asked about. A function is left alone, and reported in
+// ProcessResult.DeclinedFuncs, if confirm returns false. Changes made by
+// mutators and field initializations are not asked about.
+func WithConfirm(confirm ConfirmFunc) Option {
+	return func(p *Processor) {
+		p.confirm = confirm
+	}
+}
+
+// confirmation describes the modification of decl for WithConfirm.
+func (p *Processor) confirmation(decl *dst.FuncDecl, pkgPath, op, stmt string) Confirmation {
+	return Confirmation{
+		Package:   pkgPath,
+		File:      p.filename,
+		Func:      funcName(decl),
+		Header:    funcHeader(decl),
+		Op:        op,
+		Statement: stmt,
+	}
+}
+
+// funcHeader formats the declaration of a function without its body, with
+// types formatted as by signatureString, e.g.
+// "func (s *Service) Get(ctx context.Context, id int) error".
+func funcHeader(decl *dst.FuncDecl) string {
+	var sb strings.Builder
+	sb.WriteString("func ")
+	if decl.Recv != nil {
+		sb.WriteString("(" + strings.Join(namedFields(decl.Recv), ", ") + ") ")
+	}
+	sb.WriteString(decl.Name.Name)
+	if tp := decl.Type.TypeParams; tp != nil && len(tp.List) > 0 {
+		sb.WriteString("[" + strings.Join(namedFields(tp), ", ") + "]")
+	}
+	sb.WriteString("(" + strings.Join(namedFields(decl.Type.Params), ", ") + ")")
+
+	results := namedFields(decl.Type.Results)
+	switch {
+	case len(results) == 1 && len(decl.Type.Results.List[0].Names) == 0:
+		sb.WriteString(" " + results[0])
+	case len(results) > 0:
+		sb.WriteString(" (" + strings.Join(results, ", ") + ")")
+	}
+	return sb.String()
+}
+
+// namedFields formats each field of a list with its names, e.g. "a, b int".
+func namedFields(fields *dst.FieldList) []string {
+	if fields == nil {
+		return nil
+	}
+	formatted := make([]string, 0, len(fields.List))
+	for _, f := range fields.List {
+		t := typeString(f.Type)
+		if len(f.Names) == 0 {
+			formatted = append(formatted, t)
+			continue
+		}
+		names := make([]string, len(f.Names))
+		for i, name := range f.Names {
+			names[i] = name.Name
+		}
+		formatted = append(formatted, strings.Join(names, ", ")+" "+t)
+	}
+	return formatted
+}
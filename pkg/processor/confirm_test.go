@@ -0,0 +1,161 @@
+package processor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithConfirm(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"service.go": `package service
+
+import "context"
+
+type Service struct{}
+
+func (s *Service) Get(ctx context.Context, id int) (string, error) {
+	return "", nil
+}
+
+func Put(ctx context.Context) {
+}
+
+func Done(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func trace(context.Context) {}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	var asked []processor.Confirmation
+	proc := processor.New(registry, tmpl, nil, processor.WithConfirm(func(c processor.Confirmation) bool {
+		asked = append(asked, c)
+		return c.Func == "Put"
+	}))
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Process errors: %v", result.Errors)
+	}
+
+	// Done is up to date, so it is not asked about
+	wantAsked := []processor.Confirmation{
+		{
+			Package:   "testmod",
+			File:      "service.go",
+			Func:      "(*Service).Get",
+			Header:    "func (s *Service) Get(ctx context.Context, id int) (string, error)",
+			Op:        "insert",
+			Statement: "defer trace(ctx)",
+		},
+		{
+			Package:   "testmod",
+			File:      "service.go",
+			Func:      "Put",
+			Header:    "func Put(ctx context.Context)",
+			Op:        "insert",
+			Statement: "defer trace(ctx)",
+		},
+	}
+	if diff := cmp.Diff(wantAsked, asked); diff != "" {
+		t.Errorf("confirmations mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err := os.ReadFile(filepath.Join(tmpDir, "service.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package service
+
+import "context"
+
+type Service struct{}
+
+func (s *Service) Get(ctx context.Context, id int) (string, error) {
+	return "", nil
+}
+
+func Put(ctx context.Context) {
+	defer trace(ctx)
+
+}
+
+func Done(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func trace(context.Context) {}
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("file mismatch (-want +got):\n%s", diff)
+	}
+
+	var declined []string
+	for _, fc := range result.DeclinedFuncs {
+		declined = append(declined, fc.Func)
+	}
+	if diff := cmp.Diff([]string{"(*Service).Get"}, declined); diff != "" {
+		t.Errorf("DeclinedFuncs mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithConfirm_Tests(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"service.go": `package service
+
+import "context"
+
+func Put(ctx context.Context) {
+}
+
+func trace(context.Context) {}
+`,
+		"service_test.go": `package service
+
+import "testing"
+
+func TestPut(t *testing.T) {}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	// service.go is in both the package and its test variant, and is asked about once
+	var asked []string
+	proc := processor.New(registry, tmpl, nil, processor.WithTest(true), processor.WithConfirm(func(c processor.Confirmation) bool {
+		asked = append(asked, c.File+": "+c.Func)
+		return false
+	}))
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Process errors: %v", result.Errors)
+	}
+	if diff := cmp.Diff([]string{"service.go: Put"}, asked); diff != "" {
+		t.Errorf("confirmations mismatch (-want +got):\n%s", diff)
+	}
+}
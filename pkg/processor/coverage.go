@@ -0,0 +1,214 @@
+package processor
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"slices"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/mpyw/ctxweaver/internal/directive"
+)
+
+// PackageCoverage reports how many eligible functions of a package are instrumented.
+// A function is eligible if it has a context carrier and passes all filters and
+// skip directives; it is instrumented if a generated statement is detected in it,
+// whether or not that statement is up to date.
+type PackageCoverage struct {
+	Package      string `json:"package,omitempty"`
+	Eligible     int    `json:"eligible"`
+	Instrumented int    `json:"instrumented"`
+}
+
+// Ratio returns the fraction of eligible functions that are instrumented.
+// A package without eligible functions is fully covered.
+func (c PackageCoverage) Ratio() float64 {
+	if c.Eligible == 0 {
+		return 1
+	}
+	return float64(c.Instrumented) / float64(c.Eligible)
+}
+
+// CoverageResult contains the per-package coverage, sorted by package path.
+type CoverageResult struct {
+	Packages []PackageCoverage
+	Errors   []error
+}
+
+// Total sums the coverage of all packages.
+func (r *CoverageResult) Total() PackageCoverage {
+	var total PackageCoverage
+	for _, c := range r.Packages {
+		total.Eligible += c.Eligible
+		total.Instrumented += c.Instrumented
+	}
+	return total
+}
+
+// Coverage reports instrumentation coverage for the given package patterns
+// without modifying any file. The remove, dedupe, marker migration and rename
+// options are ignored. Functions in the baseline are not counted.
+func (p *Processor) Coverage(patterns []string) (*CoverageResult, error) {
+	result := &CoverageResult{}
+	byPath := make(map[string]*PackageCoverage)
+
+	visitFile := func(pkgPath string) {
+		if byPath[pkgPath] == nil {
+			byPath[pkgPath] = &PackageCoverage{Package: pkgPath}
+		}
+	}
+	visitFunc := func(fn eligibleFunc) {
+		if p.baseline.Contains(funcKey(fn.pkgPath, fn.decl)) {
+			return
+		}
+		cov := byPath[fn.pkgPath]
+		cov.Eligible++
+		if fn.instrumented() {
+			cov.Instrumented++
+		}
+	}
+
+	errs, err := p.inspectEligible(patterns, visitFile, visitFunc)
+	if err != nil {
+		return nil, err
+	}
+	result.Errors = errs
+
+	for _, cov := range byPath {
+		result.Packages = append(result.Packages, *cov)
+	}
+	slices.SortFunc(result.Packages, func(a, b PackageCoverage) int {
+		return cmp.Compare(a.Package, b.Package)
+	})
+
+	return result, nil
+}
+
+// eligibleFunc is an eligible function found by inspectEligible.
+type eligibleFunc struct {
+	funcCandidate
+	pkgPath string
+	file    string
+	line    int    // Line of the func keyword
+	action  Action // What a regular weave would do to the function
+	typeOf  typeResolver
+	lineOf  func(n dst.Node) int // Line of a node of the function, or 0 if unknown
+}
+
+// instrumented reports whether a generated statement is detected in the function.
+func (fn eligibleFunc) instrumented() bool {
+	_, missing := fn.action.(insertAction)
+	return !missing
+}
+
+// eligibleVisitor is called by inspectEligible for every eligible function.
+type eligibleVisitor func(fn eligibleFunc)
+
+// inspectEligible calls visitFile for every file that Process would handle and
+// visitFunc for every eligible function of the given package patterns.
+// No file is modified. Errors of individual packages and files are returned
+// as the first result.
+func (p *Processor) inspectEligible(patterns []string, visitFile func(pkgPath string), visitFunc eligibleVisitor) ([]error, error) {
+	// Detect statements as a regular weave would
+	q := *p
+	q.remove, q.dedupe, q.migrateToMarker, q.detectDrift = false, false, false, false
+	q.renames = nil
+	p = &q
+
+	_, batches, err := p.loadPackages(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	seen := make(map[string]bool) // Files shared by a package and its test variant
+
+	for pkgs, err := range batches {
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range pkgs {
+			if len(pkg.Errors) > 0 {
+				for _, e := range pkg.Errors {
+					errs = append(errs, &LoadError{Package: pkg.PkgPath, Err: e})
+				}
+				continue
+			}
+
+			if p.shouldExcludePackage(pkg.PkgPath) {
+				continue
+			}
+
+			pp := p.forPackage(pkg.PkgPath).withInterfaces(pkg).withModule(packageModule(pkg))
+			dec := newDecorator(pkg)
+			pp = pp.withPackageCandidates(pkg, dec)
+
+			for _, file := range pkg.Syntax {
+				pos := pkg.Fset.Position(file.Pos())
+				if !pos.IsValid() {
+					continue
+				}
+				filename := pos.Filename
+
+				if seen[filename] || !pp.shouldProcessFile(filename) {
+					continue
+				}
+				seen[filename] = true
+
+				visitFile(pkg.PkgPath)
+				if err := pp.inspectFile(pkg, dec, file, filename, visitFunc); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+
+	return errs, nil
+}
+
+// inspectFile calls visit for every eligible function of a single file.
+func (p *Processor) inspectFile(pkg *packages.Package, dec *decorator.Decorator, astFile *ast.File, filename string, visit eligibleVisitor) error {
+	if p.skipsGenerated(astFile, moduleRelPath(pkg, filename)) {
+		return nil
+	}
+
+	df, err := dec.DecorateFile(astFile)
+	if err != nil {
+		return &ParseError{File: filename, Err: fmt.Errorf("failed to decorate file: %w", err)}
+	}
+
+	if directive.HasSkipDirective(df.Decorations()) {
+		return nil
+	}
+
+	p = p.withFilename(moduleRelPath(pkg, filename))
+	typeOf := packageTypeResolver(pkg, dec)
+	lineOf := func(n dst.Node) int {
+		if an, ok := dec.Ast.Nodes[n]; ok {
+			return pkg.Fset.Position(an.Pos()).Line
+		}
+		return 0
+	}
+	for _, c := range p.collectCandidates(df, pkg.PkgPath, typeOf) {
+		cp := p.forCandidate(c)
+		rt, err := cp.renderCandidate(c, df, pkg.PkgPath)
+		if err != nil {
+			return &RenderError{File: filename, Func: funcName(c.decl), Err: err}
+		}
+		if rt.stmt == "" {
+			continue // The template has nothing for the function
+		}
+		action, err := cp.detectCandidateAction(c, rt)
+		if err != nil {
+			return &RenderError{File: filename, Func: funcName(c.decl), Err: err}
+		}
+
+		fn := eligibleFunc{funcCandidate: c, pkgPath: pkg.PkgPath, file: filename, action: action, typeOf: typeOf, lineOf: lineOf}
+		fn.line = lineOf(c.decl)
+		visit(fn)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package processor_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestCoverage(t *testing.T) {
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go": `package main
+
+import "context"
+
+func trace(context.Context, string) {}
+
+func Instrumented(ctx context.Context) {
+	defer trace(ctx, "main.Instrumented")
+}
+
+func Outdated(ctx context.Context) {
+	defer trace(ctx, "main.OldName")
+}
+
+func Missing(ctx context.Context) {
+}
+
+//ctxweaver:skip
+func Skipped(ctx context.Context) {
+}
+
+func NoCarrier() {
+}
+`,
+		"sub/sub.go": `package sub
+
+import "context"
+
+func Missing(ctx context.Context) {
+}
+`,
+		"empty/empty.go": `package empty
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	before, err := os.ReadFile("main.go")
+	if err != nil {
+		t.Fatalf("failed to read main.go: %v", err)
+	}
+
+	// Remove mode must not affect detection
+	proc := processor.New(registry, tmpl, nil, processor.WithRemove(true))
+	result, err := proc.Coverage([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Coverage() error = %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Coverage() errors = %v", result.Errors)
+	}
+
+	want := []processor.PackageCoverage{
+		{Package: "testmod", Eligible: 3, Instrumented: 2},
+		{Package: "testmod/empty", Eligible: 0, Instrumented: 0},
+		{Package: "testmod/sub", Eligible: 1, Instrumented: 0},
+	}
+	if diff := cmp.Diff(want, result.Packages); diff != "" {
+		t.Errorf("Packages mismatch (-want +got):\n%s", diff)
+	}
+
+	total := result.Total()
+	if total.Eligible != 4 || total.Instrumented != 2 {
+		t.Errorf("Total() = %+v, want 4 eligible and 2 instrumented", total)
+	}
+	if got := total.Ratio(); got != 0.5 {
+		t.Errorf("Ratio() = %v, want 0.5", got)
+	}
+	if got := result.Packages[1].Ratio(); got != 1 {
+		t.Errorf("Ratio() of package without eligible functions = %v, want 1", got)
+	}
+
+	after, err := os.ReadFile("main.go")
+	if err != nil {
+		t.Fatalf("failed to read main.go: %v", err)
+	}
+	if string(before) != string(after) {
+		t.Error("Coverage() must not modify files")
+	}
+}
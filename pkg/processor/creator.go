@@ -0,0 +1,58 @@
+package processor
+
+import (
+	"github.com/dave/dst"
+
+	"github.com/mpyw/ctxweaver/internal/directive"
+)
+
+// WithContextCreators reports, as ProcessResult.ContextCreators, the
+// functions passing the filters that have no context carrier but create their
+// own context with context.Background() or context.TODO(): candidates for a
+// context.Context parameter, after which they can be instrumented. They are
+// not modified.
+func WithContextCreators(report bool) Option {
+	return func(p *Processor) {
+		p.reportCreators = report
+	}
+}
+
+// contextCreators returns the functions of df passing the filters that have
+// no carrier and call context.Background() or context.TODO().
+func (p *Processor) contextCreators(df *dst.File, pkgPath string, typeOf typeResolver) []changedFunc {
+	if !p.reportCreators {
+		return nil
+	}
+	var creators []changedFunc
+	off := directive.OffDecls(df)
+	for _, decl := range df.Decls {
+		fn, ok := decl.(*dst.FuncDecl)
+		if !ok || fn.Body == nil || shouldSkipDecl(fn) || off[fn] || !p.isSelected(fn) {
+			continue
+		}
+		if !p.matchesFuncFilter(fn, pkgPath, typeOf) || p.tryMatchCarrier(fn, typeOf) != nil {
+			continue
+		}
+		if call := contextCreation(fn.Body); call != "" {
+			creators = append(creators, changedFunc{decl: fn, reason: "calls " + call})
+		}
+	}
+	return creators
+}
+
+// contextCreation returns the first call of body creating a root context, as
+// "context.Background()" or "context.TODO()", or an empty string if there is none.
+func contextCreation(body *dst.BlockStmt) string {
+	var found string
+	dst.Inspect(body, func(n dst.Node) bool {
+		call, ok := n.(*dst.CallExpr)
+		if !ok || found != "" {
+			return found == ""
+		}
+		if found = rootContext(call); found != "" {
+			return false
+		}
+		return true
+	})
+	return found
+}
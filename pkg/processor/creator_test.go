@@ -0,0 +1,98 @@
+package processor_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithContextCreators(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"service.go": `package service
+
+import "context"
+
+func Background() {
+	run(context.Background())
+}
+
+func Todo() {
+	go func() {
+		run(context.TODO())
+	}()
+}
+
+func WithCarrier(ctx context.Context) {
+	run(context.Background())
+}
+
+//ctxweaver:skip
+func Skipped() {
+	run(context.TODO())
+}
+
+func Plain() {
+	run(nil)
+}
+
+func run(ctx context.Context) {}
+
+func trace(context.Context) {}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	tests := map[string]struct {
+		report bool
+		want   map[string]string
+	}{
+		"reported": {
+			report: true,
+			want: map[string]string{
+				"Background": "calls context.Background()",
+				"Todo":       "calls context.TODO()",
+			},
+		},
+		"ignored by default": {
+			want: map[string]string{},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithContextCreators(tt.report))
+			result, err := proc.Process([]string{"./..."})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+
+			got := make(map[string]string)
+			for _, ch := range result.ContextCreators {
+				got[ch.Func] = ch.Reason
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ContextCreators mismatch (-want +got):\n%s", diff)
+			}
+
+			// Functions creating their own context are never modified
+			var modified []string
+			for _, ch := range result.ModifiedFuncs {
+				modified = append(modified, ch.Func)
+			}
+			if diff := cmp.Diff([]string{"WithCarrier", "run"}, modified); diff != "" {
+				t.Errorf("ModifiedFuncs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package processor
+
+import (
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/mpyw/ctxweaver/internal/directive"
+)
+
+// packageCandidates maps function keys (see funcKey) to the declarations of the
+// candidates of a package.
+type packageCandidates map[string]*dst.FuncDecl
+
+// skipsDelegates reports whether functions delegating to an instrumented callee are skipped.
+func (p *Processor) skipsDelegates() bool {
+	return p.funcFilter != nil && p.funcFilter.SkipDelegates
+}
+
+// withPackageCandidates returns a copy of p that knows the candidates of every
+// processed file of pkg, so that delegating functions are detected across files.
+// Returns p itself if delegating functions are not skipped.
+func (p *Processor) withPackageCandidates(pkg *packages.Package, dec *decorator.Decorator) *Processor {
+	if !p.skipsDelegates() {
+		return p
+	}
+
+	// Collect with the regular filters only: delegates are resolved afterwards
+	q := *p
+	filter := *p.funcFilter
+	filter.SkipDelegates = false
+	q.funcFilter = &filter
+
+	known := make(packageCandidates)
+	for _, file := range pkg.Syntax {
+		pos := pkg.Fset.Position(file.Pos())
+		if !pos.IsValid() || !p.shouldProcessFile(pos.Filename) {
+			continue
+		}
+		df, err := dec.DecorateFile(file)
+		if err != nil || directive.HasSkipDirective(df.Decorations()) {
+			continue // Reported when the file is processed
+		}
+		for _, c := range q.collectCandidates(df, pkg.PkgPath, packageTypeResolver(pkg, dec)) {
+			known[funcKey(pkg.PkgPath, c.decl)] = c.decl
+		}
+	}
+
+	r := *p
+	r.pkgCandidates = known
+	return &r
+}
+
+// dropDelegates removes the candidates whose body merely delegates to another
+// candidate of the same package, which would be instrumented itself.
+// Special functions with their own template are kept, as they bypass the
+// function filter.
+// Without the candidates of the whole package (TransformFile), only the
+// candidates of the file are known.
+func (p *Processor) dropDelegates(candidates []funcCandidate, pkgPath string) []funcCandidate {
+	known := p.pkgCandidates
+	if known == nil {
+		known = make(packageCandidates, len(candidates))
+		for _, c := range candidates {
+			known[funcKey(pkgPath, c.decl)] = c.decl
+		}
+	}
+
+	kept := candidates[:0]
+	for _, c := range candidates {
+		if c.tmpl != nil || !isDelegate(c.decl, pkgPath, known) {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// isDelegate reports whether decl merely delegates to a known function, directly
+// or through other delegates, so that only the function doing the work is
+// instrumented. Functions delegating to each other in a cycle are not delegates.
+func isDelegate(decl *dst.FuncDecl, pkgPath string, known packageCandidates) bool {
+	visited := map[string]bool{funcKey(pkgPath, decl): true}
+	delegates := false
+	for {
+		key, ok := delegateCallee(decl, pkgPath)
+		if !ok {
+			return delegates
+		}
+		callee, ok := known[key]
+		if !ok {
+			return delegates
+		}
+		if visited[key] {
+			return false
+		}
+		visited[key] = true
+		decl, delegates = callee, true
+	}
+}
+
+// delegateCallee returns the key (see funcKey) of the function called by a
+// body consisting of a single call statement, such as "return s.get(ctx, id)".
+// Only functions of the same package and methods called on the receiver are
+// recognized.
+func delegateCallee(decl *dst.FuncDecl, pkgPath string) (string, bool) {
+	if len(decl.Body.List) != 1 {
+		return "", false
+	}
+
+	var call *dst.CallExpr
+	switch stmt := decl.Body.List[0].(type) {
+	case *dst.ExprStmt:
+		call, _ = stmt.X.(*dst.CallExpr)
+	case *dst.ReturnStmt:
+		if len(stmt.Results) == 1 {
+			call, _ = stmt.Results[0].(*dst.CallExpr)
+		}
+	}
+	if call == nil {
+		return "", false
+	}
+
+	fun := call.Fun
+	switch f := fun.(type) {
+	case *dst.IndexExpr:
+		fun = f.X
+	case *dst.IndexListExpr:
+		fun = f.X
+	}
+
+	switch f := fun.(type) {
+	case *dst.Ident:
+		if f.Path != "" && f.Path != pkgPath {
+			return "", false
+		}
+		return pkgPath + "." + f.Name, true
+	case *dst.SelectorExpr:
+		recv, ok := f.X.(*dst.Ident)
+		if !ok || decl.Recv == nil || len(decl.Recv.List) == 0 {
+			return "", false
+		}
+		field := decl.Recv.List[0]
+		if len(field.Names) == 0 || field.Names[0].Name != recv.Name {
+			return "", false
+		}
+		return pkgPath + "." + recvTypeName(field.Type) + "." + f.Sel.Name, true
+	}
+	return "", false
+}
@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// AddedImport is an import inserted into the files of a module by a weave.
+type AddedImport struct {
+	Path   string // Import path, e.g. "go.opentelemetry.io/otel"
+	Module string // Path of the module whose files got the import; empty if unknown
+	Dir    string // Root directory of that module; empty if unknown
+}
+
+// addedImports returns the import paths of result that original does not
+// import.
+func addedImports(original, result []byte) []string {
+	before := importPaths(original)
+	var added []string
+	for _, path := range importPaths(result) {
+		if !slices.Contains(before, path) {
+			added = append(added, path)
+		}
+	}
+	return added
+}
+
+// importPaths returns the import paths of the Go source src, or nil if it
+// cannot be parsed.
+func importPaths(src []byte) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), "", src, parser.ImportsOnly)
+	if err != nil {
+		return nil
+	}
+	paths := make([]string, 0, len(f.Imports))
+	for _, spec := range f.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// sortAddedImports returns the imports of set sorted by module and path.
+func sortAddedImports(set map[AddedImport]bool) []AddedImport {
+	imports := make([]AddedImport, 0, len(set))
+	for imp := range set {
+		imports = append(imports, imp)
+	}
+	slices.SortFunc(imports, func(a, b AddedImport) int {
+		return cmp.Or(cmp.Compare(a.Module, b.Module), cmp.Compare(a.Path, b.Path))
+	})
+	return imports
+}
+
+// MissingDependencies returns the imports that no module provides to the
+// module importing them: neither the standard library, the module itself nor
+// a module required by its go.mod, as reported by go list run in the module
+// directory. These are the new dependencies a go mod tidy would add. Imports
+// of unknown modules are left out.
+func MissingDependencies(imports []AddedImport) ([]AddedImport, error) {
+	byDir := make(map[string][]AddedImport)
+	var dirs []string
+	for _, imp := range imports {
+		if imp.Dir == "" {
+			continue
+		}
+		if _, ok := byDir[imp.Dir]; !ok {
+			dirs = append(dirs, imp.Dir)
+		}
+		byDir[imp.Dir] = append(byDir[imp.Dir], imp)
+	}
+
+	var missing []AddedImport
+	for _, dir := range dirs {
+		paths := make([]string, 0, len(byDir[dir]))
+		for _, imp := range byDir[dir] {
+			paths = append(paths, imp.Path)
+		}
+		unresolved, err := UnresolvedImports(dir, paths)
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range byDir[dir] {
+			if slices.Contains(unresolved, imp.Path) {
+				missing = append(missing, imp)
+			}
+		}
+	}
+	return missing, nil
+}
+
+// UnresolvedImports returns the import paths that the module in dir (the
+// working directory if empty) cannot import: those that neither the standard
+// library, the module itself nor a module required by its go.mod provides, as
+// reported by go list.
+func UnresolvedImports(dir string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	args := append([]string{"list", "-e", "-f", "{{.ImportPath}} {{.Standard}} {{with .Module}}{{.Path}}{{end}}"}, paths...)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("go list in %s: %w: %s", cmp.Or(dir, "."), err, strings.TrimSpace(stderr.String()))
+	}
+	provided := make(map[string]bool)
+	for line := range strings.Lines(stdout.String()) {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && (fields[1] == "true" || len(fields) == 3) {
+			provided[fields[0]] = true
+		}
+	}
+	var unresolved []string
+	for _, path := range paths {
+		if !provided[path] && !slices.Contains(unresolved, path) {
+			unresolved = append(unresolved, path)
+		}
+	}
+	return unresolved, nil
+}
@@ -0,0 +1,93 @@
+package processor_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestProcess_AddedImports(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer log.Println({{.FuncName | quote}}, strings.ToUpper("x"))`)
+	imports := []string{"log", "strings"}
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"a.go": `package a
+
+import (
+	"context"
+	"strings"
+)
+
+func Foo(ctx context.Context) {
+	_ = strings.TrimSpace("")
+}
+`,
+		"b.go": `package a
+
+import "context"
+
+func Bar(ctx context.Context) {
+}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, imports)
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Process errors: %v", result.Errors)
+	}
+
+	want := []processor.AddedImport{
+		{Path: "log", Module: "testmod", Dir: tmpDir},
+		{Path: "strings", Module: "testmod", Dir: tmpDir},
+	}
+	if diff := cmp.Diff(want, result.AddedImports); diff != "" {
+		t.Errorf("AddedImports mismatch (-want +got):\n%s", diff)
+	}
+
+	// Running again inserts nothing
+	result, err = proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.AddedImports) > 0 {
+		t.Errorf("AddedImports = %v, want none", result.AddedImports)
+	}
+}
+
+func TestMissingDependencies(t *testing.T) {
+	tmpDir := setupTestModule(t, map[string]string{
+		"a.go":       "package a\n",
+		"sub/sub.go": "package sub\n",
+	})
+
+	imports := []processor.AddedImport{
+		{Path: "log", Module: "testmod", Dir: tmpDir},
+		{Path: "testmod/sub", Module: "testmod", Dir: tmpDir},
+		{Path: "example.com/trace", Module: "testmod", Dir: tmpDir},
+		{Path: "example.com/unknown"}, // Module unknown: left out
+	}
+	got, err := processor.MissingDependencies(imports)
+	if err != nil {
+		t.Fatalf("MissingDependencies failed: %v", err)
+	}
+	want := []processor.AddedImport{
+		{Path: "example.com/trace", Module: "testmod", Dir: tmpDir},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("MissingDependencies mismatch (-want +got):\n%s", diff)
+	}
+}
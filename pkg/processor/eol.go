@@ -0,0 +1,20 @@
+package processor
+
+import "bytes"
+
+// usesCRLF reports whether src has Windows line endings, judging by its first
+// line break. go/format always emits LF line endings.
+func usesCRLF(src []byte) bool {
+	i := bytes.IndexByte(src, '\n')
+	return i > 0 && src[i-1] == '\r'
+}
+
+// restoreLineEndings converts the line endings of formatted to those of
+// original, so that files with CRLF line endings are not rewritten as a whole.
+func restoreLineEndings(original, formatted []byte) []byte {
+	if !usesCRLF(original) {
+		return formatted
+	}
+	lf := bytes.ReplaceAll(formatted, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(lf, []byte("\n"), []byte("\r\n"))
+}
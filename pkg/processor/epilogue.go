@@ -0,0 +1,176 @@
+package processor
+
+import (
+	"slices"
+
+	"github.com/dave/dst"
+
+	"github.com/mpyw/ctxweaver/internal/directive"
+	"github.com/mpyw/ctxweaver/internal/dstutil"
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+// syncsEpilogue reports whether the epilogue is brought in line with the
+// statements of the main template after action. Functions whose statements
+// are protected by a skip directive are left alone, and the modes rewriting
+// existing statements in place (renames, marker migration, drift detection)
+// only handle the main template. Undoing a run only removes the epilogue of
+// the functions whose statements it removes.
+func (p *Processor) syncsEpilogue(action Action) bool {
+	if p.epilogue == nil || len(p.renames) > 0 || p.migrateToMarker || p.detectDrift {
+		return false
+	}
+	if p.remove && p.runID != "" {
+		_, removed := action.(removeAction)
+		return removed
+	}
+	_, protected := action.(protectedAction)
+	return !protected
+}
+
+// syncEpilogue brings the statements before every exit of the function in
+// line with the rendered epilogue: inserted where missing and updated where
+// outdated, or removed in remove mode. The exits are the return statements,
+// outside function literals, and the end of a function without results that
+// does not end with a return or a panic. Existing epilogues are detected by
+// skeleton matching (placeholder matching in placeholder mode) right before
+// an exit; those with a skip directive are left alone.
+// Returns whether the body was modified.
+func (p *Processor) syncEpilogue(decl *dst.FuncDecl, rt renderedTemplate) (bool, error) {
+	targetStmts, err := parseTemplateStatements(rt.epilogue.stmt)
+	if err != nil {
+		return false, err
+	}
+	stmtCount := len(targetStmts)
+	patternStmts := parsePattern(rt.epilogue.pattern, stmtCount)
+
+	skeletonStmts, skeleton := targetStmts, p.comparator
+	if p.matching == config.MatchingPlaceholder && patternStmts != nil {
+		skeletonStmts, skeleton = patternStmts, p.comparator.WithWildcards(template.PlaceholderPrefix)
+	}
+	upToDate := p.upToDate(targetStmts, patternStmts, rt.bindings)
+
+	// matchesAt reports whether the statements of list ending before exit match the epilogue
+	matchesAt := func(list []dst.Stmt, exit int) (exact, ok bool) {
+		start := exit - stmtCount
+		if start < 0 {
+			return false, false
+		}
+		exact = true
+		for j, skeletonStmt := range skeletonStmts {
+			if !skeleton.MatchesSkeleton(skeletonStmt, list[start+j]) {
+				return false, false
+			}
+			if !upToDate(j, list[start+j]) {
+				exact = false
+			}
+		}
+		return exact, true
+	}
+
+	modified := false
+	forEachStmtList(decl.Body, func(list *[]dst.Stmt, top bool) {
+		exits := returnIndices(*list)
+		if top && implicitReturn(decl, *list) {
+			exits = append(exits, len(*list))
+		}
+		// From the end so that earlier indices stay valid
+		for _, exit := range slices.Backward(exits) {
+			block := &dst.BlockStmt{List: *list}
+			exact, ok := matchesAt(block.List, exit)
+			switch {
+			case ok && directive.HasStmtSkipDirective(block.List[exit-stmtCount]):
+				// Manually maintained
+			case ok && p.remove:
+				modified = removeEpilogue(block, exit-stmtCount, stmtCount) || modified
+			case ok && !exact:
+				modified = dstutil.UpdateStatements(block, exit-stmtCount, stmtCount, rt.epilogue.stmt) || modified
+			case !ok && !p.remove:
+				modified = insertEpilogue(block, exit, rt.epilogue.stmt) || modified
+			}
+			*list = block.List
+		}
+	})
+	return modified, nil
+}
+
+// forEachStmtList calls fn with every statement list of body, including nested
+// blocks and case clauses but not function literals. top is true for the list
+// of body itself.
+func forEachStmtList(body *dst.BlockStmt, fn func(list *[]dst.Stmt, top bool)) {
+	dst.Inspect(body, func(n dst.Node) bool {
+		switch n := n.(type) {
+		case *dst.FuncLit:
+			return false
+		case *dst.BlockStmt:
+			fn(&n.List, n == body)
+		case *dst.CaseClause:
+			fn(&n.Body, false)
+		case *dst.CommClause:
+			fn(&n.Body, false)
+		}
+		return true
+	})
+}
+
+// returnIndices returns the indices of the return statements of list.
+func returnIndices(list []dst.Stmt) []int {
+	var indices []int
+	for i, stmt := range list {
+		if _, ok := stmt.(*dst.ReturnStmt); ok {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// implicitReturn reports whether the function returns by reaching the end of
+// its body, whose statements are list: it has no results, and its last
+// statement is neither a return nor a call to panic.
+func implicitReturn(decl *dst.FuncDecl, list []dst.Stmt) bool {
+	if decl.Type.Results != nil && len(decl.Type.Results.List) > 0 {
+		return false
+	}
+	if len(list) == 0 {
+		return true
+	}
+	switch last := list[len(list)-1].(type) {
+	case *dst.ReturnStmt:
+		return false
+	case *dst.ExprStmt:
+		call, ok := last.X.(*dst.CallExpr)
+		if !ok {
+			return true
+		}
+		fun, ok := call.Fun.(*dst.Ident)
+		return !ok || fun.Name != "panic" || fun.Path != ""
+	}
+	return true
+}
+
+// insertEpilogue inserts the rendered epilogue before the statement at index
+// of body, or at its end if index is its length. The epilogue takes over the
+// line spacing before the statement.
+func insertEpilogue(body *dst.BlockStmt, index int, rendered string) bool {
+	stmts, err := dstutil.ParseStatements(rendered)
+	if err != nil || len(stmts) == 0 {
+		return false
+	}
+	if index < len(body.List) {
+		next := body.List[index].Decorations()
+		stmts[0].Decorations().Before = next.Before
+		next.Before = dst.NewLine
+	}
+	body.List = slices.Insert(body.List, index, stmts...)
+	return true
+}
+
+// removeEpilogue removes the count statements of the epilogue at index of
+// body. The following statement takes over the line spacing before it.
+func removeEpilogue(body *dst.BlockStmt, index, count int) bool {
+	if index+count < len(body.List) {
+		body.List[index+count].Decorations().Before = body.List[index].Decorations().Before
+	}
+	return dstutil.RemoveStatements(body, index, count)
+}
@@ -0,0 +1,61 @@
+package processor
+
+import "fmt"
+
+// The errors of ProcessResult.Errors, ProcessResult.VerifyErrors and
+// CoverageResult.Errors are of the following types, which may be told apart
+// with errors.As.
+
+// LoadError reports a package that failed to load or type-check.
+// The package is skipped.
+type LoadError struct {
+	Package string
+	Err     error
+}
+
+func (e *LoadError) Error() string { return fmt.Sprintf("package %s: %v", e.Package, e.Err) }
+
+func (e *LoadError) Unwrap() error { return e.Err }
+
+// ParseError reports a file that could not be converted for processing.
+// The file is skipped.
+type ParseError struct {
+	File string
+	Err  error
+}
+
+func (e *ParseError) Error() string { return fmt.Sprintf("%s: %v", e.File, e.Err) }
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// RenderError reports a function for which the template could not be rendered
+// or applied, e.g. because of a variable conflict. The file is left unmodified.
+type RenderError struct {
+	File string
+	Func string // As in FuncChange.Func, e.g. "(*Service).Get"; empty outside functions
+	Err  error
+}
+
+func (e *RenderError) Error() string {
+	msg := fmt.Sprintf("function %s: %v", e.Func, e.Err)
+	if e.Func == "" {
+		msg = e.Err.Error()
+	}
+	if e.File == "" {
+		return msg
+	}
+	return e.File + ": " + msg
+}
+
+func (e *RenderError) Unwrap() error { return e.Err }
+
+// WriteError reports a modified file that could not be formatted or written,
+// or whose patch could not be written in dry run mode.
+type WriteError struct {
+	File string
+	Err  error
+}
+
+func (e *WriteError) Error() string { return fmt.Sprintf("%s: %v", e.File, e.Err) }
+
+func (e *WriteError) Unwrap() error { return e.Err }
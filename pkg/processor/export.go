@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+// Instrumentation states of an exported function.
+const (
+	StateInstrumented = "instrumented" // Generated statements are up to date
+	StateOutdated     = "outdated"     // Generated statements need an update
+	StateMissing      = "missing"      // No generated statement
+	StateProtected    = "protected"    // Generated statements have a skip directive
+)
+
+// FuncExport describes an eligible function and its instrumentation state.
+type FuncExport struct {
+	Package  string `json:"package"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`               // Line of the func keyword
+	Func     string `json:"func"`               // Name as in stack traces, e.g. "Foo" or "(*Service).Get"
+	Receiver string `json:"receiver,omitempty"` // Receiver type as written, e.g. "*Service"
+	Carrier  string `json:"carrier"`            // Matched carrier type, e.g. "net/http.Request"
+	State    string `json:"state"`              // One of the State constants
+	// Baseline is set for functions of the baseline, which are left alone
+	// unless instrumented.
+	Baseline bool `json:"baseline,omitempty"`
+}
+
+// ExportResult contains the eligible functions, sorted by file and line.
+type ExportResult struct {
+	Funcs  []FuncExport
+	Errors []error
+}
+
+// Export lists the eligible functions of the given package patterns with their
+// instrumentation state, without modifying any file. As in Coverage, the
+// remove, dedupe, marker migration and rename options are ignored.
+func (p *Processor) Export(patterns []string) (*ExportResult, error) {
+	result := &ExportResult{}
+	errs, err := p.inspectEligible(patterns, func(string) {}, func(fn eligibleFunc) {
+		fe := FuncExport{
+			Package:  fn.pkgPath,
+			File:     fn.file,
+			Line:     fn.line,
+			Func:     funcName(fn.decl),
+			Carrier:  carrierName(fn.match.Carrier),
+			State:    exportState(fn.action),
+			Baseline: p.baseline.Contains(funcKey(fn.pkgPath, fn.decl)),
+		}
+		if fn.decl.Recv != nil && len(fn.decl.Recv.List) > 0 {
+			fe.Receiver = recvString(fn.decl.Recv.List[0].Type)
+		}
+		result.Funcs = append(result.Funcs, fe)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Errors = errs
+
+	slices.SortFunc(result.Funcs, func(a, b FuncExport) int {
+		return cmp.Or(cmp.Compare(a.File, b.File), cmp.Compare(a.Line, b.Line))
+	})
+	return result, nil
+}
+
+// carrierName returns the name of the carrier type, e.g. "net/http.Request",
+// or an empty string for a special function woven without a carrier.
+func carrierName(c config.CarrierDef) string {
+	if c.Type == "" {
+		return ""
+	}
+	return c.Package + "." + c.Type
+}
+
+// exportState returns the instrumentation state of a function that a regular
+// weave would apply action to.
+func exportState(action Action) string {
+	switch action.(type) {
+	case skipAction:
+		return StateInstrumented
+	case protectedAction:
+		return StateProtected
+	case insertAction:
+		return StateMissing
+	default:
+		return StateOutdated
+	}
+}
@@ -0,0 +1,78 @@
+package processor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestExport(t *testing.T) {
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go": `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func trace(context.Context, string) {}
+
+type Server struct{}
+
+func (s *Server) Instrumented(ctx context.Context) {
+	defer trace(ctx, "main.(*Server).Instrumented")
+}
+
+func Outdated(ctx context.Context) {
+	defer trace(ctx, "main.OldName")
+}
+
+func Protected(ctx context.Context) {
+	defer trace(ctx, "main.Other") //ctxweaver:skip
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+}
+
+func Legacy(ctx context.Context) {
+}
+
+func NoCarrier() {
+}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil, processor.WithBaseline(processor.NewBaseline("testmod.Legacy")))
+	result, err := proc.Export([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Export() errors = %v", result.Errors)
+	}
+
+	file, _ := filepath.EvalSymlinks(filepath.Join(tmpDir, "main.go"))
+	want := []processor.FuncExport{
+		{Package: "testmod", File: file, Line: 12, Func: "(*Server).Instrumented", Receiver: "*Server", Carrier: "context.Context", State: processor.StateInstrumented},
+		{Package: "testmod", File: file, Line: 16, Func: "Outdated", Carrier: "context.Context", State: processor.StateOutdated},
+		{Package: "testmod", File: file, Line: 20, Func: "Protected", Carrier: "context.Context", State: processor.StateProtected},
+		{Package: "testmod", File: file, Line: 24, Func: "Handler", Carrier: "net/http.Request", State: processor.StateMissing},
+		{Package: "testmod", File: file, Line: 27, Func: "Legacy", Carrier: "context.Context", State: processor.StateMissing, Baseline: true},
+	}
+	if diff := cmp.Diff(want, result.Funcs); diff != "" {
+		t.Errorf("Funcs mismatch (-want +got):\n%s", diff)
+	}
+}
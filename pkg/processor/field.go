@@ -0,0 +1,227 @@
+package processor
+
+import (
+	"fmt"
+	"go/types"
+	"slices"
+	"strings"
+
+	"github.com/dave/dst"
+
+	"github.com/mpyw/ctxweaver/internal/directive"
+	"github.com/mpyw/ctxweaver/internal/dstutil"
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+// FieldInit ensures that every composite literal of a struct type sets a
+// field, e.g. the tracer of services constructed by hand or by a DI container.
+type FieldInit struct {
+	// Type is the struct type, as "package/path.Type" or "name.Type".
+	Type string
+	// Field is the name of the field.
+	Field string
+	// Value renders the expression of the field. Inside a function, the
+	// template variables describe the function, except {{.Ctx}} and
+	// {{.CtxVar}} which are empty; outside, only the package variables are set.
+	Value *template.Template
+}
+
+// WithFieldInits adds fields ensured in the composite literals of struct
+// types, in every file processed. A missing field is added to keyed literals
+// (unkeyed literals are left alone), and a field whose value matches the
+// rendered one by skeleton is updated when outdated, or removed in remove
+// mode; other values are considered set by hand. As with mutators, field
+// initialization is skipped in the modes rewriting the template's statements
+// in place (renames, marker migration, drift detection), in dedupe mode, and
+// in the functions of the baseline outside remove mode.
+func WithFieldInits(inits ...FieldInit) Option {
+	return func(p *Processor) {
+		p.fieldInits = append(p.fieldInits, inits...)
+	}
+}
+
+// weaveFieldInits brings the composite literals of df in line with the field
+// initializations, and records the functions changed for them.
+func (p *Processor) weaveFieldInits(df *dst.File, pkgPath string, typeOf typeResolver, fr *fileResult) error {
+	if len(p.fieldInits) == 0 || len(p.renames) > 0 || p.migrateToMarker || p.detectDrift || p.dedupe {
+		return nil
+	}
+
+	off := directive.OffDecls(df)
+	for _, decl := range df.Decls {
+		if off[decl] {
+			continue
+		}
+		var fn *dst.FuncDecl
+		var vars template.Vars
+		switch d := decl.(type) {
+		case *dst.FuncDecl:
+			if shouldSkipDecl(d) || !p.remove && p.baseline.Contains(funcKey(pkgPath, d)) {
+				continue
+			}
+			fn = d
+			vars = template.BuildVars(df, d, pkgPath, config.CarrierDef{}, "")
+		case *dst.GenDecl:
+			if directive.HasSkipDirective(d.Decorations()) {
+				continue
+			}
+			vars = template.BuildFileVars(df, pkgPath)
+		}
+		vars.SetModule(p.modulePath)
+		vars.SetFile(p.filename)
+		// Under the selection of a single function, only its literals are processed
+		if p.selection != nil && !p.selection.wholeFile() && (fn == nil || fn != p.selectedFunc) {
+			continue
+		}
+
+		var reasons []string
+		var err error
+		dst.Inspect(decl, func(n dst.Node) bool {
+			lit, ok := n.(*dst.CompositeLit)
+			if !ok || err != nil {
+				return err == nil
+			}
+			litPkg, litType := literalType(lit, pkgPath, typeOf)
+			if litType == "" {
+				return true
+			}
+			for _, fi := range p.fieldInits {
+				if !(config.CarrierDef{Package: litPkg, Type: litType}).MatchesName(fi.Type) {
+					continue
+				}
+				var reason string
+				if reason, err = p.syncField(lit, fi, vars); err != nil {
+					return false
+				}
+				if reason != "" && !slices.Contains(reasons, reason) {
+					reasons = append(reasons, reason)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			re := &RenderError{Err: err}
+			if fn != nil {
+				re.Func = funcName(fn)
+			}
+			return re
+		}
+		if len(reasons) == 0 {
+			continue
+		}
+
+		fr.modified = true
+		if fn == nil {
+			continue
+		}
+		// A function changed by the template or a mutator is listed once
+		reason := strings.Join(reasons, ", ")
+		if i := slices.IndexFunc(fr.changed, func(ch changedFunc) bool { return ch.decl == fn }); i >= 0 {
+			fr.changed[i].reason += ", " + reason
+			continue
+		}
+		fr.changed = append(fr.changed, changedFunc{decl: fn, op: "field", reason: reason})
+	}
+	return nil
+}
+
+// syncField brings the field of a composite literal in line with its rendered
+// value. Returns the reason of the change, or an empty string if unchanged.
+func (p *Processor) syncField(lit *dst.CompositeLit, fi FieldInit, vars template.Vars) (string, error) {
+	index, keyed := fieldIndex(lit, fi.Field)
+	if !keyed {
+		return "", nil
+	}
+	rendered, err := fi.Value.Render(vars)
+	if err != nil {
+		return "", fmt.Errorf("field %s of %s: %w", fi.Field, fi.Type, err)
+	}
+	value, err := dstutil.ParseExpr(rendered)
+	if err != nil {
+		return "", fmt.Errorf("field %s of %s: invalid value %q: %w", fi.Field, fi.Type, rendered, err)
+	}
+
+	if index < 0 {
+		if p.remove {
+			return "", nil
+		}
+		kv := &dst.KeyValueExpr{Key: dst.NewIdent(fi.Field), Value: value}
+		// Multi-line literals get the field on a line of its own
+		if n := len(lit.Elts); n > 0 && lit.Elts[n-1].Decorations().After == dst.NewLine {
+			kv.Decs.Before = dst.NewLine
+			kv.Decs.After = dst.NewLine
+		}
+		lit.Elts = append(lit.Elts, kv)
+		return "missing " + fi.Field + " field", nil
+	}
+
+	kv := lit.Elts[index].(*dst.KeyValueExpr)
+	if !p.comparator.Compare(value, kv.Value, "root", false) {
+		// Set by hand
+		return "", nil
+	}
+	if p.remove {
+		if index > 0 && index == len(lit.Elts)-1 {
+			lit.Elts[index-1].Decorations().After = kv.Decs.After
+		}
+		lit.Elts = slices.Delete(lit.Elts, index, index+1)
+		return fi.Field + " field to remove", nil
+	}
+	if p.comparator.Compare(value, kv.Value, "root", true) {
+		return "", nil
+	}
+	value.Decorations().Start = kv.Value.Decorations().Start
+	value.Decorations().End = kv.Value.Decorations().End
+	kv.Value = value
+	return "outdated " + fi.Field + " field", nil
+}
+
+// fieldIndex returns the index of the element of lit setting field, or -1 if
+// there is none. keyed is false for a literal with unkeyed elements.
+func fieldIndex(lit *dst.CompositeLit, field string) (index int, keyed bool) {
+	for i, elt := range lit.Elts {
+		kv, ok := elt.(*dst.KeyValueExpr)
+		if !ok {
+			return -1, false
+		}
+		if key, ok := kv.Key.(*dst.Ident); ok && key.Name == field {
+			return i, true
+		}
+	}
+	return -1, true
+}
+
+// literalType returns the package path and the name of the named type of a
+// composite literal, or empty strings if unknown. The type of a literal whose
+// type is elided (e.g. the elements of a []*Server literal) is only known
+// with typeOf.
+func literalType(lit *dst.CompositeLit, pkgPath string, typeOf typeResolver) (string, string) {
+	typ := lit.Type
+	switch t := typ.(type) {
+	case *dst.IndexExpr:
+		typ = t.X
+	case *dst.IndexListExpr:
+		typ = t.X
+	}
+
+	switch t := typ.(type) {
+	case *dst.Ident:
+		if t.Path == "" {
+			return pkgPath, t.Name
+		}
+		return t.Path, t.Name
+	case nil:
+		if typeOf == nil {
+			return "", ""
+		}
+		resolved := typeOf(lit)
+		if ptr, ok := resolved.(*types.Pointer); ok {
+			resolved = ptr.Elem()
+		}
+		if named, ok := resolved.(*types.Named); ok && named.Obj().Pkg() != nil {
+			return named.Obj().Pkg().Path(), named.Obj().Name()
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,270 @@
+package processor_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithFieldInits(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+	tracer := processor.FieldInit{
+		Type:  "service.Server",
+		Field: "tracer",
+		Value: template.MustParse(`newTracer({{.FuncName | quote}})`),
+	}
+
+	tests := map[string]struct {
+		src    string
+		remove bool
+		want   string
+	}{
+		"missing field is added": {
+			src: `package service
+
+var defaultServer = &Server{}
+
+func NewServer(name string) *Server {
+	return &Server{name: name}
+}
+
+func NewServers() []*Server {
+	return []*Server{
+		{
+			name: "a",
+		},
+	}
+}
+`,
+			want: `package service
+
+var defaultServer = &Server{tracer: newTracer("")}
+
+func NewServer(name string) *Server {
+	return &Server{name: name, tracer: newTracer("service.NewServer")}
+}
+
+func NewServers() []*Server {
+	return []*Server{
+		{
+			name: "a",
+		},
+	}
+}
+`,
+		},
+		"multi-line literal gets the field on its own line": {
+			src: `package service
+
+func NewServer(name string) *Server {
+	return &Server{
+		name: name,
+	}
+}
+`,
+			want: `package service
+
+func NewServer(name string) *Server {
+	return &Server{
+		name:   name,
+		tracer: newTracer("service.NewServer"),
+	}
+}
+`,
+		},
+		"outdated field is updated": {
+			src: `package service
+
+func NewServer(name string) *Server {
+	return &Server{name: name, tracer: newTracer("service.OldServer")}
+}
+`,
+			want: `package service
+
+func NewServer(name string) *Server {
+	return &Server{name: name, tracer: newTracer("service.NewServer")}
+}
+`,
+		},
+		"field set by hand is left alone": {
+			src: `package service
+
+func NewServer(name string, t *Tracer) *Server {
+	return &Server{name: name, tracer: t}
+}
+`,
+			want: `package service
+
+func NewServer(name string, t *Tracer) *Server {
+	return &Server{name: name, tracer: t}
+}
+`,
+		},
+		"unkeyed literal and other types are left alone": {
+			src: `package service
+
+func NewServer(name string) *Server {
+	_ = &Client{name: name}
+	return &Server{name, nil}
+}
+`,
+			want: `package service
+
+func NewServer(name string) *Server {
+	_ = &Client{name: name}
+	return &Server{name, nil}
+}
+`,
+		},
+		"skipped function is left alone": {
+			src: `package service
+
+//ctxweaver:skip
+func NewServer(name string) *Server {
+	return &Server{name: name}
+}
+`,
+			want: `package service
+
+//ctxweaver:skip
+func NewServer(name string) *Server {
+	return &Server{name: name}
+}
+`,
+		},
+		"remove mode removes the field": {
+			src: `package service
+
+func NewServer(name string) *Server {
+	return &Server{
+		name:   name,
+		tracer: newTracer("service.NewServer"),
+	}
+}
+
+func NewDefault(t *Tracer) *Server {
+	return &Server{tracer: t}
+}
+`,
+			remove: true,
+			want: `package service
+
+func NewServer(name string) *Server {
+	return &Server{
+		name: name,
+	}
+}
+
+func NewDefault(t *Tracer) *Server {
+	return &Server{tracer: t}
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithFieldInits(tracer), processor.WithRemove(tt.remove))
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("TransformFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithFieldInits_InvalidValue(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	proc := processor.New(registry, tmpl, nil, processor.WithFieldInits(processor.FieldInit{
+		Type:  "service.Server",
+		Field: "tracer",
+		Value: template.MustParse(`newTracer(`),
+	}))
+
+	const src = `package service
+
+func NewServer() *Server {
+	return &Server{}
+}
+`
+	_, _, err := proc.TransformFile([]byte(src), processor.TransformOptions{PkgPath: "example.com/app/service"})
+	if err == nil || !strings.Contains(err.Error(), "function NewServer: field tracer of service.Server: invalid value") {
+		t.Fatalf("TransformFile() error = %v, want an invalid value error", err)
+	}
+}
+
+func TestWithFieldInits_Process(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"server.go": `package server
+
+type Tracer struct{ name string }
+
+type Server struct {
+	name   string
+	tracer *Tracer
+}
+
+func NewServers() []*Server {
+	return []*Server{
+		{name: "a"},
+		{name: "b"},
+	}
+}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil, processor.WithFieldInits(processor.FieldInit{
+		Type:  "testmod.Server",
+		Field: "tracer",
+		Value: template.MustParse(`&Tracer{name: {{.FuncName | quote}}}`),
+	}))
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Process errors: %v", result.Errors)
+	}
+
+	var ops []string
+	for _, fc := range result.ModifiedFuncs {
+		ops = append(ops, fc.Func+" "+fc.Op+": "+fc.Reason)
+	}
+	if diff := cmp.Diff([]string{"NewServers field: missing tracer field"}, ops); diff != "" {
+		t.Errorf("ModifiedFuncs mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err := os.ReadFile(filepath.Join(tmpDir, "server.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Elided literal types are resolved with type information
+	want := `	return []*Server{
+		{name: "a", tracer: &Tracer{name: "server.NewServers"}},
+		{name: "b", tracer: &Tracer{name: "server.NewServers"}},
+	}
+`
+	if !strings.Contains(string(got), want) {
+		t.Errorf("server.go = %s, want containing %s", got, want)
+	}
+}
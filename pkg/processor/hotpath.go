@@ -0,0 +1,154 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+// WithHotTemplate sets the lighter template replacing the template for the
+// functions matching the hot paths of the function filter (see
+// FuncFilter.HotPaths). The epilogue is not added to them.
+func WithHotTemplate(tmpl *template.Template) Option {
+	return func(p *Processor) {
+		p.hotTmpl = tmpl
+	}
+}
+
+// WithHotBuildTag weaves the functions on hot paths into variant files behind
+// the build tag, instead of in place with the other functions: foo.go is
+// modified in place apart from them, with a !tag term added to its build
+// constraint, and its copy woven with them too is written to
+// foo_instrumented.go with a tag term, so that only builds with -tags=tag pay
+// for the hot path instrumentation. Variants are regenerated on every run, and
+// deleted along with the !tag term when the file has no hot path to weave. An
+// empty tag weaves hot paths in place. It cannot be combined with
+// WithBuildTag, whose variants are named alike.
+func WithHotBuildTag(tag string) Option {
+	return func(p *Processor) {
+		p.hotBuildTag = tag
+	}
+}
+
+// isHotPath reports whether decl matches the hot paths of the function filter
+// and a hot template is set.
+func (p *Processor) isHotPath(decl *dst.FuncDecl) bool {
+	if p.hotTmpl == nil || p.funcFilter == nil {
+		return false
+	}
+	for _, re := range p.funcFilter.HotPaths {
+		if re.MatchString(decl.Name.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// withHotPaths gives the hot template to the candidates on hot paths, except
+// special functions, which have their own. With a hot build tag, the
+// candidates on hot paths are dropped unless weaving the variants.
+func (p *Processor) withHotPaths(candidates []funcCandidate) []funcCandidate {
+	kept := candidates[:0]
+	for _, c := range candidates {
+		if c.tmpl == nil && p.isHotPath(c.decl) {
+			if p.hotBuildTag != "" && !p.hotVariant {
+				continue
+			}
+			c.tmpl = p.hotTmpl
+		}
+		kept = append(kept, c)
+	}
+	return kept
+}
+
+// emitHotVariant weaves the hot paths of filename, decorated as df and woven
+// apart from them, and writes the result as the variant of the file behind
+// the hot build tag, the file getting the rest (see WithHotBuildTag).
+func (p *Processor) emitHotVariant(pkg *packages.Package, df *dst.File, res *decorator.Restorer, filename string, typeOf typeResolver, names importNames, fr fileResult) (fileResult, error) {
+	var base []byte
+	if fr.modified {
+		original, err := p.readFile(filename)
+		if err != nil {
+			return fileResult{}, &WriteError{File: filename, Err: fmt.Errorf("failed to read file: %w", err)}
+		}
+		// A restorer restores a node once, and df is restored again as the variant
+		result, err := p.restoreFile(dst.Clone(df).(*dst.File), res, filename)
+		if err != nil {
+			return fileResult{}, &WriteError{File: filename, Err: err}
+		}
+		base = restoreLineEndings(original, restoreLineDirectives(original, result))
+	}
+
+	// The other functions are up to date already, so only the hot paths change
+	q := *p
+	q.hotVariant = true
+	hot, err := q.processFunctions(df, pkg.PkgPath, typeOf, names)
+	if err != nil {
+		if re, ok := err.(*RenderError); ok {
+			re.File = filename
+		}
+		return fileResult{}, err
+	}
+	fr.modified = hot.modified
+	fr.duplicatesRemoved += hot.duplicatesRemoved
+	fr.changed = append(fr.changed, p.hotChanges(hot.changed)...)
+	fr.protected = append(fr.protected, p.hotChanges(hot.protected)...)
+	fr.vetoed = append(fr.vetoed, p.hotChanges(hot.vetoed)...)
+	fr.declined = append(fr.declined, p.hotChanges(hot.declined)...)
+	fr.unsampled = append(fr.unsampled, p.hotChanges(hot.unsampled)...)
+	fr.conflicting = append(fr.conflicting, p.hotChanges(hot.conflicting)...)
+	return p.emitVariant(pkg, df, res, filename, p.hotBuildTag, base, fr)
+}
+
+// hotChanges returns the changes of the functions on hot paths.
+func (p *Processor) hotChanges(changes []changedFunc) []changedFunc {
+	var hot []changedFunc
+	for _, c := range changes {
+		if p.isHotPath(c.decl) {
+			hot = append(hot, c)
+		}
+	}
+	return hot
+}
+
+// checkHotPathMove returns an error if the body of c, about to be woven,
+// holds the statements of the other template: those of the regular template
+// on a hot path, or of the hot template elsewhere, left behind by a change of
+// the hot paths, so that the function is not instrumented twice. In marker
+// mode, the marked statements of either template are updated to the other.
+func (p *Processor) checkHotPathMove(c funcCandidate, df *dst.File, pkgPath string) error {
+	if p.hotTmpl == nil || p.matching == config.MatchingMarker {
+		return nil
+	}
+	q := *p
+	var moved error
+	switch {
+	case p.regularTmpl != nil:
+		q.tmpl, q.regularTmpl = p.regularTmpl, nil
+		moved = errors.New("instrumented with template before joining functions.hot_paths: remove its statements first")
+	case c.tmpl == nil:
+		q.tmpl, q.epilogue = p.hotTmpl, nil
+		moved = errors.New("instrumented with hot_template before leaving functions.hot_paths: remove its statements first")
+	default:
+		return nil // Special functions have their own template
+	}
+	q.plugins = nil
+	rt, err := q.renderCandidate(funcCandidate{decl: c.decl, match: c.match}, df, pkgPath)
+	if err != nil || rt.stmt == "" {
+		return err
+	}
+	matches, _, err := q.matchTemplate(c.decl.Body, rt)
+	if err != nil {
+		return err
+	}
+	if len(matches) > 0 {
+		return moved
+	}
+	return nil
+}
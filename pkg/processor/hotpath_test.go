@@ -0,0 +1,367 @@
+package processor_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithHotTemplate(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"codec.go": `package codec
+
+import "context"
+
+func Decode(ctx context.Context, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+}
+
+func Handle(ctx context.Context) {
+	if ctx == nil {
+		return
+	}
+}
+
+func trace(context.Context, string) {}
+
+func sampled(context.Context) {}
+
+func done(context.Context) {}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil,
+		processor.WithFunctions(config.Functions{HotPaths: []string{"^Decode"}}),
+		processor.WithEpilogue(template.MustParse(`done({{.Ctx}})`)),
+		processor.WithHotTemplate(template.MustParse(`sampled({{.Ctx}})`)),
+	)
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Process errors: %v", result.Errors)
+	}
+
+	got, err := os.ReadFile(filepath.Join(tmpDir, "codec.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package codec
+
+import "context"
+
+func Decode(ctx context.Context, b []byte) {
+	sampled(ctx)
+
+	if len(b) == 0 {
+		return
+	}
+}
+
+func Handle(ctx context.Context) {
+	defer trace(ctx, "codec.Handle")
+
+	if ctx == nil {
+		done(ctx)
+		return
+	}
+	done(ctx)
+}
+
+func trace(context.Context, string) {}
+
+func sampled(context.Context) {}
+
+func done(context.Context) {}
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("codec.go mismatch (-want +got):\n%s", diff)
+	}
+
+	// Running again leaves the hot path alone
+	result, err = proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.FilesModified != 0 {
+		t.Errorf("FilesModified = %d on the second run, want 0", result.FilesModified)
+	}
+}
+
+func TestWithHotBuildTag(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	original := `package codec
+
+import "context"
+
+func Decode(ctx context.Context) {
+}
+
+func Handle(ctx context.Context) {
+}
+
+func trace(context.Context) {}
+
+func sampled(context.Context) {}
+`
+	tmpDir := setupTestModule(t, map[string]string{
+		"codec.go": original,
+		"server.go": `package codec
+
+import "context"
+
+func Serve(ctx context.Context) {
+}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	read := func(name string) string {
+		t.Helper()
+		b, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(b)
+	}
+	process := func(opts ...processor.Option) *processor.ProcessResult {
+		t.Helper()
+		opts = append([]processor.Option{
+			processor.WithFunctions(config.Functions{HotPaths: []string{"^Decode"}}),
+			processor.WithHotTemplate(template.MustParse(`sampled({{.Ctx}})`)),
+			processor.WithHotBuildTag("instrumentation"),
+		}, opts...)
+		result, err := processor.New(registry, tmpl, nil, opts...).Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if len(result.Errors) > 0 {
+			t.Fatalf("Process errors: %v", result.Errors)
+		}
+		return result
+	}
+
+	result := process()
+	want := map[string]string{
+		"codec.go": `//go:build !instrumentation
+
+package codec
+
+import "context"
+
+func Decode(ctx context.Context) {
+}
+
+func Handle(ctx context.Context) {
+	defer trace(ctx)
+
+}
+
+func trace(context.Context) {}
+
+func sampled(context.Context) {}
+`,
+		"codec_instrumented.go": `//go:build instrumentation
+
+package codec
+
+import "context"
+
+func Decode(ctx context.Context) {
+	sampled(ctx)
+
+}
+
+func Handle(ctx context.Context) {
+	defer trace(ctx)
+
+}
+
+func trace(context.Context) {}
+
+func sampled(context.Context) {}
+`,
+		"server.go": `package codec
+
+import "context"
+
+func Serve(ctx context.Context) {
+	defer trace(ctx)
+
+}
+`,
+		"server_instrumented.go": "<missing>",
+	}
+	for name, content := range want {
+		if diff := cmp.Diff(content, read(name)); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", name, diff)
+		}
+	}
+	var funcs []string
+	for _, f := range result.ModifiedFuncs {
+		funcs = append(funcs, f.Func)
+	}
+	if diff := cmp.Diff([]string{"Decode", "Handle", "Serve"}, funcs, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("ModifiedFuncs mismatch (-want +got):\n%s", diff)
+	}
+
+	// Running again leaves the files alone
+	if result := process(); result.FilesModified != 0 {
+		t.Errorf("FilesModified = %d on the second run, want 0", result.FilesModified)
+	}
+
+	// Removing deletes the variant and the !tag term
+	process(processor.WithRemove(true))
+	if got := read("codec_instrumented.go"); got != "<missing>" {
+		t.Errorf("codec_instrumented.go still exists after removal")
+	}
+	removed := `package codec
+
+import "context"
+
+func Decode(ctx context.Context) {
+}
+
+func Handle(ctx context.Context) {}
+
+func trace(context.Context) {}
+
+func sampled(context.Context) {}
+`
+	if diff := cmp.Diff(removed, read("codec.go")); diff != "" {
+		t.Errorf("codec.go mismatch after removal (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithHotTemplate_Moved(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tests := map[string]struct {
+		matching config.MatchingMode
+		source   string
+		want     string // Empty if an error is reported and the file left alone
+		wantErr  string
+	}{
+		"into hot paths": {
+			source: `package codec
+
+import "context"
+
+func Decode(ctx context.Context) {
+	defer trace(ctx)
+}
+`,
+			wantErr: "instrumented with template before joining functions.hot_paths",
+		},
+		"out of hot paths": {
+			source: `package codec
+
+import "context"
+
+func Handle(ctx context.Context) {
+	sampled(ctx)
+}
+`,
+			wantErr: "instrumented with hot_template before leaving functions.hot_paths",
+		},
+		"into hot paths with markers": {
+			matching: config.MatchingMarker,
+			source: `package codec
+
+import "context"
+
+func Decode(ctx context.Context) {
+	defer trace(ctx) //ctxweaver:generated
+}
+`,
+			want: `package codec
+
+import "context"
+
+func Decode(ctx context.Context) {
+	sampled(ctx) //ctxweaver:generated sha=906c924f n=1
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := setupTestModule(t, map[string]string{
+				"codec.go": tt.source,
+				"helpers.go": `package codec
+
+import "context"
+
+func trace(context.Context) {}
+
+func sampled(context.Context) {}
+`,
+			})
+
+			oldWd, _ := os.Getwd()
+			_ = os.Chdir(tmpDir)
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			opts := []processor.Option{
+				processor.WithFunctions(config.Functions{HotPaths: []string{"^Decode"}}),
+				processor.WithHotTemplate(template.MustParse(`sampled({{.Ctx}})`)),
+			}
+			if tt.matching != "" {
+				opts = append(opts, processor.WithMatching(tt.matching))
+			}
+			result, err := processor.New(registry, tmpl, nil, opts...).Process([]string{"./..."})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+
+			var errs []string
+			for _, e := range result.Errors {
+				errs = append(errs, e.Error())
+			}
+			if tt.wantErr != "" {
+				if len(errs) != 1 || !strings.Contains(errs[0], tt.wantErr) {
+					t.Errorf("Errors = %q, want one containing %q", errs, tt.wantErr)
+				}
+			} else if len(errs) > 0 {
+				t.Fatalf("Process errors: %v", errs)
+			}
+
+			got, err := os.ReadFile(filepath.Join(tmpDir, "codec.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == "" {
+				want = tt.source
+			}
+			if diff := cmp.Diff(want, string(got)); diff != "" {
+				t.Errorf("codec.go mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
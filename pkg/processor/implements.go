@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"fmt"
+	"go/types"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/dave/dst"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/mpyw/ctxweaver/internal"
+)
+
+// withInterfaces returns a copy of p with the interfaces of the function
+// filter's Implements resolved from the imports of pkg.
+// Returns p itself if the filter has none.
+func (p *Processor) withInterfaces(pkg *packages.Package) *Processor {
+	if p.funcFilter == nil || len(p.funcFilter.Implements) == 0 {
+		return p
+	}
+	q := *p
+	q.interfaces = make([]*types.Interface, 0, len(p.funcFilter.Implements))
+	for _, name := range p.funcFilter.Implements {
+		if iface := lookupInterface(pkg.Types, name); iface != nil {
+			q.interfaces = append(q.interfaces, iface)
+		}
+	}
+	return &q
+}
+
+// warnUnresolvedInterfaces warns about the interfaces of function filters
+// (the base filter and those of overrides) found in none of pkgs.
+func (p *Processor) warnUnresolvedInterfaces(pkgs []*packages.Package) {
+	warnInterfacesNotFound(unresolvedInterfaces(pkgs, p.implementsNames()))
+}
+
+// implementsNames returns the interfaces of function filters (the base filter
+// and those of overrides), in order.
+func (p *Processor) implementsNames() []string {
+	filters := []*FuncFilter{p.funcFilter}
+	for _, o := range p.overrides {
+		filters = append(filters, o.Functions)
+	}
+	var names []string
+	for _, f := range filters {
+		if f != nil {
+			names = append(names, f.Implements...)
+		}
+	}
+	return names
+}
+
+// unresolvedInterfaces returns the names of interfaces found in none of pkgs
+// or their imports.
+func unresolvedInterfaces(pkgs []*packages.Package, names []string) []string {
+	return slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+		return slices.ContainsFunc(pkgs, func(pkg *packages.Package) bool {
+			return lookupInterface(pkg.Types, name) != nil
+		})
+	})
+}
+
+// warnInterfacesNotFound warns about interfaces found in no loaded package.
+func warnInterfacesNotFound(names []string) {
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "%swarning:%s interface %q not found in the loaded packages or their imports\n",
+			internal.StderrColor(internal.ColorYellow),
+			internal.StderrColor(internal.ColorReset),
+			name)
+	}
+}
+
+// lookupInterface finds the interface named "name.Type" or "package/path.Type"
+// in root or the packages it imports, directly or indirectly.
+// Returns nil if not found.
+func lookupInterface(root *types.Package, name string) *types.Interface {
+	i := strings.LastIndex(name, ".")
+	if root == nil || i < 0 {
+		return nil
+	}
+	pkgName, typeName := name[:i], name[i+1:]
+
+	visited := make(map[*types.Package]bool)
+	stack := []*types.Package{root}
+	for len(stack) > 0 {
+		pkg := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[pkg] {
+			continue
+		}
+		visited[pkg] = true
+
+		if pkg.Path() == pkgName || pkg.Name() == pkgName {
+			if tn, ok := pkg.Scope().Lookup(typeName).(*types.TypeName); ok {
+				if iface, ok := tn.Type().Underlying().(*types.Interface); ok {
+					return iface
+				}
+			}
+		}
+		stack = append(stack, pkg.Imports()...)
+	}
+	return nil
+}
+
+// implementsAny reports whether decl is a method of one of the interfaces,
+// implemented by its receiver type (or a pointer to it).
+func implementsAny(decl *dst.FuncDecl, typeOf typeResolver, interfaces []*types.Interface) bool {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 || typeOf == nil {
+		return false
+	}
+	recv := typeOf(decl.Recv.List[0].Type)
+	if recv == nil {
+		return false
+	}
+	for _, iface := range interfaces {
+		if !hasMethod(iface, decl.Name.Name) {
+			continue
+		}
+		if types.Implements(recv, iface) {
+			return true
+		}
+		if _, ok := recv.(*types.Pointer); !ok && types.Implements(types.NewPointer(recv), iface) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMethod reports whether the interface has a method with the name.
+func hasMethod(iface *types.Interface, name string) bool {
+	for m := range iface.Methods() {
+		if m.Name() == name {
+			return true
+		}
+	}
+	return false
+}
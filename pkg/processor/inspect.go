@@ -2,11 +2,13 @@ package processor
 
 import (
 	"fmt"
+	"go/types"
 
 	"github.com/dave/dst"
 
 	"github.com/mpyw/ctxweaver/internal/directive"
 	"github.com/mpyw/ctxweaver/pkg/carrier"
+	"github.com/mpyw/ctxweaver/pkg/config"
 	"github.com/mpyw/ctxweaver/pkg/template"
 )
 
@@ -15,7 +17,8 @@ import (
 // the need to re-validate in subsequent processing steps.
 type funcCandidate struct {
 	decl  *dst.FuncDecl
-	match *carrier.MatchResult
+	match *carrier.MatchResult // Carrier; empty for a special function without one
+	tmpl  *template.Template   // Template of a special function; nil for the processor's
 }
 
 func extractFirstParam(decl *dst.FuncDecl) *dst.Field {
@@ -48,24 +51,90 @@ func shouldSkipDecl(decl *dst.FuncDecl) bool {
 }
 
 // matchesFuncFilter checks if a function matches the configured filter.
-func (p *Processor) matchesFuncFilter(decl *dst.FuncDecl) bool {
+// Interfaces of the filter are only checked if resolved for the package.
+func (p *Processor) matchesFuncFilter(decl *dst.FuncDecl, pkgPath string, typeOf typeResolver) bool {
 	if p.funcFilter == nil {
 		return true
 	}
 	isMethod := decl.Recv != nil && len(decl.Recv.List) > 0
 	isExported := isExportedFunc(decl.Name.Name)
-	return p.funcFilter.Match(decl.Name.Name, isMethod, isExported)
+	if !p.funcFilter.Match(decl.Name.Name, isMethod, isExported) {
+		return false
+	}
+	if isMethod && !p.funcFilter.Receivers.Match(recvString(decl.Recv.List[0].Type)) {
+		return false
+	}
+	if sig := p.funcFilter.Signatures; (len(sig.Only) > 0 || len(sig.Omit) > 0) && !sig.Match(signatureString(decl.Type)) {
+		return false
+	}
+	if p.funcFilter.MinStatements > 0 && countStmts(decl.Body) < p.funcFilter.MinStatements {
+		return false
+	}
+	if p.interfaces != nil && !implementsAny(decl, typeOf, p.interfaces) {
+		return false
+	}
+	return p.funcFilter.reachable == nil || p.funcFilter.reachable[funcKey(pkgPath, decl)]
+}
+
+// countStmts counts the statements in a function body, including nested ones
+// but not the blocks containing them.
+func countStmts(body *dst.BlockStmt) int {
+	n := 0
+	dst.Inspect(body, func(node dst.Node) bool {
+		if _, ok := node.(dst.Stmt); ok {
+			if _, block := node.(*dst.BlockStmt); !block {
+				n++
+			}
+		}
+		return true
+	})
+	return n
 }
 
+// typeResolver returns the type of a DST expression, or nil if unknown.
+type typeResolver func(dst.Expr) types.Type
+
 // tryMatchCarrier attempts to match the first parameter against registered carriers.
+// If typeOf is non-nil, carriers embedded in the parameter type are matched too.
+// If the first parameter does not match, the parameters are matched against the
+// registered function shapes, and then the first later parameter matching a
+// carrier allowed at any position (CarrierDef.AnyParam) is used.
+// With a carrier priority, every parameter is considered and the carrier ranked
+// highest wins; parameters whose carrier is not listed rank last, and ties go to
+// the earlier parameter.
 // Returns nil if no match is found.
-func (p *Processor) tryMatchCarrier(decl *dst.FuncDecl) *funcCandidate {
+func (p *Processor) tryMatchCarrier(decl *dst.FuncDecl, typeOf typeResolver) *funcCandidate {
 	param := extractFirstParam(decl)
 	if param == nil {
 		return nil
 	}
 
-	result := carrier.Match(param, p.registry)
+	var result *carrier.MatchResult
+	if len(p.carrierPriority) == 0 {
+		result = p.matchParam(param, typeOf)
+		if result == nil {
+			result = carrier.MatchShape(decl.Type.Params, p.registry)
+		}
+		for _, field := range decl.Type.Params.List[1:] {
+			if result != nil {
+				break
+			}
+			if r := p.matchParam(field, typeOf); r != nil && r.Carrier.AnyParam {
+				result = r
+			}
+		}
+	} else {
+		best := len(p.carrierPriority) + 1
+		for _, field := range decl.Type.Params.List {
+			r := p.matchParam(field, typeOf)
+			if r == nil {
+				continue
+			}
+			if rank := p.carrierRank(r.Carrier); rank < best {
+				result, best = r, rank
+			}
+		}
+	}
 	if result == nil {
 		return nil
 	}
@@ -76,10 +145,31 @@ func (p *Processor) tryMatchCarrier(decl *dst.FuncDecl) *funcCandidate {
 	}
 }
 
+// matchParam matches a single parameter against registered carriers.
+func (p *Processor) matchParam(param *dst.Field, typeOf typeResolver) *carrier.MatchResult {
+	result := carrier.Match(param, p.registry)
+	if result == nil && typeOf != nil && len(param.Names) > 0 {
+		result = carrier.MatchEmbedded(param.Names[0].Name, typeOf(param.Type), p.registry)
+	}
+	return result
+}
+
+// carrierRank returns the position of the carrier in the priority list,
+// or the length of the list if it is not listed.
+func (p *Processor) carrierRank(def config.CarrierDef) int {
+	for i, name := range p.carrierPriority {
+		if def.MatchesName(name) {
+			return i
+		}
+	}
+	return len(p.carrierPriority)
+}
+
 // collectCandidates traverses the DST file and collects all function candidates
 // that have a context carrier and pass the configured filters.
-func (p *Processor) collectCandidates(df *dst.File) []funcCandidate {
+func (p *Processor) collectCandidates(df *dst.File, pkgPath string, typeOf typeResolver) []funcCandidate {
 	var candidates []funcCandidate
+	off := directive.OffDecls(df)
 
 	dst.Inspect(df, func(n dst.Node) bool {
 		decl, ok := n.(*dst.FuncDecl)
@@ -87,55 +177,315 @@ func (p *Processor) collectCandidates(df *dst.File) []funcCandidate {
 			return true
 		}
 
-		if shouldSkipDecl(decl) {
+		if shouldSkipDecl(decl) || off[decl] || !p.isSelected(decl) {
+			return true
+		}
+
+		if sf, ok := p.specialFunc(df, decl); ok && (sf.Exclude || sf.Template != nil) {
+			if !sf.Exclude {
+				candidates = append(candidates, p.specialCandidate(decl, sf, typeOf))
+			}
 			return true
 		}
 
-		if !p.matchesFuncFilter(decl) {
+		if !p.matchesFuncFilter(decl, pkgPath, typeOf) {
 			return true
 		}
 
-		if c := p.tryMatchCarrier(decl); c != nil {
+		if c := p.tryMatchCarrier(decl, typeOf); c != nil {
 			candidates = append(candidates, *c)
 		}
 
 		return true
 	})
 
-	return candidates
+	if p.skipsDelegates() {
+		candidates = p.dropDelegates(candidates, pkgPath)
+	}
+	return p.withHotPaths(candidates)
+}
+
+// fileResult summarizes the changes made to a single file.
+type fileResult struct {
+	modified          bool
+	duplicatesRemoved int    // Duplicate statement groups removed in dedupe mode
+	generated         bool   // Some function contains generated statements after processing; only checked with a banner
+	original          []byte // Content before writing; only recorded in verify mode
+	patch             string // Patch file written in dry run mode with a patch directory
+	content           []byte // Content after processing; only recorded in dry run mode when kept
+	diff              []byte // Unified diff of the modification; only recorded in dry run mode when kept
+	selected          bool   // The file declares the selected function
+	dirty             bool   // The file had uncommitted changes; only with RequireCleanWarn
+	changed           []changedFunc
+	protected         []changedFunc // Functions whose generated statements have a skip directive
+	vetoed            []changedFunc // Functions left alone because of a plugin veto
+	declined          []changedFunc // Functions whose modification was declined; only with WithConfirm
+	unsampled         []changedFunc // Functions left out of the sample; only with WithSample
+	conflicting       []changedFunc // Functions left alone because of a call matching a conflict pattern
+	creators          []changedFunc // Functions without carrier creating their own context; only with WithContextCreators
+	paramSkipped      []changedFunc // Functions left without a ctx parameter; only in add-param mode
+	addedImports      []string      // Import paths the modification adds to the file
 }
 
 // processCandidate processes a single function candidate:
 // renders the template, detects the required action, and applies it.
-func (p *Processor) processCandidate(c funcCandidate, df *dst.File, pkgPath string) (bool, error) {
+func (p *Processor) processCandidate(c funcCandidate, df *dst.File, pkgPath string, scope fileScope, fr *fileResult) error {
+	rt, err := p.renderCandidate(c, df, pkgPath)
+	if err != nil {
+		return err
+	}
+	if rt.stmt == "" {
+		return nil // Nothing to weave, e.g. a layer template for another package
+	}
+	if rt.veto != "" {
+		fr.vetoed = append(fr.vetoed, changedFunc{decl: c.decl, reason: rt.veto})
+		return nil
+	}
+
+	action, err := p.detectCandidateAction(c, rt)
+	if err != nil {
+		return err
+	}
+
+	if d, ok := action.(dedupeAction); ok {
+		fr.duplicatesRemoved += len(d.duplicates)
+	}
+	if _, ok := action.(protectedAction); ok {
+		fr.protected = append(fr.protected, changedFunc{decl: c.decl, reason: "protected by //ctxweaver:skip"})
+	}
+	if _, ok := action.(insertAction); ok {
+		// Leave functions of the baseline alone
+		if p.baseline.Contains(funcKey(pkgPath, c.decl)) {
+			return nil
+		}
+		// Leave functions out of the sample alone
+		if !p.sampled(funcKey(pkgPath, c.decl)) {
+			fr.unsampled = append(fr.unsampled, changedFunc{decl: c.decl, reason: "not sampled"})
+			return nil
+		}
+		// Leave manually instrumented functions alone
+		if call := p.conflictingCall(c.decl.Body); call != "" {
+			fr.conflicting = append(fr.conflicting, changedFunc{decl: c.decl, reason: "calls " + call})
+			return nil
+		}
+		if err := checkConflicts(c.decl, rt.stmt, c.match.VarName); err != nil {
+			return err
+		}
+		if err := p.checkHotPathMove(c, df, pkgPath); err != nil {
+			return err
+		}
+	}
+	switch action.(type) {
+	case insertAction, updateAction:
+		if err := p.checkImportCollisions(c.decl, rt.stmt, scope); err != nil {
+			return err
+		}
+	}
+
+	// The body is restored if the modification is declined
+	var original *dst.BlockStmt
+	if p.confirm != nil {
+		original = dst.Clone(c.decl.Body).(*dst.BlockStmt)
+	}
+
+	// In remove mode, references must be reverted while the statements declaring
+	// the variable are still in place
+	var modified bool
+	if p.ctxRewrite != "" && p.remove {
+		if modified, err = p.rewriteCtxRefs(c.decl.Body, rt, action); err != nil {
+			return err
+		}
+	}
+	if action.Apply(c.decl.Body, rt.stmt) {
+		modified = true
+	}
+	if u, ok := action.(updateAction); ok && len(p.renames) > 0 {
+		if p.renameRefs(c.decl.Body, u.index+u.count) {
+			modified = true
+		}
+	}
+	if p.ctxRewrite != "" && !p.remove {
+		rewritten, err := p.rewriteCtxRefs(c.decl.Body, rt, action)
+		if err != nil {
+			return err
+		}
+		modified = modified || rewritten
+	}
+	if p.syncsEpilogue(action) {
+		synced, err := p.syncEpilogue(c.decl, rt)
+		if err != nil {
+			return fmt.Errorf("epilogue: %w", err)
+		}
+		// Epilogues may be left behind by statements removed by hand
+		if synced && !modified && p.remove {
+			action = removeAction{}
+		}
+		modified = modified || synced
+	}
+	if modified && p.confirm != nil && !p.confirm(p.confirmation(c.decl, pkgPath, changeOp(action), rt.stmt)) {
+		c.decl.Body = original
+		fr.declined = append(fr.declined, changedFunc{decl: c.decl, reason: "declined"})
+		return nil
+	}
+	if modified {
+		fr.modified = true
+		fr.changed = append(fr.changed, changedFunc{decl: c.decl, op: changeOp(action), reason: changeReason(action)})
+	}
+	if p.banner && !fr.generated {
+		if fr.generated, err = p.hasGenerated(c.decl.Body, rt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changedFunc is a function modified by processCandidate.
+type changedFunc struct {
+	decl   *dst.FuncDecl
+	op     string // See FuncChange.Op
+	reason string // See FuncChange.Reason
+}
+
+// changeOp names the operation of the action on a function.
+func changeOp(action Action) string {
+	switch action.(type) {
+	case insertAction:
+		return "insert"
+	case removeAction:
+		return "remove"
+	case dedupeAction:
+		return "dedupe"
+	case driftAction:
+		return "drift"
+	default:
+		return "update"
+	}
+}
+
+// changeReason describes why a function is modified by the action.
+func changeReason(action Action) string {
+	switch a := action.(type) {
+	case insertAction:
+		return "missing instrumentation"
+	case removeAction:
+		return "instrumentation to remove"
+	case dedupeAction:
+		return "duplicate instrumentation"
+	case driftAction:
+		if a.hash == "" {
+			return "generated from a template of unknown version"
+		}
+		return fmt.Sprintf("generated from an outdated template (sha=%s)", a.hash)
+	default:
+		return "outdated instrumentation"
+	}
+}
+
+// renderCandidate renders the template for a function candidate, along with
+// the placeholder pattern when the matching or refresh mode needs it.
+// In marker mode, the generated marker is appended to the rendered statements.
+// A template rendering nothing for the function, which is then left alone,
+// gives an empty statement and nothing else.
+func (p *Processor) renderCandidate(c funcCandidate, df *dst.File, pkgPath string) (renderedTemplate, error) {
 	vars := template.BuildVars(df, c.decl, pkgPath, c.match.Carrier, c.match.VarName)
+	vars.SetModule(p.modulePath)
+	vars.SetFile(p.filename)
+	if p.tmpl.UsesUniqueVar() || p.epilogue != nil && p.epilogue.UsesUniqueVar() {
+		if err := p.setDeclaredNames(&vars, c.decl); err != nil {
+			return renderedTemplate{}, err
+		}
+	}
+	if p.names != nil && (p.tmpl.UsesUnique() || p.epilogue != nil && p.epilogue.UsesUnique()) {
+		vars.SetNames(p.names, funcKey(pkgPath, c.decl))
+	}
 
 	rendered, err := p.tmpl.Render(vars)
 	if err != nil {
-		return false, fmt.Errorf("function %s: %w", c.decl.Name.Name, err)
+		return renderedTemplate{}, err
+	}
+	rt := renderedTemplate{stmt: rendered, ctx: vars.Ctx}
+	if rendered == "" {
+		return rt, nil
 	}
 
-	action, err := p.detectAction(c.decl.Body, rendered)
-	if err != nil {
-		return false, fmt.Errorf("function %s: %w", c.decl.Name.Name, err)
+	if p.matching == config.MatchingPlaceholder || p.refresh == config.RefreshVars {
+		rt.pattern, err = p.tmpl.RenderPlaceholders(vars)
+		if err != nil {
+			return renderedTemplate{}, err
+		}
+		rt.bindings = template.PlaceholderBindings(vars)
+	}
+
+	if len(p.plugins) > 0 {
+		if rt.veto, err = p.reviewStatements(c, vars, &rt); err != nil || rt.veto != "" {
+			return rt, err
+		}
+	}
+
+	if p.migrateToMarker || p.detectDrift || p.matching == config.MatchingMarker {
+		if rt.stmt, err = appendGeneratedMarker(rt.stmt, p.marker, p.tmpl.Hash(), p.runID); err != nil {
+			return renderedTemplate{}, err
+		}
 	}
 
-	return action.Apply(c.decl.Body, rendered), nil
+	if p.epilogue != nil {
+		if rt.epilogue.stmt, err = p.epilogue.Render(vars); err != nil {
+			return renderedTemplate{}, fmt.Errorf("epilogue: %w", err)
+		}
+		if rt.pattern != "" {
+			if rt.epilogue.pattern, err = p.epilogue.RenderPlaceholders(vars); err != nil {
+				return renderedTemplate{}, fmt.Errorf("epilogue: %w", err)
+			}
+		}
+	}
+	return rt, nil
+}
+
+// detectCandidateAction determines the action for a function candidate
+// according to the processor's mode.
+func (p *Processor) detectCandidateAction(c funcCandidate, rt renderedTemplate) (Action, error) {
+	switch {
+	case len(p.renames) > 0:
+		return p.detectRename(c.decl, rt)
+	case p.migrateToMarker:
+		return p.detectMigration(c.decl.Body, rt)
+	case p.detectDrift:
+		return p.detectDriftAction(c.decl.Body, rt)
+	case p.matching == config.MatchingMarker:
+		return p.detectMarkedAction(c.decl.Body, rt)
+	default:
+		return p.detectAction(c.decl.Body, rt)
+	}
 }
 
 // processFunctions processes functions in the DST file.
 // Relies on dst.Ident.Path set by NewDecoratorFromPackage for import resolution.
-func (p *Processor) processFunctions(df *dst.File, pkgPath string) (bool, error) {
-	candidates := p.collectCandidates(df)
+// typeOf may be nil when type information is unavailable. names resolves the
+// package names of import paths.
+func (p *Processor) processFunctions(df *dst.File, pkgPath string, typeOf typeResolver, names importNames) (fileResult, error) {
+	candidates := p.collectCandidates(df, pkgPath, typeOf)
+	scope := p.newFileScope(df, names)
 
-	var modified bool
+	fr := fileResult{creators: p.contextCreators(df, pkgPath, typeOf)}
 	for _, c := range candidates {
-		m, err := p.processCandidate(c, df, pkgPath)
-		if err != nil {
-			return false, err
+		if err := p.forCandidate(c).processCandidate(c, df, pkgPath, scope, &fr); err != nil {
+			return fileResult{}, &RenderError{Func: funcName(c.decl), Err: err}
+		}
+		if err := p.applyMutators(c, df, pkgPath, &fr); err != nil {
+			return fileResult{}, &RenderError{Func: funcName(c.decl), Err: err}
+		}
+	}
+	if err := p.weaveFieldInits(df, pkgPath, typeOf, &fr); err != nil {
+		return fileResult{}, err
+	}
+
+	if p.banner {
+		if fr.generated {
+			fr.modified = directive.AddBanner(df) || fr.modified
+		} else {
+			fr.modified = directive.RemoveBanner(df) || fr.modified
 		}
-		modified = modified || m
 	}
 
-	return modified, nil
+	return fr, nil
 }
@@ -0,0 +1,32 @@
+package processor
+
+import (
+	"bytes"
+	"strings"
+)
+
+// restoreLineDirectives moves the //line directives of original back to the
+// first column of formatted. A //line directive is only honored at the start
+// of a line, but the restored file indents it like the statement it precedes.
+func restoreLineDirectives(original, formatted []byte) []byte {
+	directives := make(map[string]bool)
+	for line := range bytes.Lines(original) {
+		line = bytes.TrimRight(line, "\r\n")
+		if bytes.HasPrefix(line, []byte("//line ")) {
+			directives[string(line)] = true
+		}
+	}
+	if len(directives) == 0 {
+		return formatted
+	}
+
+	var buf bytes.Buffer
+	for line := range bytes.Lines(formatted) {
+		trimmed := bytes.TrimLeft(line, " \t")
+		if len(trimmed) < len(line) && directives[strings.TrimRight(string(trimmed), "\r\n")] {
+			line = trimmed
+		}
+		buf.Write(line)
+	}
+	return buf.Bytes()
+}
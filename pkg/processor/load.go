@@ -0,0 +1,99 @@
+package processor
+
+import (
+	"strings"
+
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver/goast"
+	"golang.org/x/tools/go/packages"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+// needsTypes reports whether packages are loaded with type information:
+// always unless in syntax load mode, where only the features relying on it
+// (interface and reachability filters, embedded carriers) need it.
+func (p *Processor) needsTypes() bool {
+	if p.load != config.LoadSyntax || p.addParam {
+		return true
+	}
+	filters := []*FuncFilter{p.funcFilter}
+	for _, o := range p.overrides {
+		filters = append(filters, o.Functions)
+	}
+	for _, f := range filters {
+		if f != nil && (len(f.Implements) > 0 || len(f.ReachableFrom) > 0) {
+			return true
+		}
+	}
+	for _, c := range p.registry.All() {
+		if c.Embedded {
+			return true
+		}
+	}
+	return false
+}
+
+// loadsTests reports whether test files are loaded: if enabled, or if the
+// base filter or that of an override processes test files only.
+func (p *Processor) loadsTests() bool {
+	if p.test {
+		return true
+	}
+	filters := []*FuncFilter{p.funcFilter}
+	for _, o := range p.overrides {
+		filters = append(filters, o.Functions)
+	}
+	for _, f := range filters {
+		if f != nil && f.TestsOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// packagesLoadMode returns the information requested from packages.Load.
+func (p *Processor) packagesLoadMode() packages.LoadMode {
+	mode := packages.NeedName | packages.NeedFiles | packages.NeedSyntax | packages.NeedModule
+	if p.needsTypes() {
+		mode |= packages.NeedTypes | packages.NeedTypesInfo | packages.NeedImports
+	}
+	return mode
+}
+
+// newDecorator returns a decorator for the files of pkg. Without type
+// information, qualified identifiers are resolved from the import declarations
+// of each file, like TransformFile does.
+func newDecorator(pkg *packages.Package) *decorator.Decorator {
+	if pkg.TypesInfo != nil {
+		return decorator.NewDecoratorFromPackage(pkg)
+	}
+	return decorator.NewDecoratorWithImports(pkg.Fset, pkg.PkgPath, goast.WithResolver(importNames(nil)))
+}
+
+// importNames is a resolver of package names by import path. Names of unknown
+// paths are guessed (see guessPackageName).
+type importNames map[string]string
+
+// ResolvePackage implements resolver.RestorerResolver.
+func (m importNames) ResolvePackage(path string) (string, error) {
+	if name, ok := m[path]; ok && name != "" {
+		return name, nil
+	}
+	return guessPackageName(path), nil
+}
+
+// guessPackageName guesses the name of the package at path by the usual
+// conventions: the last element of the path, ignoring a major version suffix
+// ("v4", or ".v3" for gopkg.in), a "go-" prefix and a "-go" or ".go" suffix
+// (e.g. "echo" for github.com/labstack/echo/v4, "yaml" for gopkg.in/yaml.v3).
+func guessPackageName(path string) string {
+	name := qualifierName(path)
+	if i := strings.LastIndex(name, "."); i > 0 && isMajorVersion(name[i+1:]) {
+		name = name[:i]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	name = strings.TrimSuffix(name, "-go")
+	name = strings.TrimSuffix(name, ".go")
+	return name
+}
@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"path"
+
+	"github.com/dave/dst"
+)
+
+// WithConflicts sets patterns of calls revealing manual instrumentation,
+// such as "*.Start" or "newrelic.FromContext": the template is not inserted
+// into a function calling any of them anywhere in its body, so that
+// hand-instrumented functions do not get a second span. Patterns are matched
+// with path.Match against the callee as written ("tracer.Start",
+// "s.tracer.Start"), or as "package/path.Func" and "name.Func" for functions
+// of imported packages. Statements already generated are updated and removed
+// as usual.
+func WithConflicts(patterns []string) Option {
+	return func(p *Processor) {
+		p.conflicts = patterns
+	}
+}
+
+// conflictingCall returns the first call of body matching a conflict pattern,
+// as written, or an empty string if there is none.
+func (p *Processor) conflictingCall(body *dst.BlockStmt) string {
+	if len(p.conflicts) == 0 {
+		return ""
+	}
+	var found string
+	dst.Inspect(body, func(n dst.Node) bool {
+		call, ok := n.(*dst.CallExpr)
+		if !ok || found != "" {
+			return found == ""
+		}
+		for _, name := range calleeNames(call.Fun) {
+			for _, pattern := range p.conflicts {
+				if ok, _ := path.Match(pattern, name); ok {
+					found = name
+					return false
+				}
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// calleeNames returns the names a callee is matched by: the selector chain as
+// written, and for a function of an imported package, its qualified names by
+// import path and by the last element of the path. Returns nil for other
+// callees, such as function literals.
+func calleeNames(fun dst.Expr) []string {
+	switch f := fun.(type) {
+	case *dst.Ident:
+		if f.Path == "" {
+			return []string{f.Name}
+		}
+		return []string{guessPackageName(f.Path) + "." + f.Name, f.Path + "." + f.Name}
+	case *dst.SelectorExpr:
+		x := calleeNames(f.X)
+		if len(x) == 0 {
+			return nil
+		}
+		names := make([]string, len(x))
+		for i, name := range x {
+			names[i] = name + "." + f.Sel.Name
+		}
+		return names
+	case *dst.IndexExpr:
+		return calleeNames(f.X)
+	case *dst.IndexListExpr:
+		return calleeNames(f.X)
+	case *dst.ParenExpr:
+		return calleeNames(f.X)
+	case *dst.CallExpr:
+		// e.g. otel.Tracer("x").Start: the chain continues after the call
+		x := calleeNames(f.Fun)
+		for i := range x {
+			x[i] += "()"
+		}
+		return x
+	}
+	return nil
+}
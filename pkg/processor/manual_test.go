@@ -0,0 +1,212 @@
+package processor_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithConflicts(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		conflicts []string
+		src       string
+		want      string
+	}{
+		"method call matching a wildcard": {
+			conflicts: []string{"*.Start"},
+			src: `package service
+
+import "context"
+
+func (s *Service) Get(ctx context.Context) {
+	ctx, span := s.tracer.Start(ctx, "Get")
+	defer span.End()
+}
+`,
+			want: `package service
+
+import "context"
+
+func (s *Service) Get(ctx context.Context) {
+	ctx, span := s.tracer.Start(ctx, "Get")
+	defer span.End()
+}
+`,
+		},
+		"call in a function literal": {
+			conflicts: []string{"newrelic.FromContext"},
+			src: `package service
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Get(ctx context.Context) {
+	go func() {
+		defer newrelic.FromContext(ctx).StartSegment("Get").End()
+	}()
+}
+`,
+			want: `package service
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Get(ctx context.Context) {
+	go func() {
+		defer newrelic.FromContext(ctx).StartSegment("Get").End()
+	}()
+}
+`,
+		},
+		"chained call matching by import path": {
+			conflicts: []string{"go.opentelemetry.io/otel.Tracer().Start"},
+			src: `package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+)
+
+func Get(ctx context.Context) {
+	ctx, span := otel.Tracer("service").Start(ctx, "Get")
+	defer span.End()
+}
+`,
+			want: `package service
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+)
+
+func Get(ctx context.Context) {
+	ctx, span := otel.Tracer("service").Start(ctx, "Get")
+	defer span.End()
+}
+`,
+		},
+		"other calls do not conflict": {
+			conflicts: []string{"*.Start"},
+			src: `package service
+
+import "context"
+
+func Get(ctx context.Context) {
+	s.Stop()
+}
+`,
+			want: `package service
+
+import "context"
+
+func Get(ctx context.Context) {
+	defer trace(ctx, "service.Get")
+
+	s.Stop()
+}
+`,
+		},
+		"generated statements are still updated": {
+			conflicts: []string{"trace"},
+			src: `package service
+
+import "context"
+
+func Get(ctx context.Context) {
+	defer trace(ctx, "old")
+}
+`,
+			want: `package service
+
+import "context"
+
+func Get(ctx context.Context) {
+	defer trace(ctx, "service.Get")
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithConflicts(tt.conflicts))
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("TransformFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithConflicts_ConflictingFuncs(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"service.go": `package service
+
+import "context"
+
+type tracer struct{}
+
+var t tracer
+
+func (tracer) Start(name string) {}
+
+func Manual(ctx context.Context) {
+	t.Start("Manual")
+}
+
+func Plain(ctx context.Context) {
+}
+
+func trace(context.Context) {}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithConflicts([]string{"*.Start"}))
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, ch := range result.ConflictingFuncs {
+		got[ch.Func] = ch.Reason
+	}
+	if diff := cmp.Diff(map[string]string{"Manual": "calls t.Start"}, got); diff != "" {
+		t.Errorf("ConflictingFuncs mismatch (-want +got):\n%s", diff)
+	}
+
+	var modified []string
+	for _, ch := range result.ModifiedFuncs {
+		modified = append(modified, ch.Func)
+	}
+	if diff := cmp.Diff([]string{"Plain"}, modified); diff != "" {
+		t.Errorf("ModifiedFuncs mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,300 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/packages"
+)
+
+// moduleLoad is a set of patterns loaded from the directory of a module.
+type moduleLoad struct {
+	dir      string // Empty for the working directory
+	patterns []string
+}
+
+// loadModules loads the packages matching patterns with cfg, like go work
+// would in a multi-module repository: recursive directory patterns such as
+// "./..." also match the packages of the modules nested below their root,
+// which are loaded from their own directory. Returns the packages of each load;
+// a package found by several loads (e.g. through a go.work file) is only
+// returned by the first one.
+func loadModules(cfg *packages.Config, patterns []string) ([][]*packages.Package, error) {
+	loads := splitModules(patterns)
+
+	var groups [][]*packages.Package
+	seen := make(map[string]bool)
+	for _, l := range loads {
+		c := *cfg
+		c.Dir = l.dir
+		pkgs, err := packages.Load(&c, l.patterns...)
+		if err != nil {
+			return nil, err
+		}
+		pkgs = slices.DeleteFunc(pkgs, func(pkg *packages.Package) bool {
+			dup := seen[pkg.ID]
+			seen[pkg.ID] = true
+			return dup
+		})
+		groups = append(groups, pkgs)
+	}
+	return groups, nil
+}
+
+// listBatches lists the packages matching patterns as loadModules would, and
+// splits them into loads of at most size packages each, in order. Test
+// variants are loaded along with their package. Returns the loads and the
+// number of packages listed, test variants included.
+func listBatches(cfg *packages.Config, patterns []string, size int) ([]moduleLoad, int, error) {
+	c := *cfg
+	c.Mode = packages.NeedName
+
+	var batches []moduleLoad
+	var total int
+	seen := make(map[string]bool)
+	for _, l := range splitModules(patterns) {
+		c.Dir = l.dir
+		pkgs, err := packages.Load(&c, l.patterns...)
+		if err != nil {
+			return nil, 0, err
+		}
+		var paths []string
+		for _, pkg := range pkgs {
+			if seen[pkg.ID] {
+				continue
+			}
+			seen[pkg.ID] = true
+			total++
+			// Test variants and test binaries are loaded along with their package
+			if !strings.Contains(pkg.ID, " [") && !strings.HasSuffix(pkg.ID, ".test") {
+				paths = append(paths, pkg.ID)
+			}
+		}
+		for chunk := range slices.Chunk(paths, size) {
+			batches = append(batches, moduleLoad{dir: l.dir, patterns: chunk})
+		}
+	}
+	return batches, total, nil
+}
+
+// moduleLoads accumulates the patterns to load per directory.
+type moduleLoads []moduleLoad
+
+// add adds pattern to the load of dir.
+func (loads *moduleLoads) add(dir, pattern string) {
+	for i := range *loads {
+		if l := &(*loads)[i]; l.dir == dir {
+			if !slices.Contains(l.patterns, pattern) {
+				l.patterns = append(l.patterns, pattern)
+			}
+			return
+		}
+	}
+	*loads = append(*loads, moduleLoad{dir: dir, patterns: []string{pattern}})
+}
+
+// list returns the loads, without the working directory if it has no patterns.
+func (loads moduleLoads) list() []moduleLoad {
+	if len(loads[0].patterns) == 0 && len(loads) > 1 {
+		return loads[1:]
+	}
+	return loads
+}
+
+// splitModules distributes patterns over the modules they cover. Patterns
+// other than relative recursive ones are loaded from the working directory.
+// Within a go.work workspace, see splitWorkspace instead.
+func splitModules(patterns []string) []moduleLoad {
+	wd, _ := os.Getwd()
+	if modules := workspaceModules(wd); modules != nil {
+		return splitWorkspace(patterns, modules)
+	}
+	mainRoot := moduleRoot(wd)
+
+	loads := moduleLoads{{}}
+	add := func(dir, pattern string) {
+		if dir == mainRoot {
+			dir = ""
+		}
+		loads.add(dir, pattern)
+	}
+
+	for _, pattern := range patterns {
+		base, ok := recursiveBase(pattern)
+		if !ok {
+			add(mainRoot, pattern)
+			continue
+		}
+		absBase := filepath.Join(wd, base)
+
+		// The module containing the root of the pattern, if any
+		switch root := moduleRoot(absBase); root {
+		case "":
+		case mainRoot:
+			add(mainRoot, pattern)
+		default:
+			rel, _ := filepath.Rel(root, absBase)
+			add(root, "./"+filepath.ToSlash(filepath.Join(rel, "...")))
+		}
+
+		for _, dir := range nestedModules(absBase) {
+			add(dir, "./...")
+		}
+	}
+
+	return loads.list()
+}
+
+// splitWorkspace distributes patterns over the modules of a go.work workspace,
+// as if ctxweaver were run in each of them: relative patterns are resolved
+// against the directory of every module containing their base directory.
+// Other patterns are loaded once from the working directory.
+func splitWorkspace(patterns []string, modules []string) []moduleLoad {
+	loads := moduleLoads{{}}
+	for _, pattern := range patterns {
+		if !isRelativePattern(pattern) {
+			loads.add("", pattern)
+			continue
+		}
+		base := strings.TrimSuffix(pattern, "/...")
+		for _, dir := range modules {
+			if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(base))); err == nil && info.IsDir() {
+				loads.add(dir, pattern)
+			}
+		}
+	}
+	return loads.list()
+}
+
+// workspaceModules returns the module directories used by the go.work file in
+// effect in dir, or nil outside a workspace.
+func workspaceModules(dir string) []string {
+	cmd := exec.Command("go", "env", "GOWORK")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil
+	}
+	gowork := strings.TrimSpace(string(out))
+	if gowork == "" || gowork == "off" {
+		return nil
+	}
+	data, err := os.ReadFile(gowork)
+	if err != nil {
+		return nil
+	}
+	work, err := modfile.ParseWork(gowork, data, nil)
+	if err != nil {
+		return nil // Reported by packages.Load
+	}
+
+	modules := make([]string, 0, len(work.Use))
+	for _, use := range work.Use {
+		path := filepath.FromSlash(use.Path)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(gowork), path)
+		}
+		modules = append(modules, path)
+	}
+	return modules
+}
+
+// isRelativePattern reports whether pattern is a directory relative to the
+// working directory, such as "." or "./handler/...".
+func isRelativePattern(pattern string) bool {
+	return pattern == "." || pattern == ".." || strings.HasPrefix(pattern, "./") || strings.HasPrefix(pattern, "../")
+}
+
+// recursiveBase returns the directory of a relative recursive pattern such as
+// "./..." or "../services/...".
+func recursiveBase(pattern string) (string, bool) {
+	if !isRelativePattern(pattern) {
+		return "", false
+	}
+	base, ok := strings.CutSuffix(pattern, "/...")
+	if !ok {
+		return "", false
+	}
+	return filepath.FromSlash(base), true
+}
+
+// moduleRoot returns the directory of the go.mod file governing dir,
+// or "" if there is none.
+func moduleRoot(dir string) string {
+	for {
+		if isModuleDir(dir) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// nestedModules returns the directories below root (excluding root) containing
+// a go.mod file, skipping the directories ignored by the go command.
+func nestedModules(root string) []string {
+	var dirs []string
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if path == root {
+			return nil
+		}
+		name := d.Name()
+		if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor" {
+			return filepath.SkipDir
+		}
+		if isModuleDir(path) {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	return dirs
+}
+
+// isModuleDir reports whether dir contains a go.mod file.
+func isModuleDir(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, "go.mod"))
+	return err == nil && !info.IsDir()
+}
+
+// checkWritable returns an error if filename of pkg must not be modified:
+// unless external files are allowed, the file must belong to a main module
+// and stay within its directory once symbolic links are resolved. Files of
+// packages outside any module (e.g. loaded through file= patterns) are allowed.
+func (p *Processor) checkWritable(pkg *packages.Package, filename string) error {
+	if p.allowExternal || pkg.Module == nil {
+		return nil
+	}
+	if !pkg.Module.Main {
+		return fmt.Errorf("refusing to modify a file of dependency module %s", pkg.Module.Path)
+	}
+
+	root, err := filepath.EvalSymlinks(pkg.Module.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve module directory: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(filename)
+	if os.IsNotExist(err) {
+		// New file of the overlay
+		resolved, err = filepath.EvalSymlinks(filepath.Dir(filename))
+		resolved = filepath.Join(resolved, filepath.Base(filename))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve file: %w", err)
+	}
+	if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+		return fmt.Errorf("refusing to modify a file resolving to %s, outside module %s", resolved, pkg.Module.Path)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package processor
+
+import (
+	"github.com/dave/dst"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+// Candidate is a function processed by a Processor: one with a context carrier
+// that passes the configured filters. Mutators receive it after the template
+// has been applied to the function.
+type Candidate struct {
+	// Decl is the function declaration; mutators modify its body in place.
+	Decl *dst.FuncDecl
+	// File is the file declaring the function.
+	File *dst.File
+	// PkgPath is the import path of the package of the file.
+	PkgPath string
+	// Carrier is the carrier matched by the parameter named VarName.
+	Carrier config.CarrierDef
+	VarName string
+	// Ctx is the expression of the context, as {{.Ctx}} in templates.
+	Ctx string
+	// Remove is set in remove mode, where mutators should revert their changes.
+	Remove bool
+}
+
+// Mutator is a custom mutation of function bodies, applied to every candidate
+// function along with the template. Candidate collection, the selection of
+// functions, dry run mode and file writing are shared with the template.
+type Mutator interface {
+	// Inspect reports why the function needs the mutation, such as "missing
+	// metrics", or an empty string if it is up to date. It must not modify
+	// the function. Checking the existing statements here keeps repeated runs
+	// from applying the mutation twice.
+	Inspect(c Candidate) (reason string, err error)
+	// Apply applies the mutation to a function for which Inspect reported a reason.
+	Apply(c Candidate) error
+}
+
+// WithMutators adds mutators applied to every candidate function, in order,
+// after the template. They are not applied in the modes rewriting the
+// template's statements in place (renames, marker migration, drift detection),
+// nor to the functions of the baseline outside remove mode.
+func WithMutators(mutators ...Mutator) Option {
+	return func(p *Processor) {
+		p.mutators = append(p.mutators, mutators...)
+	}
+}
+
+// applyMutators applies the mutators that a function candidate needs, and
+// records the function as changed for the reasons they report.
+func (p *Processor) applyMutators(c funcCandidate, df *dst.File, pkgPath string, fr *fileResult) error {
+	if len(p.mutators) == 0 || len(p.renames) > 0 || p.migrateToMarker || p.detectDrift {
+		return nil
+	}
+	if !p.remove && p.baseline.Contains(funcKey(pkgPath, c.decl)) {
+		return nil
+	}
+
+	mc := Candidate{
+		Decl:    c.decl,
+		File:    df,
+		PkgPath: pkgPath,
+		Carrier: c.match.Carrier,
+		VarName: c.match.VarName,
+		Ctx:     c.match.Carrier.BuildContextExpr(c.match.VarName),
+		Remove:  p.remove,
+	}
+	for _, m := range p.mutators {
+		reason, err := m.Inspect(mc)
+		if err != nil {
+			return err
+		}
+		if reason == "" {
+			continue
+		}
+		if err := m.Apply(mc); err != nil {
+			return err
+		}
+		fr.modified = true
+		// A function changed by the template or a previous mutator is listed once
+		if n := len(fr.changed); n > 0 && fr.changed[n-1].decl == c.decl {
+			fr.changed[n-1].reason += ", " + reason
+			continue
+		}
+		fr.changed = append(fr.changed, changedFunc{decl: c.decl, op: "mutate", reason: reason})
+	}
+	return nil
+}
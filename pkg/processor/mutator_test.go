@@ -0,0 +1,188 @@
+package processor_test
+
+import (
+	"go/token"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+// countMutator appends count("<func>") to function bodies.
+type countMutator struct{}
+
+// find returns the index of the count call in the body, or -1.
+func (countMutator) find(c processor.Candidate) int {
+	return slices.IndexFunc(c.Decl.Body.List, func(stmt dst.Stmt) bool {
+		es, ok := stmt.(*dst.ExprStmt)
+		if !ok {
+			return false
+		}
+		call, ok := es.X.(*dst.CallExpr)
+		if !ok {
+			return false
+		}
+		fun, ok := call.Fun.(*dst.Ident)
+		return ok && fun.Name == "count"
+	})
+}
+
+func (m countMutator) Inspect(c processor.Candidate) (string, error) {
+	switch found := m.find(c) >= 0; {
+	case c.Remove && found:
+		return "counter to remove", nil
+	case !c.Remove && !found:
+		return "missing counter", nil
+	}
+	return "", nil
+}
+
+func (m countMutator) Apply(c processor.Candidate) error {
+	if c.Remove {
+		c.Decl.Body.List = slices.Delete(c.Decl.Body.List, m.find(c), m.find(c)+1)
+		return nil
+	}
+	call := &dst.CallExpr{
+		Fun:  dst.NewIdent("count"),
+		Args: []dst.Expr{&dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(c.Decl.Name.Name)}},
+	}
+	c.Decl.Body.List = append(c.Decl.Body.List, &dst.ExprStmt{X: call})
+	return nil
+}
+
+func TestWithMutators(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	const woven = `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+
+	work()
+	count("Foo")
+}
+
+func bar() {
+	work()
+}
+`
+
+	tests := map[string]struct {
+		src     string
+		remove  bool
+		want    string
+		wantMod bool
+	}{
+		"mutation is applied to candidates": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	work()
+}
+
+func bar() {
+	work()
+}
+`,
+			want:    woven,
+			wantMod: true,
+		},
+		"mutation is not applied twice": {
+			src: woven,
+		},
+		"mutation is reverted in remove mode": {
+			src:    woven,
+			remove: true,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+
+	work()
+}
+
+func bar() {
+	work()
+}
+`,
+			wantMod: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithMutators(countMutator{}), processor.WithRemove(tt.remove))
+			got, modified, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if modified != tt.wantMod {
+				t.Errorf("modified = %v, want %v", modified, tt.wantMod)
+			}
+			want := tt.want
+			if !tt.wantMod {
+				want = tt.src
+			}
+			if diff := cmp.Diff(want, string(got)); diff != "" {
+				t.Errorf("TransformFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithMutators_Reasons(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"service.go": `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+
+func Bar(ctx context.Context) {
+	defer trace(ctx)
+}
+
+func trace(context.Context) {}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithMutators(countMutator{}))
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, ch := range result.ModifiedFuncs {
+		got[ch.Func] = ch.Reason
+	}
+	want := map[string]string{
+		"Foo": "missing instrumentation, missing counter",
+		"Bar": "missing counter",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ModifiedFuncs reasons mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,115 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+// Plugin reviews the statements rendered for every candidate function before
+// they are inserted or updated, e.g. to enforce an organization's policy.
+// The request and response are the messages of the exec plugin protocol.
+type Plugin interface {
+	Review(req PluginRequest) (PluginResponse, error)
+}
+
+// PluginRequest describes a candidate function and its rendered statements.
+type PluginRequest struct {
+	Package   string `json:"package"`   // Import path of the package
+	Func      string `json:"func"`      // As {{.FuncName}}, e.g. "service.(*UserService).GetByID"
+	Carrier   string `json:"carrier"`   // Matched carrier type, e.g. "net/http.Request"
+	Var       string `json:"var"`       // Name of the carrier parameter
+	Ctx       string `json:"ctx"`       // As {{.Ctx}}, e.g. "r.Context()"
+	Statement string `json:"statement"` // Rendered template
+	Remove    bool   `json:"remove"`    // Remove mode, where vetoes are ignored
+}
+
+// PluginResponse is the decision of a plugin on a PluginRequest. The zero
+// value keeps the rendered statements.
+type PluginResponse struct {
+	// Veto leaves the function alone: its statements are neither inserted
+	// nor updated. Ignored in remove mode.
+	Veto bool `json:"veto,omitempty"`
+	// Reason describes the veto.
+	Reason string `json:"reason,omitempty"`
+	// Statement replaces the rendered statements if not empty.
+	Statement string `json:"statement,omitempty"`
+}
+
+// WithPlugins adds plugins reviewing the rendered statements of every
+// candidate function, in order: each one sees the statements rewritten by the
+// previous ones, and the first veto stops the review. Rewritten statements
+// are matched against existing ones by their skeleton, as in skeleton mode.
+func WithPlugins(plugins ...Plugin) Option {
+	return func(p *Processor) {
+		p.plugins = append(p.plugins, plugins...)
+	}
+}
+
+// reviewStatements lets the plugins review the statements rendered for a
+// function candidate. Returns the reason of a veto, which is never empty.
+func (p *Processor) reviewStatements(c funcCandidate, vars template.Vars, rt *renderedTemplate) (string, error) {
+	req := PluginRequest{
+		Package: vars.PackagePath,
+		Func:    vars.FuncName,
+		Carrier: c.match.Carrier.Package + "." + c.match.Carrier.Type,
+		Var:     c.match.VarName,
+		Ctx:     rt.ctx,
+		Remove:  p.remove,
+	}
+	for _, plugin := range p.plugins {
+		req.Statement = rt.stmt
+		resp, err := plugin.Review(req)
+		if err != nil {
+			return "", fmt.Errorf("plugin: %w", err)
+		}
+		if resp.Veto && !p.remove {
+			if resp.Reason == "" {
+				return "vetoed by plugin", nil
+			}
+			return resp.Reason, nil
+		}
+		if resp.Statement != "" && resp.Statement != rt.stmt {
+			rt.stmt = resp.Statement
+			// The placeholder pattern no longer describes the statements
+			rt.pattern, rt.bindings = "", nil
+		}
+	}
+	return "", nil
+}
+
+// ExecPlugin is a plugin run as a shell command for every candidate function:
+// the command reads the PluginRequest as JSON from its standard input and
+// writes the PluginResponse as JSON to its standard output, where no output
+// keeps the rendered statements. Its standard error is passed through.
+type ExecPlugin struct {
+	Command string
+}
+
+// Review implements Plugin.
+func (e ExecPlugin) Review(req PluginRequest) (PluginResponse, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return PluginResponse{}, err
+	}
+	cmd := exec.Command("sh", "-c", e.Command)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return PluginResponse{}, fmt.Errorf("%s: %w", e.Command, err)
+	}
+
+	var resp PluginResponse
+	if len(bytes.TrimSpace(out)) == 0 {
+		return resp, nil
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return PluginResponse{}, fmt.Errorf("%s: invalid response: %w", e.Command, err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,229 @@
+package processor_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+// pluginFunc adapts a function to processor.Plugin.
+type pluginFunc func(req processor.PluginRequest) (processor.PluginResponse, error)
+
+func (f pluginFunc) Review(req processor.PluginRequest) (processor.PluginResponse, error) {
+	return f(req)
+}
+
+func TestWithPlugins(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	const src = `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	work()
+}
+
+func Internal(ctx context.Context) {
+	work()
+}
+`
+
+	tests := map[string]struct {
+		plugins []processor.Plugin
+		want    string
+		wantErr string
+	}{
+		"plugin vetoes a function": {
+			plugins: []processor.Plugin{pluginFunc(func(req processor.PluginRequest) (processor.PluginResponse, error) {
+				return processor.PluginResponse{Veto: req.Func == "service.Internal"}, nil
+			})},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo")
+
+	work()
+}
+
+func Internal(ctx context.Context) {
+	work()
+}
+`,
+		},
+		"plugin rewrites the statement": {
+			plugins: []processor.Plugin{pluginFunc(func(req processor.PluginRequest) (processor.PluginResponse, error) {
+				return processor.PluginResponse{Statement: strings.ReplaceAll(req.Statement, "trace(", "traceDebug(")}, nil
+			})},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer traceDebug(ctx, "service.Foo")
+
+	work()
+}
+
+func Internal(ctx context.Context) {
+	defer traceDebug(ctx, "service.Internal")
+
+	work()
+}
+`,
+		},
+		"plugins see the statements rewritten by the previous ones": {
+			plugins: []processor.Plugin{
+				pluginFunc(func(req processor.PluginRequest) (processor.PluginResponse, error) {
+					return processor.PluginResponse{Statement: strings.ReplaceAll(req.Statement, "trace(", "traceDebug(")}, nil
+				}),
+				pluginFunc(func(req processor.PluginRequest) (processor.PluginResponse, error) {
+					return processor.PluginResponse{Veto: strings.Contains(req.Statement, "traceDebug")}, nil
+				}),
+			},
+			want: src,
+		},
+		"plugin error": {
+			plugins: []processor.Plugin{pluginFunc(func(processor.PluginRequest) (processor.PluginResponse, error) {
+				return processor.PluginResponse{}, errors.New("policy server unavailable")
+			})},
+			wantErr: "plugin: policy server unavailable",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithPlugins(tt.plugins...))
+			got, _, err := proc.TransformFile([]byte(src), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("TransformFile() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("TransformFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithPlugins_Vetoed(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"service.go": `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+
+func Bar(ctx context.Context) {
+}
+
+func trace(context.Context) {}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	var requests []processor.PluginRequest
+	plugin := pluginFunc(func(req processor.PluginRequest) (processor.PluginResponse, error) {
+		requests = append(requests, req)
+		if req.Func == "service.Bar" {
+			return processor.PluginResponse{Veto: true, Reason: "excluded by policy"}, nil
+		}
+		return processor.PluginResponse{}, nil
+	})
+
+	proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithPlugins(plugin))
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	wantRequests := []processor.PluginRequest{
+		{Package: "testmod", Func: "service.Foo", Carrier: "context.Context", Var: "ctx", Ctx: "ctx", Statement: "defer trace(ctx)"},
+		{Package: "testmod", Func: "service.Bar", Carrier: "context.Context", Var: "ctx", Ctx: "ctx", Statement: "defer trace(ctx)"},
+	}
+	if diff := cmp.Diff(wantRequests, requests); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+
+	got := make(map[string]string)
+	for _, ch := range result.VetoedFuncs {
+		got[ch.Func] = ch.Reason
+	}
+	if diff := cmp.Diff(map[string]string{"Bar": "excluded by policy"}, got); diff != "" {
+		t.Errorf("VetoedFuncs mismatch (-want +got):\n%s", diff)
+	}
+	if len(result.ModifiedFuncs) != 1 || result.ModifiedFuncs[0].Func != "Foo" {
+		t.Errorf("ModifiedFuncs = %v, want only Foo", result.ModifiedFuncs)
+	}
+}
+
+func TestExecPlugin(t *testing.T) {
+	req := processor.PluginRequest{Package: "example.com/app/service", Func: "service.Foo", Statement: "defer trace(ctx)"}
+
+	tests := map[string]struct {
+		command string
+		want    processor.PluginResponse
+		wantErr string
+	}{
+		"no output keeps the statement": {
+			command: "cat >/dev/null",
+		},
+		"veto": {
+			command: `cat >/dev/null; echo '{"veto": true, "reason": "excluded by policy"}'`,
+			want:    processor.PluginResponse{Veto: true, Reason: "excluded by policy"},
+		},
+		"request is sent on stdin": {
+			command: `grep -q '"func":"service.Foo"' && echo '{"statement": "defer traceFoo(ctx)"}'`,
+			want:    processor.PluginResponse{Statement: "defer traceFoo(ctx)"},
+		},
+		"command fails": {
+			command: "exit 3",
+			wantErr: "exit status 3",
+		},
+		"invalid response": {
+			command: "cat >/dev/null; echo veto",
+			wantErr: "invalid response",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := processor.ExecPlugin{Command: tt.command}.Review(req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Review() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Review() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Review() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
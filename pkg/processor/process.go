@@ -5,158 +5,679 @@ import (
 	"fmt"
 	"go/ast"
 	"go/format"
+	"go/types"
+	"iter"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 
+	"github.com/dave/dst"
 	"github.com/dave/dst/decorator"
-	"github.com/dave/dst/decorator/resolver/guess"
 	"golang.org/x/tools/go/ast/astutil"
 	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/imports"
 
 	"github.com/mpyw/ctxweaver/internal/directive"
+	"github.com/mpyw/ctxweaver/internal/patch"
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/template"
 )
 
 // Process processes the given package patterns.
 func (p *Processor) Process(patterns []string) (*ProcessResult, error) {
-	cfg := &packages.Config{
-		Mode: packages.NeedName |
-			packages.NeedFiles |
-			packages.NeedSyntax |
-			packages.NeedTypes |
-			packages.NeedTypesInfo |
-			packages.NeedImports,
-		Tests: p.test,
+	if p.addParam && p.selection != nil {
+		return nil, fmt.Errorf("add-param mode updates the call sites of all packages and cannot be combined with a selection")
 	}
-
-	pkgs, err := packages.Load(cfg, patterns...)
+	p.reportProgress(Progress{})
+	total, batches, err := p.loadPackages(patterns)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load packages: %w", err)
+		return nil, err
 	}
 
-	result := &ProcessResult{}
-
-	for _, pkg := range pkgs {
-		if len(pkg.Errors) > 0 {
-			for _, e := range pkg.Errors {
-				result.Errors = append(result.Errors, fmt.Errorf("package %s: %v", pkg.PkgPath, e))
+	// Names passed to the unique template function are unique across the run
+	q := *p
+	q.names = template.NewNameRegistry()
+	q.dirtyFiles = make(map[string]bool)
+	if p.addParam {
+		var pkgs []*packages.Package
+		for batch, err := range batches {
+			if err != nil {
+				return nil, err
 			}
-			continue
+			pkgs = append(pkgs, batch...)
 		}
+		q.paramTargets = p.resolveParamTargets(pkgs)
+	}
+	p = &q
 
-		// Check if package should be excluded by regex patterns
-		if p.shouldExcludePackage(pkg.PkgPath) {
-			if p.verbose {
-				fmt.Printf("excluded: %s\n", pkg.PkgPath)
-			}
-			continue
-		}
+	result := &ProcessResult{}
+	var written []writtenFile
+	addedImports := make(map[AddedImport]bool)
+	pkgIndex := make(map[string]int) // Index in result.Packages by package path
+	seen := make(map[string]bool)    // Files shared by a package and its test variant
 
-		// Create decorator once per package for efficient type-resolved DST conversion
-		dec := decorator.NewDecoratorFromPackage(pkg)
+	progress := Progress{Packages: total}
+	p.reportProgress(progress)
 
-		for _, file := range pkg.Syntax {
-			// Get filename from AST position (more reliable than index-based access)
-			pos := pkg.Fset.Position(file.Pos())
-			if !pos.IsValid() {
+	var done int
+pkgLoop:
+	for pkgs, err := range batches {
+		if err != nil {
+			return nil, err
+		}
+		for _, pkg := range pkgs {
+			progress.PackagesDone = done
+			done++
+			if len(pkg.Errors) > 0 {
+				for _, e := range pkg.Errors {
+					result.Errors = append(result.Errors, &LoadError{Package: pkg.PkgPath, Err: e})
+				}
+				if p.failFast {
+					break pkgLoop
+				}
 				continue
 			}
-			filename := pos.Filename
 
-			if !p.shouldProcessFile(filename) {
+			// Check if package should be excluded by regex patterns
+			if p.shouldExcludePackage(pkg.PkgPath) {
+				if p.verbose {
+					fmt.Printf("excluded: %s\n", pkg.PkgPath)
+				}
 				continue
 			}
 
-			result.FilesProcessed++
-
-			modified, err := p.processFile(pkg, dec, file, filename)
-			if err != nil {
-				result.Errors = append(result.Errors, fmt.Errorf("%s: %w", filename, err))
-				continue
+			idx, ok := pkgIndex[pkg.PkgPath]
+			if !ok {
+				idx = len(result.Packages)
+				pkgIndex[pkg.PkgPath] = idx
+				pr := PackageResult{PkgPath: pkg.PkgPath}
+				if pkg.Module != nil {
+					pr.Module = pkg.Module.Path
+				}
+				result.Packages = append(result.Packages, pr)
 			}
+			pr := &result.Packages[idx]
 
-			if modified {
-				result.FilesModified++
-				if p.verbose {
-					fmt.Printf("modified: %s\n", filename)
+			// Apply the first matching per-package override
+			pp := p.forPackage(pkg.PkgPath).withInterfaces(pkg).withModule(packageModule(pkg))
+
+			// Create decorator and restorer once per package for efficient type-resolved DST conversion
+			dec := newDecorator(pkg)
+			res := decorator.NewRestorerWithImports(pkg.PkgPath, buildRestorerResolver(pkg))
+			pp = pp.withPackageCandidates(pkg, dec).withPackageDecls(pkg.Syntax)
+
+			for _, file := range pkg.Syntax {
+				// Get filename from AST position (more reliable than index-based access)
+				pos := pkg.Fset.Position(file.Pos())
+				if !pos.IsValid() {
+					continue
+				}
+				filename := pos.Filename
+
+				if seen[filename] || !pp.shouldProcessFile(filename) {
+					continue
+				}
+				seen[filename] = true
+
+				result.FilesProcessed++
+				pr.FilesProcessed++
+
+				fr, err := pp.processFile(pkg, dec, res, file, filename)
+				if err == nil && !fr.modified && p.outputDir != "" && !p.dryRun {
+					err = p.copyToOutputDir(filename)
+				}
+				progress.FilesProcessed = result.FilesProcessed
+				p.reportProgress(progress)
+				if err != nil {
+					result.Errors = append(result.Errors, err)
+					if p.failFast {
+						break pkgLoop
+					}
+					continue
+				}
+
+				if fr.selected {
+					result.Selected = true
+				}
+				for _, ch := range fr.protected {
+					result.ProtectedFuncs = append(result.ProtectedFuncs, funcChange(pkg, dec, filename, ch))
+				}
+				for _, ch := range fr.vetoed {
+					result.VetoedFuncs = append(result.VetoedFuncs, funcChange(pkg, dec, filename, ch))
+				}
+				for _, ch := range fr.declined {
+					result.DeclinedFuncs = append(result.DeclinedFuncs, funcChange(pkg, dec, filename, ch))
+				}
+				for _, ch := range fr.unsampled {
+					result.UnsampledFuncs = append(result.UnsampledFuncs, funcChange(pkg, dec, filename, ch))
+				}
+				for _, ch := range fr.conflicting {
+					result.ConflictingFuncs = append(result.ConflictingFuncs, funcChange(pkg, dec, filename, ch))
+				}
+				for _, ch := range fr.creators {
+					result.ContextCreators = append(result.ContextCreators, funcChange(pkg, dec, filename, ch))
+				}
+				for _, ch := range fr.paramSkipped {
+					result.ParamSkippedFuncs = append(result.ParamSkippedFuncs, funcChange(pkg, dec, filename, ch))
+				}
+				if fr.modified {
+					result.FilesModified++
+					pr.FilesModified++
+					result.ModifiedFiles = append(result.ModifiedFiles, filename)
+					if fr.dirty {
+						result.DirtyFiles = append(result.DirtyFiles, filename)
+					}
+					for _, path := range fr.addedImports {
+						imp := AddedImport{Path: path}
+						if pkg.Module != nil {
+							imp.Module, imp.Dir = pkg.Module.Path, pkg.Module.Dir
+						}
+						addedImports[imp] = true
+					}
+					if fr.patch != "" {
+						result.Patches = append(result.Patches, fr.patch)
+					}
+					if fr.content != nil {
+						if result.Contents == nil {
+							result.Contents = make(map[string][]byte)
+						}
+						result.Contents[filename] = fr.content
+					}
+					if fr.diff != nil {
+						if result.Diffs == nil {
+							result.Diffs = make(map[string][]byte)
+						}
+						result.Diffs[filename] = fr.diff
+					}
+					var changed []FuncChange
+					for _, ch := range fr.changed {
+						changed = append(changed, funcChange(pkg, dec, filename, ch))
+					}
+					result.ModifiedFuncs = append(result.ModifiedFuncs, changed...)
+					if fr.original != nil {
+						written = append(written, writtenFile{filename: filename, pkgPath: pkg.PkgPath, original: fr.original})
+					}
+					if p.verbose {
+						printModified(filename, changed)
+					}
+				}
+				if fr.duplicatesRemoved > 0 {
+					result.DuplicatesRemoved += fr.duplicatesRemoved
+					if p.verbose {
+						fmt.Printf("deduplicated: %s (%d removed)\n", filename, fr.duplicatesRemoved)
+					}
 				}
 			}
 		}
 	}
 
+	progress.PackagesDone = total
+	p.reportProgress(progress)
+	result.Modules = moduleResults(result.Packages)
+	result.AddedImports = sortAddedImports(addedImports)
+	result.NameCollisions = p.names.Collisions()
+
+	if len(written) > 0 {
+		if err := p.verifyWritten(written, result); err != nil {
+			return nil, err
+		}
+	}
+
 	return result, nil
 }
 
+// moduleResults sums the package results per module, in order of first appearance.
+func moduleResults(pkgs []PackageResult) []ModuleResult {
+	var modules []ModuleResult
+	index := make(map[string]int)
+	for _, pr := range pkgs {
+		i, ok := index[pr.Module]
+		if !ok {
+			i = len(modules)
+			index[pr.Module] = i
+			modules = append(modules, ModuleResult{Path: pr.Module})
+		}
+		modules[i].Packages++
+		modules[i].FilesProcessed += pr.FilesProcessed
+		modules[i].FilesModified += pr.FilesModified
+	}
+	return modules
+}
+
+// funcChange locates a function changed in filename through the AST node
+// recorded by the decorator.
+func funcChange(pkg *packages.Package, dec *decorator.Decorator, filename string, ch changedFunc) FuncChange {
+	fc := FuncChange{File: filename, Func: funcName(ch.decl), Op: ch.op, Reason: ch.reason}
+	if n, ok := dec.Ast.Nodes[ch.decl]; ok {
+		fc.Line = pkg.Fset.Position(n.Pos()).Line
+		fc.EndLine = pkg.Fset.Position(n.End()).Line
+	}
+	return fc
+}
+
+// printModified prints the functions changed in a modified file, or the file
+// alone if it was modified outside functions (e.g. by a banner).
+func printModified(filename string, changed []FuncChange) {
+	name := displayPath(filename)
+	if len(changed) == 0 {
+		fmt.Printf("modified: %s\n", name)
+		return
+	}
+	for _, fc := range changed {
+		fmt.Printf("modified: %s:%d %s (%s)\n", name, fc.Line, fc.Func, fc.Op)
+	}
+}
+
+// displayPath returns filename relative to the working directory if it is
+// inside it, or as is otherwise.
+func displayPath(filename string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return filename
+	}
+	rel, err := filepath.Rel(wd, filename)
+	if err != nil || !filepath.IsLocal(rel) {
+		return filename
+	}
+	return filepath.ToSlash(rel)
+}
+
+// funcName returns the name of decl as in stack traces, e.g. "Foo" or "(*Service).Get".
+func funcName(decl *dst.FuncDecl) string {
+	if decl.Recv == nil || len(decl.Recv.List) == 0 {
+		return decl.Name.Name
+	}
+	recv := recvString(decl.Recv.List[0].Type)
+	if strings.HasPrefix(recv, "*") {
+		recv = "(" + recv + ")"
+	}
+	return recv + "." + decl.Name.Name
+}
+
+// reportProgress calls the progress function, if any.
+func (p *Processor) reportProgress(progress Progress) {
+	if p.progress != nil {
+		p.progress(progress)
+	}
+}
+
+// loadPackages loads the packages matching patterns with syntax and, unless
+// not needed in syntax load mode, type information, from every module the
+// patterns cover (see loadModules). Returns the number of packages and their
+// batches: a single one, or with a batch size, batches of at most that many
+// packages loaded one after another, so that the syntax and type information
+// of a batch can be released before the next one is loaded.
+func (p *Processor) loadPackages(patterns []string) (int, iter.Seq2[[]*packages.Package, error], error) {
+	cfg := &packages.Config{
+		Mode:    p.packagesLoadMode(),
+		Tests:   p.loadsTests(),
+		Overlay: p.overlay,
+	}
+
+	if p.batchSize <= 0 {
+		groups, err := loadModules(cfg, patterns)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to load packages: %w", err)
+		}
+		pkgs := slices.Concat(groups...)
+		p.resolveReachable(groups)
+		p.warnUnresolvedInterfaces(pkgs)
+		return len(pkgs), func(yield func([]*packages.Package, error) bool) { yield(pkgs, nil) }, nil
+	}
+
+	if len(p.reachableFilters()) > 0 {
+		return 0, nil, fmt.Errorf("functions.reachable_from needs the call graph of all packages and cannot be loaded in batches")
+	}
+	if p.addParam {
+		return 0, nil, fmt.Errorf("add-param mode needs the call sites of all packages and cannot load them in batches")
+	}
+	batches, total, err := listBatches(cfg, patterns, p.batchSize)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to load packages: %w", err)
+	}
+	return total, func(yield func([]*packages.Package, error) bool) {
+		unresolved := p.implementsNames()
+		for _, b := range batches {
+			c := *cfg
+			c.Dir = b.dir
+			pkgs, err := packages.Load(&c, b.patterns...)
+			if err != nil {
+				yield(nil, fmt.Errorf("failed to load packages: %w", err))
+				return
+			}
+			unresolved = unresolvedInterfaces(pkgs, unresolved)
+			if !yield(pkgs, nil) {
+				return
+			}
+		}
+		warnInterfacesNotFound(unresolved)
+	}, nil
+}
+
+// packageTypeResolver resolves types of DST expressions through the AST nodes
+// recorded by the decorator and the package's type information.
+func packageTypeResolver(pkg *packages.Package, dec *decorator.Decorator) typeResolver {
+	return func(expr dst.Expr) types.Type {
+		n, ok := dec.Ast.Nodes[expr].(ast.Expr)
+		if !ok || pkg.TypesInfo == nil {
+			return nil
+		}
+		return pkg.TypesInfo.TypeOf(n)
+	}
+}
+
+// withModule returns a copy of p rendering templates for the module path, and
+// formatting files for the Go version of the module.
+func (p *Processor) withModule(path, goVersion string) *Processor {
+	q := *p
+	q.modulePath = path
+	q.goVersion = goVersion
+	return &q
+}
+
+// packageModule returns the path and Go version of the module of pkg, or
+// empty strings if unknown.
+func packageModule(pkg *packages.Package) (path, goVersion string) {
+	if pkg.Module == nil {
+		return "", ""
+	}
+	return pkg.Module.Path, pkg.Module.GoVersion
+}
+
+// withFilename returns a copy of p rendering templates for the file name, a
+// slash-separated path relative to the module root.
+func (p *Processor) withFilename(name string) *Processor {
+	q := *p
+	q.filename = name
+	return &q
+}
+
+// moduleRelPath returns the slash-separated path of filename relative to the
+// root of the module of pkg, or relative to the working directory outside
+// modules.
+func moduleRelPath(pkg *packages.Package, filename string) string {
+	if pkg.Module != nil {
+		return relPath(pkg.Module.Dir, filename)
+	}
+	return relPath("", filename)
+}
+
+// relPath returns the slash-separated path of filename relative to root, or
+// relative to the working directory if root is empty or does not contain it.
+func relPath(root, filename string) string {
+	if root != "" {
+		if rel, err := filepath.Rel(root, filename); err == nil && filepath.IsLocal(rel) {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return filepath.ToSlash(displayPath(filename))
+}
+
 // shouldExcludePackage checks if the package path should be excluded based on regex filters.
 func (p *Processor) shouldExcludePackage(pkgPath string) bool {
 	return !p.pkgRegexps.Match(pkgPath)
 }
 
 // buildRestorerResolver creates a resolver from packages.Package.Imports.
-// This avoids additional packages.Load calls while providing accurate package names.
-func buildRestorerResolver(pkg *packages.Package) guess.RestorerResolver {
-	m := make(map[string]string, len(pkg.Imports))
+// This avoids additional packages.Load calls while providing accurate package
+// names; names of other packages (e.g. without type information) are guessed.
+func buildRestorerResolver(pkg *packages.Package) importNames {
+	m := make(importNames, len(pkg.Imports))
 	for path, imported := range pkg.Imports {
 		m[path] = imported.Name
 	}
-	return guess.WithMap(m)
+	return m
 }
 
 func (p *Processor) shouldProcessFile(filename string) bool {
-	// Skip test files if not enabled
-	if !p.test && strings.HasSuffix(filename, "_test.go") {
+	// Skip test files if not enabled, and other files in tests_only mode
+	isTest := strings.HasSuffix(filename, "_test.go")
+	if p.funcFilter != nil && p.funcFilter.TestsOnly {
+		if !isTest {
+			return false
+		}
+	} else if !p.test && isTest {
+		return false
+	}
+	// Skip the variants of other files, regenerated from them
+	if (p.buildTag != "" || p.hotBuildTag != "") && isVariant(filename) {
+		return false
+	}
+	// Skip testdata directories (convention for test fixtures) unless enabled
+	if !p.testdata && slices.Contains(strings.Split(filepath.ToSlash(filepath.Dir(filename)), "/"), "testdata") {
+		return false
+	}
+	// Skip the copies written by previous runs to an output directory inside
+	// the loaded packages, which would otherwise be nested deeper on every run
+	if p.inOutputDir(filename) {
 		return false
 	}
-	// Skip testdata directories (convention for test fixtures)
-	if strings.Contains(filename, "/testdata/") || strings.Contains(filename, "\\testdata\\") {
+	// Skip files other than the selected one
+	if p.selection != nil && p.selection.filename != "" && filename != p.selection.filename {
+		return false
+	}
+	// Skip files excluded by .ctxweaverignore
+	if p.ignore.Match(filename) {
+		if p.verbose {
+			fmt.Printf("ignored: %s\n", filename)
+		}
 		return false
 	}
 	return true
 }
 
-func (p *Processor) processFile(pkg *packages.Package, dec *decorator.Decorator, astFile *ast.File, filename string) (bool, error) {
-	// Skip generated files (files with "// Code generated" comment)
-	if ast.IsGenerated(astFile) {
-		return false, nil
+func (p *Processor) processFile(pkg *packages.Package, dec *decorator.Decorator, res *decorator.Restorer, astFile *ast.File, filename string) (fileResult, error) {
+	// Skip generated files (files with "// Code generated" comment) unless included
+	if p.skipsGenerated(astFile, moduleRelPath(pkg, filename)) {
+		return fileResult{}, nil
 	}
 
 	// Convert to DST using type-resolved decorator (sets dst.Ident.Path automatically)
 	df, err := dec.DecorateFile(astFile)
 	if err != nil {
-		return false, fmt.Errorf("failed to decorate file: %w", err)
+		return fileResult{}, &ParseError{File: filename, Err: fmt.Errorf("failed to decorate file: %w", err)}
 	}
 
-	// Check for file-level skip directive
-	if directive.HasSkipDirective(df.Decorations()) {
-		return false, nil
+	// Check for file-level skip directive; calls are still updated in add-param
+	// mode, since they would not compile otherwise
+	if directive.HasSkipDirective(df.Decorations()) && !p.addParam {
+		return fileResult{}, nil
 	}
 
 	// Process functions
-	modified, err := p.processFunctions(df, pkg.PkgPath)
+	p = p.withSelectedFunc(pkg.Fset, dec, astFile, pkg.PkgPath).withFilename(moduleRelPath(pkg, filename))
+	typeOf, names := packageTypeResolver(pkg, dec), buildRestorerResolver(pkg)
+	var fr fileResult
+	if p.addParam {
+		fr = p.addParams(df, pkg, dec)
+	} else {
+		fr, err = p.processFunctions(df, pkg.PkgPath, typeOf, names)
+	}
 	if err != nil {
-		return false, err
+		if re, ok := err.(*RenderError); ok {
+			re.File = filename
+		}
+		return fileResult{}, err
+	}
+	fr.selected = p.selectedFunc != nil || p.selection != nil && p.selection.wholeFile()
+	if p.buildTag != "" {
+		return p.emitVariant(pkg, df, res, filename, p.buildTag, nil, fr)
 	}
-	if !modified {
-		return false, nil
+	if p.hotBuildTag != "" && !p.addParam {
+		return p.emitHotVariant(pkg, df, res, filename, typeOf, names, fr)
+	}
+	if !fr.modified {
+		return fr, nil
+	}
+	if err := p.checkWritable(pkg, filename); err != nil {
+		return fileResult{}, &WriteError{File: filename, Err: err}
+	}
+	if err := p.checkClean(filename, &fr); err != nil {
+		return fileResult{}, &WriteError{File: filename, Err: err}
 	}
 
 	// Convert back to AST using package import info (no additional packages.Load)
-	restorer := decorator.NewRestorerWithImports(pkg.PkgPath, buildRestorerResolver(pkg))
+	result, err := p.restoreFile(df, res, filename)
+	if err != nil {
+		return fileResult{}, &WriteError{File: filename, Err: err}
+	}
+	// Keep the line endings of the file (e.g. CRLF on Windows)
+	original, err := p.readFile(filename)
+	if err != nil {
+		return fileResult{}, &WriteError{File: filename, Err: fmt.Errorf("failed to read file: %w", err)}
+	}
+	result = restoreLineEndings(original, restoreLineDirectives(original, result))
+	fr.addedImports = addedImports(original, result)
+
+	// Dry run: leave the file alone, optionally writing the modification as a patch
+	if p.dryRun {
+		if p.keepContents {
+			fr.content = result
+		}
+		if p.keepDiffs {
+			fr.diff = patch.Unified(displayPath(filename), original, result)
+		}
+		if p.patchDir != "" {
+			if fr.patch, err = p.writePatch(filename, result); err != nil {
+				return fileResult{}, &WriteError{File: filename, Err: err}
+			}
+		}
+		return fr, nil
+	}
+
+	if p.outputDir != "" {
+		if err := p.writeOutput(filename, result); err != nil {
+			return fileResult{}, &WriteError{File: filename, Err: err}
+		}
+		return fr, nil
+	}
+
+	if p.verify {
+		if fr.original, err = os.ReadFile(filename); err != nil {
+			return fileResult{}, &WriteError{File: filename, Err: fmt.Errorf("failed to read file: %w", err)}
+		}
+	}
+	if err := os.WriteFile(filename, result, 0o644); err != nil {
+		return fileResult{}, &WriteError{File: filename, Err: fmt.Errorf("failed to write file: %w", err)}
+	}
+
+	return fr, nil
+}
+
+// readFile returns the contents of filename, from the overlay if present.
+func (p *Processor) readFile(filename string) ([]byte, error) {
+	if content, ok := p.overlay[filename]; ok {
+		return content, nil
+	}
+	return os.ReadFile(filename)
+}
+
+// writePatch writes the modification of filename to content as a patch file
+// under the patch directory, and returns the path of the patch file.
+func (p *Processor) writePatch(filename string, content []byte) (string, error) {
+	// Keep the line endings of the file (e.g. CRLF on Windows)
+	original, err := p.readFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get working directory: %w", err)
+	}
+	rel, err := filepath.Rel(wd, filename)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("cannot write a patch for a file outside the working directory")
+	}
+
+	out := filepath.Join(p.patchDir, rel+".patch")
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create patch directory: %w", err)
+	}
+	if err := os.WriteFile(out, patch.Unified(filepath.ToSlash(rel), original, content), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write patch: %w", err)
+	}
+	return out, nil
+}
+
+// copyToOutputDir writes the unmodified contents of filename under the output
+// directory.
+func (p *Processor) copyToOutputDir(filename string) error {
+	content, err := p.readFile(filename)
+	if err != nil {
+		return &WriteError{File: filename, Err: fmt.Errorf("failed to read file: %w", err)}
+	}
+	if err := p.writeOutput(filename, content); err != nil {
+		return &WriteError{File: filename, Err: err}
+	}
+	return nil
+}
+
+// inOutputDir reports whether filename is under the output directory, if any.
+func (p *Processor) inOutputDir(filename string) bool {
+	if p.outputDir == "" {
+		return false
+	}
+	dir, err := filepath.Abs(p.outputDir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, filename)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// writeOutput writes content under the output directory, at the path of
+// filename relative to the working directory.
+func (p *Processor) writeOutput(filename string, content []byte) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	rel, err := filepath.Rel(wd, filename)
+	if err != nil || !filepath.IsLocal(rel) {
+		return fmt.Errorf("cannot write a file outside the working directory to the output directory")
+	}
+
+	out := filepath.Join(p.outputDir, rel)
+	if err := os.MkdirAll(filepath.Dir(out), 0o755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	if err := os.WriteFile(out, content, 0o644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	return nil
+}
+
+// restoreFile converts a modified DST file back to formatted source,
+// adding the configured imports and cleaning up unused ones.
+func (p *Processor) restoreFile(df *dst.File, restorer *decorator.Restorer, filename string) ([]byte, error) {
 	f, err := restorer.RestoreFile(df)
 	if err != nil {
-		return false, fmt.Errorf("failed to restore file: %w", err)
+		return nil, fmt.Errorf("failed to restore file: %w", err)
 	}
 	fset := restorer.Fset
 
-	// Add imports
+	// Add imports, under their alias if any so that the template can refer to it
 	for _, imp := range p.imports {
-		astutil.AddImport(fset, f, imp)
+		astutil.AddNamedImport(fset, f, imp.Alias, imp.Path)
+	}
+	if p.format == config.FormatNone {
+		// goimports is skipped, so the imports left unused (e.g. in remove mode) are removed here
+		for _, imp := range p.imports {
+			if imp.Alias != "_" && imp.Alias != "." && !usesImport(f, imp) {
+				astutil.DeleteNamedImport(fset, f, imp.Alias, imp.Path)
+			}
+		}
 	}
 
 	// Format
 	var buf bytes.Buffer
 	if err := format.Node(&buf, fset, f); err != nil {
-		return false, fmt.Errorf("failed to format file: %w", err)
+		return nil, fmt.Errorf("failed to format file: %w", err)
+	}
+	if p.format == config.FormatNone {
+		return buf.Bytes(), nil
 	}
 
 	// Clean up unused imports using goimports
@@ -172,12 +693,56 @@ func (p *Processor) processFile(pkg *packages.Package, dec *decorator.Decorator,
 		result = buf.Bytes()
 	}
 
-	// Write if not dry run
-	if !p.dryRun {
-		if err := os.WriteFile(filename, result, 0o644); err != nil {
-			return false, fmt.Errorf("failed to write file: %w", err)
-		}
+	if p.format == config.FormatGofumpt {
+		return gofumpt(result, p.goVersion, p.modulePath)
 	}
+	return result, nil
+}
 
-	return true, nil
+// usesImport reports whether f refers to the package of imp, by its alias or
+// by the package name guessed from its path.
+func usesImport(f *ast.File, imp config.Import) bool {
+	name := imp.Alias
+	if name == "" {
+		name = guessPackageName(imp.Path)
+	}
+	var used bool
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == name {
+				used = true
+			}
+		}
+		return !used
+	})
+	return used
+}
+
+// gofumpt formats src with the gofumpt command, for the given Go version and
+// module path unless empty. Read from stdin, the file has no go.mod for
+// gofumpt to take them from.
+func gofumpt(src []byte, goVersion, modulePath string) ([]byte, error) {
+	bin, err := exec.LookPath("gofumpt")
+	if err != nil {
+		return nil, fmt.Errorf("format.tool is gofumpt, but gofumpt is not installed: install it with go install mvdan.cc/gofumpt@latest")
+	}
+	var args []string
+	if goVersion != "" {
+		args = append(args, "-lang", "go"+goVersion)
+	}
+	if modulePath != "" {
+		args = append(args, "-modpath", modulePath)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = bytes.NewReader(src)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("gofumpt failed: %s", msg)
+		}
+		return nil, fmt.Errorf("gofumpt failed: %w", err)
+	}
+	return stdout.Bytes(), nil
 }
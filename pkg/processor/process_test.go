@@ -2,13 +2,19 @@ package processor_test
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/mpyw/ctxweaver/pkg/config"
 	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/scaffold"
 	"github.com/mpyw/ctxweaver/pkg/template"
 )
 
@@ -77,6 +83,125 @@ func Foo(ctx context.Context) {
 		}
 	})
 
+	t.Run("CRLF line endings are preserved", func(t *testing.T) {
+		tmpDir := setupTestModule(t, map[string]string{
+			"main.go": "package main\r\n\r\nimport \"context\"\r\n\r\nfunc Foo(ctx context.Context) {\r\n}\r\n",
+		})
+
+		proc := processor.New(registry, tmpl, nil)
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		if _, err := proc.Process([]string{"./..."}); err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+
+		content, _ := os.ReadFile(filepath.Join(tmpDir, "main.go"))
+		want := "package main\r\n\r\nimport \"context\"\r\n\r\nfunc Foo(ctx context.Context) {\r\n\tdefer trace(ctx)\r\n\r\n}\r\n"
+		if diff := cmp.Diff(want, string(content)); diff != "" {
+			t.Errorf("content mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("testdata directories are skipped", func(t *testing.T) {
+		tmpDir := setupTestModule(t, map[string]string{
+			"main.go": "package main\n",
+			"testdata/fixture/fixture.go": `package fixture
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+			"testdatahelper/helper.go": `package testdatahelper
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+		})
+
+		proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true))
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		result, err := proc.Process([]string{"./...", "./testdata/fixture"})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+
+		if diff := cmp.Diff([]string{filepath.Join(tmpDir, "testdatahelper", "helper.go")}, result.ModifiedFiles); diff != "" {
+			t.Errorf("ModifiedFiles mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("testdata directories are processed when enabled", func(t *testing.T) {
+		tmpDir := setupTestModule(t, map[string]string{
+			"main.go": "package main\n",
+			"testdata/fixture/fixture.go": `package fixture
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+		})
+
+		proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithTestdata(true))
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		result, err := proc.Process([]string{"./...", "./testdata/fixture"})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+
+		if diff := cmp.Diff([]string{filepath.Join(tmpDir, "testdata", "fixture", "fixture.go")}, result.ModifiedFiles); diff != "" {
+			t.Errorf("ModifiedFiles mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("tests_only processes test files only", func(t *testing.T) {
+		tmpDir := setupTestModule(t, map[string]string{
+			"main.go": `package main
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+			"main_test.go": `package main
+
+import "context"
+
+func helper(ctx context.Context) {
+}
+`,
+		})
+
+		proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithFunctions(config.Functions{Files: config.FilesTestsOnly}))
+
+		oldWd, _ := os.Getwd()
+		_ = os.Chdir(tmpDir)
+		defer func() { _ = os.Chdir(oldWd) }()
+
+		result, err := proc.Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+
+		if diff := cmp.Diff([]string{filepath.Join(tmpDir, "main_test.go")}, result.ModifiedFiles); diff != "" {
+			t.Errorf("ModifiedFiles mismatch (-want +got):\n%s", diff)
+		}
+	})
+
 	t.Run("verbose mode prints modified files", func(t *testing.T) {
 		tmpDir := setupTestModule(t, map[string]string{
 			"main.go": `package main
@@ -975,3 +1100,1596 @@ func Foo(ctx context.Context) {
 		}
 	})
 }
+
+// TestProcess_Verify tests type-checking modified packages after writing.
+func TestProcess_IgnoreFile(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		".ctxweaverignore": "handler/giant_generated_handler.go\n",
+		"handler/handler.go": `package handler
+
+import "context"
+
+func Handle(ctx context.Context) {
+}
+`,
+		"handler/giant_generated_handler.go": `package handler
+
+import "context"
+
+func Giant(ctx context.Context) {
+}
+`,
+	})
+
+	proc := processor.New(registry, tmpl, nil)
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.FilesProcessed != 1 {
+		t.Errorf("FilesProcessed = %d, want 1", result.FilesProcessed)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "handler/giant_generated_handler.go"))
+	if strings.Contains(string(content), "defer trace(ctx)") {
+		t.Errorf("giant_generated_handler.go should not be modified (ignored)")
+	}
+	content, _ = os.ReadFile(filepath.Join(tmpDir, "handler/handler.go"))
+	if !strings.Contains(string(content), "defer trace(ctx)") {
+		t.Errorf("handler.go should be modified")
+	}
+}
+
+func TestProcess_IncludeGenerated(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	generated := func(name string) string {
+		return `// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package service
+
+import "context"
+
+func ` + name + `(ctx context.Context) {
+}
+`
+	}
+
+	tests := map[string]struct {
+		include config.IncludeGenerated
+		want    []string
+	}{
+		"left alone by default": {
+			want: []string{"service/service.go"},
+		},
+		"all": {
+			include: config.IncludeGenerated{All: true},
+			want:    []string{"service/service.go", "service/service_grpc.pb.go", "service/wire_gen.go"},
+		},
+		"matching regexps": {
+			include: config.IncludeGenerated{Regexps: []string{`^service/.*_grpc\.pb\.go$`}},
+			want:    []string{"service/service.go", "service/service_grpc.pb.go"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := setupTestModule(t, map[string]string{
+				"service/service.go": `package service
+
+import "context"
+
+func Get(ctx context.Context) {
+}
+`,
+				"service/service_grpc.pb.go": generated("Serve"),
+				"service/wire_gen.go":        generated("Wire"),
+				// Scaffold files are left alone even when all generated files are included
+				"trace/trace.go": scaffold.Marker + `
+
+package trace
+
+import "context"
+
+func Start(ctx context.Context) {
+}
+`,
+			})
+
+			oldWd, _ := os.Getwd()
+			_ = os.Chdir(tmpDir)
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			proc := processor.New(registry, tmpl, nil, processor.WithIncludeGenerated(tt.include))
+			result, err := proc.Process([]string{"./..."})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+
+			var got []string
+			for _, f := range result.ModifiedFiles {
+				rel, _ := filepath.Rel(tmpDir, f)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			slices.Sort(got)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ModifiedFiles mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProcess_Overrides(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	spanTmpl, _ := template.Parse(`defer span({{.Ctx}}, {{.FuncName | quote}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go": `package main
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+		"handler/handler.go": `package handler
+
+import "context"
+
+func Handle(ctx context.Context) {
+}
+
+func helper(ctx context.Context) {
+}
+`,
+	})
+
+	proc := processor.New(registry, tmpl, nil,
+		processor.WithOverrides(processor.PackageOverride{
+			Packages:  []*regexp.Regexp{regexp.MustCompile(`/handler$`)},
+			Template:  spanTmpl,
+			Functions: processor.NewFuncFilter(config.Functions{Scopes: []config.FuncScope{config.FuncScopeExported}}),
+		}),
+	)
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	if _, err := proc.Process([]string{"./..."}); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "main.go"))
+	if !strings.Contains(string(content), "defer trace(ctx)") {
+		t.Errorf("main.go should use the base template:\n%s", content)
+	}
+
+	content, _ = os.ReadFile(filepath.Join(tmpDir, "handler/handler.go"))
+	if !strings.Contains(string(content), `defer span(ctx, "handler.Handle")`) {
+		t.Errorf("handler.go should use the override template:\n%s", content)
+	}
+	if strings.Contains(string(content), "trace(") || strings.Contains(string(content), `"handler.helper"`) {
+		t.Errorf("handler.go should only instrument exported functions with the override template:\n%s", content)
+	}
+}
+
+func TestProcess_ReachableFrom(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go": `package main
+
+import (
+	"context"
+
+	"testmod/service"
+)
+
+func main() {
+	run(context.Background())
+}
+
+func run(ctx context.Context) {
+	svc := &service.Service{}
+	go func() {
+		svc.Get(ctx)
+	}()
+}
+
+func unused(ctx context.Context) {
+}
+`,
+		"service/service.go": `package service
+
+import "context"
+
+type Service struct{}
+
+func (s *Service) Get(ctx context.Context) {
+	load(ctx)
+}
+
+func (s *Service) Delete(ctx context.Context) {
+}
+
+func load(ctx context.Context) {
+}
+`,
+	})
+
+	proc := processor.New(registry, tmpl, nil,
+		processor.WithFunctions(config.Functions{ReachableFrom: []string{"main.main"}}),
+	)
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	if _, err := proc.Process([]string{"./..."}); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "main.go"))
+	if !strings.Contains(string(content), "func run(ctx context.Context) {\n\tdefer trace(ctx)") {
+		t.Errorf("run should be instrumented:\n%s", content)
+	}
+	if strings.Contains(string(content), "func unused(ctx context.Context) {\n\tdefer trace(ctx)") {
+		t.Errorf("unused should not be instrumented:\n%s", content)
+	}
+
+	content, _ = os.ReadFile(filepath.Join(tmpDir, "service/service.go"))
+	for _, fn := range []string{"(s *Service) Get", "load"} {
+		if !strings.Contains(string(content), "func "+fn+"(ctx context.Context) {\n\tdefer trace(ctx)") {
+			t.Errorf("%s should be instrumented (reachable through a closure):\n%s", fn, content)
+		}
+	}
+	if strings.Contains(string(content), "func (s *Service) Delete(ctx context.Context) {\n\tdefer trace(ctx)") {
+		t.Errorf("Delete should not be instrumented:\n%s", content)
+	}
+}
+
+func TestProcess_SkipDelegates(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"service/api.go": `package service
+
+import "context"
+
+func Get(ctx context.Context, id int) string {
+	return get(ctx, id)
+}
+`,
+		"service/impl.go": `package service
+
+import "context"
+
+func get(ctx context.Context, id int) string {
+	println(id)
+	return ""
+}
+`,
+	})
+
+	proc := processor.New(registry, tmpl, nil,
+		processor.WithFunctions(config.Functions{SkipDelegates: true}),
+	)
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.FilesModified != 1 {
+		t.Errorf("FilesModified = %d, want 1", result.FilesModified)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "service/api.go"))
+	if strings.Contains(string(content), "defer trace(ctx)") {
+		t.Errorf("Get delegates to get in another file and should not be instrumented:\n%s", content)
+	}
+	content, _ = os.ReadFile(filepath.Join(tmpDir, "service/impl.go"))
+	if !strings.Contains(string(content), "defer trace(ctx)") {
+		t.Errorf("get should be instrumented:\n%s", content)
+	}
+}
+
+func TestProcess_Implements(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"domain/domain.go": `package domain
+
+import "context"
+
+type Repository interface {
+	Find(ctx context.Context, id int) (string, error)
+}
+`,
+		"infra/infra.go": `package infra
+
+import (
+	"context"
+
+	"testmod/domain"
+)
+
+var _ domain.Repository = (*UserRepository)(nil)
+
+type UserRepository struct{}
+
+func (r *UserRepository) Find(ctx context.Context, id int) (string, error) {
+	return "", nil
+}
+
+func (r *UserRepository) Count(ctx context.Context) int {
+	return 0
+}
+
+type Cache struct{}
+
+func (c Cache) Find(ctx context.Context) {
+}
+
+func Find(ctx context.Context, id int) (string, error) {
+	return "", nil
+}
+`,
+	})
+
+	proc := processor.New(registry, tmpl, nil,
+		processor.WithFunctions(config.Functions{Implements: []string{"domain.Repository"}}),
+	)
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	if _, err := proc.Process([]string{"./..."}); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "infra/infra.go"))
+	if !strings.Contains(string(content), "(string, error) {\n\tdefer trace(ctx)\n\n\treturn \"\", nil\n}\n\nfunc (r *UserRepository) Count") {
+		t.Errorf("UserRepository.Find should be instrumented:\n%s", content)
+	}
+	if strings.Count(string(content), "defer trace(ctx)") != 1 {
+		t.Errorf("only UserRepository.Find should be instrumented:\n%s", content)
+	}
+}
+
+func TestProcess_Progress(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go": `package main
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+		"handler/handler.go": `package handler
+
+func Handle() {
+}
+`,
+		"handler/util.go": `package handler
+
+import "context"
+
+func util(ctx context.Context) {
+}
+`,
+	})
+
+	var reports []processor.Progress
+	proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true),
+		processor.WithProgress(func(p processor.Progress) { reports = append(reports, p) }),
+	)
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	want := []processor.PackageResult{
+		{PkgPath: "testmod", Module: "testmod", FilesProcessed: 1, FilesModified: 1},
+		{PkgPath: "testmod/handler", Module: "testmod", FilesProcessed: 2, FilesModified: 1},
+	}
+	if diff := cmp.Diff(want, result.Packages); diff != "" {
+		t.Errorf("Packages mismatch (-want +got):\n%s", diff)
+	}
+
+	// Before loading, after loading, after each file and at the end
+	wantReports := []processor.Progress{
+		{},
+		{Packages: 2},
+		{Packages: 2, PackagesDone: 0, FilesProcessed: 1},
+		{Packages: 2, PackagesDone: 1, FilesProcessed: 2},
+		{Packages: 2, PackagesDone: 1, FilesProcessed: 3},
+		{Packages: 2, PackagesDone: 2, FilesProcessed: 3},
+	}
+	if diff := cmp.Diff(wantReports, reports); diff != "" {
+		t.Errorf("progress mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProcess_BatchSize(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go": `package main
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+		"handler/handler.go": `package handler
+
+import "context"
+
+func Handle(ctx context.Context) {
+}
+`,
+		"handler/handler_test.go": `package handler
+
+import "context"
+
+func helper(ctx context.Context) {
+}
+`,
+		"store/store.go": `package store
+
+import "context"
+
+func Get(ctx context.Context) {
+}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	process := func(batchSize int) *processor.ProcessResult {
+		t.Helper()
+		var last processor.Progress
+		proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithTest(true),
+			processor.WithBatchSize(batchSize),
+			processor.WithProgress(func(p processor.Progress) { last = p }),
+		)
+		result, err := proc.Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process(batch size %d) failed: %v", batchSize, err)
+		}
+		if last.PackagesDone != last.Packages {
+			t.Errorf("Process(batch size %d) progress = %+v, want all packages done", batchSize, last)
+		}
+		// Packages are in load order, which differs between batches and a single load
+		slices.SortFunc(result.Packages, func(a, b processor.PackageResult) int { return strings.Compare(a.PkgPath, b.PkgPath) })
+		slices.SortFunc(result.ModifiedFuncs, func(a, b processor.FuncChange) int { return strings.Compare(a.File, b.File) })
+		return result
+	}
+
+	// Batches give the same result as a single load
+	want := process(0)
+	for _, batchSize := range []int{1, 2} {
+		got := process(batchSize)
+		if diff := cmp.Diff(want.Packages, got.Packages); diff != "" {
+			t.Errorf("Packages with batch size %d mismatch (-want +got):\n%s", batchSize, diff)
+		}
+		if diff := cmp.Diff(want.ModifiedFuncs, got.ModifiedFuncs); diff != "" {
+			t.Errorf("ModifiedFuncs with batch size %d mismatch (-want +got):\n%s", batchSize, diff)
+		}
+	}
+	if len(want.ModifiedFuncs) == 0 {
+		t.Error("ModifiedFuncs is empty")
+	}
+
+	// The call graph needs all packages at once
+	proc := processor.New(registry, tmpl, nil, processor.WithBatchSize(1),
+		processor.WithFunctions(config.Functions{ReachableFrom: []string{"testmod.Foo"}}),
+	)
+	if _, err := proc.Process([]string{"./..."}); err == nil || !strings.Contains(err.Error(), "cannot be loaded in batches") {
+		t.Errorf("Process() error = %v, want a batch error", err)
+	}
+}
+
+func TestProcess_PatchDir(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	src := `package handler
+
+import "context"
+
+func Handle(ctx context.Context) {
+}
+`
+	tmpDir := setupTestModule(t, map[string]string{
+		"handler/handler.go": src,
+		"handler/util.go": `package handler
+
+func util() {
+}
+`,
+	})
+	patchDir := filepath.Join(t.TempDir(), "patches")
+	proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithPatchDir(patchDir))
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	patchFile := filepath.Join(patchDir, "handler", "handler.go.patch")
+	if diff := cmp.Diff([]string{patchFile}, result.Patches); diff != "" {
+		t.Errorf("Patches mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err := os.ReadFile(patchFile)
+	if err != nil {
+		t.Fatalf("failed to read patch: %v", err)
+	}
+	want := `diff --git a/handler/handler.go b/handler/handler.go
+--- a/handler/handler.go
++++ b/handler/handler.go
+@@ -3,4 +3,6 @@
+ import "context"
+` + " \n" + ` func Handle(ctx context.Context) {
++	defer trace(ctx)
++
+ }
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("patch mismatch (-want +got):\n%s", diff)
+	}
+
+	// Sources are left alone
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "handler", "handler.go"))
+	if string(content) != src {
+		t.Errorf("source modified in dry run mode:\n%s", content)
+	}
+}
+
+func TestProcess_OutputDir(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	src := `package handler
+
+import "context"
+
+func Handle(ctx context.Context) {
+}
+`
+	util := `package handler
+
+func util() {
+}
+`
+	tmpDir := setupTestModule(t, map[string]string{
+		"handler/handler.go": src,
+		"handler/util.go":    util,
+	})
+	outputDir := filepath.Join(t.TempDir(), "out")
+	proc := processor.New(registry, tmpl, nil, processor.WithOutputDir(outputDir))
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Process errors: %v", result.Errors)
+	}
+	if result.FilesModified != 1 {
+		t.Errorf("FilesModified = %d, want 1", result.FilesModified)
+	}
+
+	// Every processed file is written, modified or not
+	want := map[string]string{
+		"handler/handler.go": `package handler
+
+import "context"
+
+func Handle(ctx context.Context) {
+	defer trace(ctx)
+
+}
+`,
+		"handler/util.go": util,
+	}
+	got := make(map[string]string)
+	_ = filepath.WalkDir(outputDir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(outputDir, path)
+			content, _ := os.ReadFile(path)
+			got[filepath.ToSlash(rel)] = string(content)
+		}
+		return err
+	})
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("output directory mismatch (-want +got):\n%s", diff)
+	}
+
+	// Sources are left alone
+	content, _ := os.ReadFile(filepath.Join(tmpDir, "handler", "handler.go"))
+	if string(content) != src {
+		t.Errorf("source modified with an output directory:\n%s", content)
+	}
+}
+
+func TestProcess_OutputDirInsideModule(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	src := `package handler
+
+import "context"
+
+func trace(context.Context) {}
+
+func Handle(ctx context.Context) {
+}
+`
+	tmpDir := setupTestModule(t, map[string]string{
+		"handler/handler.go": src,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	// The second run loads the copies written by the first one too
+	for range 2 {
+		proc := processor.New(registry, tmpl, nil, processor.WithOutputDir("woven"))
+		result, err := proc.Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if len(result.Errors) > 0 {
+			t.Fatalf("Process errors: %v", result.Errors)
+		}
+		if result.FilesProcessed != 1 {
+			t.Errorf("FilesProcessed = %d, want 1", result.FilesProcessed)
+		}
+	}
+
+	var got []string
+	_ = filepath.WalkDir(filepath.Join(tmpDir, "woven"), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(tmpDir, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if diff := cmp.Diff([]string{"woven/handler/handler.go"}, got); diff != "" {
+		t.Errorf("output directory mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProcess_Overlay(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	saved := `package handler
+
+func Handle() {
+}
+`
+	tmpDir := setupTestModule(t, map[string]string{"handler/handler.go": saved})
+	filename := filepath.Join(tmpDir, "handler", "handler.go")
+
+	// The unsaved buffer adds a context parameter
+	overlay := map[string][]byte{filename: []byte(`package handler
+
+import "context"
+
+func Handle(ctx context.Context) {
+}
+`)}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	t.Run("dry run patch applies to the overlay", func(t *testing.T) {
+		patchDir := filepath.Join(t.TempDir(), "patches")
+		proc := processor.New(registry, tmpl, nil,
+			processor.WithDryRun(true), processor.WithPatchDir(patchDir), processor.WithOverlay(overlay))
+
+		result, err := proc.Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if diff := cmp.Diff([]string{filename}, result.ModifiedFiles); diff != "" {
+			t.Errorf("ModifiedFiles mismatch (-want +got):\n%s", diff)
+		}
+
+		got, err := os.ReadFile(filepath.Join(patchDir, "handler", "handler.go.patch"))
+		if err != nil {
+			t.Fatalf("failed to read patch: %v", err)
+		}
+		want := `diff --git a/handler/handler.go b/handler/handler.go
+--- a/handler/handler.go
++++ b/handler/handler.go
+@@ -3,4 +3,6 @@
+ import "context"
+` + " \n" + ` func Handle(ctx context.Context) {
++	defer trace(ctx)
++
+ }
+`
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Errorf("patch mismatch (-want +got):\n%s", diff)
+		}
+
+		content, _ := os.ReadFile(filename)
+		if string(content) != saved {
+			t.Errorf("source modified in dry run mode:\n%s", content)
+		}
+	})
+
+	t.Run("written file is verified as written", func(t *testing.T) {
+		proc := processor.New(registry, tmpl, nil, processor.WithOverlay(overlay), processor.WithVerify(true))
+
+		result, err := proc.Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		// trace is undefined in the written file, unlike in the overlay
+		if len(result.VerifyErrors) == 0 {
+			t.Error("expected verification errors for the written file")
+		}
+
+		content, _ := os.ReadFile(filename)
+		if !strings.Contains(string(content), "func Handle(ctx context.Context) {\n\tdefer trace(ctx)\n") {
+			t.Errorf("overlay contents should be written with the statement:\n%s", content)
+		}
+	})
+}
+
+func TestProcess_Selection(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	src := `package main
+
+import "context"
+
+func Foo(ctx context.Context) {
+	println()
+}
+
+func Bar(ctx context.Context) {
+}
+`
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go":  src,
+		"other.go": "package main\n\nimport \"context\"\n\nfunc Baz(ctx context.Context) {\n}\n",
+	})
+	filename := filepath.Join(tmpDir, "main.go")
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	tests := map[string]struct {
+		selection processor.Option
+		want      string
+	}{
+		"line inside the body": {
+			selection: processor.WithSelection(filename, 6),
+			want:      "func Foo(ctx context.Context) {\n\tdefer trace(ctx)\n\n\tprintln()\n}\n\nfunc Bar(ctx context.Context) {\n}\n",
+		},
+		"line on the closing brace": {
+			selection: processor.WithSelection(filename, 10),
+			want:      "func Foo(ctx context.Context) {\n\tprintln()\n}\n\nfunc Bar(ctx context.Context) {\n\tdefer trace(ctx)\n\n}\n",
+		},
+		"line outside functions": {
+			selection: processor.WithSelection(filename, 2),
+		},
+		"function key": {
+			selection: processor.WithFuncSelection("testmod.Bar"),
+			want:      "func Foo(ctx context.Context) {\n\tprintln()\n}\n\nfunc Bar(ctx context.Context) {\n\tdefer trace(ctx)\n\n}\n",
+		},
+		"unknown function key": {
+			selection: processor.WithFuncSelection("testmod.Qux"),
+		},
+		"file": {
+			selection: processor.WithFileSelection(filename),
+			want:      "func Foo(ctx context.Context) {\n\tdefer trace(ctx)\n\n\tprintln()\n}\n\nfunc Bar(ctx context.Context) {\n\tdefer trace(ctx)\n\n}\n",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil,
+				processor.WithDryRun(true), processor.WithKeepContents(true), tt.selection)
+
+			result, err := proc.Process([]string{"./..."})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+
+			if result.Selected != (tt.want != "") {
+				t.Errorf("Selected = %v, want %v", result.Selected, tt.want != "")
+			}
+			content, ok := result.Contents[filename]
+			if tt.want == "" {
+				if ok || len(result.ModifiedFiles) > 0 {
+					t.Errorf("expected no modification, got %v:\n%s", result.ModifiedFiles, content)
+				}
+				return
+			}
+			if diff := cmp.Diff([]string{filename}, result.ModifiedFiles); diff != "" {
+				t.Errorf("ModifiedFiles mismatch (-want +got):\n%s", diff)
+			}
+			if !strings.HasSuffix(string(content), tt.want) {
+				t.Errorf("unexpected content:\n%s", content)
+			}
+		})
+	}
+
+	// Sources are left alone in dry run mode
+	content, _ := os.ReadFile(filename)
+	if string(content) != src {
+		t.Errorf("source modified in dry run mode:\n%s", content)
+	}
+}
+
+func TestProcess_KeepDiffs(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	src := "package main\n\nimport \"context\"\n\nfunc Foo(ctx context.Context) {\n\tprintln()\n}\n"
+	tmpDir := setupTestModule(t, map[string]string{"sub/main.go": src})
+	filename := filepath.Join(tmpDir, "sub", "main.go")
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithKeepDiffs(true))
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	want := `diff --git a/sub/main.go b/sub/main.go
+--- a/sub/main.go
++++ b/sub/main.go
+@@ -3,5 +3,7 @@
+ import "context"
+ 
+ func Foo(ctx context.Context) {
++	defer trace(ctx)
++
+ 	println()
+ }
+`
+	if diff := cmp.Diff(want, string(result.Diffs[filename])); diff != "" {
+		t.Errorf("Diffs mismatch (-want +got):\n%s", diff)
+	}
+
+	// Sources are left alone in dry run mode
+	content, _ := os.ReadFile(filename)
+	if string(content) != src {
+		t.Errorf("source modified in dry run mode:\n%s", content)
+	}
+}
+
+func TestProcess_LocationVars(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}}, {{.ModulePath | quote}}, {{.PackageRelPath | quote}}, {{.FileName | quote}}, {{.FileBase | quote}}, {{.BuildTags | quote}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"sub/main.go":        "package main\n\nimport \"context\"\n\nfunc Foo(ctx context.Context) {\n}\n",
+		"sub/main_tagged.go": "//go:build go1.21 || integration\n\npackage main\n\nimport \"context\"\n\nfunc Bar(ctx context.Context) {\n}\n",
+	})
+
+	// File names are relative to the module root, not to the working directory
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(filepath.Join(tmpDir, "sub"))
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithKeepContents(true))
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	tests := map[string]string{
+		"main.go":        `defer trace(ctx, "testmod", "sub", "sub/main.go", "main.go", "")`,
+		"main_tagged.go": `defer trace(ctx, "testmod", "sub", "sub/main_tagged.go", "main_tagged.go", "go1.21 || integration")`,
+	}
+	for file, want := range tests {
+		got := string(result.Contents[filepath.Join(tmpDir, "sub", file)])
+		if !strings.Contains(got, want) {
+			t.Errorf("%s = %s, want containing %s", file, got, want)
+		}
+	}
+}
+
+func TestProcess_ModifiedFuncs(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go": `package main
+
+import "context"
+
+func trace(context.Context, string) {}
+
+type Service struct{}
+
+func (s *Service) Get(ctx context.Context) {
+}
+
+func Woven(ctx context.Context) {
+	defer trace(ctx, "main.Woven")
+}
+
+func Outdated(ctx context.Context) {
+	defer trace(ctx, "Old")
+}
+
+func Protected(ctx context.Context) {
+	defer trace(ctx, "Old") //ctxweaver:skip
+}
+`,
+	})
+	proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true))
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	if len(result.ModifiedFiles) != 1 {
+		t.Fatalf("ModifiedFiles = %v, want 1 file (errors: %v)", result.ModifiedFiles, result.Errors)
+	}
+	file := result.ModifiedFiles[0]
+	want := []processor.FuncChange{
+		{File: file, Line: 9, EndLine: 10, Func: "(*Service).Get", Op: "insert", Reason: "missing instrumentation"},
+		{File: file, Line: 16, EndLine: 18, Func: "Outdated", Op: "update", Reason: "outdated instrumentation"},
+	}
+	if diff := cmp.Diff(want, result.ModifiedFuncs); diff != "" {
+		t.Errorf("ModifiedFuncs mismatch (-want +got):\n%s", diff)
+	}
+	wantProtected := []processor.FuncChange{
+		{File: file, Line: 20, EndLine: 22, Func: "Protected", Reason: "protected by //ctxweaver:skip"},
+	}
+	if diff := cmp.Diff(wantProtected, result.ProtectedFuncs); diff != "" {
+		t.Errorf("ProtectedFuncs mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestProcess_MultiModule(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	src := func(pkg string) string {
+		return "package " + pkg + `
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+}
+`
+	}
+	files := map[string]string{
+		"main.go":             src("main"),
+		"svc/go.mod":          "module example.com/svc\n\ngo 1.21\n",
+		"svc/svc.go":          src("svc"),
+		"svc/api/api.go":      src("api"),
+		"svc/tools/go.mod":    "module example.com/svc/tools\n\ngo 1.21\n",
+		"svc/tools/tools.go":  src("tools"),
+		"_archive/go.mod":     "module example.com/archive\n\ngo 1.21\n",
+		"_archive/archive.go": src("archive"),
+	}
+
+	tests := map[string]struct {
+		patterns     []string
+		noRootModule bool
+		want         []string
+	}{
+		"nested modules": {
+			patterns: []string{"./..."},
+			want:     []string{"testmod", "example.com/svc", "example.com/svc/api", "example.com/svc/tools"},
+		},
+		"without root module": {
+			patterns:     []string{"./..."},
+			noRootModule: true,
+			want:         []string{"example.com/svc", "example.com/svc/api", "example.com/svc/tools"},
+		},
+		"pattern inside nested module": {
+			patterns: []string{"./svc/api/..."},
+			want:     []string{"example.com/svc/api"},
+		},
+		"non-recursive pattern": {
+			patterns: []string{"."},
+			want:     []string{"testmod"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := setupTestModule(t, files)
+			if tt.noRootModule {
+				_ = os.Remove(filepath.Join(tmpDir, "go.mod"))
+				_ = os.Remove(filepath.Join(tmpDir, "main.go"))
+			}
+			proc := processor.New(registry, tmpl, nil, processor.WithVerify(true))
+
+			oldWd, _ := os.Getwd()
+			_ = os.Chdir(tmpDir)
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			result, err := proc.Process(tt.patterns)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if len(result.Errors) > 0 || len(result.VerifyErrors) > 0 {
+				t.Fatalf("unexpected errors: %v %v", result.Errors, result.VerifyErrors)
+			}
+
+			var got []string
+			for _, pr := range result.Packages {
+				if pr.FilesModified > 0 {
+					got = append(got, pr.PkgPath)
+				}
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("modified packages mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProcess_Workspace(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	src := func(pkg string) string {
+		return "package " + pkg + `
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+}
+`
+	}
+	tmpDir := setupTestModule(t, map[string]string{
+		"ws/go.work":                "go 1.21\n\nuse (\n\t./a\n\t../shared\n)\n",
+		"ws/a/go.mod":               "module example.com/a\n\ngo 1.21\n",
+		"ws/a/a.go":                 src("a"),
+		"ws/a/handler/handler.go":   src("handler"),
+		"shared/go.mod":             "module example.com/shared\n\ngo 1.21\n",
+		"shared/shared.go":          src("shared"),
+		"shared/handler/handler.go": src("handler"),
+	})
+
+	tests := map[string]struct {
+		patterns []string
+		want     []processor.ModuleResult
+	}{
+		"all packages of every module": {
+			patterns: []string{"./..."},
+			want: []processor.ModuleResult{
+				{Path: "example.com/a", Packages: 2, FilesProcessed: 2, FilesModified: 2},
+				{Path: "example.com/shared", Packages: 2, FilesProcessed: 2, FilesModified: 2},
+			},
+		},
+		"relative pattern in every module": {
+			patterns: []string{"./handler"},
+			want: []processor.ModuleResult{
+				{Path: "example.com/a", Packages: 1, FilesProcessed: 1, FilesModified: 1},
+				{Path: "example.com/shared", Packages: 1, FilesProcessed: 1, FilesModified: 1},
+			},
+		},
+		"import path": {
+			patterns: []string{"example.com/shared/..."},
+			want: []processor.ModuleResult{
+				{Path: "example.com/shared", Packages: 2, FilesProcessed: 2, FilesModified: 2},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true))
+
+			oldWd, _ := os.Getwd()
+			_ = os.Chdir(filepath.Join(tmpDir, "ws"))
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			result, err := proc.Process(tt.patterns)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if len(result.Errors) > 0 {
+				t.Fatalf("unexpected errors: %v", result.Errors)
+			}
+			if diff := cmp.Diff(tt.want, result.Modules); diff != "" {
+				t.Errorf("Modules mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProcess_LoadMode(t *testing.T) {
+	tmpl, _ := template.Parse(`defer trace({{.Ctx}})`)
+
+	// The service has a type error and imports a package whose name differs
+	// from the last element of its path
+	files := map[string]string{
+		"lib/v2/lib.go": `package lib
+
+func Do() {}
+`,
+		"svc/svc.go": `package svc
+
+import (
+	"context"
+
+	"testmod/lib/v2"
+)
+
+var broken int = "type error"
+
+func Foo(ctx context.Context) {
+	lib.Do()
+}
+`,
+	}
+	contextOnly := func() *config.CarrierRegistry {
+		r := config.NewCarrierRegistry(false)
+		r.Register(config.CarrierDef{Package: "context", Type: "Context"})
+		return r
+	}
+
+	tests := map[string]struct {
+		registry  *config.CarrierRegistry
+		opts      []processor.Option
+		wantTyped bool
+	}{
+		"typed": {
+			registry:  contextOnly(),
+			opts:      []processor.Option{processor.WithLoadMode(config.LoadTyped)},
+			wantTyped: true,
+		},
+		"syntax": {
+			registry: contextOnly(),
+			opts:     []processor.Option{processor.WithLoadMode(config.LoadSyntax)},
+		},
+		"syntax with embedded carrier": {
+			registry:  config.NewCarrierRegistry(true),
+			opts:      []processor.Option{processor.WithLoadMode(config.LoadSyntax)},
+			wantTyped: true,
+		},
+		"syntax with implements filter": {
+			registry: contextOnly(),
+			opts: []processor.Option{
+				processor.WithLoadMode(config.LoadSyntax),
+				processor.WithFunctions(config.Functions{Implements: []string{"io.Closer"}}),
+			},
+			wantTyped: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := setupTestModule(t, files)
+			proc := processor.New(tt.registry, tmpl, nil, tt.opts...)
+
+			oldWd, _ := os.Getwd()
+			_ = os.Chdir(tmpDir)
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			result, err := proc.Process([]string{"./..."})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+
+			// Type errors are only reported when type information is loaded
+			if got := len(result.Errors) > 0; got != tt.wantTyped {
+				t.Fatalf("type errors reported = %v, want %v: %v", got, tt.wantTyped, result.Errors)
+			}
+			if tt.wantTyped {
+				return
+			}
+
+			content, _ := os.ReadFile(filepath.Join(tmpDir, "svc/svc.go"))
+			want := `package svc
+
+import (
+	"context"
+
+	"testmod/lib/v2"
+)
+
+var broken int = "type error"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+
+	lib.Do()
+}
+`
+			if diff := cmp.Diff(want, string(content)); diff != "" {
+				t.Errorf("content mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProcess_Verify(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	src := `package main
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+}
+`
+
+	tests := map[string]struct {
+		tmpl         string
+		tests        bool
+		rollback     bool
+		wantErrors   bool
+		wantRollback bool
+	}{
+		"valid template": {
+			tmpl: `defer trace({{.Ctx}})`,
+		},
+		"undefined function is reported": {
+			tmpl:       `defer undefinedTrace({{.Ctx}})`,
+			wantErrors: true,
+		},
+		"undefined function is rolled back": {
+			tmpl:         `defer undefinedTrace({{.Ctx}})`,
+			rollback:     true,
+			wantErrors:   true,
+			wantRollback: true,
+		},
+		"undefined function is rolled back with tests": {
+			tmpl:         `defer undefinedTrace({{.Ctx}})`,
+			tests:        true,
+			rollback:     true,
+			wantErrors:   true,
+			wantRollback: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := setupTestModule(t, map[string]string{
+				"main.go":      src,
+				"main_test.go": "package main\n\nimport \"testing\"\n\nfunc TestFoo(t *testing.T) {}\n",
+			})
+			proc := processor.New(registry, template.MustParse(tt.tmpl), nil, processor.WithTest(tt.tests), processor.WithVerify(true), processor.WithRollback(tt.rollback))
+
+			oldWd, _ := os.Getwd()
+			_ = os.Chdir(tmpDir)
+			defer func() { _ = os.Chdir(oldWd) }()
+
+			result, err := proc.Process([]string{"./..."})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if result.FilesModified != 1 {
+				t.Errorf("FilesModified = %d, want 1", result.FilesModified)
+			}
+			if got := len(result.VerifyErrors) > 0; got != tt.wantErrors {
+				t.Errorf("VerifyErrors = %v, want errors: %v", result.VerifyErrors, tt.wantErrors)
+			}
+			if got := len(result.RolledBack) > 0; got != tt.wantRollback {
+				t.Errorf("RolledBack = %v, want rollback: %v", result.RolledBack, tt.wantRollback)
+			}
+
+			content, _ := os.ReadFile(filepath.Join(tmpDir, "main.go"))
+			if restored := string(content) == src; restored != tt.wantRollback {
+				t.Errorf("file restored = %v, want %v:\n%s", restored, tt.wantRollback, content)
+			}
+		})
+	}
+}
+
+func TestProcess_ErrorTypes(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace.Start({{.Ctx}})()`)
+	imports := []string{"testmod/trace"}
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"a/a.go": `package a
+
+func Broken() { undefinedVariable() }
+`,
+		"b/b.go": `package b
+
+import "context"
+
+var trace = 1
+
+func Foo(ctx context.Context) {}
+`,
+		"c/c.go": `package c
+
+import "context"
+
+func Bar(ctx context.Context) {}
+`,
+		"trace/trace.go": `package trace
+
+import "context"
+
+func Start(context.Context) func() { return func() {} }
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	t.Run("errors are typed", func(t *testing.T) {
+		proc := processor.New(registry, tmpl, imports, processor.WithDryRun(true))
+		result, err := proc.Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if len(result.Errors) < 2 {
+			t.Fatalf("Errors = %v, want errors of testmod/a and b.go", result.Errors)
+		}
+
+		// The type errors of testmod/a, then the collision in b.go
+		last := len(result.Errors) - 1
+		for _, e := range result.Errors[:last] {
+			var loadErr *processor.LoadError
+			if !errors.As(e, &loadErr) || loadErr.Package != "testmod/a" {
+				t.Errorf("error = %#v, want a LoadError of testmod/a", e)
+			}
+		}
+		var renderErr *processor.RenderError
+		if !errors.As(result.Errors[last], &renderErr) {
+			t.Fatalf("last error = %#v, want a RenderError", result.Errors[last])
+		}
+		if renderErr.Func != "Foo" || filepath.Base(renderErr.File) != "b.go" {
+			t.Errorf("RenderError = {File: %s, Func: %s}, want {File: .../b.go, Func: Foo}", renderErr.File, renderErr.Func)
+		}
+
+		// Other packages are processed
+		if diff := cmp.Diff([]string{filepath.Join(tmpDir, "c", "c.go")}, result.ModifiedFiles); diff != "" {
+			t.Errorf("ModifiedFiles mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("fail fast stops at the first error", func(t *testing.T) {
+		proc := processor.New(registry, tmpl, imports, processor.WithDryRun(true), processor.WithFailFast(true))
+		result, err := proc.Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if len(result.Errors) == 0 {
+			t.Fatal("Errors is empty, want the errors of testmod/a")
+		}
+		for _, e := range result.Errors {
+			var loadErr *processor.LoadError
+			if !errors.As(e, &loadErr) || loadErr.Package != "testmod/a" {
+				t.Errorf("error = %#v, want a LoadError of testmod/a", e)
+			}
+		}
+		if result.FilesProcessed != 0 || len(result.ModifiedFiles) != 0 {
+			t.Errorf("FilesProcessed = %d, ModifiedFiles = %v, want nothing processed", result.FilesProcessed, result.ModifiedFiles)
+		}
+	})
+}
+
+func TestProcess_External(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	const src = `package ext
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`
+	tmpDir := setupTestModule(t, map[string]string{
+		"go.mod":      "module testmod\n\ngo 1.21\n\nrequire example.com/dep v0.0.0\n\nreplace example.com/dep => ./_dep\n",
+		"_dep/go.mod": "module example.com/dep\n\ngo 1.21\n",
+		"_dep/dep.go": src,
+	})
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "ext.go"), []byte(src), 0o644); err != nil {
+		t.Fatalf("failed to write ext.go: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(tmpDir, "linked")); err != nil {
+		t.Skipf("symlinks are not supported: %v", err)
+	}
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	tests := map[string]struct {
+		pattern       string
+		allowExternal bool
+		wantErr       string
+	}{
+		"dependency module is refused": {
+			pattern: "example.com/dep",
+			wantErr: "refusing to modify a file of dependency module example.com/dep",
+		},
+		"file through a symbolic link is refused": {
+			pattern: "./linked",
+			wantErr: "outside module testmod",
+		},
+		"dependency module is allowed explicitly": {
+			pattern:       "example.com/dep",
+			allowExternal: true,
+		},
+		"file through a symbolic link is allowed explicitly": {
+			pattern:       "./linked",
+			allowExternal: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithAllowExternal(tt.allowExternal))
+			result, err := proc.Process([]string{tt.pattern})
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+
+			if tt.wantErr == "" {
+				if len(result.Errors) > 0 || result.FilesModified != 1 {
+					t.Errorf("FilesModified = %d, Errors = %v, want 1 file without errors", result.FilesModified, result.Errors)
+				}
+				return
+			}
+			var writeErr *processor.WriteError
+			if len(result.Errors) != 1 || !errors.As(result.Errors[0], &writeErr) || !strings.Contains(writeErr.Error(), tt.wantErr) {
+				t.Errorf("Errors = %v, want a WriteError containing %q", result.Errors, tt.wantErr)
+			}
+			if result.FilesModified != 0 {
+				t.Errorf("FilesModified = %d, want 0", result.FilesModified)
+			}
+		})
+	}
+}
+
+func TestProcess_UniqueNames(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, "{{.FuncName | unique}}")`)
+
+	const src = `package handler
+
+import "context"
+
+type Server struct{}
+
+func (s *Server) Get(ctx context.Context) {
+}
+
+func trace(context.Context, string) {}
+`
+	tmpDir := setupTestModule(t, map[string]string{
+		"a/handler/handler.go": src,
+		"b/handler/handler.go": src,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	// Repeated runs give the same names
+	for range 2 {
+		proc := processor.New(registry, tmpl, nil)
+		result, err := proc.Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if len(result.Errors) > 0 {
+			t.Fatalf("unexpected errors: %v", result.Errors)
+		}
+
+		for file, want := range map[string]string{
+			"a/handler/handler.go": `defer trace(ctx, "handler.(*Server).Get")`,
+			"b/handler/handler.go": `defer trace(ctx, "handler.(*Server).Get#2")`,
+		} {
+			content, _ := os.ReadFile(filepath.Join(tmpDir, file))
+			if !strings.Contains(string(content), want) {
+				t.Errorf("%s should contain %s:\n%s", file, want, content)
+			}
+		}
+
+		want := []template.NameCollision{{
+			Name:   "handler.(*Server).Get",
+			Unique: "handler.(*Server).Get#2",
+			Func:   "testmod/b/handler.Server.Get",
+			Owner:  "testmod/a/handler.Server.Get",
+		}}
+		if diff := cmp.Diff(want, result.NameCollisions); diff != "" {
+			t.Errorf("NameCollisions mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestProcess_LayerTemplate(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`{{if .InPackage "handler"}}defer trace({{.Ctx}}){{end}}`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"handler/handler.go": "package handler\n\nimport \"context\"\n\nfunc trace(context.Context) {}\n\nfunc Get(ctx context.Context) {\n}\n",
+		"store/store.go":     "package store\n\nimport \"context\"\n\nfunc Load(ctx context.Context) {\n}\n",
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	for _, matching := range []config.MatchingMode{config.MatchingSkeleton, config.MatchingMarker} {
+		proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithMatching(matching))
+		result, err := proc.Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("%s: Process failed: %v", matching, err)
+		}
+		if len(result.Errors) > 0 {
+			t.Fatalf("%s: Process errors: %v", matching, result.Errors)
+		}
+		var funcs []string
+		for _, fc := range result.ModifiedFuncs {
+			funcs = append(funcs, fc.Func)
+		}
+		if diff := cmp.Diff([]string{"Get"}, funcs); diff != "" {
+			t.Errorf("%s: ModifiedFuncs mismatch (-want +got):\n%s", matching, diff)
+		}
+	}
+}
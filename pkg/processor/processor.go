@@ -3,11 +3,17 @@ package processor
 
 import (
 	"fmt"
+	"go/ast"
+	"go/types"
 	"os"
 	"regexp"
 
+	"github.com/dave/dst"
+
 	"github.com/mpyw/ctxweaver/internal"
+	"github.com/mpyw/ctxweaver/internal/ignore"
 	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/scaffold"
 	"github.com/mpyw/ctxweaver/pkg/template"
 )
 
@@ -75,14 +81,48 @@ type FuncFilter struct {
 	Types   []config.FuncType
 	Scopes  []config.FuncScope
 	Regexps CompiledRegexps
+	// Receivers are matched against receiver types of methods (e.g. "*Service").
+	Receivers CompiledRegexps
+	// Signatures are matched against canonical signatures
+	// (e.g. "(context.Context, int) (string, error)").
+	Signatures CompiledRegexps
+	// ReachableFrom are the call graph entrypoints functions must be reachable from.
+	// The call graph is built when packages are loaded, so TransformFile ignores them.
+	ReachableFrom []string
+	// MinStatements is the minimum number of statements in the function body,
+	// nested ones included. Zero means no minimum.
+	MinStatements int
+	// SkipDelegates skips functions whose body is a single call to another
+	// function of the same package that is instrumented.
+	SkipDelegates bool
+	// Implements restricts processing to methods of these interfaces ("name.Type"
+	// or "package/path.Type") implemented by their receiver. Interfaces are
+	// resolved with type information, so TransformFile ignores them.
+	Implements []string
+	// TestsOnly restricts processing to test files, whether or not test files
+	// are enabled.
+	TestsOnly bool
+	// HotPaths are matched against function names; matching functions get the
+	// template set by WithHotTemplate instead of the regular one.
+	HotPaths []*regexp.Regexp
+
+	reachable map[string]bool // Keys (see funcKey) of reachable functions; nil if not resolved
 }
 
 // NewFuncFilter creates a FuncFilter from config.Functions.
 func NewFuncFilter(f config.Functions) *FuncFilter {
 	return &FuncFilter{
-		Types:   f.Types,
-		Scopes:  f.Scopes,
-		Regexps: CompileRegexps(f.Regexps),
+		Types:         f.Types,
+		Scopes:        f.Scopes,
+		Regexps:       CompileRegexps(f.Regexps),
+		Receivers:     CompileRegexps(f.Receivers.Regexps),
+		Signatures:    CompileRegexps(f.Signatures.Regexps),
+		ReachableFrom: f.ReachableFrom,
+		MinStatements: f.MinStatements,
+		SkipDelegates: f.SkipDelegates,
+		Implements:    f.Implements,
+		TestsOnly:     f.Files == config.FilesTestsOnly,
+		HotPaths:      CompileRegexps(config.Regexps{Only: f.HotPaths}).Only,
 	}
 }
 
@@ -134,15 +174,73 @@ func (f *FuncFilter) Match(funcName string, isMethod, isExported bool) bool {
 
 // Processor handles code transformation.
 type Processor struct {
-	registry   *config.CarrierRegistry
-	tmpl       *template.Template
-	imports    []string
-	pkgRegexps CompiledRegexps // Regex patterns for package paths
-	funcFilter *FuncFilter     // Function filter
-	remove     bool            // Remove mode: remove generated statements instead of adding
-	test       bool
-	dryRun     bool
-	verbose    bool
+	registry        *config.CarrierRegistry
+	tmpl            *template.Template
+	epilogue        *template.Template     // Statements before every return, managed along with tmpl; nil if none
+	mutators        []Mutator              // Custom mutations applied after the template
+	plugins         []Plugin               // Review the rendered statements of every function
+	confirm         ConfirmFunc            // Asked before modifying every function; nil to modify them all
+	fieldInits      []FieldInit            // Fields ensured in the composite literals of struct types
+	conflicts       []string               // Patterns of calls revealing manual instrumentation
+	reportCreators  bool                   // Report functions without carrier creating their own context
+	addParam        bool                   // Add a ctx parameter to functions without carrier instead of weaving
+	specialFuncs    map[string]SpecialFunc // Weaving of main, init and TestMain, by name
+	hotTmpl         *template.Template     // Template of the functions on hot paths; nil to use the regular one
+	regularTmpl     *template.Template     // Template replaced by hotTmpl in the copies weaving hot paths; nil otherwise
+	hotBuildTag     string                 // Build tag of the variant files holding the instrumentation of hot paths
+	hotVariant      bool                   // Weave the hot paths left alone in place into the variant (see WithHotBuildTag)
+	buildTag        string                 // Build tag of the variant files written instead of modifying files in place
+	paramTargets    map[string]string      // Functions getting a ctx parameter in add-param mode, see resolveParamTargets
+	imports         []config.Import
+	pkgRegexps      CompiledRegexps        // Regex patterns for package paths
+	generatedAll    bool                   // Process all generated files
+	generated       []*regexp.Regexp       // Paths of the generated files processed anyway, relative to the module root
+	funcFilter      *FuncFilter            // Function filter
+	overrides       []PackageOverride      // Per-package overrides of the template, imports and function filter
+	ignore          *ignore.Matcher        // Files excluded by .ctxweaverignore files
+	baseline        *Baseline              // Functions excluded from insertion
+	sample          float64                // Percentage of the functions receiving insertions; 0 for all
+	carrierPriority []string               // Carrier names in priority order; any parameter may be the carrier if set
+	comparator      *Comparator            // Node comparator for existing statement detection
+	matching        config.MatchingMode    // How existing statements are matched against the template
+	marker          string                 // Generated marker comment in marker mode; empty for the default
+	runID           string                 // Run recorded by the generated marker; in remove mode, the run undone
+	requireClean    RequireClean           // How files with uncommitted changes are handled
+	refresh         config.RefreshMode     // When matched statements are considered outdated
+	load            config.LoadMode        // Package information loaded by Process and Coverage
+	format          config.FormatTool      // Final formatting step of modified files
+	batchSize       int                    // Packages loaded at a time by Process and Coverage; all at once if zero
+	remove          bool                   // Remove mode: remove generated statements instead of adding
+	dedupe          bool                   // Dedupe mode: collapse repeated generated statements into one
+	migrateToMarker bool                   // Migration mode: append the generated marker to existing statements
+	detectDrift     bool                   // Drift mode: only update marked statements generated from another template version
+	renames         map[string]string      // Rename mode: variables of existing statements renamed by the template (old to new)
+	pkgCandidates   packageCandidates      // Candidates of the whole package; set per package when delegates are skipped
+	pkgDecls        map[string]bool        // Package-level declarations of the package; set per package
+	interfaces      []*types.Interface     // Resolved interfaces of the function filter; set per package, nil if not resolved
+	ctxRewrite      string                 // Variable that replaces context references after the generated statements
+	banner          bool                   // Write a banner at the top of files containing generated statements
+	progress        func(Progress)         // Called as packages are loaded and files processed
+	patchDir        string                 // Dry run mode: directory receiving a patch file per modified file
+	outputDir       string                 // Directory receiving a copy of every processed file instead of modifying them in place
+	overlay         map[string][]byte      // Contents replacing files on disk, by absolute path
+	keepContents    bool                   // Dry run mode: record the contents of modified files in the result
+	keepDiffs       bool                   // Dry run mode: record the diffs of modified files in the result
+	selection       *selection             // Only process the selected function
+	selectedFunc    *dst.FuncDecl          // Selected function of the current file; set per file
+	modulePath      string                 // Module of the current package, as {{.ModulePath}}; set per package
+	goVersion       string                 // Go version of the module of the current package, for gofumpt; set per package
+	filename        string                 // Current file relative to its module root, as {{.FileName}}; set per file
+	names           *template.NameRegistry // Names made unique by the unique template function; set per run
+	dirtyFiles      map[string]bool        // Uncommitted changes of the files checked by checkClean, by filename; set per run
+	verify          bool                   // Verify mode: type-check modified packages after writing
+	rollback        bool                   // Restore the files of packages that fail verification
+	failFast        bool                   // Stop processing at the first error
+	allowExternal   bool                   // Modify files outside the main modules
+	test            bool
+	testdata        bool // Process files in testdata directories
+	dryRun          bool
+	verbose         bool
 }
 
 // Option configures a Processor.
@@ -155,6 +253,15 @@ func WithTest(test bool) Option {
 	}
 }
 
+// WithTestdata enables processing of files in testdata directories, which are
+// otherwise skipped as test fixtures. Patterns such as ./... do not match
+// them, so their packages must be given explicitly.
+func WithTestdata(testdata bool) Option {
+	return func(p *Processor) {
+		p.testdata = testdata
+	}
+}
+
 // WithDryRun enables dry run mode (no file writes).
 func WithDryRun(dryRun bool) Option {
 	return func(p *Processor) {
@@ -162,6 +269,56 @@ func WithDryRun(dryRun bool) Option {
 	}
 }
 
+// WithPatchDir makes dry run mode write each modification as a patch file
+// under dir, at the path of the modified file relative to the working directory
+// with a .patch extension (e.g. dir/service/handler.go.patch). The patches can
+// be applied with git apply from the working directory. Has no effect unless in
+// dry run mode.
+func WithPatchDir(dir string) Option {
+	return func(p *Processor) {
+		p.patchDir = dir
+	}
+}
+
+// WithOutputDir writes the processed files under dir, at their path relative
+// to the working directory, instead of modifying them in place: modified files
+// with their new contents and the others as they are, so that dir holds a
+// complete copy of the processed files (e.g. generated sources of a build
+// system). Files outside the working directory cannot be written, and files
+// under dir are never processed, so that it may be inside the module. Has no
+// effect in dry run mode.
+func WithOutputDir(dir string) Option {
+	return func(p *Processor) {
+		p.outputDir = dir
+	}
+}
+
+// WithOverlay processes the given contents instead of the files on disk, keyed
+// by absolute file path, like packages.Config.Overlay: e.g. the unsaved buffers
+// of an editor. Patches written in dry run mode apply to the overlay contents.
+// Written files replace their overlay entry, for verification.
+func WithOverlay(overlay map[string][]byte) Option {
+	return func(p *Processor) {
+		p.overlay = overlay
+	}
+}
+
+// WithKeepDiffs makes dry run mode record the modifications of files as
+// unified diffs in ProcessResult.Diffs, e.g. to preview them.
+func WithKeepDiffs(keep bool) Option {
+	return func(p *Processor) {
+		p.keepDiffs = keep
+	}
+}
+
+// WithKeepContents makes dry run mode record the contents that modified files
+// would have in ProcessResult.Contents, e.g. to present them as editor edits.
+func WithKeepContents(keep bool) Option {
+	return func(p *Processor) {
+		p.keepContents = keep
+	}
+}
+
 // WithVerbose enables verbose output.
 func WithVerbose(verbose bool) Option {
 	return func(p *Processor) {
@@ -176,6 +333,136 @@ func WithRemove(remove bool) Option {
 	}
 }
 
+// WithDedupe enables dedupe mode: when a function contains the generated
+// statements more than once, the first occurrence is kept (and updated) and
+// the others are removed.
+func WithDedupe(dedupe bool) Option {
+	return func(p *Processor) {
+		p.dedupe = dedupe
+	}
+}
+
+// WithMarkerMigration enables migration to marker mode: existing statements
+// detected by skeleton matching are rewritten with the generated marker
+// appended. No statements are inserted or removed.
+func WithMarkerMigration(migrate bool) Option {
+	return func(p *Processor) {
+		p.migrateToMarker = migrate
+	}
+}
+
+// WithDriftDetection enables drift mode: only the statements whose generated
+// marker records the hash of another version of the template (or no hash) are
+// updated, and reported with a reason naming the recorded hash. No statements
+// are inserted or removed. Combine with WithDryRun to report drift without
+// modifying anything.
+func WithDriftDetection(detect bool) Option {
+	return func(p *Processor) {
+		p.detectDrift = detect
+	}
+}
+
+// WithRenames enables rename mode for templates whose variables were renamed:
+// renames maps the old names to the new ones used by the template. Existing
+// statements matching the template with the old names are replaced with the
+// rendered template, and references following them are renamed up to the first
+// statement reassigning the variable. No statements are inserted or removed.
+func WithRenames(renames map[string]string) Option {
+	return func(p *Processor) {
+		p.renames = renames
+	}
+}
+
+// WithCtxRewrite sets the name of a variable declared by the template, such as
+// an enriched context. References to the {{.Ctx}} expression following the
+// generated statements are rewritten to it, up to the first statement that
+// reassigns the context. In remove mode, the rewrite is reverted.
+// With config.CtxRewriteAuto, the variable is taken from the rendered template:
+// the first one defined by := from an expression using {{.Ctx}}, such as
+// spanCtx in spanCtx, span := tracer.Start(ctx, "..."). Templates shadowing the
+// context variable itself need no rewrite.
+// An empty name disables the rewrite.
+func WithCtxRewrite(name string) Option {
+	return func(p *Processor) {
+		p.ctxRewrite = name
+	}
+}
+
+// WithEpilogue sets the template of the statements inserted before every
+// return of the functions (and at the end of functions without results that
+// do not end with a return), managed along with the main template: they are
+// kept up to date where the main template's statements are present, and
+// removed in remove mode. Nil disables the epilogue.
+func WithEpilogue(tmpl *template.Template) Option {
+	return func(p *Processor) {
+		p.epilogue = tmpl
+	}
+}
+
+// WithBanner writes directive.Banner at the top of files containing generated
+// statements after processing, and removes it from files without them (e.g.
+// in remove mode). Generated files and files with a skip directive are left alone.
+func WithBanner(banner bool) Option {
+	return func(p *Processor) {
+		p.banner = banner
+	}
+}
+
+// Progress reports how far Process has advanced.
+type Progress struct {
+	// Packages is the number of packages loaded; zero while loading.
+	Packages int
+	// PackagesDone is the number of packages processed so far.
+	PackagesDone int
+	// FilesProcessed is the number of files processed so far.
+	FilesProcessed int
+}
+
+// WithProgress sets a function called by Process before and after packages are
+// loaded, after each processed file, and once all packages are processed.
+// A nil function disables progress reporting.
+func WithProgress(fn func(Progress)) Option {
+	return func(p *Processor) {
+		p.progress = fn
+	}
+}
+
+// WithVerify enables verify mode: after writing, the packages of modified
+// files are loaded again and type-checked. Errors are reported in
+// ProcessResult.VerifyErrors. Has no effect in dry run mode.
+func WithVerify(verify bool) Option {
+	return func(p *Processor) {
+		p.verify = verify
+	}
+}
+
+// WithRollback restores the original content of modified files whose package
+// fails verification. Only effective in verify mode.
+func WithRollback(rollback bool) Option {
+	return func(p *Processor) {
+		p.rollback = rollback
+	}
+}
+
+// WithFailFast stops Process at the first package or file error instead of
+// carrying on with the others. The error is reported in ProcessResult.Errors
+// along with the results so far; files already written are kept.
+func WithFailFast(failFast bool) Option {
+	return func(p *Processor) {
+		p.failFast = failFast
+	}
+}
+
+// WithAllowExternal allows modifying files outside the main modules: files of
+// dependencies (e.g. in the module cache) and files reached through symbolic
+// links pointing out of their module. By default, such files are reported as
+// errors instead, even in dry run mode.
+func WithAllowExternal(allow bool) Option {
+	return func(p *Processor) {
+		p.allowExternal = allow
+	}
+}
+
 // WithPackageRegexps sets regex patterns for filtering packages.
 func WithPackageRegexps(r config.Regexps) Option {
 	return func(p *Processor) {
@@ -183,6 +470,37 @@ func WithPackageRegexps(r config.Regexps) Option {
 	}
 }
 
+// WithIncludeGenerated processes the selected generated files, which are
+// otherwise left alone. Invalid patterns are reported as warnings and ignored,
+// as with WithPackageRegexps.
+func WithIncludeGenerated(g config.IncludeGenerated) Option {
+	return func(p *Processor) {
+		p.generatedAll = g.All
+		p.generated = CompileRegexps(config.Regexps{Only: g.Regexps}).Only
+	}
+}
+
+// skipsGenerated reports whether file is a generated file left alone: unless
+// included by its slash-separated path relative to the module root, and
+// always for scaffold files, which are rewritten when their template changes.
+func (p *Processor) skipsGenerated(file *ast.File, rel string) bool {
+	if !ast.IsGenerated(file) {
+		return false
+	}
+	if len(file.Comments) > 0 && file.Comments[0].List[0].Text == scaffold.Marker {
+		return true
+	}
+	if p.generatedAll {
+		return false
+	}
+	for _, re := range p.generated {
+		if re.MatchString(rel) {
+			return false
+		}
+	}
+	return true
+}
+
 // WithFunctions sets function filtering options.
 func WithFunctions(f config.Functions) Option {
 	return func(p *Processor) {
@@ -190,12 +508,179 @@ func WithFunctions(f config.Functions) Option {
 	}
 }
 
+// PackageOverride replaces parts of the configuration for packages whose
+// import path matches one of Packages.
+type PackageOverride struct {
+	// Packages are matched against package import paths.
+	Packages []*regexp.Regexp
+	// Template replaces the base template. Nil keeps the base template.
+	Template *template.Template
+	// Epilogue replaces the base epilogue. An override replacing the template
+	// replaces the epilogue too, with none if nil.
+	Epilogue *template.Template
+	// Imports replace the base imports. Nil keeps the base imports.
+	Imports []string
+	// AliasedImports replace the base imports as Imports does, with aliases
+	// the template can refer to (see WithImports). Nil keeps Imports.
+	AliasedImports []config.Import
+	// Functions replaces the base function filter. Nil keeps the base filter.
+	Functions *FuncFilter
+}
+
+// matches reports whether the override applies to the package.
+func (o *PackageOverride) matches(pkgPath string) bool {
+	for _, re := range o.Packages {
+		if re.MatchString(pkgPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithOverrides sets per-package overrides. For each package, the first
+// matching override applies.
+func WithOverrides(overrides ...PackageOverride) Option {
+	return func(p *Processor) {
+		p.overrides = overrides
+	}
+}
+
+// forPackage returns the processor to use for the package, with the first
+// matching override applied. Returns p itself if no override matches.
+func (p *Processor) forPackage(pkgPath string) *Processor {
+	for _, o := range p.overrides {
+		if !o.matches(pkgPath) {
+			continue
+		}
+		q := *p
+		if o.Template != nil {
+			q.tmpl = o.Template
+		}
+		if o.Template != nil || o.Epilogue != nil {
+			q.epilogue = o.Epilogue
+		}
+		if o.AliasedImports != nil {
+			q.imports = o.AliasedImports
+		} else if o.Imports != nil {
+			q.imports = pathImports(o.Imports)
+		}
+		if o.Functions != nil {
+			q.funcFilter = o.Functions
+		}
+		return &q
+	}
+	return p
+}
+
+// WithCarrierPriority makes every parameter eligible as the carrier, not only
+// the first one. When several parameters match, the carrier listed first in
+// priority wins (names as accepted by config.CarrierDef.MatchesName, e.g.
+// "context.Context"); unlisted carriers rank last and ties go to the earlier
+// parameter. An empty priority keeps first-parameter-only matching.
+func WithCarrierPriority(priority []string) Option {
+	return func(p *Processor) {
+		p.carrierPriority = priority
+	}
+}
+
+// WithComparator sets the comparator used to detect existing statements.
+// The comparator is cloned, so later registrations on c do not affect the Processor.
+func WithComparator(c *Comparator) Option {
+	return func(p *Processor) {
+		p.comparator = c.Clone()
+	}
+}
+
+// WithMatching sets how existing statements are detected.
+func WithMatching(mode config.MatchingMode) Option {
+	return func(p *Processor) {
+		p.matching = mode
+	}
+}
+
+// WithMarker sets the generated marker comment appended to the statements in
+// marker mode, e.g. "// managed-by: obs-platform" (the leading "//" may be
+// omitted). Statements carrying the default marker are still detected, and
+// get the configured marker when updated. An empty marker selects the default.
+func WithMarker(marker string) Option {
+	return func(p *Processor) {
+		p.marker = marker
+	}
+}
+
+// WithRunID sets the identifier of the run recorded by the generated marker
+// of the statements inserted or updated in marker mode, e.g.
+// "//ctxweaver:generated sha=3f2a9c1e n=1 run=2024-06-01T12:00". In remove mode,
+// only the statements whose marker records id are removed (along with the
+// epilogue of their functions), which undoes the run. An empty id records no
+// run.
+func WithRunID(id string) Option {
+	return func(p *Processor) {
+		p.runID = id
+	}
+}
+
+// WithRefresh sets when a detected statement is updated.
+func WithRefresh(mode config.RefreshMode) Option {
+	return func(p *Processor) {
+		p.refresh = mode
+	}
+}
+
+// WithLoadMode sets the package information loaded by Process and Coverage.
+// With config.LoadSyntax, type information is only loaded when needed (see
+// config.LoadSyntax); otherwise, qualified identifiers are resolved from the
+// import declarations of each file, with package names guessed from import
+// paths, and carriers embedded in other types are not detected.
+func WithLoadMode(mode config.LoadMode) Option {
+	return func(p *Processor) {
+		p.load = mode
+	}
+}
+
+// WithBatchSize makes Process and Coverage load at most n packages at a
+// time, releasing their syntax and type information before loading the next
+// batch, so that runs over thousands of packages fit in limited memory. The
+// packages matching the patterns are listed beforehand. Function filters with
+// entrypoints (functions.reachable_from) need the call graph of all packages
+// and cannot be combined with batches. Zero, the default, loads all packages
+// at once.
+func WithBatchSize(n int) Option {
+	return func(p *Processor) {
+		p.batchSize = n
+	}
+}
+
+// WithFormat sets the final formatting step of modified files. With
+// config.FormatGofumpt, the output of goimports is piped through the gofumpt
+// command, which must be in PATH, given the Go version and path of the module
+// of the file (-lang and -modpath); with config.FormatNone, files are printed
+// in the canonical gofmt style without goimports, so that imports missing
+// from the configuration are not added. The default is config.FormatGofmt.
+func WithFormat(tool config.FormatTool) Option {
+	return func(p *Processor) {
+		p.format = tool
+	}
+}
+
+// WithImports sets the imports added when the template is inserted, replacing
+// the import paths given to New, so that packages can be imported under the
+// aliases the template refers to (e.g. oteltrace for
+// go.opentelemetry.io/otel/trace).
+func WithImports(imports []config.Import) Option {
+	return func(p *Processor) {
+		p.imports = imports
+	}
+}
+
 // New creates a new Processor.
 func New(registry *config.CarrierRegistry, tmpl *template.Template, importPaths []string, opts ...Option) *Processor {
 	p := &Processor{
-		registry: registry,
-		tmpl:     tmpl,
-		imports:  importPaths,
+		registry:   registry,
+		tmpl:       tmpl,
+		imports:    pathImports(importPaths),
+		comparator: NewComparator(),
+		ignore:     ignore.NewMatcher(),
 	}
 	for _, opt := range opts {
 		opt(p)
@@ -203,9 +688,106 @@ func New(registry *config.CarrierRegistry, tmpl *template.Template, importPaths
 	return p
 }
 
+// pathImports returns the imports of paths, without aliases.
+func pathImports(paths []string) []config.Import {
+	if paths == nil {
+		return nil
+	}
+	imports := make([]config.Import, 0, len(paths))
+	for _, path := range paths {
+		imports = append(imports, config.Import{Path: path})
+	}
+	return imports
+}
+
 // ProcessResult holds the result of processing.
 type ProcessResult struct {
 	FilesProcessed int
 	FilesModified  int
-	Errors         []error
+	// Packages are the results of the processed packages, in load order.
+	// A package and its test variant share a single entry.
+	Packages []PackageResult
+	// Modules sum the results of Packages per module, in load order.
+	Modules []ModuleResult
+	// ModifiedFiles are the files modified (or, in dry run mode, that would be modified).
+	ModifiedFiles []string
+	// DirtyFiles are the files of ModifiedFiles that had uncommitted changes
+	// (see WithRequireClean).
+	DirtyFiles []string
+	// ModifiedFuncs are the functions of ModifiedFiles modified by the weave.
+	ModifiedFuncs []FuncChange
+	// AddedImports are the imports added to ModifiedFiles, per module, sorted
+	// by module and path. See MissingDependencies for their impact on go.mod.
+	AddedImports []AddedImport
+	// ProtectedFuncs are the functions whose generated statements are left
+	// alone because of a //ctxweaver:skip directive.
+	ProtectedFuncs []FuncChange
+	// VetoedFuncs are the functions left alone because a plugin vetoed their
+	// statements; Reason is the plugin's.
+	VetoedFuncs []FuncChange
+	// DeclinedFuncs are the functions left alone because their modification
+	// was declined (see WithConfirm).
+	DeclinedFuncs []FuncChange
+	// UnsampledFuncs are the functions left without insertion because they
+	// are out of the sample (see WithSample).
+	UnsampledFuncs []FuncChange
+	// ContextCreators are the functions without carrier creating their own
+	// context, reported with WithContextCreators; Reason names the call.
+	ContextCreators []FuncChange
+	// ParamSkippedFuncs are the functions without carrier left without a ctx
+	// parameter in add-param mode (see WithAddParam); Reason says why.
+	ParamSkippedFuncs []FuncChange
+	// ConflictingFuncs are the functions left without statements because they
+	// call a function matching a conflict pattern; Reason names the call.
+	ConflictingFuncs []FuncChange
+	// NameCollisions are the names made unique by the unique template function,
+	// because several functions rendered the same name.
+	NameCollisions []template.NameCollision
+	// DuplicatesRemoved is the number of duplicate statement groups removed in dedupe mode.
+	DuplicatesRemoved int
+	Errors            []error
+	// VerifyErrors are the errors found when type-checking modified packages in verify mode.
+	VerifyErrors []error
+	// RolledBack are the files restored because their package failed verification.
+	RolledBack []string
+	// Patches are the patch files written in dry run mode with a patch directory.
+	Patches []string
+	// Contents are the contents of ModifiedFiles in dry run mode with WithKeepContents.
+	Contents map[string][]byte
+	// Diffs are the unified diffs of ModifiedFiles in dry run mode with
+	// WithKeepDiffs, with paths relative to the working directory when inside it.
+	Diffs map[string][]byte
+	// Selected reports whether the function selected by WithSelection or
+	// WithFuncSelection, or the file selected by WithFileSelection, was found.
+	Selected bool
+}
+
+// PackageResult holds the result of processing a single package.
+type PackageResult struct {
+	PkgPath        string
+	Module         string // Path of the module of the package; empty if unknown
+	FilesProcessed int
+	FilesModified  int
+}
+
+// ModuleResult holds the results of the packages of a single module.
+type ModuleResult struct {
+	Path           string // Empty for packages without a known module
+	Packages       int
+	FilesProcessed int
+	FilesModified  int
+}
+
+// FuncChange describes a function modified (or, in dry run mode, that would be modified).
+type FuncChange struct {
+	File    string
+	Line    int    // Line of the func keyword
+	EndLine int    // Line of the closing brace
+	Func    string // Name as in stack traces, e.g. "Foo" or "(*Service).Get"
+	// Op is the operation applied to the function: "insert", "update",
+	// "remove", "dedupe", "drift" (reported by drift detection), "mutate"
+	// (by mutators alone) or "field" (by field initializations alone).
+	Op string
+	// Reason describes the change, e.g. "missing instrumentation" or "outdated instrumentation".
+	Reason string
 }
@@ -265,7 +265,7 @@ func runInsertionTest(t *testing.T, testdataRoot, caseName string) {
 			t.Fatalf("failed to parse template: %v", err)
 		}
 
-		proc := processor.New(registry, tmpl, cfg.Imports)
+		proc := processor.New(registry, tmpl, nil, processor.WithImports(config.ParseImports(cfg.Imports)))
 
 		oldWd, _ := os.Getwd()
 		if err := os.Chdir(caseDir); err != nil {
@@ -337,7 +337,7 @@ func runRemoveTest(t *testing.T, testdataRoot, caseName string) {
 			t.Fatalf("failed to parse template: %v", err)
 		}
 
-		proc := processor.New(registry, tmpl, cfg.Imports, processor.WithRemove(true))
+		proc := processor.New(registry, tmpl, nil, processor.WithImports(config.ParseImports(cfg.Imports)), processor.WithRemove(true))
 
 		oldWd, _ := os.Getwd()
 		if err := os.Chdir(caseDir); err != nil {
@@ -394,7 +394,7 @@ func runIdempotencyTest(t *testing.T, testdataRoot, caseName string) {
 			t.Fatalf("failed to parse template: %v", err)
 		}
 
-		proc := processor.New(registry, tmpl, cfg.Imports)
+		proc := processor.New(registry, tmpl, nil, processor.WithImports(config.ParseImports(cfg.Imports)))
 
 		oldWd, _ := os.Getwd()
 		if err := os.Chdir(caseDir); err != nil {
@@ -0,0 +1,122 @@
+package processor
+
+import (
+	"cmp"
+	"go/types"
+	"slices"
+
+	"github.com/dave/dst"
+
+	"github.com/mpyw/ctxweaver/internal/directive"
+)
+
+// PropagationIssue is a place in an eligible function where its context is
+// dropped instead of propagated.
+type PropagationIssue struct {
+	Package string `json:"package"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Func    string `json:"func"`   // Name as in stack traces, e.g. "Foo" or "(*Service).Get"
+	Reason  string `json:"reason"` // e.g. "context.Background() passed to fetch"
+}
+
+// PropagationResult contains the propagation issues, sorted by file and line.
+type PropagationResult struct {
+	Issues []PropagationIssue
+	Errors []error
+}
+
+// Propagation reports the places of the eligible functions of the given
+// package patterns where their context is not propagated, without modifying
+// any file: calls receiving context.Background() or context.TODO() as an
+// argument, and goroutines started without referring to the carrier or to any
+// context.Context (with type information). Statements with a
+// //ctxweaver:skip directive are left out. As in Coverage, the remove, dedupe,
+// marker migration and rename options are ignored; functions in the baseline
+// are not inspected, nor are special functions woven without a carrier.
+func (p *Processor) Propagation(patterns []string) (*PropagationResult, error) {
+	result := &PropagationResult{}
+	errs, err := p.inspectEligible(patterns, func(string) {}, func(fn eligibleFunc) {
+		if fn.match.Carrier.Type == "" || p.baseline.Contains(funcKey(fn.pkgPath, fn.decl)) {
+			return
+		}
+		for _, issue := range droppedContexts(fn) {
+			issue.Package, issue.File, issue.Func = fn.pkgPath, fn.file, funcName(fn.decl)
+			result.Issues = append(result.Issues, issue)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Errors = errs
+
+	slices.SortFunc(result.Issues, func(a, b PropagationIssue) int {
+		return cmp.Or(cmp.Compare(a.File, b.File), cmp.Compare(a.Line, b.Line))
+	})
+	return result, nil
+}
+
+// droppedContexts returns the places of fn dropping its context, with their
+// line and reason.
+func droppedContexts(fn eligibleFunc) []PropagationIssue {
+	var issues []PropagationIssue
+	dst.Inspect(fn.decl.Body, func(n dst.Node) bool {
+		if stmt, ok := n.(dst.Stmt); ok && directive.HasStmtSkipDirective(stmt) {
+			return false
+		}
+		switch n := n.(type) {
+		case *dst.GoStmt:
+			if !refersToContext(n.Call, fn) {
+				issues = append(issues, PropagationIssue{Line: fn.lineOf(n), Reason: "goroutine started without the context"})
+			}
+		case *dst.CallExpr:
+			for _, arg := range n.Args {
+				root := rootContext(arg)
+				if root == "" {
+					continue
+				}
+				callee := "a function"
+				if names := calleeNames(n.Fun); len(names) > 0 {
+					callee = names[0]
+				}
+				issues = append(issues, PropagationIssue{Line: fn.lineOf(n), Reason: root + " passed to " + callee})
+			}
+		}
+		return true
+	})
+	return issues
+}
+
+// rootContext returns "context.Background()" or "context.TODO()" if expr
+// calls either, or an empty string otherwise.
+func rootContext(expr dst.Expr) string {
+	call, ok := expr.(*dst.CallExpr)
+	if !ok {
+		return ""
+	}
+	if id, ok := call.Fun.(*dst.Ident); ok && id.Path == "context" && (id.Name == "Background" || id.Name == "TODO") {
+		return "context." + id.Name + "()"
+	}
+	return ""
+}
+
+// refersToContext reports whether node refers to the carrier variable of fn,
+// or, with type information, to any context.Context.
+func refersToContext(node dst.Node, fn eligibleFunc) bool {
+	var found bool
+	dst.Inspect(node, func(n dst.Node) bool {
+		id, ok := n.(*dst.Ident)
+		if !ok || found {
+			return !found
+		}
+		if id.Path == "" && id.Name == fn.match.VarName && id.Name != "_" {
+			found = true
+		} else if fn.typeOf != nil {
+			if t := fn.typeOf(id); t != nil && types.TypeString(t, nil) == "context.Context" {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
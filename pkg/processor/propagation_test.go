@@ -0,0 +1,88 @@
+package processor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestPropagation(t *testing.T) {
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	registry := config.NewCarrierRegistry(true)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go": `package main
+
+import (
+	"context"
+	"net/http"
+)
+
+func trace(context.Context) {}
+
+func fetch(ctx context.Context, id int) {}
+
+func Dropped(ctx context.Context) {
+	fetch(context.Background(), 1)
+	go func() {
+		fetch(context.TODO(), 2)
+	}()
+}
+
+func Propagated(ctx context.Context) {
+	fetch(ctx, 1)
+	go fetch(ctx, 2)
+	derived, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		fetch(derived, 3)
+	}()
+}
+
+func Handler(w http.ResponseWriter, r *http.Request) {
+	go fetch(r.Context(), 1)
+	go work()
+}
+
+func Skipped(ctx context.Context) {
+	go work() //ctxweaver:skip
+}
+
+func NoCarrier() {
+	fetch(context.Background(), 1)
+}
+
+func work() {}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil)
+	result, err := proc.Propagation([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Propagation() error = %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Propagation() errors = %v", result.Errors)
+	}
+
+	file, _ := filepath.EvalSymlinks(filepath.Join(tmpDir, "main.go"))
+	want := []processor.PropagationIssue{
+		{Package: "testmod", File: file, Line: 13, Func: "Dropped", Reason: "context.Background() passed to fetch"},
+		{Package: "testmod", File: file, Line: 14, Func: "Dropped", Reason: "goroutine started without the context"},
+		{Package: "testmod", File: file, Line: 15, Func: "Dropped", Reason: "context.TODO() passed to fetch"},
+		{Package: "testmod", File: file, Line: 31, Func: "Handler", Reason: "goroutine started without the context"},
+	}
+	if diff := cmp.Diff(want, result.Issues); diff != "" {
+		t.Errorf("Issues mismatch (-want +got):\n%s", diff)
+	}
+}
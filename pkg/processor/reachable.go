@@ -0,0 +1,155 @@
+package processor
+
+import (
+	"fmt"
+	"go/types"
+	"maps"
+	"os"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+
+	"github.com/mpyw/ctxweaver/internal"
+)
+
+// reachableFilters returns the function filters with entrypoints: the base
+// filter and those of overrides.
+func (p *Processor) reachableFilters() []*FuncFilter {
+	var filters []*FuncFilter
+	if p.funcFilter != nil && len(p.funcFilter.ReachableFrom) > 0 {
+		filters = append(filters, p.funcFilter)
+	}
+	for _, o := range p.overrides {
+		if o.Functions != nil && len(o.Functions.ReachableFrom) > 0 {
+			filters = append(filters, o.Functions)
+		}
+	}
+	return filters
+}
+
+// resolveReachable computes the reachable functions of every function filter
+// with entrypoints (the base filter and those of overrides) from the call graphs
+// of the package groups loaded by loadModules, one per module. The call graphs
+// are only built if some filter needs them.
+func (p *Processor) resolveReachable(groups [][]*packages.Package) {
+	filters := p.reachableFilters()
+	if len(filters) == 0 {
+		return
+	}
+
+	graphs := make([]*callgraph.Graph, 0, len(groups))
+	for _, pkgs := range groups {
+		prog, _ := ssautil.Packages(pkgs, 0)
+		prog.Build()
+		graphs = append(graphs, cha.CallGraph(prog))
+	}
+
+	for _, f := range filters {
+		f.reachable = make(map[string]bool)
+		found := make(map[string]bool)
+		for _, graph := range graphs {
+			maps.Copy(f.reachable, reachableFuncs(graph, f.ReachableFrom, found))
+		}
+		for _, e := range f.ReachableFrom {
+			if !found[e] {
+				fmt.Fprintf(os.Stderr, "%swarning:%s reachable_from entrypoint %q matches no function\n",
+					internal.StderrColor(internal.ColorYellow),
+					internal.StderrColor(internal.ColorReset),
+					e)
+			}
+		}
+	}
+}
+
+// reachableFuncs returns the keys (see funcKey) of the declared functions
+// reachable from the entrypoints, including the entrypoints themselves.
+// Function literals and generic instantiations are traversed but not recorded.
+// The entrypoints matching some function are recorded in found.
+func reachableFuncs(graph *callgraph.Graph, entrypoints []string, found map[string]bool) map[string]bool {
+	var queue []*ssa.Function
+	for fn := range graph.Nodes {
+		if fn == nil || fn.Synthetic != "" || fn.Origin() != nil {
+			continue
+		}
+		for _, e := range entrypoints {
+			if matchesEntrypoint(fn, e) {
+				queue = append(queue, fn)
+				found[e] = true
+			}
+		}
+	}
+	reachable := make(map[string]bool)
+	visited := make(map[*ssa.Function]bool)
+	for len(queue) > 0 {
+		fn := queue[0]
+		queue = queue[1:]
+		if fn == nil || visited[fn] {
+			continue
+		}
+		visited[fn] = true
+
+		if key, ok := ssaFuncKey(fn); ok {
+			reachable[key] = true
+		}
+		queue = append(queue, fn.Origin())
+		queue = append(queue, fn.AnonFuncs...)
+		if node := graph.Nodes[fn]; node != nil {
+			for _, edge := range node.Out {
+				queue = append(queue, edge.Callee.Func)
+			}
+		}
+	}
+	return reachable
+}
+
+// matchesEntrypoint reports whether fn is denoted by the entrypoint: its bare
+// name (e.g. "ServeHTTP"), or its name in the {{.FuncName}} format (e.g.
+// "main.main", "handler.(*Server).ServeHTTP") with the package name or path.
+func matchesEntrypoint(fn *ssa.Function, entrypoint string) bool {
+	obj, ok := fn.Object().(*types.Func)
+	if !ok || obj.Pkg() == nil {
+		return false
+	}
+	if entrypoint == obj.Name() {
+		return true
+	}
+
+	name := obj.Name()
+	if recv := fn.Signature.Recv(); recv != nil {
+		t := recv.Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			name = "(*" + namedTypeName(ptr.Elem()) + ")." + name
+		} else {
+			name = namedTypeName(t) + "." + name
+		}
+	}
+	return entrypoint == obj.Pkg().Name()+"."+name || entrypoint == obj.Pkg().Path()+"."+name
+}
+
+// ssaFuncKey returns the key of a declared function in the format of funcKey.
+// Reports false for function literals and synthetic functions.
+func ssaFuncKey(fn *ssa.Function) (string, bool) {
+	obj, ok := fn.Object().(*types.Func)
+	if !ok || obj.Pkg() == nil || fn.Synthetic != "" {
+		return "", false
+	}
+	if recv := fn.Signature.Recv(); recv != nil {
+		t := recv.Type()
+		if ptr, ok := t.(*types.Pointer); ok {
+			t = ptr.Elem()
+		}
+		return obj.Pkg().Path() + "." + namedTypeName(t) + "." + obj.Name(), true
+	}
+	return obj.Pkg().Path() + "." + obj.Name(), true
+}
+
+// namedTypeName returns the name of a named receiver type without type arguments.
+func namedTypeName(t types.Type) string {
+	if named, ok := t.(*types.Named); ok {
+		return named.Origin().Obj().Name()
+	}
+	return ""
+}
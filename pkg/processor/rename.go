@@ -0,0 +1,79 @@
+package processor
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/dave/dst"
+)
+
+// detectRename determines what action to take in rename mode. Existing
+// statements that match the template once the renamed variables are given
+// their old names are replaced with the rendered template; functions without
+// such statements are left alone.
+func (p *Processor) detectRename(decl *dst.FuncDecl, rt renderedTemplate) (Action, error) {
+	targetStmts, err := parseTemplateStatements(rt.stmt)
+	if err != nil {
+		return nil, err
+	}
+	stmtCount := len(targetStmts)
+
+	// Give the variables of the rendered template their old names
+	oldNames := make(map[string]string, len(p.renames))
+	for from, to := range p.renames {
+		oldNames[to] = from
+	}
+	oldStmts := make([]dst.Stmt, stmtCount)
+	for i, stmt := range targetStmts {
+		oldStmts[i] = dst.Clone(stmt).(dst.Stmt)
+		renameIdents(oldStmts[i], oldNames)
+	}
+
+	matches := p.findMatches(decl.Body, stmtCount, oldStmts, p.comparator, func(int, dst.Stmt) bool { return false })
+	if len(matches) == 0 || matches[0].protected {
+		return skipAction{}, nil
+	}
+
+	// The new names must not collide with the other declarations of the function
+	first := matches[0]
+	declared := declaredNames(decl, decl.Body.List[first.index:first.index+stmtCount])
+	for _, to := range slices.Sorted(maps.Values(p.renames)) {
+		if declared[to] {
+			return nil, fmt.Errorf("cannot rename to %s: already declared", to)
+		}
+	}
+	return updateAction{index: first.index, count: stmtCount}, nil
+}
+
+// renameRefs renames references to the renamed variables in the statements
+// following the generated ones. Function literals with a parameter of the old
+// name are skipped, since the parameter shadows the variable.
+func (p *Processor) renameRefs(body *dst.BlockStmt, start int) bool {
+	modified := false
+	for _, from := range slices.Sorted(maps.Keys(p.renames)) {
+		for _, stmt := range body.List[start:] {
+			if _, changed := p.replaceExprs(stmt, dst.NewIdent(from), dst.NewIdent(p.renames[from]), from); changed {
+				modified = true
+			}
+		}
+	}
+	return modified
+}
+
+// renameIdents renames the local identifiers of node according to names.
+// Package-qualified identifiers and selected fields or methods are kept.
+func renameIdents(node dst.Node, names map[string]string) {
+	dst.Inspect(node, func(n dst.Node) bool {
+		switch n := n.(type) {
+		case *dst.SelectorExpr:
+			renameIdents(n.X, names)
+			return false
+		case *dst.Ident:
+			if to, ok := names[n.Name]; ok && n.Path == "" {
+				n.Name = to
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,234 @@
+package processor
+
+import (
+	"go/token"
+	"slices"
+
+	"github.com/dave/dst"
+	dstcursor "github.com/dave/dst/dstutil"
+
+	"github.com/mpyw/ctxweaver/internal/directive"
+	"github.com/mpyw/ctxweaver/internal/dstutil"
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+// rewriteCtxRefs propagates the variable configured by WithCtxRewrite through
+// the function body: references to the context expression following the
+// generated statements are replaced with the variable. In remove mode the
+// replacement is reverted, so the body does not refer to a removed variable.
+//
+// It must be called after action is applied, except in remove mode where it
+// must be called before.
+func (p *Processor) rewriteCtxRefs(body *dst.BlockStmt, rt renderedTemplate, action Action) (bool, error) {
+	ctxExpr, err := dstutil.ParseExpr(rt.ctx)
+	if err != nil {
+		return false, err
+	}
+	name := p.ctxRewrite
+	if name == config.CtxRewriteAuto {
+		stmts, err := parseTemplateStatements(rt.stmt)
+		if err != nil {
+			return false, err
+		}
+		if name = p.derivedCtxVar(stmts, ctxExpr); name == "" {
+			return false, nil
+		}
+	}
+	varExpr := dst.NewIdent(name)
+
+	if p.remove {
+		a, ok := action.(removeAction)
+		if !ok {
+			return false, nil
+		}
+		return p.replaceRefs(body.List[a.index+a.count:], varExpr, ctxExpr), nil
+	}
+
+	end, err := p.generatedEnd(body, rt)
+	if err != nil || end < 0 {
+		return false, err
+	}
+	return p.replaceRefs(body.List[end:], ctxExpr, varExpr), nil
+}
+
+// derivedCtxVar returns the variable a template statement derives from the
+// context, i.e. the first variable defined by a short variable declaration
+// whose right-hand side refers to ctxExpr, as in
+// spanCtx, span := tracer.Start(ctx, "..."). Returns "" if there is none, or
+// if the declaration shadows the context variable itself.
+func (p *Processor) derivedCtxVar(stmts []dst.Stmt, ctxExpr dst.Expr) string {
+	for _, stmt := range stmts {
+		assign, ok := stmt.(*dst.AssignStmt)
+		if !ok || assign.Tok != token.DEFINE {
+			continue
+		}
+		id, ok := assign.Lhs[0].(*dst.Ident)
+		if !ok || id.Name == "_" {
+			continue
+		}
+		refers := false
+		for _, rhs := range assign.Rhs {
+			dst.Inspect(rhs, func(n dst.Node) bool {
+				if e, ok := n.(dst.Expr); ok && p.comparator.Compare(ctxExpr, e, "root", true) {
+					refers = true
+				}
+				return !refers
+			})
+		}
+		if !refers {
+			continue
+		}
+		if id.Name == rootIdent(ctxExpr) {
+			return ""
+		}
+		return id.Name
+	}
+	return ""
+}
+
+// generatedEnd returns the index following the generated statements in body,
+// or -1 if there are none or they are protected by a skip directive.
+func (p *Processor) generatedEnd(body *dst.BlockStmt, rt renderedTemplate) (int, error) {
+	if p.matching == config.MatchingMarker {
+		targetStmts, err := parseTemplateStatements(rt.stmt)
+		if err != nil {
+			return -1, err
+		}
+		index, count := p.findMarked(body, len(targetStmts))
+		if index < 0 || directive.HasStmtSkipDirective(body.List[index]) {
+			return -1, nil
+		}
+		return index + count, nil
+	}
+
+	matches, stmtCount, err := p.matchTemplate(body, rt)
+	if err != nil || len(matches) == 0 || matches[0].protected {
+		return -1, err
+	}
+	return matches[0].index + stmtCount, nil
+}
+
+// replaceRefs replaces expressions equal to from with copies of to in stmts.
+// Replacing stops at the first statement that assigns or declares the root
+// identifier of from, since later references no longer denote the same value.
+// The right-hand side of a top-level assignment is still rewritten, so that
+// a context derived there builds on the replacement.
+func (p *Processor) replaceRefs(stmts []dst.Stmt, from, to dst.Expr) bool {
+	root := rootIdent(from)
+	modified := false
+	for _, stmt := range stmts {
+		if root != "" && assignsIdent(stmt, root) {
+			if assign, ok := stmt.(*dst.AssignStmt); ok {
+				for i, rhs := range assign.Rhs {
+					result, changed := p.replaceExprs(rhs, from, to, root)
+					assign.Rhs[i] = result.(dst.Expr)
+					modified = modified || changed
+				}
+			}
+			break
+		}
+		if _, changed := p.replaceExprs(stmt, from, to, root); changed {
+			modified = true
+		}
+	}
+	return modified
+}
+
+// replaceExprs replaces expressions equal to from with copies of to in node.
+// Function literals with a parameter named root are skipped, since the
+// parameter shadows it.
+func (p *Processor) replaceExprs(node dst.Node, from, to dst.Expr, root string) (dst.Node, bool) {
+	modified := false
+	result := dstcursor.Apply(node, func(c *dstcursor.Cursor) bool {
+		// Identifiers in these positions name fields, labels or declarations,
+		// not the value of a variable
+		switch c.Parent().(type) {
+		case *dst.SelectorExpr:
+			if c.Name() == "Sel" {
+				return false
+			}
+		case *dst.KeyValueExpr:
+			if c.Name() == "Key" {
+				return false
+			}
+		case *dst.Field:
+			if c.Name() == "Names" {
+				return false
+			}
+		case *dst.LabeledStmt, *dst.BranchStmt:
+			return false
+		}
+
+		switch n := c.Node().(type) {
+		case *dst.FuncLit:
+			return !declaresParam(n.Type, root)
+		case dst.Expr:
+			if p.comparator.Compare(from, n, "root", true) {
+				c.Replace(dst.Clone(to))
+				modified = true
+				return false
+			}
+		}
+		return true
+	}, nil)
+	return result, modified
+}
+
+// rootIdent returns the name of the variable an expression such as
+// ctx or c.Request().Context() is evaluated from, or "" if there is none.
+func rootIdent(expr dst.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *dst.Ident:
+			return e.Name
+		case *dst.SelectorExpr:
+			expr = e.X
+		case *dst.CallExpr:
+			expr = e.Fun
+		case *dst.ParenExpr:
+			expr = e.X
+		case *dst.StarExpr:
+			expr = e.X
+		case *dst.IndexExpr:
+			expr = e.X
+		default:
+			return ""
+		}
+	}
+}
+
+// assignsIdent reports whether stmt assigns or declares a variable named name.
+func assignsIdent(stmt dst.Stmt, name string) bool {
+	isName := func(e dst.Expr) bool {
+		id, ok := e.(*dst.Ident)
+		return ok && id.Name == name
+	}
+	found := false
+	dst.Inspect(stmt, func(n dst.Node) bool {
+		switch n := n.(type) {
+		case *dst.AssignStmt:
+			found = found || slices.ContainsFunc(n.Lhs, isName)
+		case *dst.ValueSpec:
+			found = found || slices.ContainsFunc(n.Names, func(id *dst.Ident) bool { return id.Name == name })
+		case *dst.RangeStmt:
+			found = found || isName(n.Key) || isName(n.Value)
+		}
+		return !found
+	})
+	return found
+}
+
+// declaresParam reports whether a function type has a parameter named name.
+func declaresParam(ft *dst.FuncType, name string) bool {
+	if ft == nil || ft.Params == nil {
+		return false
+	}
+	for _, field := range ft.Params.List {
+		for _, id := range field.Names {
+			if id.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package processor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithRunID(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	hash := tmpl.Hash()
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"a.go": `package service
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+}
+`,
+		"b.go": `package service
+
+import "context"
+
+func Bar(ctx context.Context) {
+}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	run := func(pattern, id string, remove bool) {
+		t.Helper()
+		proc := processor.New(registry, tmpl, nil,
+			processor.WithMatching(config.MatchingMarker),
+			processor.WithRunID(id),
+			processor.WithRemove(remove),
+		)
+		result, err := proc.Process([]string{pattern})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if len(result.Errors) > 0 {
+			t.Fatalf("Process errors: %v", result.Errors)
+		}
+	}
+	read := func(name string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(tmpDir, name))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		return string(content)
+	}
+
+	run("./a.go", "r1", false)
+	run("./...", "r2", false) // Foo is up to date and keeps r1
+
+	wantA := `package service
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {
+	defer trace(ctx) //ctxweaver:generated sha=` + hash + ` n=1 run=r1
+
+}
+`
+	wantB := `package service
+
+import "context"
+
+func Bar(ctx context.Context) {
+	defer trace(ctx) //ctxweaver:generated sha=` + hash + ` n=1 run=r2
+
+}
+`
+	if diff := cmp.Diff(wantA, read("a.go")); diff != "" {
+		t.Errorf("a.go mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(wantB, read("b.go")); diff != "" {
+		t.Errorf("b.go mismatch (-want +got):\n%s", diff)
+	}
+
+	// Undoing r1 only removes the statements it inserted
+	run("./...", "r1", true)
+
+	wantA = `package service
+
+import "context"
+
+func trace(context.Context) {}
+
+func Foo(ctx context.Context) {}
+`
+	if diff := cmp.Diff(wantA, read("a.go")); diff != "" {
+		t.Errorf("a.go after undo mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(wantB, read("b.go")); diff != "" {
+		t.Errorf("b.go after undo mismatch (-want +got):\n%s", diff)
+	}
+}
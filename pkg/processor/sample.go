@@ -0,0 +1,34 @@
+package processor
+
+import "hash/fnv"
+
+// sampleScale is the number of buckets functions are hashed into: percentages
+// are applied with a precision of 0.01%.
+const sampleScale = 10000
+
+// WithSample restricts insertion to a sample of percent percent of the
+// functions, for staged rollouts evaluating the impact of new instrumentation
+// before weaving whole services. Functions are sampled by a hash of their key
+// (see Baseline), so that the sample is the same on every run and only grows
+// with percent: functions sampled at 10% are sampled at 20% too. Statements
+// already inserted are updated and removed regardless, and functions left out
+// are reported in ProcessResult.UnsampledFuncs. A percent out of (0, 100)
+// disables the restriction.
+func WithSample(percent float64) Option {
+	return func(p *Processor) {
+		p.sample = 0
+		if percent > 0 && percent < 100 {
+			p.sample = percent
+		}
+	}
+}
+
+// sampled reports whether the function of the key is in the sample.
+func (p *Processor) sampled(key string) bool {
+	if p.sample == 0 {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return float64(h.Sum32()%sampleScale) < p.sample*sampleScale/100
+}
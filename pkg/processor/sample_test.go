@@ -0,0 +1,67 @@
+package processor_test
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithSample(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	const total = 40
+	var src strings.Builder
+	src.WriteString("package service\n\nimport \"context\"\n\nfunc trace(context.Context) {}\n")
+	for i := range total {
+		fmt.Fprintf(&src, "\nfunc F%d(ctx context.Context) {\n}\n", i)
+	}
+	tmpDir := setupTestModule(t, map[string]string{"service.go": src.String()})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	// sampled returns the functions a dry run inserts into at percent
+	sampled := func(percent float64) []string {
+		t.Helper()
+		proc := processor.New(registry, tmpl, nil, processor.WithDryRun(true), processor.WithSample(percent))
+		result, err := proc.Process([]string{"./..."})
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if len(result.Errors) > 0 {
+			t.Fatalf("Process errors: %v", result.Errors)
+		}
+		var funcs []string
+		for _, fc := range result.ModifiedFuncs {
+			funcs = append(funcs, fc.Func)
+		}
+		if got := len(funcs) + len(result.UnsampledFuncs); got != total {
+			t.Errorf("%v%%: %d sampled and %d unsampled functions, want %d in all", percent, len(funcs), len(result.UnsampledFuncs), total)
+		}
+		return funcs
+	}
+
+	low, high := sampled(25), sampled(75)
+	if len(low) == 0 || len(low) >= len(high) || len(high) == total {
+		t.Errorf("sampled %d functions at 25%% and %d at 75%% out of %d", len(low), len(high), total)
+	}
+	for _, fn := range low {
+		if !slices.Contains(high, fn) {
+			t.Errorf("%s is sampled at 25%% but not at 75%%", fn)
+		}
+	}
+	if again := sampled(25); !slices.Equal(low, again) {
+		t.Errorf("sample is not deterministic: %v, then %v", low, again)
+	}
+	if all := sampled(100); len(all) != total {
+		t.Errorf("sampled %d functions at 100%%, want %d", len(all), total)
+	}
+}
@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"fmt"
+	"go/token"
+	"slices"
+
+	"github.com/dave/dst"
+
+	"github.com/mpyw/ctxweaver/internal/dstutil"
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+// declaredNames returns the names declared in the function scope of decl:
+// type parameters, receiver, parameters, results, and the top-level
+// declarations of the body. Statements in exclude are ignored.
+func declaredNames(decl *dst.FuncDecl, exclude []dst.Stmt) map[string]bool {
+	names := make(map[string]bool)
+	addFields := func(fl *dst.FieldList) {
+		if fl == nil {
+			return
+		}
+		for _, field := range fl.List {
+			for _, id := range field.Names {
+				names[id.Name] = true
+			}
+		}
+	}
+	addFields(decl.Recv)
+	addFields(decl.Type.TypeParams)
+	addFields(decl.Type.Params)
+	addFields(decl.Type.Results)
+
+	for _, stmt := range decl.Body.List {
+		if slices.Contains(exclude, stmt) {
+			continue
+		}
+		for _, name := range stmtDeclaredNames(stmt) {
+			names[name] = true
+		}
+	}
+	delete(names, "_")
+	return names
+}
+
+// stmtDeclaredNames returns the names a statement declares in its enclosing block.
+func stmtDeclaredNames(stmt dst.Stmt) []string {
+	var names []string
+	switch s := stmt.(type) {
+	case *dst.AssignStmt:
+		if s.Tok != token.DEFINE {
+			return nil
+		}
+		for _, lhs := range s.Lhs {
+			if id, ok := lhs.(*dst.Ident); ok {
+				names = append(names, id.Name)
+			}
+		}
+	case *dst.DeclStmt:
+		gen, ok := s.Decl.(*dst.GenDecl)
+		if !ok {
+			return nil
+		}
+		for _, spec := range gen.Specs {
+			switch spec := spec.(type) {
+			case *dst.ValueSpec:
+				for _, id := range spec.Names {
+					names = append(names, id.Name)
+				}
+			case *dst.TypeSpec:
+				names = append(names, spec.Name.Name)
+			}
+		}
+	}
+	return names
+}
+
+// setDeclaredNames passes the names declared in the candidate's function scope
+// to vars for UniqueVar. Statements previously generated from the template are
+// excluded, so that the names they declare are chosen again on later runs.
+func (p *Processor) setDeclaredNames(vars *template.Vars, decl *dst.FuncDecl) error {
+	pattern, err := p.tmpl.RenderPlaceholders(*vars)
+	if err != nil {
+		return err
+	}
+	patternStmts, err := parseTemplateStatements(pattern)
+	if err != nil {
+		return err
+	}
+	stmtCount := len(patternStmts)
+
+	index, count := -1, stmtCount
+	if p.matching == config.MatchingMarker {
+		index, count = p.findMarked(decl.Body, stmtCount)
+	} else {
+		wildcards := p.comparator.WithWildcards(template.PlaceholderPrefix)
+		anyState := func(int, dst.Stmt) bool { return true }
+		if matches := p.findMatches(decl.Body, stmtCount, patternStmts, wildcards, anyState); len(matches) > 0 {
+			index = matches[0].index
+		}
+	}
+
+	var exclude []dst.Stmt
+	if index >= 0 {
+		exclude = decl.Body.List[index : index+count]
+	}
+	vars.SetDeclared(declaredNames(decl, exclude))
+	return nil
+}
+
+// checkConflicts reports an error if the rendered template declares a name
+// that is already declared in the function scope, which would not compile or
+// would silently reassign an existing variable. Rebinding the context carrier
+// variable itself, as in ctx, span := tracer.Start(ctx, "..."), is allowed.
+func checkConflicts(decl *dst.FuncDecl, rendered, ctxVar string) error {
+	stmts, err := dstutil.ParseStatements(rendered)
+	if err != nil {
+		return fmt.Errorf("failed to parse rendered statement: %w", err)
+	}
+	declared := declaredNames(decl, nil)
+	for _, stmt := range stmts {
+		for _, name := range stmtDeclaredNames(stmt) {
+			if name != ctxVar && declared[name] {
+				return fmt.Errorf("template declares %q, which is already declared in the function (use {{.UniqueVar %q}} to pick a free name)", name, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package processor
+
+import (
+	"go/ast"
+	"go/token"
+	"path/filepath"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+)
+
+// selection selects a single function to process, either by a line of a file
+// or by function key, or all the functions of a file.
+type selection struct {
+	filename string
+	line     int
+	key      string
+}
+
+// wholeFile reports whether all the functions of the file are selected.
+func (s *selection) wholeFile() bool {
+	return s.key == "" && s.line == 0
+}
+
+// WithSelection restricts Process to the function of filename whose
+// declaration spans line (1-based), e.g. the function at the cursor of an
+// editor. Other functions and files are left alone.
+func WithSelection(filename string, line int) Option {
+	return func(p *Processor) {
+		p.selection = &selection{filename: filepath.Clean(filename), line: line}
+	}
+}
+
+// WithFuncSelection restricts Process to the function identified by key, as in
+// baselines (e.g. "example.com/app/handler.Server.Handle"). Other functions
+// are left alone.
+func WithFuncSelection(key string) Option {
+	return func(p *Processor) {
+		p.selection = &selection{key: key}
+	}
+}
+
+// WithFileSelection restricts Process to the functions of filename, e.g. the
+// file of a //go:generate directive. Other files are left alone.
+func WithFileSelection(filename string) Option {
+	return func(p *Processor) {
+		p.selection = &selection{filename: filepath.Clean(filename)}
+	}
+}
+
+// withSelectedFunc returns a processor restricted to the selected function of
+// the file, if any. Returns p itself without a selection of a single function.
+func (p *Processor) withSelectedFunc(fset *token.FileSet, dec *decorator.Decorator, astFile *ast.File, pkgPath string) *Processor {
+	if p.selection == nil || p.selection.wholeFile() {
+		return p
+	}
+	q := *p
+	q.selectedFunc = nil
+	for _, decl := range astFile.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		df, ok := dec.Dst.Nodes[fd].(*dst.FuncDecl)
+		if !ok {
+			continue
+		}
+		if p.selection.key != "" {
+			if funcKey(pkgPath, df) == p.selection.key {
+				q.selectedFunc = df
+				break
+			}
+			continue
+		}
+		start, end := fset.Position(fd.Pos()).Line, fset.Position(fd.End()).Line
+		if start <= p.selection.line && p.selection.line <= end {
+			q.selectedFunc = df
+			break
+		}
+	}
+	return &q
+}
+
+// isSelected reports whether decl may be processed under the selection.
+func (p *Processor) isSelected(decl *dst.FuncDecl) bool {
+	return p.selection == nil || p.selection.wholeFile() || decl == p.selectedFunc
+}
@@ -0,0 +1,128 @@
+package processor
+
+import (
+	"strings"
+
+	"github.com/dave/dst"
+)
+
+// signatureString returns the canonical signature of a function matched by
+// signature regexps: the parameter and result types without names, receiver
+// and type parameters, e.g. "(context.Context, int) (string, error)".
+// Types of other packages are qualified by the last element of the import path,
+// ignoring a major version suffix (e.g. "echo.Context").
+func signatureString(ft *dst.FuncType) string {
+	var sb strings.Builder
+	sb.WriteString("(")
+	sb.WriteString(strings.Join(fieldTypes(ft.Params), ", "))
+	sb.WriteString(")")
+
+	results := fieldTypes(ft.Results)
+	switch {
+	case len(results) == 1:
+		sb.WriteString(" " + results[0])
+	case len(results) > 1:
+		sb.WriteString(" (" + strings.Join(results, ", ") + ")")
+	}
+	return sb.String()
+}
+
+// fieldTypes returns the type of each parameter or result, repeated for
+// grouped names (e.g. "a, b int").
+func fieldTypes(fields *dst.FieldList) []string {
+	if fields == nil {
+		return nil
+	}
+	var types []string
+	for _, f := range fields.List {
+		t := typeString(f.Type)
+		for range max(len(f.Names), 1) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// typeString formats a type expression on a single line.
+func typeString(expr dst.Expr) string {
+	switch t := expr.(type) {
+	case *dst.Ident:
+		if t.Path != "" {
+			return qualifierName(t.Path) + "." + t.Name
+		}
+		return t.Name
+	case *dst.SelectorExpr:
+		return typeString(t.X) + "." + t.Sel.Name
+	case *dst.StarExpr:
+		return "*" + typeString(t.X)
+	case *dst.ParenExpr:
+		return typeString(t.X)
+	case *dst.Ellipsis:
+		return "..." + typeString(t.Elt)
+	case *dst.ArrayType:
+		if t.Len == nil {
+			return "[]" + typeString(t.Elt)
+		}
+		if lit, ok := t.Len.(*dst.BasicLit); ok {
+			return "[" + lit.Value + "]" + typeString(t.Elt)
+		}
+		return "[...]" + typeString(t.Elt)
+	case *dst.MapType:
+		return "map[" + typeString(t.Key) + "]" + typeString(t.Value)
+	case *dst.ChanType:
+		switch t.Dir {
+		case dst.SEND:
+			return "chan<- " + typeString(t.Value)
+		case dst.RECV:
+			return "<-chan " + typeString(t.Value)
+		default:
+			return "chan " + typeString(t.Value)
+		}
+	case *dst.FuncType:
+		return "func" + signatureString(t)
+	case *dst.IndexExpr:
+		return typeString(t.X) + "[" + typeString(t.Index) + "]"
+	case *dst.IndexListExpr:
+		args := make([]string, len(t.Indices))
+		for i, index := range t.Indices {
+			args[i] = typeString(index)
+		}
+		return typeString(t.X) + "[" + strings.Join(args, ", ") + "]"
+	case *dst.InterfaceType:
+		if t.Methods == nil || len(t.Methods.List) == 0 {
+			return "interface{}"
+		}
+		return "interface{...}"
+	case *dst.StructType:
+		if t.Fields == nil || len(t.Fields.List) == 0 {
+			return "struct{}"
+		}
+		return "struct{...}"
+	default:
+		return "?"
+	}
+}
+
+// qualifierName returns the last element of an import path, ignoring a major
+// version suffix such as "v4".
+func qualifierName(pkgPath string) string {
+	elems := strings.Split(pkgPath, "/")
+	last := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(last) {
+		last = elems[len(elems)-2]
+	}
+	return last
+}
+
+// isMajorVersion reports whether elem is a module major version suffix like "v2".
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' {
+		return false
+	}
+	for _, r := range elem[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package processor
+
+import (
+	"strings"
+
+	"github.com/dave/dst"
+
+	"github.com/mpyw/ctxweaver/pkg/carrier"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+// Special functions, called by the runtime or the testing package rather than
+// by the code.
+const (
+	SpecialMain     = "main"     // main of a main package
+	SpecialInit     = "init"     // init of any package
+	SpecialTestMain = "TestMain" // TestMain of a test file
+)
+
+// SpecialFunc defines the weaving of a special function.
+type SpecialFunc struct {
+	// Exclude leaves the function alone, whatever its signature.
+	Exclude bool
+	// Template replaces the template for the function, which is then woven
+	// even without a carrier ({{.Ctx}} and {{.CtxVar}} are empty) and
+	// regardless of the function filter. The epilogue is not added. Nil keeps
+	// the template and weaves the function like any other.
+	Template *template.Template
+}
+
+// WithSpecialFuncs sets the weaving of the special functions, by name (see
+// SpecialMain, SpecialInit and SpecialTestMain). Special functions not set
+// are woven like any other: only with a carrier, which main and init never
+// have.
+func WithSpecialFuncs(funcs map[string]SpecialFunc) Option {
+	return func(p *Processor) {
+		p.specialFuncs = funcs
+	}
+}
+
+// specialFunc returns the definition of decl if it is a special function
+// configured with WithSpecialFuncs.
+func (p *Processor) specialFunc(df *dst.File, decl *dst.FuncDecl) (SpecialFunc, bool) {
+	if len(p.specialFuncs) == 0 || decl.Recv != nil {
+		return SpecialFunc{}, false
+	}
+	var name string
+	switch decl.Name.Name {
+	case "main":
+		if df.Name.Name != "main" {
+			return SpecialFunc{}, false
+		}
+		name = SpecialMain
+	case "init":
+		name = SpecialInit
+	case "TestMain":
+		if !strings.HasSuffix(p.filename, "_test.go") {
+			return SpecialFunc{}, false
+		}
+		name = SpecialTestMain
+	default:
+		return SpecialFunc{}, false
+	}
+	sf, ok := p.specialFuncs[name]
+	return sf, ok
+}
+
+// specialCandidate returns the candidate of a special function woven with its
+// own template, with the carrier if it has one.
+func (p *Processor) specialCandidate(decl *dst.FuncDecl, sf SpecialFunc, typeOf typeResolver) funcCandidate {
+	c := funcCandidate{decl: decl, match: &carrier.MatchResult{}}
+	if m := p.tryMatchCarrier(decl, typeOf); m != nil {
+		c.match = m.match
+	}
+	c.tmpl = sf.Template
+	return c
+}
+
+// forCandidate returns the processor rendering the candidate: a copy with the
+// template of a special function or of a hot path and no epilogue, or p itself.
+func (p *Processor) forCandidate(c funcCandidate) *Processor {
+	if c.tmpl == nil {
+		return p
+	}
+	q := *p
+	q.tmpl, q.epilogue = c.tmpl, nil
+	if c.tmpl == p.hotTmpl {
+		q.regularTmpl = p.tmpl
+	}
+	return &q
+}
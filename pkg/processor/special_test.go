@@ -0,0 +1,122 @@
+package processor_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWithSpecialFuncs(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	tmpDir := setupTestModule(t, map[string]string{
+		"main.go": `package main
+
+import "context"
+
+func init() {
+	setup()
+}
+
+func main() {
+	run(context.Background())
+}
+
+func run(ctx context.Context) {
+}
+
+func setup() {}
+
+func trace(context.Context) {}
+
+func start(string) {}
+`,
+		"main_test.go": `package main
+
+import "testing"
+
+func TestMain(m *testing.M) {
+	m.Run()
+}
+`,
+	})
+
+	oldWd, _ := os.Getwd()
+	_ = os.Chdir(tmpDir)
+	defer func() { _ = os.Chdir(oldWd) }()
+
+	proc := processor.New(registry, tmpl, nil,
+		processor.WithTest(true),
+		processor.WithFunctions(config.Functions{SkipDelegates: true}),
+		processor.WithSpecialFuncs(map[string]processor.SpecialFunc{
+			processor.SpecialMain:     {Template: template.MustParse(`start({{.FuncName | quote}})`)},
+			processor.SpecialInit:     {Exclude: true},
+			processor.SpecialTestMain: {Template: template.MustParse(`start({{.FuncName | quote}})`)},
+		}),
+	)
+	result, err := proc.Process([]string{"./..."})
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Process errors: %v", result.Errors)
+	}
+
+	got, err := os.ReadFile(filepath.Join(tmpDir, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `package main
+
+import "context"
+
+func init() {
+	setup()
+}
+
+func main() {
+	start("main.main")
+
+	run(context.Background())
+}
+
+func run(ctx context.Context) {
+	defer trace(ctx)
+
+}
+
+func setup() {}
+
+func trace(context.Context) {}
+
+func start(string) {}
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("main.go mismatch (-want +got):\n%s", diff)
+	}
+
+	got, err = os.ReadFile(filepath.Join(tmpDir, "main_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `package main
+
+import "testing"
+
+func TestMain(m *testing.M) {
+	start("main.TestMain")
+
+	m.Run()
+}
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("main_test.go mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,141 @@
+package processor
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver/goast"
+	"github.com/dave/dst/decorator/resolver/guess"
+	"golang.org/x/mod/modfile"
+
+	"github.com/mpyw/ctxweaver/internal/directive"
+)
+
+// TransformOptions describes the package context of a single source file
+// transformed by TransformFile.
+type TransformOptions struct {
+	// PkgPath is the import path of the package the file belongs to.
+	// It is exposed to templates as {{.PackagePath}}.
+	PkgPath string
+	// PkgName is the expected package name. If set, it must match the
+	// package clause of the source file.
+	PkgName string
+	// Imports maps import paths to package names. It is used to resolve
+	// qualified identifiers (e.g. the package of a carrier type) when the
+	// package name differs from the last element of its import path.
+	Imports map[string]string
+	// ModulePath is the path of the module containing the package, exposed
+	// to templates as {{.ModulePath}}. If empty, it is read from the go.mod
+	// file enclosing Filename, if any, which also gives the Go version for
+	// gofumpt (see WithFormat).
+	ModulePath string
+	// Filename is the path the source would have on disk. It is optional and
+	// used by goimports to locate the enclosing module, and exposed to
+	// templates as {{.FileName}} relative to the root of that module.
+	Filename string
+}
+
+// TransformFile transforms a single source file without loading its package.
+// Carrier types are resolved from the file's import declarations, so results
+// match Process for carriers imported from other packages.
+// Returns the transformed source and whether it differs from src.
+// In remove mode, generated statements are removed instead of added.
+// It is safe for concurrent use, provided the functions given as options
+// (e.g. WithConfirm, WithPlugins, WithMutators) are.
+func (p *Processor) TransformFile(src []byte, opts TransformOptions) ([]byte, bool, error) {
+	p = p.forPackage(opts.PkgPath)
+
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, opts.Filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to parse source: %w", err)
+	}
+
+	if opts.PkgName != "" && astFile.Name.Name != opts.PkgName {
+		return nil, false, fmt.Errorf("package clause %q does not match PkgName %q", astFile.Name.Name, opts.PkgName)
+	}
+
+	// Skip generated files (files with "// Code generated" comment)
+	if p.skipsGenerated(astFile, transformFilename(opts.Filename)) {
+		return src, false, nil
+	}
+
+	dec := decorator.NewDecoratorWithImports(fset, opts.PkgPath, goast.WithResolver(guess.WithMap(opts.Imports)))
+	df, err := dec.DecorateFile(astFile)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to decorate file: %w", err)
+	}
+
+	// Check for file-level skip directive
+	if directive.HasSkipDirective(df.Decorations()) {
+		return src, false, nil
+	}
+
+	p = p.withModule(transformModule(opts.ModulePath, opts.Filename))
+	fr, err := p.withPackageDecls([]*ast.File{astFile}).withFilename(transformFilename(opts.Filename)).processFunctions(df, opts.PkgPath, nil, importNames(opts.Imports))
+	if err != nil {
+		return nil, false, err
+	}
+	if !fr.modified {
+		return src, false, nil
+	}
+
+	result, err := p.restoreFile(df, decorator.NewRestorerWithImports(opts.PkgPath, guess.WithMap(opts.Imports)), opts.Filename)
+	if err != nil {
+		return nil, false, err
+	}
+	return restoreLineEndings(src, restoreLineDirectives(src, result)), true, nil
+}
+
+// transformFilename returns the name of a transformed file exposed to
+// templates: filename relative to the root of its module if found, or as is.
+func transformFilename(filename string) string {
+	if filename == "" {
+		return ""
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return filepath.ToSlash(filename)
+	}
+	if root := moduleRoot(filepath.Dir(abs)); root != "" {
+		return relPath(root, abs)
+	}
+	return filepath.ToSlash(filename)
+}
+
+// transformModule returns the module path of a transformed file: modulePath
+// if set, or the path declared by the go.mod file enclosing filename, if any;
+// and the Go version declared by that go.mod file, if any.
+func transformModule(modulePath, filename string) (path, goVersion string) {
+	if filename == "" {
+		return modulePath, ""
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return modulePath, ""
+	}
+	root := moduleRoot(filepath.Dir(abs))
+	if root == "" {
+		return modulePath, ""
+	}
+	data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return modulePath, ""
+	}
+	f, err := modfile.ParseLax("go.mod", data, nil)
+	if err != nil {
+		return modulePath, ""
+	}
+	if f.Go != nil {
+		goVersion = f.Go.Version
+	}
+	if modulePath == "" && f.Module != nil {
+		modulePath = f.Module.Mod.Path
+	}
+	return modulePath, goVersion
+}
@@ -0,0 +1,2970 @@
+package processor_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dave/dst"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestTransformFile(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+
+	tests := map[string]struct {
+		tmpl    string
+		src     string
+		opts    processor.TransformOptions
+		remove  bool
+		want    string
+		wantMod bool
+		wantErr string
+	}{
+		"package path is available to templates": {
+			tmpl: `defer trace({{.Ctx}}, {{.PackagePath | quote}})`,
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+			opts: processor.TransformOptions{PkgPath: "example.com/app/service"},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "example.com/app/service")
+
+}
+`,
+			wantMod: true,
+		},
+		"module path is available to templates": {
+			tmpl: `defer trace({{.Ctx}}, {{.ModulePath | quote}}, {{.PackageRelPath | quote}})`,
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+			opts: processor.TransformOptions{PkgPath: "example.com/app/internal/service", ModulePath: "example.com/app"},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "example.com/app", "internal/service")
+
+}
+`,
+			wantMod: true,
+		},
+		"CRLF line endings are preserved": {
+			tmpl:    `defer trace({{.Ctx}})`,
+			src:     "package service\r\n\r\nimport \"context\"\r\n\r\n// Foo does nothing.\r\nfunc Foo(ctx context.Context) {\r\n}\r\n",
+			want:    "package service\r\n\r\nimport \"context\"\r\n\r\n// Foo does nothing.\r\nfunc Foo(ctx context.Context) {\r\n\tdefer trace(ctx)\r\n\r\n}\r\n",
+			opts:    processor.TransformOptions{PkgPath: "example.com/app/service"},
+			wantMod: true,
+		},
+		"carrier resolved through imports map": {
+			tmpl: `defer trace({{.Ctx}})`,
+			src: `package handler
+
+import "github.com/labstack/echo/v4"
+
+func Handle(c echo.Context) error {
+	return nil
+}
+`,
+			opts: processor.TransformOptions{
+				PkgPath: "example.com/app/handler",
+				Imports: map[string]string{"github.com/labstack/echo/v4": "echo"},
+			},
+			want: `package handler
+
+import "github.com/labstack/echo/v4"
+
+func Handle(c echo.Context) error {
+	defer trace(c.Request().Context())
+
+	return nil
+}
+`,
+			wantMod: true,
+		},
+		"aliased carrier import": {
+			tmpl: `defer trace({{.Ctx}})`,
+			src: `package service
+
+import stdctx "context"
+
+func Foo(ctx stdctx.Context) {
+}
+`,
+			opts: processor.TransformOptions{PkgPath: "example.com/app/service"},
+			want: `package service
+
+import stdctx "context"
+
+func Foo(ctx stdctx.Context) {
+	defer trace(ctx)
+
+}
+`,
+			wantMod: true,
+		},
+		"already up to date": {
+			tmpl: `defer trace({{.Ctx}})`,
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+}
+`,
+			opts: processor.TransformOptions{PkgPath: "example.com/app/service"},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+}
+`,
+		},
+		"remove mode": {
+			tmpl: `defer trace({{.Ctx}})`,
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+}
+`,
+			opts:   processor.TransformOptions{PkgPath: "example.com/app/service"},
+			remove: true,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {}
+`,
+			wantMod: true,
+		},
+		"package name mismatch": {
+			tmpl: `defer trace({{.Ctx}})`,
+			src: `package service
+`,
+			opts:    processor.TransformOptions{PkgName: "other"},
+			wantErr: `package clause "service" does not match PkgName "other"`,
+		},
+		"functions between off and on directives are left alone": {
+			tmpl: `defer trace({{.Ctx}})`,
+			src: `package service
+
+import "context"
+
+//ctxweaver:off generated region
+func Gen1(ctx context.Context) {
+}
+
+func Gen2(ctx context.Context) {
+}
+
+//ctxweaver:on
+
+func Foo(ctx context.Context) {
+}
+`,
+			opts: processor.TransformOptions{PkgPath: "example.com/app/service"},
+			want: `package service
+
+import "context"
+
+//ctxweaver:off generated region
+func Gen1(ctx context.Context) {
+}
+
+func Gen2(ctx context.Context) {
+}
+
+//ctxweaver:on
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+
+}
+`,
+			wantMod: true,
+		},
+		"remove mode leaves functions in an off region alone": {
+			tmpl: `defer trace({{.Ctx}})`,
+			src: `package service
+
+import "context"
+
+//ctxweaver:off
+func Gen(ctx context.Context) {
+	defer trace(ctx)
+}
+`,
+			opts:   processor.TransformOptions{PkgPath: "example.com/app/service"},
+			remove: true,
+			want: `package service
+
+import "context"
+
+//ctxweaver:off
+func Gen(ctx context.Context) {
+	defer trace(ctx)
+}
+`,
+		},
+		"syntax error": {
+			tmpl:    `defer trace({{.Ctx}})`,
+			src:     `package service func`,
+			wantErr: "failed to parse source",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, template.MustParse(tt.tmpl), nil, processor.WithRemove(tt.remove))
+
+			got, modified, err := proc.TransformFile([]byte(tt.src), tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("TransformFile() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if modified != tt.wantMod {
+				t.Errorf("modified = %v, want %v", modified, tt.wantMod)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// anyBasicLit treats all basic literals as equal, even in exact mode.
+type anyBasicLit struct{}
+
+func (anyBasicLit) Compare(_, _ dst.Node, _ string, _ bool, _ *processor.Comparator) bool {
+	return true
+}
+
+func TestTransformFile_Concurrent(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`{{.UniqueVar "span"}} := trace({{.Ctx}}, {{.FuncName | unique | quote}})
+defer {{.UniqueVar "span"}}.End()`)
+	proc := processor.New(registry, tmpl, nil,
+		processor.WithEpilogue(template.MustParse(`log({{.FuncName | quote}})`)),
+		processor.WithOverrides(processor.PackageOverride{Packages: []*regexp.Regexp{regexp.MustCompile("/handler$")}, Template: template.MustParse(`defer handle({{.Ctx}})`)}),
+	)
+
+	// Every transform runs against the same processor (e.g. in analysis drivers)
+	var wg sync.WaitGroup
+	for i := range 16 {
+		pkg := []string{"service", "handler"}[i%2]
+		src := fmt.Sprintf("package %s\n\nimport \"context\"\n\nfunc Foo%d(ctx context.Context) {\n}\n", pkg, i)
+		want := map[string]string{
+			"service": fmt.Sprintf("\tspan := trace(ctx, \"%[1]s.Foo%[2]d\")\n\tdefer span.End()\n\n\tlog(\"%[1]s.Foo%[2]d\")\n", pkg, i),
+			"handler": "\tdefer handle(ctx)\n",
+		}[pkg]
+		wg.Go(func() {
+			got, _, err := proc.TransformFile([]byte(src), processor.TransformOptions{PkgPath: "example.com/app/" + pkg})
+			if err != nil {
+				t.Errorf("TransformFile() error = %v", err)
+				return
+			}
+			if !strings.Contains(string(got), want) {
+				t.Errorf("TransformFile() = %s, want containing %q", got, want)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+func TestWithComparator(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, "v2")`)
+	src := []byte(`package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "v1")
+}
+`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	t.Run("default comparator updates literal", func(t *testing.T) {
+		proc := processor.New(registry, tmpl, nil)
+		got, modified, err := proc.TransformFile(src, opts)
+		if err != nil {
+			t.Fatalf("TransformFile() error = %v", err)
+		}
+		if !modified || !strings.Contains(string(got), `defer trace(ctx, "v2")`) {
+			t.Errorf("expected statement to be updated, got:\n%s", got)
+		}
+	})
+
+	t.Run("custom comparer is used", func(t *testing.T) {
+		c := processor.NewComparator()
+		c.Register(reflect.TypeOf((*dst.BasicLit)(nil)), anyBasicLit{})
+
+		proc := processor.New(registry, tmpl, nil, processor.WithComparator(c))
+		_, modified, err := proc.TransformFile(src, opts)
+		if err != nil {
+			t.Fatalf("TransformFile() error = %v", err)
+		}
+		if modified {
+			t.Error("expected custom comparer to treat the statement as up to date")
+		}
+	})
+}
+
+func TestWithMatching(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+	src := []byte(`package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(context.WithoutCancel(ctx), "service.Foo")
+}
+`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	t.Run("skeleton mode inserts a duplicate", func(t *testing.T) {
+		proc := processor.New(registry, tmpl, nil, processor.WithMatching(config.MatchingSkeleton))
+		got, _, err := proc.TransformFile(src, opts)
+		if err != nil {
+			t.Fatalf("TransformFile() error = %v", err)
+		}
+		if n := strings.Count(string(got), "defer trace("); n != 2 {
+			t.Errorf("expected 2 trace statements, got %d:\n%s", n, got)
+		}
+	})
+
+	t.Run("placeholder mode updates the existing statement", func(t *testing.T) {
+		proc := processor.New(registry, tmpl, nil, processor.WithMatching(config.MatchingPlaceholder))
+		got, modified, err := proc.TransformFile(src, opts)
+		if err != nil {
+			t.Fatalf("TransformFile() error = %v", err)
+		}
+		want := `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo")
+}
+`
+		if !modified {
+			t.Error("expected file to be modified")
+		}
+		if diff := cmp.Diff(want, string(got)); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("placeholder mode still requires static parts to match", func(t *testing.T) {
+		other := []byte(`package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer untrace(ctx, "service.Foo")
+}
+`)
+		proc := processor.New(registry, tmpl, nil, processor.WithMatching(config.MatchingPlaceholder))
+		got, _, err := proc.TransformFile(other, opts)
+		if err != nil {
+			t.Fatalf("TransformFile() error = %v", err)
+		}
+		if !strings.Contains(string(got), "defer untrace(") || !strings.Contains(string(got), "defer trace(") {
+			t.Errorf("expected unrelated statement to be kept and template inserted:\n%s", got)
+		}
+	})
+}
+
+func TestWithDedupe(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		src    string
+		dedupe bool
+		want   string
+	}{
+		"duplicates collapsed into one updated statement": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Old")
+	defer trace(ctx, "service.Foo")
+	doSomething()
+	defer trace(ctx, "service.Older")
+}
+`,
+			dedupe: true,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo")
+	doSomething()
+}
+`,
+		},
+		"protected duplicate is kept": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo")
+	defer trace(ctx, "custom") //ctxweaver:skip
+}
+`,
+			dedupe: true,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo")
+	defer trace(ctx, "custom") //ctxweaver:skip
+}
+`,
+		},
+		"without dedupe only the first statement is updated": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Old")
+	defer trace(ctx, "service.Old")
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo")
+	defer trace(ctx, "service.Old")
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithDedupe(tt.dedupe))
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithCarrierPriority(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/handler"}
+
+	src := `package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+func Serve(r *http.Request, ctx context.Context) {
+}
+`
+
+	tests := map[string]struct {
+		priority []string
+		want     string
+	}{
+		"without priority the first parameter is the carrier": {
+			want: `package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+func Serve(r *http.Request, ctx context.Context) {
+	defer trace(r.Context())
+
+}
+`,
+		},
+		"highest-ranked carrier wins": {
+			priority: []string{"context.Context", "net/http.Request"},
+			want: `package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+func Serve(r *http.Request, ctx context.Context) {
+	defer trace(ctx)
+
+}
+`,
+		},
+		"unlisted carriers rank last": {
+			priority: []string{"*http.Request"},
+			want: `package handler
+
+import (
+	"context"
+	"net/http"
+)
+
+func Serve(r *http.Request, ctx context.Context) {
+	defer trace(r.Context())
+
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithCarrierPriority(tt.priority))
+			got, _, err := proc.TransformFile([]byte(src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithFunctions_MinStatements(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	src := `package service
+
+import "context"
+
+func Get(ctx context.Context) string {
+	return "value"
+}
+
+func Sync(ctx context.Context, items []string) {
+	for _, item := range items {
+		save(ctx, item)
+	}
+}
+`
+
+	tests := map[string]struct {
+		minStatements int
+		wantGet       bool
+		wantSync      bool
+	}{
+		"no minimum": {
+			wantGet:  true,
+			wantSync: true,
+		},
+		"nested statements are counted": {
+			minStatements: 2,
+			wantSync:      true,
+		},
+		"below minimum": {
+			minStatements: 3,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithFunctions(config.Functions{MinStatements: tt.minStatements}))
+			got, _, err := proc.TransformFile([]byte(src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if gotGet := strings.Contains(string(got), "string {\n\tdefer trace(ctx)"); gotGet != tt.wantGet {
+				t.Errorf("Get instrumented = %v, want %v:\n%s", gotGet, tt.wantGet, got)
+			}
+			if gotSync := strings.Contains(string(got), "[]string) {\n\tdefer trace(ctx)"); gotSync != tt.wantSync {
+				t.Errorf("Sync instrumented = %v, want %v:\n%s", gotSync, tt.wantSync, got)
+			}
+		})
+	}
+}
+
+func TestWithFunctions_Receivers(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	src := `package service
+
+import "context"
+
+func (s *Service) Get(ctx context.Context) {
+}
+
+func (h Handler) Serve(ctx context.Context) {
+}
+
+func (m *mockService) Get(ctx context.Context) {
+}
+
+func Run(ctx context.Context) {
+}
+`
+
+	tests := map[string]struct {
+		regexps config.Regexps
+		want    []string
+	}{
+		"no filter": {
+			want: []string{"Service", "Handler", "mockService", "Run"},
+		},
+		"only pointer receivers": {
+			regexps: config.Regexps{Only: []string{`^\*`}},
+			want:    []string{"Service", "mockService", "Run"},
+		},
+		"only and omit": {
+			regexps: config.Regexps{Only: []string{`Service$`, `Handler$`}, Omit: []string{`(?i)mock`}},
+			want:    []string{"Service", "Handler", "Run"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithFunctions(config.Functions{
+				Receivers: config.Receivers{Regexps: tt.regexps},
+			}))
+			got, _, err := proc.TransformFile([]byte(src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			var instrumented []string
+			for _, fn := range []struct{ name, sig string }{
+				{"Service", "(s *Service) Get"},
+				{"Handler", "(h Handler) Serve"},
+				{"mockService", "(m *mockService) Get"},
+				{"Run", "Run"},
+			} {
+				if strings.Contains(string(got), "func "+fn.sig+"(ctx context.Context) {\n\tdefer trace(ctx)") {
+					instrumented = append(instrumented, fn.name)
+				}
+			}
+			if diff := cmp.Diff(tt.want, instrumented); diff != "" {
+				t.Errorf("instrumented functions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithFunctions_Signatures(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	opts := processor.TransformOptions{
+		PkgPath: "example.com/app/service",
+		Imports: map[string]string{"github.com/labstack/echo/v4": "echo"},
+	}
+
+	src := `package service
+
+import (
+	"context"
+
+	"github.com/labstack/echo/v4"
+)
+
+func Ping(ctx context.Context) {
+}
+
+func Name(ctx context.Context) string {
+	return ""
+}
+
+func Find(ctx context.Context, ids ...int) (map[int]*User, error) {
+	return nil, nil
+}
+
+func Handle(c echo.Context) error {
+	return nil
+}
+`
+
+	tests := map[string]struct {
+		regexps config.Regexps
+		want    []string
+	}{
+		"no filter": {
+			want: []string{"Ping", "Name", "Find", "Handle"},
+		},
+		"omit context-only params and string results": {
+			regexps: config.Regexps{Omit: []string{`^\(context\.Context\)$`, `\) string$`}},
+			want:    []string{"Find", "Handle"},
+		},
+		"canonical types": {
+			regexps: config.Regexps{Only: []string{`^\(context\.Context, \.\.\.int\) \(map\[int\]\*User, error\)$`, `^\(echo\.Context\) error$`}},
+			want:    []string{"Find", "Handle"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithFunctions(config.Functions{
+				Signatures: config.Signatures{Regexps: tt.regexps},
+			}))
+			got, _, err := proc.TransformFile([]byte(src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			var instrumented []string
+			for _, fn := range []string{"Ping", "Name", "Find", "Handle"} {
+				if regexp.MustCompile(`func ` + fn + `\(.*\{\n\tdefer trace\(`).Match(got) {
+					instrumented = append(instrumented, fn)
+				}
+			}
+			if diff := cmp.Diff(tt.want, instrumented); diff != "" {
+				t.Errorf("instrumented functions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithFunctions_SkipDelegates(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		src  string
+		want string
+	}{
+		"delegating functions and methods are skipped": {
+			src: `package service
+
+import "context"
+
+type Service struct{}
+
+func (s *Service) Get(ctx context.Context, id int) string {
+	return s.get(ctx, id)
+}
+
+func (s *Service) get(ctx context.Context, id int) string {
+	return load(ctx, id)
+}
+
+func load(ctx context.Context, id int) string {
+	println(id)
+	return ""
+}
+`,
+			want: `package service
+
+import "context"
+
+type Service struct{}
+
+func (s *Service) Get(ctx context.Context, id int) string {
+	return s.get(ctx, id)
+}
+
+func (s *Service) get(ctx context.Context, id int) string {
+	return load(ctx, id)
+}
+
+func load(ctx context.Context, id int) string {
+	defer trace(ctx)
+
+	println(id)
+	return ""
+}
+`,
+		},
+		"callees that are not instrumented keep the caller": {
+			src: `package service
+
+import "context"
+
+func Sync(ctx context.Context) {
+	sync()
+}
+
+func sync() {
+}
+`,
+			want: `package service
+
+import "context"
+
+func Sync(ctx context.Context) {
+	defer trace(ctx)
+
+	sync()
+}
+
+func sync() {
+}
+`,
+		},
+		"cycles are instrumented": {
+			src: `package service
+
+import "context"
+
+func Ping(ctx context.Context) {
+	Pong(ctx)
+}
+
+func Pong(ctx context.Context) {
+	Ping(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Ping(ctx context.Context) {
+	defer trace(ctx)
+
+	Pong(ctx)
+}
+
+func Pong(ctx context.Context) {
+	defer trace(ctx)
+
+	Ping(ctx)
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithFunctions(config.Functions{SkipDelegates: true}))
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithBanner(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		src          string
+		remove       bool
+		want         string
+		wantModified bool
+	}{
+		"banner is added with the first statement": {
+			src: `// Package service provides services.
+package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	println()
+}
+`,
+			want: `// Instrumented by ctxweaver; DO NOT EDIT generated statements.
+
+// Package service provides services.
+package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+
+	println()
+}
+`,
+			wantModified: true,
+		},
+		"up-to-date file is unchanged": {
+			src: `// Instrumented by ctxweaver; DO NOT EDIT generated statements.
+
+package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+
+	println()
+}
+`,
+			want: `// Instrumented by ctxweaver; DO NOT EDIT generated statements.
+
+package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+
+	println()
+}
+`,
+		},
+		"banner is removed with the last statement": {
+			src: `// Instrumented by ctxweaver; DO NOT EDIT generated statements.
+
+//go:build linux
+
+package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+	println()
+}
+`,
+			remove: true,
+			want: `//go:build linux
+
+package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	println()
+}
+`,
+			wantModified: true,
+		},
+		"files without generated statements get no banner": {
+			src: `package service
+
+func Foo() {
+}
+`,
+			want: `package service
+
+func Foo() {
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithBanner(true), processor.WithRemove(tt.remove))
+			got, modified, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if modified != tt.wantModified {
+				t.Errorf("modified = %v, want %v", modified, tt.wantModified)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithRenames(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse("traceSpan := start({{.Ctx}})\ndefer traceSpan.End()")
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+	renames := map[string]string{"span": "traceSpan"}
+
+	tests := map[string]struct {
+		src     string
+		want    string
+		wantErr string
+	}{
+		"statements and references are renamed": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	span := start(ctx)
+	defer span.End()
+
+	span.SetName(span.Name)
+	if err := do(); err != nil {
+		span.Fail(err)
+	}
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	traceSpan := start(ctx)
+	defer traceSpan.End()
+
+	traceSpan.SetName(traceSpan.Name)
+	if err := do(); err != nil {
+		traceSpan.Fail(err)
+	}
+}
+`,
+		},
+		"reassignments and shadowing function literals": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	span := start(ctx)
+	defer span.End()
+
+	span = other()
+	go func(span *Span) {
+		span.Fail(nil)
+	}(span)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	traceSpan := start(ctx)
+	defer traceSpan.End()
+
+	traceSpan = other()
+	go func(span *Span) {
+		span.Fail(nil)
+	}(traceSpan)
+}
+`,
+		},
+		"functions without statements are left alone": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+		},
+		"new name already declared": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context, traceSpan string) {
+	span := start(ctx)
+	defer span.End()
+}
+`,
+			wantErr: "cannot rename to traceSpan: already declared",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithRenames(renames))
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("TransformFile() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMarkerMatching(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		src     string
+		options []processor.Option
+		want    string
+	}{
+		"inserts with marker": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH n=1
+
+}
+`,
+		},
+		"marked statement is updated": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(context.WithoutCancel(ctx), "service.Old") //ctxweaver:generated
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH n=1
+}
+`,
+		},
+		"unmarked statement is left alone": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo")
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH n=1
+
+	defer trace(ctx, "service.Foo")
+}
+`,
+		},
+		"marker of another template version is updated": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=00000000
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH n=1
+}
+`,
+		},
+		"marker of the current template version is unchanged": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") // ctxweaver:generated sha=HASH n=1
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") // ctxweaver:generated sha=HASH n=1
+}
+`,
+		},
+		"inserts with custom marker": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+			options: []processor.Option{processor.WithMarker("managed-by: obs-platform")},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") // managed-by: obs-platform sha=HASH n=1
+
+}
+`,
+		},
+		"marker without a count gets one": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH n=1
+}
+`,
+		},
+		"custom marker of the current template version is unchanged": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") // managed-by: obs-platform sha=HASH n=1
+}
+`,
+			options: []processor.Option{processor.WithMarker("// managed-by: obs-platform")},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") // managed-by: obs-platform sha=HASH n=1
+}
+`,
+		},
+		"default marker gets the custom marker": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH
+}
+`,
+			options: []processor.Option{processor.WithMarker("// managed-by: obs-platform")},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") // managed-by: obs-platform sha=HASH n=1
+}
+`,
+		},
+		"default marker is removed with a custom marker": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated
+	defer trace(ctx, "manual")
+}
+`,
+			options: []processor.Option{processor.WithMarker("// managed-by: obs-platform"), processor.WithRemove(true)},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "manual")
+}
+`,
+		},
+		"remove only removes marked statement": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated
+	defer trace(ctx, "manual")
+}
+`,
+			options: []processor.Option{processor.WithRemove(true)},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "manual")
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			options := append([]processor.Option{processor.WithMatching(config.MatchingMarker)}, tt.options...)
+			proc := processor.New(registry, tmpl, nil, options...)
+			// HASH stands for the hash of the template recorded by the marker
+			src := strings.ReplaceAll(tt.src, "HASH", tmpl.Hash())
+			got, _, err := proc.TransformFile([]byte(src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(strings.ReplaceAll(tt.want, "HASH", tmpl.Hash()), string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMarkerMatching_GroupSpan(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+	single := `defer trace({{.Ctx}}, {{.FuncName | quote}})`
+	double := `ctx, span := tracer.Start({{.Ctx}}, {{.FuncName | quote}})
+defer span.End()`
+
+	tests := map[string]struct {
+		tmpl    string
+		src     string
+		options []processor.Option
+		want    string
+		wantErr string
+	}{
+		"grown template replaces the marked statement": {
+			tmpl: double,
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=00000000 n=1
+	doWork(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "service.Foo")
+	defer span.End() //ctxweaver:generated sha=HASH n=2
+	doWork(ctx)
+}
+`,
+		},
+		"shrunk template replaces the marked statements": {
+			tmpl: single,
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "service.Foo")
+	defer span.End() //ctxweaver:generated sha=00000000 n=2
+	doWork(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH n=1
+	doWork(ctx)
+}
+`,
+		},
+		"marker without a count at the start of the body": {
+			tmpl: double,
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated
+	doWork(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "service.Foo")
+	defer span.End() //ctxweaver:generated sha=HASH n=2
+	doWork(ctx)
+}
+`,
+		},
+		"remove spans the marked statements": {
+			tmpl: single,
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "service.Foo")
+	defer span.End() //ctxweaver:generated sha=00000000 n=2
+	doWork(ctx)
+}
+`,
+			options: []processor.Option{processor.WithRemove(true)},
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	doWork(ctx)
+}
+`,
+		},
+		"template ending with a comment is rejected": {
+			tmpl: `defer trace({{.Ctx}}) // traced`,
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+			wantErr: "ends with a comment",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tmpl := template.MustParse(tt.tmpl)
+			options := append([]processor.Option{processor.WithMatching(config.MatchingMarker)}, tt.options...)
+			proc := processor.New(registry, tmpl, nil, options...)
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("TransformFile() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			// HASH stands for the hash of the template recorded by the marker
+			if diff := cmp.Diff(strings.ReplaceAll(tt.want, "HASH", tmpl.Hash()), string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithMarkerMigration(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		src  string
+		want string
+	}{
+		"existing statement gets marker": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Old")
+	doSomething()
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH n=1
+	doSomething()
+}
+`,
+		},
+		"already marked statement is unchanged": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated
+}
+`,
+		},
+		"function without statement is not instrumented": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	doSomething()
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	doSomething()
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithMarkerMigration(true))
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			// HASH stands for the hash of the template recorded by the marker
+			if diff := cmp.Diff(strings.ReplaceAll(tt.want, "HASH", tmpl.Hash()), string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithDriftDetection(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		src         string
+		want        string
+		wantChanged bool
+	}{
+		"marker of another template version is drifted": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx) //ctxweaver:generated sha=00000000
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH n=1
+}
+`,
+			wantChanged: true,
+		},
+		"marker without hash is drifted": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo") //ctxweaver:generated sha=HASH n=1
+}
+`,
+			wantChanged: true,
+		},
+		"marker of the current template version is not drifted": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx) //ctxweaver:generated sha=HASH
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx) //ctxweaver:generated sha=HASH
+}
+`,
+		},
+		"function without statement is not instrumented": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	doSomething()
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	doSomething()
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil,
+				processor.WithMatching(config.MatchingMarker),
+				processor.WithDriftDetection(true),
+			)
+			// HASH stands for the hash of the template recorded by the marker
+			got, changed, err := proc.TransformFile([]byte(strings.ReplaceAll(tt.src, "HASH", tmpl.Hash())), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+			if diff := cmp.Diff(strings.ReplaceAll(tt.want, "HASH", tmpl.Hash()), string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithRefresh(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}}, "v1")`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		src  string
+		mode config.RefreshMode
+		want string
+	}{
+		"vars: renamed function is refreshed": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Bar", "v1")
+}
+`,
+			mode: config.RefreshVars,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo", "v1")
+}
+`,
+		},
+		"vars: hand-edited static literal is kept": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo", "custom")
+}
+`,
+			mode: config.RefreshVars,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo", "custom")
+}
+`,
+		},
+		"all: hand-edited static literal is overwritten": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo", "custom")
+}
+`,
+			mode: config.RefreshAll,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, "service.Foo", "v1")
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithRefresh(tt.mode))
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTransformFile_PrometheusPreset(t *testing.T) {
+	preset, ok := config.LookupPreset("prometheus")
+	if !ok {
+		t.Fatal("prometheus preset not found")
+	}
+	registry := config.NewCarrierRegistry(true)
+	proc := processor.New(registry, template.MustParse(preset.Template), preset.Imports)
+
+	src := `package service
+
+import (
+	"context"
+
+	"example.com/app/internal/metrics"
+)
+
+var _ = metrics.FunctionDuration
+
+type UserService struct{}
+
+func (s *UserService) GetUserByID(ctx context.Context) {
+}
+`
+	got, _, err := proc.TransformFile([]byte(src), processor.TransformOptions{PkgPath: "example.com/app/service/v2"})
+	if err != nil {
+		t.Fatalf("TransformFile() error = %v", err)
+	}
+
+	want := `package service
+
+import (
+	"context"
+	"time"
+
+	"example.com/app/internal/metrics"
+)
+
+var _ = metrics.FunctionDuration
+
+type UserService struct{}
+
+func (s *UserService) GetUserByID(ctx context.Context) {
+	defer func(start time.Time) {
+		metrics.FunctionDuration.WithLabelValues("service", "user_service_get_user_by_id").Observe(time.Since(start).Seconds())
+	}(time.Now())
+
+}
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTransformFile_RecoverPreset(t *testing.T) {
+	preset, ok := config.LookupPreset("recover")
+	if !ok {
+		t.Fatal("recover preset not found")
+	}
+	registry := config.NewCarrierRegistry(true)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	const woven = `package service
+
+import (
+	"net/http"
+
+	"example.com/app/internal/panics"
+)
+
+func Handle(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			panics.Report(r.Context(), "service.Handle", recovered)
+			panic(recovered)
+		}
+	}()
+
+	w.WriteHeader(http.StatusOK)
+}
+`
+
+	tests := map[string]struct {
+		src     string
+		remove  bool
+		want    string
+		wantMod bool
+	}{
+		"recovery block is inserted": {
+			src: `package service
+
+import "net/http"
+
+func Handle(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+`,
+			want:    woven,
+			wantMod: true,
+		},
+		"recovery block is not duplicated": {
+			src: woven,
+		},
+		"outdated recovery block is updated": {
+			src:     strings.Replace(woven, `"service.Handle"`, `"Handle"`, 1),
+			want:    woven,
+			wantMod: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// The package declaring the reporter is imported as for the prometheus preset
+			imports := append(preset.Imports, "example.com/app/internal/panics")
+			proc := processor.New(registry, template.MustParse(preset.Template), imports)
+			got, modified, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if modified != tt.wantMod {
+				t.Errorf("modified = %v, want %v", modified, tt.wantMod)
+			}
+			want := tt.want
+			if !tt.wantMod {
+				want = tt.src
+			}
+			if diff := cmp.Diff(want, string(got)); diff != "" {
+				t.Errorf("TransformFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithCtxRewrite(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`logCtx := withLogger({{.Ctx}}, {{.FuncName | quote}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		src    string
+		remove bool
+		want   string
+	}{
+		"references after the statement are rewritten": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	call(ctx)
+	go func() { call(ctx) }()
+	s := struct{ ctx context.Context }{ctx: ctx}
+	_ = s.ctx
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	logCtx := withLogger(ctx, "service.Foo")
+
+	call(logCtx)
+	go func() { call(logCtx) }()
+	s := struct{ ctx context.Context }{ctx: logCtx}
+	_ = s.ctx
+}
+`,
+		},
+		"rewriting stops at reassignment": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	call(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	logCtx := withLogger(ctx, "service.Foo")
+
+	ctx, cancel := context.WithCancel(logCtx)
+	defer cancel()
+	call(ctx)
+}
+`,
+		},
+		"shadowing parameters are respected": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	f := func(ctx context.Context) { call(ctx) }
+	f(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	logCtx := withLogger(ctx, "service.Foo")
+
+	f := func(ctx context.Context) { call(ctx) }
+	f(logCtx)
+}
+`,
+		},
+		"existing statement is kept and new references are rewritten": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	logCtx := withLogger(ctx, "service.Foo")
+	call(logCtx)
+	call(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	logCtx := withLogger(ctx, "service.Foo")
+	call(logCtx)
+	call(logCtx)
+}
+`,
+		},
+		"remove reverts the rewrite": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	logCtx := withLogger(ctx, "service.Foo")
+	call(logCtx)
+}
+`,
+			remove: true,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	call(ctx)
+}
+`,
+		},
+		"carrier accessor is rewritten": {
+			src: `package service
+
+import "net/http"
+
+func Handle(r *http.Request) {
+	call(r.Context())
+	_ = r.URL
+}
+`,
+			want: `package service
+
+import "net/http"
+
+func Handle(r *http.Request) {
+	logCtx := withLogger(r.Context(), "service.Handle")
+
+	call(logCtx)
+	_ = r.URL
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil, processor.WithCtxRewrite("logCtx"), processor.WithRemove(tt.remove))
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithCtxRewrite_Auto(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		tmpl   string
+		src    string
+		remove bool
+		want   string
+	}{
+		"derived variable is detected": {
+			tmpl: "spanCtx, span := tracer.Start({{.Ctx}}, {{.FuncName | quote}})\ndefer span.End()",
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	call(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	spanCtx, span := tracer.Start(ctx, "service.Foo")
+	defer span.End()
+
+	call(spanCtx)
+}
+`,
+		},
+		"shadowing template needs no rewrite": {
+			tmpl: "{{.CtxVar}}, span := tracer.Start({{.Ctx}}, {{.FuncName | quote}})\ndefer span.End()",
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	call(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "service.Foo")
+	defer span.End()
+
+	call(ctx)
+}
+`,
+		},
+		"template without derived context": {
+			tmpl: "defer trace({{.Ctx}})",
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	call(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx)
+
+	call(ctx)
+}
+`,
+		},
+		"remove reverts the detected variable": {
+			tmpl: "spanCtx, span := tracer.Start({{.Ctx}}, {{.FuncName | quote}})\ndefer span.End()",
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	spanCtx, span := tracer.Start(ctx, "service.Foo")
+	defer span.End()
+
+	call(spanCtx)
+}
+`,
+			remove: true,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+
+	call(ctx)
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, template.MustParse(tt.tmpl), nil, processor.WithCtxRewrite(config.CtxRewriteAuto), processor.WithRemove(tt.remove))
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTransformFile_ZerologPreset(t *testing.T) {
+	preset, ok := config.LookupPreset("zerolog")
+	if !ok {
+		t.Fatal("zerolog preset not found")
+	}
+	registry := config.NewCarrierRegistry(true)
+	proc := processor.New(registry, template.MustParse(preset.Template), preset.Imports, processor.WithCtxRewrite(preset.CtxRewrite))
+
+	src := `package service
+
+import "context"
+
+func Foo(ctx context.Context) error {
+	return call(ctx)
+}
+`
+	got, _, err := proc.TransformFile([]byte(src), processor.TransformOptions{PkgPath: "example.com/app/service"})
+	if err != nil {
+		t.Fatalf("TransformFile() error = %v", err)
+	}
+
+	want := `package service
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+)
+
+func Foo(ctx context.Context) error {
+	logCtx := zerolog.Ctx(ctx).With().Str("func", "service.Foo").Logger().WithContext(ctx)
+	_ = logCtx
+
+	return call(logCtx)
+}
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestUniqueVar(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`{{.CtxVar}}, {{.UniqueVar "span"}} := tracer.Start({{.Ctx}}, {{.FuncName | quote}})
+defer {{.UniqueVar "span"}}.End()`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		src  string
+		want string
+	}{
+		"free name is used as is": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	call(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "service.Foo")
+	defer span.End()
+
+	call(ctx)
+}
+`,
+		},
+		"declared name gets a suffix": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context, span int) {
+	call(ctx, span)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context, span int) {
+	ctx, span2 := tracer.Start(ctx, "service.Foo")
+	defer span2.End()
+
+	call(ctx, span)
+}
+`,
+		},
+		"existing statements keep their name": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "service.Foo")
+	defer span.End()
+
+	call(ctx)
+}
+`,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	ctx, span := tracer.Start(ctx, "service.Foo")
+	defer span.End()
+
+	call(ctx)
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, nil)
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+
+			// A second run must not change the result
+			again, _, err := proc.TransformFile(got, opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(string(got), string(again)); diff != "" {
+				t.Errorf("second run mismatch (-first +second):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTransformFile_DeclarationConflict(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		tmpl    string
+		src     string
+		wantErr string
+	}{
+		"conflicting parameter": {
+			tmpl: "{{.CtxVar}}, span := tracer.Start({{.Ctx}}, {{.FuncName | quote}})\ndefer span.End()",
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context, span int) {}
+`,
+			wantErr: `template declares "span"`,
+		},
+		"conflicting declaration in the body": {
+			tmpl: "logger := newLogger({{.Ctx}})\n_ = logger",
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	var logger = other()
+	_ = logger
+}
+`,
+			wantErr: `template declares "logger"`,
+		},
+		"rebinding the context variable is allowed": {
+			tmpl: "{{.CtxVar}}, span := tracer.Start({{.Ctx}}, {{.FuncName | quote}})\ndefer span.End()",
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	if true {
+		span := 1
+		_ = span
+	}
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, template.MustParse(tt.tmpl), nil)
+			_, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("TransformFile() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("TransformFile() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransformFile_ImportCollisions(t *testing.T) {
+	t.Parallel()
+
+	registry := config.NewCarrierRegistry(true)
+	const path = "github.com/newrelic/go-agent/v3/newrelic"
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	tests := map[string]struct {
+		alias   string
+		src     string
+		wantErr string
+	}{
+		"other import with the same name": {
+			src: `package service
+
+import (
+	"context"
+
+	"example.com/app/internal/newrelic"
+)
+
+func Foo(ctx context.Context) {
+	newrelic.Record()
+}
+`,
+			wantErr: `which is the name of the import of "example.com/app/internal/newrelic"`,
+		},
+		"package declaration": {
+			src: `package service
+
+import "context"
+
+var newrelic = struct{}{}
+
+func Foo(ctx context.Context) {
+}
+`,
+			wantErr: "which is declared in the package",
+		},
+		"parameter": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context, newrelic string) {
+}
+`,
+			wantErr: "which is a parameter of the function",
+		},
+		"same import": {
+			src: `package service
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Foo(ctx context.Context) {
+	_ = newrelic.Config{}
+}
+`,
+		},
+		"alias avoids the collision": {
+			alias: "nr",
+			src: `package service
+
+import (
+	"context"
+
+	"example.com/app/internal/newrelic"
+)
+
+func Foo(ctx context.Context) {
+	newrelic.Record()
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			qualifier := "newrelic"
+			if tt.alias != "" {
+				qualifier = tt.alias
+			}
+			tmpl := template.MustParse(`defer ` + qualifier + `.FromContext({{.Ctx}}).StartSegment({{.FuncName | quote}}).End()`)
+			proc := processor.New(registry, tmpl, nil, processor.WithImports([]config.Import{{Path: path, Alias: tt.alias}}))
+
+			got, _, err := proc.TransformFile([]byte(tt.src), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("TransformFile() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if !strings.Contains(string(got), qualifier+".FromContext(ctx)") {
+				t.Errorf("template not inserted:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestPackageOverride_AliasedImports(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	src := []byte("package handler\n\nimport \"context\"\n\nfunc Foo(ctx context.Context) {\n}\n")
+
+	// Aliased imports take precedence over the import paths of the override
+	proc := processor.New(registry, template.MustParse(`defer trace({{.Ctx}})`), []string{"example.com/trace"},
+		processor.WithFormat(config.FormatNone),
+		processor.WithOverrides(processor.PackageOverride{
+			Packages:       []*regexp.Regexp{regexp.MustCompile(`/handler$`)},
+			Template:       template.MustParse(`defer tr.Span({{.Ctx}})`),
+			Imports:        []string{"example.com/unused"},
+			AliasedImports: []config.Import{{Path: "example.com/trace", Alias: "tr"}},
+		}),
+	)
+	got, _, err := proc.TransformFile(src, processor.TransformOptions{PkgPath: "example.com/app/handler"})
+	if err != nil {
+		t.Fatalf("TransformFile() error = %v", err)
+	}
+	want := `package handler
+
+import (
+	"context"
+	tr "example.com/trace"
+)
+
+func Foo(ctx context.Context) {
+	defer tr.Span(ctx)
+
+}
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("TransformFile() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestTransformFile_Epilogue(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`start := time.Now()`)
+	epilogue := template.MustParse(`observe({{.FuncName | quote}}, time.Since(start))`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	const woven = `package service
+
+import (
+	"context"
+	"time"
+)
+
+func Foo(ctx context.Context, n int) (int, error) {
+	start := time.Now()
+
+	if n < 0 {
+		observe("service.Foo", time.Since(start))
+		return 0, nil
+	}
+	f := func() int {
+		return n
+	}
+	switch n {
+	case 1:
+		observe("service.Foo", time.Since(start))
+		return f(), nil
+	}
+
+	observe("service.Foo", time.Since(start))
+	return n, nil
+}
+
+func Bar(ctx context.Context) {
+	start := time.Now()
+
+	work()
+	observe("service.Bar", time.Since(start))
+}
+`
+
+	tests := map[string]struct {
+		src     string
+		remove  bool
+		want    string
+		wantMod bool
+	}{
+		"prologue and epilogues are inserted": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context, n int) (int, error) {
+	if n < 0 {
+		return 0, nil
+	}
+	f := func() int {
+		return n
+	}
+	switch n {
+	case 1:
+		return f(), nil
+	}
+
+	return n, nil
+}
+
+func Bar(ctx context.Context) {
+	work()
+}
+`,
+			want:    woven,
+			wantMod: true,
+		},
+		"woven source is left alone": {
+			src: woven,
+		},
+		"outdated and missing epilogues are fixed": {
+			src: strings.Replace(strings.Replace(woven, `observe("service.Bar", time.Since(start))`, `observe("Bar", time.Since(start))`, 1),
+				"\t\tobserve(\"service.Foo\", time.Since(start))\n\t\treturn 0, nil", "\t\treturn 0, nil", 1),
+			want:    woven,
+			wantMod: true,
+		},
+		"prologue and epilogues are removed": {
+			src:    woven,
+			remove: true,
+			want: `package service
+
+import (
+	"context"
+)
+
+func Foo(ctx context.Context, n int) (int, error) {
+
+	if n < 0 {
+		return 0, nil
+	}
+	f := func() int {
+		return n
+	}
+	switch n {
+	case 1:
+		return f(), nil
+	}
+
+	return n, nil
+}
+
+func Bar(ctx context.Context) {
+
+	work()
+}
+`,
+			wantMod: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			proc := processor.New(registry, tmpl, []string{"time"},
+				processor.WithEpilogue(epilogue), processor.WithRemove(tt.remove))
+			got, modified, err := proc.TransformFile([]byte(tt.src), opts)
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if modified != tt.wantMod {
+				t.Errorf("modified = %v, want %v", modified, tt.wantMod)
+			}
+			want := tt.want
+			if !tt.wantMod {
+				want = tt.src
+			}
+			if diff := cmp.Diff(want, string(got)); diff != "" {
+				t.Errorf("TransformFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTransformFile_Directives(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	const src = `package service
+
+import "context"
+
+//go:noinline
+func Doc(ctx context.Context) {
+	//nolint:errcheck
+	work()
+}
+
+func Line(ctx context.Context) {
+//line handler.tmpl:10
+	work()
+}
+
+func Brace(ctx context.Context) { //nolint:gocyclo
+	work()
+}
+
+func Empty(ctx context.Context) {
+	//nolint:all
+}
+`
+	const woven = `package service
+
+import "context"
+
+//go:noinline
+func Doc(ctx context.Context) {
+	defer trace(ctx)
+
+	//nolint:errcheck
+	work()
+}
+
+func Line(ctx context.Context) {
+	defer trace(ctx)
+
+//line handler.tmpl:10
+	work()
+}
+
+func Brace(ctx context.Context) { //nolint:gocyclo
+	defer trace(ctx)
+
+	work()
+}
+
+func Empty(ctx context.Context) {
+	defer trace(ctx)
+
+	//nolint:all
+}
+`
+
+	got, _, err := processor.New(registry, tmpl, nil).TransformFile([]byte(src), opts)
+	if err != nil {
+		t.Fatalf("TransformFile() error = %v", err)
+	}
+	if diff := cmp.Diff(woven, string(got)); diff != "" {
+		t.Errorf("insertion mismatch (-want +got):\n%s", diff)
+	}
+
+	// Removing the statements restores the directives as they were, except for
+	// the blank lines left by the removal
+	removed, _, err := processor.New(registry, tmpl, nil, processor.WithRemove(true)).TransformFile(got, opts)
+	if err != nil {
+		t.Fatalf("TransformFile() error = %v", err)
+	}
+	want := `package service
+
+import "context"
+
+//go:noinline
+func Doc(ctx context.Context) {
+
+	//nolint:errcheck
+	work()
+}
+
+func Line(ctx context.Context) {
+
+//line handler.tmpl:10
+	work()
+}
+
+func Brace(ctx context.Context) { //nolint:gocyclo
+
+	work()
+}
+
+func Empty(ctx context.Context) {
+	//nolint:all
+}
+`
+	if diff := cmp.Diff(want, string(removed)); diff != "" {
+		t.Errorf("removal mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWithFormat(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, time.Now())`)
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service"}
+
+	const src = `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`
+
+	tests := map[string]struct {
+		tool    config.FormatTool
+		gofumpt string // Script installed as gofumpt in PATH; none if empty
+		remove  bool
+		want    string
+		wantErr string
+	}{
+		"gofmt adds missing imports with goimports": {
+			tool: config.FormatGofmt,
+			want: `package service
+
+import (
+	"context"
+	"time"
+)
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, time.Now())
+
+}
+`,
+		},
+		"gofumpt pipes the output through the command": {
+			tool:    config.FormatGofumpt,
+			gofumpt: "#!/bin/sh\n/bin/cat\necho '// gofumpt'\n",
+			want: `package service
+
+import (
+	"context"
+	"time"
+)
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, time.Now())
+
+}
+// gofumpt
+`,
+		},
+		"gofumpt not installed": {
+			tool:    config.FormatGofumpt,
+			wantErr: "gofumpt is not installed",
+		},
+		"gofumpt failing": {
+			tool:    config.FormatGofumpt,
+			gofumpt: "#!/bin/sh\necho 'unsupported syntax' >&2\nexit 1\n",
+			wantErr: "gofumpt failed: unsupported syntax",
+		},
+		"none does not add missing imports": {
+			tool: config.FormatNone,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+	defer trace(ctx, time.Now())
+
+}
+`,
+		},
+	}
+
+	// goimports runs the go command
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.gofumpt != "" {
+				if err := os.WriteFile(filepath.Join(dir, "gofumpt"), []byte(tt.gofumpt), 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Setenv("PATH", dir+string(os.PathListSeparator)+filepath.Dir(goBin))
+
+			proc := processor.New(registry, tmpl, nil, processor.WithFormat(tt.tool))
+			got, _, err := proc.TransformFile([]byte(src), opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("TransformFile() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TransformFile() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("TransformFile() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWithFormat_GofumptModule(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	bin := t.TempDir()
+	script := "#!/bin/sh\n/bin/cat\necho \"// gofumpt $*\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "gofumpt"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+filepath.Dir(goBin))
+
+	// The Go version and module path are read from the go.mod file of the file
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n\ngo 1.22\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := processor.TransformOptions{PkgPath: "example.com/app/service", Filename: filepath.Join(dir, "service", "service.go")}
+
+	proc := processor.New(registry, tmpl, nil, processor.WithFormat(config.FormatGofumpt))
+	got, _, err := proc.TransformFile([]byte("package service\n\nimport \"context\"\n\nfunc Foo(ctx context.Context) {\n}\n"), opts)
+	if err != nil {
+		t.Fatalf("TransformFile() error = %v", err)
+	}
+	if want := "// gofumpt -lang go1.22 -modpath example.com/app\n"; !strings.HasSuffix(string(got), want) {
+		t.Errorf("TransformFile() = %q, want ending with %q", got, want)
+	}
+}
+
+func TestWithFormat_NoneRemovesUnusedImports(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer trace.Start({{.Ctx}})`)
+	proc := processor.New(registry, tmpl, []string{"example.com/trace"},
+		processor.WithFormat(config.FormatNone), processor.WithRemove(true))
+
+	const src = `package service
+
+import (
+	"context"
+	"fmt"
+
+	"example.com/trace"
+)
+
+func Foo(ctx context.Context) {
+	defer trace.Start(ctx)
+
+	fmt.Println()
+}
+`
+	got, _, err := proc.TransformFile([]byte(src), processor.TransformOptions{PkgPath: "example.com/app/service"})
+	if err != nil {
+		t.Fatalf("TransformFile() error = %v", err)
+	}
+	want := `package service
+
+import (
+	"context"
+	"fmt"
+)
+
+func Foo(ctx context.Context) {
+
+	fmt.Println()
+}
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("TransformFile() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// writtenFile records a file written by Process, for verification and rollback.
+type writtenFile struct {
+	filename string
+	pkgPath  string
+	original []byte
+}
+
+// verifyWritten type-checks the packages of the written files and records the errors
+// in result. In rollback mode, the files of packages that fail are restored.
+func (p *Processor) verifyWritten(written []writtenFile, result *ProcessResult) error {
+	// Package paths by the module of their files
+	var modules []string
+	pkgPaths := make(map[string][]string)
+	for _, w := range written {
+		dir := moduleRoot(filepath.Dir(w.filename))
+		if _, ok := pkgPaths[dir]; !ok {
+			modules = append(modules, dir)
+		}
+		if !slices.Contains(pkgPaths[dir], w.pkgPath) {
+			pkgPaths[dir] = append(pkgPaths[dir], w.pkgPath)
+		}
+	}
+
+	// Written files are checked as written, not as in the overlay
+	overlay := maps.Clone(p.overlay)
+	for _, w := range written {
+		delete(overlay, w.filename)
+	}
+
+	failed := make(map[string]bool)
+	for _, dir := range modules {
+		cfg := &packages.Config{
+			Mode: packages.NeedName |
+				packages.NeedFiles |
+				packages.NeedSyntax |
+				packages.NeedTypes,
+			Tests:   p.loadsTests(),
+			Dir:     dir,
+			Overlay: overlay,
+		}
+		pkgs, err := packages.Load(cfg, pkgPaths[dir]...)
+		if err != nil {
+			return fmt.Errorf("failed to load packages for verification: %w", err)
+		}
+
+		for _, pkg := range pkgs {
+			for _, e := range pkg.Errors {
+				result.VerifyErrors = append(result.VerifyErrors, &LoadError{Package: pkg.PkgPath, Err: e})
+				failed[pkg.PkgPath] = true
+			}
+		}
+	}
+
+	if !p.rollback {
+		return nil
+	}
+	// A file processed again (e.g. in the test variant of its package) is
+	// recorded again with the content of the first write; only the first
+	// original is the one from before the run
+	restored := make(map[string]bool)
+	for _, w := range written {
+		if !failed[w.pkgPath] || restored[w.filename] {
+			continue
+		}
+		restored[w.filename] = true
+		if err := os.WriteFile(w.filename, w.original, 0o644); err != nil {
+			return fmt.Errorf("failed to roll back %s: %w", w.filename, err)
+		}
+		result.RolledBack = append(result.RolledBack, w.filename)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package processor
+
+import (
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/dstutil"
+
+	"github.com/mpyw/ctxweaver/internal/directive"
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/scaffold"
+)
+
+// WeaveFile weaves a single file held as a DST tree, e.g. by a code generator
+// or a refactoring tool, modifying df in place without re-parsing its source.
+// pkgPath is the import path of the package of the file; it is exposed to
+// templates as {{.PackagePath}}. Returns whether df was modified.
+// In remove mode, generated statements are removed instead of added.
+//
+// As in Process, df must be decorated with import management (e.g. by a
+// decorator.NewDecoratorWithImports), so that carrier types resolve to their
+// packages. The references of the generated statements to the configured
+// imports are resolved likewise, so restoring df with import management
+// (e.g. by a decorator.NewRestorerWithImports) adds and removes imports as needed.
+func (p *Processor) WeaveFile(df *dst.File, pkgPath string) (bool, error) {
+	p = p.forPackage(pkgPath)
+
+	// Skip generated files, unless all are included (the path is unknown), and
+	// files with a file-level skip directive
+	if isGenerated(df) && (!p.generatedAll || slices.Contains(df.Decs.Start.All(), scaffold.Marker)) || directive.HasSkipDirective(df.Decorations()) {
+		return false, nil
+	}
+
+	fr, err := p.withFileDecls(df).processFunctions(df, pkgPath, nil, fileImportNames(df))
+	if err != nil {
+		return false, err
+	}
+	if !fr.modified {
+		return false, nil
+	}
+	p.resolveImports(df)
+	return true, nil
+}
+
+// isGenerated reports whether df has a "// Code generated ... DO NOT EDIT."
+// comment before its package clause, as ast.IsGenerated does for AST files.
+func isGenerated(df *dst.File) bool {
+	for _, c := range df.Decs.Start.All() {
+		if strings.HasPrefix(c, "// Code generated ") && strings.HasSuffix(c, " DO NOT EDIT.") {
+			return true
+		}
+	}
+	return false
+}
+
+// fileImportNames returns the package names given to import paths by the
+// aliased imports of df; the names of other imports are guessed.
+func fileImportNames(df *dst.File) importNames {
+	names := make(importNames)
+	for _, spec := range df.Imports {
+		if spec.Name != nil && spec.Name.Name != "_" && spec.Name.Name != "." {
+			names[importPath(spec)] = spec.Name.Name
+		}
+	}
+	return names
+}
+
+// resolveImports turns the references of df to the configured imports by
+// name (pkg.Name) into identifiers resolved to the import path, as the
+// decorator does for the rest of the file. Imports with an alias are added to
+// the import declarations so that the restorer keeps the alias.
+func (p *Processor) resolveImports(df *dst.File) {
+	for _, imp := range p.imports {
+		if imp.Alias == "_" || imp.Alias == "." {
+			if findImport(df, imp.Path) == nil {
+				addImport(df, imp)
+			}
+			continue
+		}
+
+		name := imp.Alias
+		if name == "" {
+			name = guessPackageName(imp.Path)
+		}
+		resolved := false
+		dstutil.Apply(df, func(c *dstutil.Cursor) bool {
+			sel, ok := c.Node().(*dst.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if x, ok := sel.X.(*dst.Ident); ok && x.Path == "" && x.Name == name {
+				id := &dst.Ident{Name: sel.Sel.Name, Path: imp.Path}
+				id.Decs.NodeDecs = sel.Decs.NodeDecs
+				c.Replace(id)
+				resolved = true
+			}
+			return true
+		}, nil)
+		if resolved && imp.Alias != "" && findImport(df, imp.Path) == nil {
+			addImport(df, imp)
+		}
+	}
+}
+
+// importPath returns the unquoted path of an import spec.
+func importPath(spec *dst.ImportSpec) string {
+	path, err := strconv.Unquote(spec.Path.Value)
+	if err != nil {
+		return spec.Path.Value
+	}
+	return path
+}
+
+// findImport returns the import spec of path in df, or nil if there is none.
+func findImport(df *dst.File, path string) *dst.ImportSpec {
+	for _, spec := range df.Imports {
+		if importPath(spec) == path {
+			return spec
+		}
+	}
+	return nil
+}
+
+// addImport adds imp to the first import declaration of df, or to a new one.
+func addImport(df *dst.File, imp config.Import) {
+	spec := &dst.ImportSpec{Name: dst.NewIdent(imp.Alias), Path: &dst.BasicLit{Kind: token.STRING, Value: strconv.Quote(imp.Path)}}
+	df.Imports = append(df.Imports, spec)
+
+	for _, decl := range df.Decls {
+		gd, ok := decl.(*dst.GenDecl)
+		if !ok || gd.Tok != token.IMPORT {
+			continue
+		}
+		// Leave the import of "C" alone: its doc comment is the cgo preamble
+		if len(gd.Specs) == 1 && importPath(gd.Specs[0].(*dst.ImportSpec)) == "C" {
+			continue
+		}
+		spec.Decs.Before = dst.NewLine
+		gd.Specs = append(gd.Specs, spec)
+		gd.Lparen = true
+		return
+	}
+
+	gd := &dst.GenDecl{Tok: token.IMPORT, Specs: []dst.Spec{spec}}
+	gd.Decs.Before = dst.EmptyLine
+	gd.Decs.After = dst.EmptyLine
+	df.Decls = slices.Insert(df.Decls, 0, dst.Decl(gd))
+}
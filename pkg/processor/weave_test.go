@@ -0,0 +1,176 @@
+package processor_test
+
+import (
+	"bytes"
+	"go/token"
+	"testing"
+
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver/goast"
+	"github.com/dave/dst/decorator/resolver/guess"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/processor"
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestWeaveFile(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer newrelic.FromContext({{.Ctx}}).StartSegment({{.FuncName | quote}}).End()`)
+	imports := []string{"github.com/newrelic/go-agent/v3/newrelic"}
+
+	tests := map[string]struct {
+		src     string
+		remove  bool
+		want    string
+		wantMod bool
+	}{
+		"statement and import are added": {
+			src: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+			want: `package service
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Foo(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("service.Foo").End()
+
+}
+`,
+			wantMod: true,
+		},
+		"up to date file is left alone": {
+			src: `package service
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Foo(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("service.Foo").End()
+}
+`,
+		},
+		"statement and unused import are removed": {
+			src: `package service
+
+import (
+	"context"
+
+	"github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Foo(ctx context.Context) {
+	defer newrelic.FromContext(ctx).StartSegment("service.Foo").End()
+}
+`,
+			remove: true,
+			want: `package service
+
+import "context"
+
+func Foo(ctx context.Context) {}
+`,
+			wantMod: true,
+		},
+		"generated file is skipped": {
+			src: `// Code generated by tool. DO NOT EDIT.
+
+package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			dec := decorator.NewDecoratorWithImports(token.NewFileSet(), "example.com/app/service", goast.New())
+			df, err := dec.Parse(tt.src)
+			if err != nil {
+				t.Fatalf("failed to parse source: %v", err)
+			}
+
+			proc := processor.New(registry, tmpl, imports, processor.WithRemove(tt.remove))
+			modified, err := proc.WeaveFile(df, "example.com/app/service")
+			if err != nil {
+				t.Fatalf("WeaveFile failed: %v", err)
+			}
+			if modified != tt.wantMod {
+				t.Errorf("modified = %v, want %v", modified, tt.wantMod)
+			}
+
+			var buf bytes.Buffer
+			res := guess.WithMap(map[string]string{"github.com/newrelic/go-agent/v3/newrelic": "newrelic"})
+			if err := decorator.NewRestorerWithImports("example.com/app/service", res).Fprint(&buf, df); err != nil {
+				t.Fatalf("failed to print file: %v", err)
+			}
+			want := tt.want
+			if !tt.wantMod {
+				want = tt.src
+			}
+			if diff := cmp.Diff(want, buf.String()); diff != "" {
+				t.Errorf("source mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWeaveFile_AliasedImport(t *testing.T) {
+	registry := config.NewCarrierRegistry(true)
+	tmpl := template.MustParse(`defer nr.FromContext({{.Ctx}}).StartSegment({{.FuncName | quote}}).End()`)
+	imports := []config.Import{{Path: "github.com/newrelic/go-agent/v3/newrelic", Alias: "nr"}}
+
+	dec := decorator.NewDecoratorWithImports(token.NewFileSet(), "example.com/app/service", goast.New())
+	df, err := dec.Parse(`package service
+
+import "context"
+
+func Foo(ctx context.Context) {
+}
+`)
+	if err != nil {
+		t.Fatalf("failed to parse source: %v", err)
+	}
+
+	proc := processor.New(registry, tmpl, nil, processor.WithImports(imports))
+	if modified, err := proc.WeaveFile(df, "example.com/app/service"); err != nil || !modified {
+		t.Fatalf("WeaveFile() = %v, %v; want modified", modified, err)
+	}
+
+	var buf bytes.Buffer
+	if err := decorator.NewRestorerWithImports("example.com/app/service", guess.New()).Fprint(&buf, df); err != nil {
+		t.Fatalf("failed to print file: %v", err)
+	}
+	want := `package service
+
+import (
+	"context"
+
+	nr "github.com/newrelic/go-agent/v3/newrelic"
+)
+
+func Foo(ctx context.Context) {
+	defer nr.FromContext(ctx).StartSegment("service.Foo").End()
+
+}
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("source mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,174 @@
+// Package scaffold writes the helper files required by templates, such as the
+// package providing the function the generated statements call.
+package scaffold
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"golang.org/x/mod/modfile"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+// Marker is the first line of scaffolded files. Files still starting with it
+// are rewritten when their template changes; removing it takes ownership of
+// the file, which is then left alone. As a generated file header, it also
+// keeps the helper from being woven itself.
+const Marker = "// Code generated by ctxweaver scaffold. DO NOT EDIT."
+
+// Vars holds the variables available in scaffold templates.
+type Vars struct {
+	// PackageName is the package name derived from the directory of the file (e.g. "trace")
+	PackageName string
+	// PackagePath is the import path of the directory of the file
+	// (e.g. "github.com/example/myapp/internal/trace"); empty outside a module
+	PackagePath string
+}
+
+// Status is the outcome of scaffolding a file.
+type Status string
+
+const (
+	// Created means the file did not exist and was written.
+	Created Status = "created"
+	// Updated means the file had the marker and was rewritten from the template.
+	Updated Status = "updated"
+	// Unchanged means the file was up to date.
+	Unchanged Status = "unchanged"
+	// Owned means the file exists without the marker and was left alone.
+	Owned Status = "owned"
+)
+
+// Result describes the outcome of scaffolding a file.
+type Result struct {
+	Path   string
+	Status Status
+}
+
+// Changed reports whether the file was (or, in dry run mode, would be) written.
+func (r Result) Changed() bool {
+	return r.Status == Created || r.Status == Updated
+}
+
+// Apply writes the scaffold files that are missing or outdated. In dry run
+// mode, nothing is written but the results are the same.
+func Apply(files []config.ScaffoldFile, dryRun bool) ([]Result, error) {
+	results := make([]Result, 0, len(files))
+	for _, f := range files {
+		status, err := apply(f, dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("scaffold %s: %w", f.Path, err)
+		}
+		results = append(results, Result{Path: f.Path, Status: status})
+	}
+	return results, nil
+}
+
+func apply(f config.ScaffoldFile, dryRun bool) (Status, error) {
+	content, err := Render(f)
+	if err != nil {
+		return "", err
+	}
+
+	status := Created
+	existing, err := os.ReadFile(f.Path)
+	switch {
+	case err == nil && !bytes.HasPrefix(existing, []byte(Marker+"\n")):
+		return Owned, nil
+	case err == nil && bytes.Equal(existing, content):
+		return Unchanged, nil
+	case err == nil:
+		status = Updated
+	case !os.IsNotExist(err):
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if dryRun {
+		return status, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(f.Path, content, 0o644); err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	return status, nil
+}
+
+// Render returns the content of the scaffold file: the marker followed by the
+// rendered template, formatted as Go source.
+func Render(f config.ScaffoldFile) ([]byte, error) {
+	text, err := f.Template.Content()
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(f.Path).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
+	vars, err := buildVars(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	body := strings.TrimPrefix(buf.String(), Marker+"\n")
+
+	content, err := format.Source([]byte(Marker + "\n\n" + strings.TrimLeft(body, "\n")))
+	if err != nil {
+		return nil, fmt.Errorf("rendered template is not valid Go: %w", err)
+	}
+	return content, nil
+}
+
+// buildVars derives the template variables from the path of the file.
+func buildVars(file string) (Vars, error) {
+	dir, err := filepath.Abs(filepath.Dir(file))
+	if err != nil {
+		return Vars{}, err
+	}
+	vars := Vars{PackageName: packageName(filepath.Base(dir))}
+
+	// Import path from the enclosing module
+	for root := dir; ; {
+		data, err := os.ReadFile(filepath.Join(root, "go.mod"))
+		if err == nil {
+			rel, _ := filepath.Rel(root, dir)
+			vars.PackagePath = path.Join(modfile.ModulePath(data), filepath.ToSlash(rel))
+			break
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			break
+		}
+		root = parent
+	}
+	return vars, nil
+}
+
+// packageName turns a directory name into a package name by the usual
+// conventions: lower case, without characters invalid in identifiers
+// (e.g. "gotrace" for "go-trace").
+func packageName(dir string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, dir)
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "pkg" + name
+	}
+	return name
+}
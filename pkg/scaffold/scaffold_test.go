@@ -0,0 +1,163 @@
+package scaffold_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/config"
+	"github.com/mpyw/ctxweaver/pkg/scaffold"
+)
+
+const helperTemplate = `package {{.PackageName}}
+
+import "context"
+
+// Trace traces the calls of {{.PackagePath}} users.
+func Trace(ctx context.Context, name string) func() { return func() {} }
+`
+
+const helperContent = scaffold.Marker + `
+
+package trace
+
+import "context"
+
+// Trace traces the calls of testmod/internal/trace users.
+func Trace(ctx context.Context, name string) func() { return func() {} }
+`
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		existing   *string // nil when the file does not exist
+		dryRun     bool
+		wantStatus scaffold.Status
+		want       *string // nil when the file should not exist
+	}{
+		"missing file is created": {
+			wantStatus: scaffold.Created,
+			want:       ptr(helperContent),
+		},
+		"missing file is not created in dry run": {
+			dryRun:     true,
+			wantStatus: scaffold.Created,
+		},
+		"up to date file is unchanged": {
+			existing:   ptr(helperContent),
+			wantStatus: scaffold.Unchanged,
+			want:       ptr(helperContent),
+		},
+		"outdated file with marker is updated": {
+			existing:   ptr(scaffold.Marker + "\n\npackage trace\n"),
+			wantStatus: scaffold.Updated,
+			want:       ptr(helperContent),
+		},
+		"file without marker is left alone": {
+			existing:   ptr("package trace\n\n// Customized\n"),
+			wantStatus: scaffold.Owned,
+			want:       ptr("package trace\n\n// Customized\n"),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tmpDir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte("module testmod\n\ngo 1.21\n"), 0o644); err != nil {
+				t.Fatalf("failed to write go.mod: %v", err)
+			}
+			path := filepath.Join(tmpDir, "internal", "trace", "trace.go")
+			if tt.existing != nil {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatalf("failed to create directory: %v", err)
+				}
+				if err := os.WriteFile(path, []byte(*tt.existing), 0o644); err != nil {
+					t.Fatalf("failed to write file: %v", err)
+				}
+			}
+
+			files := []config.ScaffoldFile{{Path: path, Template: config.Template{Inline: helperTemplate}}}
+			results, err := scaffold.Apply(files, tt.dryRun)
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if diff := cmp.Diff([]scaffold.Result{{Path: path, Status: tt.wantStatus}}, results); diff != "" {
+				t.Errorf("results mismatch (-want +got):\n%s", diff)
+			}
+
+			content, err := os.ReadFile(path)
+			switch {
+			case tt.want == nil && err == nil:
+				t.Errorf("file should not exist:\n%s", content)
+			case tt.want != nil && err != nil:
+				t.Errorf("failed to read file: %v", err)
+			case tt.want != nil:
+				if diff := cmp.Diff(*tt.want, string(content)); diff != "" {
+					t.Errorf("content mismatch (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		path    string
+		tmpl    string
+		want    string
+		wantErr string
+	}{
+		"package name is sanitized": {
+			path: filepath.Join("go-trace", "trace.go"),
+			tmpl: "package {{.PackageName}}",
+			want: scaffold.Marker + "\n\npackage gotrace\n",
+		},
+		"marker in the template is not repeated": {
+			path: filepath.Join("trace", "trace.go"),
+			tmpl: scaffold.Marker + "\npackage {{.PackageName}}",
+			want: scaffold.Marker + "\n\npackage trace\n",
+		},
+		"unknown variable": {
+			path:    filepath.Join("trace", "trace.go"),
+			tmpl:    "package {{.Name}}",
+			wantErr: "failed to render template",
+		},
+		"invalid Go": {
+			path:    filepath.Join("trace", "trace.go"),
+			tmpl:    "func {{.PackageName}}",
+			wantErr: "rendered template is not valid Go",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := scaffold.Render(config.ScaffoldFile{Path: tt.path, Template: config.Template{Inline: tt.tmpl}})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Render() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, string(got)); diff != "" {
+				t.Errorf("content mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
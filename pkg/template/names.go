@@ -0,0 +1,70 @@
+package template
+
+import (
+	"strconv"
+	"sync"
+)
+
+// NameRegistry makes the names passed to the unique template function unique
+// across the functions of a run, e.g. span names of methods of same-named
+// types in packages sharing a name: {{.FuncName | unique}}. The first function
+// claiming a name keeps it; the following ones get a "#2", "#3", ... suffix.
+// It is safe for concurrent use.
+type NameRegistry struct {
+	mu         sync.Mutex
+	owners     map[string]string    // Function key by unique name
+	claims     map[[2]string]string // Unique name by function key and requested name
+	collisions []NameCollision
+}
+
+// NameCollision describes a name made unique by a NameRegistry.
+type NameCollision struct {
+	// Name is the requested name (e.g. "handler.(*Server).Get")
+	Name string
+	// Unique is the name given instead (e.g. "handler.(*Server).Get#2")
+	Unique string
+	// Func is the key of the function given Unique
+	Func string
+	// Owner is the key of the function keeping Name
+	Owner string
+}
+
+// NewNameRegistry returns an empty NameRegistry.
+func NewNameRegistry() *NameRegistry {
+	return &NameRegistry{
+		owners: make(map[string]string),
+		claims: make(map[[2]string]string),
+	}
+}
+
+// Claim returns name, or name with the smallest numeric suffix not claimed by
+// another function. A function claiming the same name again gets the same result.
+func (r *NameRegistry) Claim(name, funcKey string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	claim := [2]string{funcKey, name}
+	if unique, ok := r.claims[claim]; ok {
+		return unique
+	}
+	unique := name
+	for i := 2; ; i++ {
+		if owner, ok := r.owners[unique]; !ok || owner == funcKey {
+			break
+		}
+		unique = name + "#" + strconv.Itoa(i)
+	}
+	r.owners[unique] = funcKey
+	r.claims[claim] = unique
+	if unique != name {
+		r.collisions = append(r.collisions, NameCollision{Name: name, Unique: unique, Func: funcKey, Owner: r.owners[name]})
+	}
+	return unique
+}
+
+// Collisions returns the names made unique so far, in order of claim.
+func (r *NameRegistry) Collisions() []NameCollision {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]NameCollision(nil), r.collisions...)
+}
@@ -0,0 +1,120 @@
+package template_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/mpyw/ctxweaver/pkg/template"
+)
+
+func TestNameRegistry_Claim(t *testing.T) {
+	t.Parallel()
+
+	type claim struct {
+		name, funcKey, want string
+	}
+	tests := map[string]struct {
+		claims         []claim
+		wantCollisions []template.NameCollision
+	}{
+		"distinct names": {
+			claims: []claim{
+				{"a.Get", "example.com/a.Get", "a.Get"},
+				{"b.Get", "example.com/b.Get", "b.Get"},
+			},
+		},
+		"same name is suffixed": {
+			claims: []claim{
+				{"h.Get", "example.com/a/h.Get", "h.Get"},
+				{"h.Get", "example.com/b/h.Get", "h.Get#2"},
+				{"h.Get", "example.com/c/h.Get", "h.Get#3"},
+			},
+			wantCollisions: []template.NameCollision{
+				{Name: "h.Get", Unique: "h.Get#2", Func: "example.com/b/h.Get", Owner: "example.com/a/h.Get"},
+				{Name: "h.Get", Unique: "h.Get#3", Func: "example.com/c/h.Get", Owner: "example.com/a/h.Get"},
+			},
+		},
+		"same function claims again": {
+			claims: []claim{
+				{"h.Get", "example.com/a/h.Get", "h.Get"},
+				{"h.Get", "example.com/b/h.Get", "h.Get#2"},
+				{"h.Get", "example.com/b/h.Get", "h.Get#2"},
+				{"h.Get", "example.com/a/h.Get", "h.Get"},
+			},
+			wantCollisions: []template.NameCollision{
+				{Name: "h.Get", Unique: "h.Get#2", Func: "example.com/b/h.Get", Owner: "example.com/a/h.Get"},
+			},
+		},
+		"suffixed name taken by a literal one": {
+			claims: []claim{
+				{"h.Get#2", "example.com/a/h.Get", "h.Get#2"},
+				{"h.Get", "example.com/b/h.Get", "h.Get"},
+				{"h.Get", "example.com/c/h.Get", "h.Get#3"},
+			},
+			wantCollisions: []template.NameCollision{
+				{Name: "h.Get", Unique: "h.Get#3", Func: "example.com/c/h.Get", Owner: "example.com/b/h.Get"},
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			r := template.NewNameRegistry()
+			for _, c := range tt.claims {
+				if got := r.Claim(c.name, c.funcKey); got != c.want {
+					t.Errorf("Claim(%q, %q) = %q, want %q", c.name, c.funcKey, got, c.want)
+				}
+			}
+			if diff := cmp.Diff(tt.wantCollisions, r.Collisions()); diff != "" {
+				t.Errorf("Collisions() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTemplate_Render_Unique(t *testing.T) {
+	t.Parallel()
+
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, "{{.FuncName | unique}}")`)
+	if !tmpl.UsesUnique() {
+		t.Error("UsesUnique() = false, want true")
+	}
+
+	names := template.NewNameRegistry()
+	render := func(funcKey string, names *template.NameRegistry) string {
+		t.Helper()
+		vars := template.Vars{Ctx: "ctx", FuncName: "h.Get"}
+		if names != nil {
+			vars.SetNames(names, funcKey)
+		}
+		got, err := tmpl.Render(vars)
+		if err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		return got
+	}
+
+	if got, want := render("example.com/a/h.Get", names), `defer trace(ctx, "h.Get")`; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+	if got, want := render("example.com/b/h.Get", names), `defer trace(ctx, "h.Get#2")`; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+	// Without a registry, names are left as is
+	if got, want := render("example.com/b/h.Get", nil), `defer trace(ctx, "h.Get")`; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+
+	vars := template.Vars{Ctx: "ctx", FuncName: "h.Get"}
+	vars.SetNames(names, "example.com/b/h.Get")
+	got, err := tmpl.RenderPlaceholders(vars)
+	if err != nil {
+		t.Fatalf("RenderPlaceholders() error = %v", err)
+	}
+	if want := `defer trace(__ctxweaver_Ctx__, "__ctxweaver_FuncName__")`; got != want {
+		t.Errorf("RenderPlaceholders() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+package template
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/decorator"
+	"github.com/dave/dst/decorator/resolver/goast"
+	"github.com/dave/dst/decorator/resolver/guess"
+
+	"github.com/mpyw/ctxweaver/pkg/carrier"
+	"github.com/mpyw/ctxweaver/pkg/config"
+)
+
+// RenderForSignature renders the template for a function declared with the
+// signature sig (e.g. "func (s *Service) Get(ctx context.Context, id int) error",
+// the func keyword being optional) in the package pkgPath, without loading any
+// source code. It shows what would be inserted into such a function, e.g. in
+// documentation generators and preview UIs.
+//
+// Types are qualified by the last element of their package path, without a
+// major version suffix (e.g. "echo.Context"), and the carrier is found among
+// the built-in carriers and function shapes as when weaving: the first
+// parameter, the parameter of a matching shape, or a later parameter of a
+// carrier allowed at any position. The package name is the last element of
+// pkgPath. Variables unknown from a signature (ModulePath, FileName, ...) are
+// empty.
+func (t *Template) RenderForSignature(sig, pkgPath string) (string, error) {
+	registry := config.NewCarrierRegistry(true)
+	df, decl, err := parseSignature(sig, pkgPath, registry)
+	if err != nil {
+		return "", err
+	}
+	df.Name.Name = shortPackageName(pkgPath, "main")
+
+	match := signatureCarrier(decl.Type.Params, registry)
+	if match == nil {
+		return "", fmt.Errorf("no context carrier in signature %q", sig)
+	}
+	vars := BuildVars(df, decl, pkgPath, match.Carrier, match.VarName)
+	vars.SetDeclared(signatureNames(decl))
+	return t.Render(vars)
+}
+
+// parseSignature parses sig as the declaration of a function with an empty
+// body, in a file importing the packages of the carriers and shapes of
+// registry, so that its types resolve to their package paths.
+func parseSignature(sig, pkgPath string, registry *config.CarrierRegistry) (*dst.File, *dst.FuncDecl, error) {
+	sig = strings.TrimSpace(sig)
+	if !strings.HasPrefix(sig, "func ") {
+		sig = "func " + sig
+	}
+
+	var src strings.Builder
+	src.WriteString("package p\n\n")
+	names := make(map[string]bool)
+	addImport := func(path string) {
+		name := shortPackageName(path, "")
+		if !token.IsIdentifier(name) || names[name] {
+			return
+		}
+		names[name] = true
+		fmt.Fprintf(&src, "import %s %q\n", name, path)
+	}
+	for _, c := range registry.All() {
+		addImport(c.Package)
+	}
+	for _, shape := range registry.Shapes() {
+		for _, param := range shape.Params {
+			if i := strings.LastIndex(param, "."); i > 0 {
+				addImport(strings.TrimPrefix(param[:i], "*"))
+			}
+		}
+	}
+	src.WriteString("\n" + sig + " {}\n")
+
+	fset := token.NewFileSet()
+	astFile, err := parser.ParseFile(fset, "", src.String(), parser.ParseComments)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid signature %q: %w", sig, err)
+	}
+	if len(astFile.Decls) == 0 {
+		return nil, nil, fmt.Errorf("invalid signature %q: want a function", sig)
+	}
+	last := astFile.Decls[len(astFile.Decls)-1]
+	if _, ok := last.(*ast.FuncDecl); !ok || len(astFile.Decls) != len(astFile.Imports)+1 {
+		return nil, nil, fmt.Errorf("invalid signature %q: want a single function", sig)
+	}
+	df, err := decorator.NewDecoratorWithImports(fset, pkgPath, goast.WithResolver(guess.New())).DecorateFile(astFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid signature %q: %w", sig, err)
+	}
+	return df, df.Decls[len(df.Decls)-1].(*dst.FuncDecl), nil
+}
+
+// signatureCarrier returns the carrier of the parameters, or nil if none.
+func signatureCarrier(params *dst.FieldList, registry *config.CarrierRegistry) *carrier.MatchResult {
+	if len(params.List) == 0 {
+		return nil
+	}
+	if result := carrier.Match(params.List[0], registry); result != nil {
+		return result
+	}
+	if result := carrier.MatchShape(params, registry); result != nil {
+		return result
+	}
+	for _, field := range params.List[1:] {
+		if result := carrier.Match(field, registry); result != nil && result.Carrier.AnyParam {
+			return result
+		}
+	}
+	return nil
+}
+
+// signatureNames returns the names declared by the receiver, parameters,
+// results and type parameters of decl, which UniqueVar avoids.
+func signatureNames(decl *dst.FuncDecl) map[string]bool {
+	names := make(map[string]bool)
+	for _, list := range []*dst.FieldList{decl.Recv, decl.Type.TypeParams, decl.Type.Params, decl.Type.Results} {
+		if list == nil {
+			continue
+		}
+		for _, field := range list.List {
+			for _, id := range field.Names {
+				names[id.Name] = true
+			}
+		}
+	}
+	delete(names, "_")
+	return names
+}
@@ -3,9 +3,18 @@ package template
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash/maphash"
+	"maps"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
 )
 
@@ -15,14 +24,34 @@ type Vars struct {
 	Ctx string
 	// CtxVar is the name of the context parameter variable (e.g., "ctx", "c")
 	CtxVar string
+	// CarrierPackage is the import path of the carrier type
+	// (e.g., "context", "github.com/labstack/echo/v4")
+	CarrierPackage string
+	// CarrierType is the name of the carrier type (e.g., "Context", "Request")
+	CarrierType string
+	// CarrierAccessor is the expression appended to CtxVar to get the context
+	// (e.g., "" for context.Context, ".Request().Context()" for echo.Context)
+	CarrierAccessor string
 	// FuncName is the fully qualified function name (e.g., "(*pkg.Service).Method")
 	FuncName string
 	// PackageName is the package name (e.g., "service")
 	PackageName string
 	// PackagePath is the full import path (e.g., "github.com/example/myapp/pkg/service")
 	PackagePath string
+	// ModulePath is the path of the module containing the package
+	// (e.g., "github.com/example/myapp"); empty if unknown
+	ModulePath string
+	// PackageRelPath is PackagePath relative to ModulePath (e.g., "pkg/service");
+	// empty for the package at the module root or if the module is unknown
+	PackageRelPath string
+	// PackageNameShort is the last element of PackagePath without a major version
+	// suffix (e.g., "service"; "api" for a main package in cmd/api)
+	PackageNameShort string
 	// FuncBaseName is the function name without package/receiver (e.g., "Method")
 	FuncBaseName string
+	// FuncNameSnake is the receiver type and function name in snake_case
+	// (e.g., "service_method"), suitable for metric labels
+	FuncNameSnake string
 	// ReceiverType is the receiver type name (empty if not a method)
 	ReceiverType string
 	// ReceiverVar is the receiver variable name (empty if not a method)
@@ -35,28 +64,158 @@ type Vars struct {
 	IsGenericFunc bool
 	// IsGenericReceiver indicates whether the receiver type has type parameters
 	IsGenericReceiver bool
+	// FileName is the path of the file relative to its module root
+	// (e.g., "internal/service/user.go"); empty if unknown
+	FileName string
+	// FileBase is the base name of the file (e.g., "user.go"); empty if unknown
+	FileBase string
+	// BuildTags is the build constraint of the file's //go:build line
+	// (e.g., "linux && !cgo"); empty if the file has none
+	BuildTags string
+	// Attrs are the attributes of //ctxweaver:attr directives above the function
+	// (e.g., {{.Attrs.tier}} is "critical" for "//ctxweaver:attr tier=critical");
+	// missing attributes are empty
+	Attrs map[string]string
+
+	// declared holds the names declared in the function scope; avoided by UniqueVar
+	declared map[string]bool
+	// placeholders makes UniqueVar return a placeholder; set by RenderPlaceholders
+	placeholders bool
+	// packagePath is PackagePath before RenderPlaceholders replaced it
+	packagePath string
+	// names makes the names passed to the unique function unique; nil to leave them as is
+	names *NameRegistry
+	// funcKey identifies the function in names
+	funcKey string
+}
+
+// UniqueVar returns name, or name followed by the smallest numeric suffix
+// (starting at 2) that is not declared in the function scope.
+// Use it for variables declared by the template: {{.UniqueVar "span"}}.
+func (v Vars) UniqueVar(name string) string {
+	if v.placeholders {
+		return placeholder("UniqueVar_" + name)
+	}
+	if !v.declared[name] {
+		return name
+	}
+	for i := 2; ; i++ {
+		if candidate := name + strconv.Itoa(i); !v.declared[candidate] {
+			return candidate
+		}
+	}
+}
+
+// InPackage reports whether the package path contains elems as whole path
+// elements, e.g. "handler" or "internal/handler" for
+// "example.com/app/internal/handler". Use it to vary the statements per layer:
+// {{if .InPackage "handler"}}...{{end}}.
+func (v Vars) InPackage(elems string) bool {
+	elems = strings.Trim(elems, "/")
+	if elems == "" {
+		return false
+	}
+	return strings.Contains("/"+v.realPackagePath()+"/", "/"+elems+"/")
+}
+
+// PackageHasPrefix reports whether the package path begins with prefix.
+func (v Vars) PackageHasPrefix(prefix string) bool {
+	return strings.HasPrefix(v.realPackagePath(), prefix)
+}
+
+// PackageMatches reports whether the package path matches the regular expression.
+func (v Vars) PackageMatches(pattern string) (bool, error) {
+	return regexp.MatchString(pattern, v.realPackagePath())
+}
+
+// realPackagePath returns the package path, even when rendering placeholders,
+// so that conditions on it render the same structure.
+func (v Vars) realPackagePath() string {
+	if v.placeholders {
+		return v.packagePath
+	}
+	return v.PackagePath
+}
+
+// SetDeclared sets the names declared in the function scope, which UniqueVar avoids.
+func (v *Vars) SetDeclared(names map[string]bool) {
+	v.declared = names
+}
+
+// SetModule sets ModulePath and PackageRelPath from the path of the module
+// containing the package.
+func (v *Vars) SetModule(modulePath string) {
+	v.ModulePath = modulePath
+	if modulePath == "" {
+		return
+	}
+	if rel, ok := strings.CutPrefix(v.PackagePath, modulePath+"/"); ok {
+		v.PackageRelPath = rel
+	}
+}
+
+// SetFile sets FileName and FileBase from the slash-separated path of the
+// file, relative to its module root.
+func (v *Vars) SetFile(name string) {
+	v.FileName = name
+	if name != "" {
+		v.FileBase = path.Base(name)
+	}
+}
+
+// SetNames sets the registry making the names passed to the unique template
+// function unique, and the key identifying the function in it.
+func (v *Vars) SetNames(names *NameRegistry, funcKey string) {
+	v.names, v.funcKey = names, funcKey
+}
+
+// unique implements the unique template function.
+func (v Vars) unique(name string) string {
+	if v.placeholders || v.names == nil {
+		return name
+	}
+	return v.names.Claim(name, v.funcKey)
 }
 
 // Template wraps a parsed template for statement generation.
 type Template struct {
 	tmpl *template.Template
 	raw  string
+
+	mu           sync.Mutex
+	unique       *template.Template // Clone of tmpl whose unique function is bound by every Render; guarded by mu
+	placeholders map[uint64]string  // Outputs of RenderPlaceholders by placeholderKey; guarded by mu
 }
 
+// maxPlaceholders bounds the outputs of RenderPlaceholders kept by a template.
+// They only vary with the variables RenderPlaceholders keeps (e.g. the carrier
+// and the package path), so the functions of a package mostly share one.
+// Render is not cached: its output differs for every function, so hashing the
+// variables would only add to the cost (see BenchmarkTemplate_Render).
+const maxPlaceholders = 1024
+
+// placeholderSeed seeds the hashes of placeholderKey.
+var placeholderSeed = maphash.MakeSeed()
+
 // funcs returns the template function map.
 func funcs() template.FuncMap {
 	return template.FuncMap{
 		"quote":    strconv.Quote,
 		"backtick": func(s string) string { return "`" + s + "`" },
+		"unique":   func(s string) string { return s }, // Bound to the Vars by Render
 	}
 }
 
 // Parse parses a template string.
+// References to fields that do not exist in Vars are rejected.
 func Parse(text string) (*Template, error) {
-	tmpl, err := template.New("stmt").Funcs(funcs()).Parse(text)
+	tmpl, err := template.New("stmt").Funcs(funcs()).Option("missingkey=zero").Parse(text)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse template: %w", err)
 	}
+	if err := checkFields(tmpl.Tree); err != nil {
+		return nil, fmt.Errorf("failed to parse template: %w", err)
+	}
 	return &Template{tmpl: tmpl, raw: text}, nil
 }
 
@@ -70,15 +229,155 @@ func MustParse(text string) *Template {
 }
 
 // Render executes the template with the given variables.
+// When the unique function is bound, renders are serialized, since they bind
+// it on the same clone of the template.
 func (t *Template) Render(vars Vars) (string, error) {
+	if vars.names == nil {
+		return t.execute(t.tmpl, vars)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.unique == nil {
+		t.unique = template.Must(t.tmpl.Clone())
+	}
+	return t.execute(t.unique.Funcs(template.FuncMap{"unique": vars.unique}), vars)
+}
+
+// execute executes tmpl with vars.
+func (t *Template) execute(tmpl *template.Template, vars Vars) (string, error) {
 	var buf bytes.Buffer
-	if err := t.tmpl.Execute(&buf, vars); err != nil {
+	if err := tmpl.Execute(&buf, vars); err != nil {
 		return "", fmt.Errorf("failed to execute template: %w", err)
 	}
 	return strings.TrimSpace(buf.String()), nil
 }
 
+// PlaceholderPrefix starts every value substituted by RenderPlaceholders.
+const PlaceholderPrefix = "__ctxweaver_"
+
+// RenderPlaceholders executes the template with placeholder identifiers in
+// place of the string fields of vars (e.g. "__ctxweaver_FuncName__"), the
+// attributes (e.g. "__ctxweaver_Attrs_tier__") and the names returned by
+// UniqueVar (e.g. "__ctxweaver_UniqueVar_span__"), while the boolean fields,
+// the carrier fields (see keptFields) and the package path conditions
+// (InPackage, PackageHasPrefix, PackageMatches) are kept, so that conditional
+// sections render the same structure as Render. Positions containing
+// PlaceholderPrefix in the output are the ones filled by template variables.
+func (t *Template) RenderPlaceholders(vars Vars) (string, error) {
+	key := placeholderKey(vars)
+	t.mu.Lock()
+	out, ok := t.placeholders[key]
+	t.mu.Unlock()
+	if ok {
+		return out, nil
+	}
+
+	vars.packagePath = vars.PackagePath
+	v := reflect.ValueOf(&vars).Elem()
+	for i := range v.NumField() {
+		if f := v.Field(i); f.Kind() == reflect.String && f.CanSet() && !keptFields[v.Type().Field(i).Name] {
+			f.SetString(placeholder(v.Type().Field(i).Name))
+		}
+	}
+	if vars.Attrs != nil {
+		attrs := make(map[string]string, len(vars.Attrs))
+		for key := range vars.Attrs {
+			attrs[key] = placeholder("Attrs_" + key)
+		}
+		vars.Attrs = attrs
+	}
+	vars.placeholders = true
+	vars.names = nil // The unique function returns its argument with placeholders
+	out, err := t.Render(vars)
+	if err != nil {
+		return "", err
+	}
+
+	t.mu.Lock()
+	if t.placeholders == nil || len(t.placeholders) >= maxPlaceholders {
+		t.placeholders = make(map[uint64]string)
+	}
+	t.placeholders[key] = out
+	t.mu.Unlock()
+	return out, nil
+}
+
+// placeholderKey hashes the variables the output of RenderPlaceholders
+// depends on: the boolean fields, the kept fields, the package path and the
+// attribute names.
+func placeholderKey(vars Vars) uint64 {
+	var h maphash.Hash
+	h.SetSeed(placeholderSeed)
+	v := reflect.ValueOf(&vars).Elem()
+	for i := range v.NumField() {
+		f, field := v.Field(i), v.Type().Field(i)
+		switch {
+		case f.Kind() == reflect.Bool && field.IsExported():
+			if f.Bool() {
+				_ = h.WriteByte(1)
+			} else {
+				_ = h.WriteByte(0)
+			}
+		case keptFields[field.Name]:
+			_, _ = h.WriteString(f.String())
+			_ = h.WriteByte(0)
+		}
+	}
+	_, _ = h.WriteString(vars.PackagePath)
+	_ = h.WriteByte(0)
+	for _, key := range slices.Sorted(maps.Keys(vars.Attrs)) {
+		_, _ = h.WriteString(key)
+		_ = h.WriteByte(0)
+	}
+	return h.Sum64()
+}
+
+// UsesUniqueVar reports whether the template may call UniqueVar.
+// It may report false positives, e.g. for the word in a comment.
+func (t *Template) UsesUniqueVar() bool {
+	return strings.Contains(t.raw, "UniqueVar")
+}
+
+// UsesUnique reports whether the template may call the unique function.
+// It may report false positives, e.g. for the word in a comment.
+func (t *Template) UsesUnique() bool {
+	return strings.Contains(t.raw, "unique")
+}
+
+// keptFields are the string fields of Vars that RenderPlaceholders keeps, so
+// that templates branching on the carrier render the same structure.
+var keptFields = map[string]bool{"CarrierPackage": true, "CarrierType": true, "CarrierAccessor": true}
+
+// PlaceholderBindings maps each placeholder used by RenderPlaceholders to the
+// corresponding string field of vars.
+func PlaceholderBindings(vars Vars) map[string]string {
+	v := reflect.ValueOf(vars)
+	bindings := make(map[string]string)
+	for i := range v.NumField() {
+		if f := v.Field(i); f.Kind() == reflect.String && v.Type().Field(i).IsExported() && !keptFields[v.Type().Field(i).Name] {
+			bindings[placeholder(v.Type().Field(i).Name)] = f.String()
+		}
+	}
+	for key, value := range vars.Attrs {
+		bindings[placeholder("Attrs_"+key)] = value
+	}
+	return bindings
+}
+
+// placeholder returns the placeholder identifier for a Vars field.
+func placeholder(field string) string {
+	return PlaceholderPrefix + field + "__"
+}
+
 // Raw returns the original template string.
 func (t *Template) Raw() string {
 	return t.raw
 }
+
+// Hash returns a short hash of the template string identifying its version,
+// as recorded by the generated marker (e.g. "3f2a9c1e").
+func (t *Template) Hash() string {
+	sum := sha256.Sum256([]byte(t.raw))
+	return hex.EncodeToString(sum[:4])
+}
@@ -1,8 +1,11 @@
 package template_test
 
 import (
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/mpyw/ctxweaver/pkg/config"
 	"github.com/mpyw/ctxweaver/pkg/template"
 )
 
@@ -30,6 +33,38 @@ defer span.End()`,
 			input:   `defer trace({{.Ctx}`,
 			wantErr: true,
 		},
+		"unknown field": {
+			input:   `defer trace({{.Ctx}}, {{.FunName | quote}})`,
+			wantErr: true,
+		},
+		"unknown field in if branch": {
+			input:   `{{if .IsMethod}}defer trace({{.Receiver}}){{end}}`,
+			wantErr: true,
+		},
+		"field on scalar variable": {
+			input:   `defer trace({{.Ctx.Value}})`,
+			wantErr: true,
+		},
+		"field inside with body is not checked": {
+			input: `{{with .ReceiverVar}}{{.Anything}}{{end}}`,
+		},
+		"unique var method": {
+			input: `{{.CtxVar}}, {{.UniqueVar "span"}} := tracer.Start({{.Ctx}}, {{.FuncName | quote}})`,
+		},
+		"field on method": {
+			input:   `defer trace({{.UniqueVar.Name}})`,
+			wantErr: true,
+		},
+		"attribute": {
+			input: `defer trace({{.Ctx}}, {{.Attrs.tier | quote}})`,
+		},
+		"field on attribute": {
+			input:   `defer trace({{.Attrs.tier.Name}})`,
+			wantErr: true,
+		},
+		"variables are not checked": {
+			input: `{{$name := .FuncName}}defer trace({{.Ctx}}, {{$name | quote}})`,
+		},
 	}
 
 	for name, tt := range tests {
@@ -150,6 +185,19 @@ defer trace(ctx, "pkg.Transform[...]")`,
 			},
 			want: `// has generics`,
 		},
+		"attribute": {
+			tmpl: `defer trace({{.Ctx}}, {{.Attrs.tier | quote}})`,
+			vars: template.Vars{
+				Ctx:   "ctx",
+				Attrs: map[string]string{"tier": "critical"},
+			},
+			want: `defer trace(ctx, "critical")`,
+		},
+		"missing attribute": {
+			tmpl: `defer trace({{.Ctx}}{{if .Attrs.tier}}, {{.Attrs.tier | quote}}{{end}})`,
+			vars: template.Vars{Ctx: "ctx"},
+			want: `defer trace(ctx)`,
+		},
 	}
 
 	for name, tt := range tests {
@@ -184,6 +232,21 @@ func TestTemplate_Raw(t *testing.T) {
 	}
 }
 
+func TestTemplate_Hash(t *testing.T) {
+	t.Parallel()
+
+	tmpl := template.MustParse(`defer trace({{.Ctx}})`)
+	if got := tmpl.Hash(); len(got) != 8 {
+		t.Errorf("Hash() = %q, want 8 hex digits", got)
+	}
+	if tmpl.Hash() != template.MustParse(`defer trace({{.Ctx}})`).Hash() {
+		t.Error("Hash() differs for the same template")
+	}
+	if tmpl.Hash() == template.MustParse(`defer trace({{.Ctx}}, {{.FuncName | quote}})`).Hash() {
+		t.Error("Hash() is the same for different templates")
+	}
+}
+
 func TestMustParse_Panic(t *testing.T) {
 	t.Parallel()
 
@@ -199,14 +262,546 @@ func TestMustParse_Panic(t *testing.T) {
 func TestTemplate_Render_Error(t *testing.T) {
 	t.Parallel()
 
-	// Test rendering with missing required variable causes error
-	tmpl, err := template.Parse(`{{.NonExistent.Field}}`)
+	// Test rendering with an out-of-range index causes an execution error
+	tmpl, err := template.Parse(`{{index .FuncName 10}}`)
 	if err != nil {
 		t.Fatalf("Parse() error = %v", err)
 	}
 
 	_, err = tmpl.Render(template.Vars{})
 	if err == nil {
-		t.Error("Render() should error when accessing non-existent field")
+		t.Error("Render() should error when indexing out of range")
+	}
+}
+
+func TestTemplate_RenderForSignature(t *testing.T) {
+	t.Parallel()
+
+	tmpl := template.MustParse(`{{.UniqueVar "span"}} := start({{.Ctx}}, {{.FuncName | quote}}, {{.PackagePath | quote}})`)
+
+	tests := map[string]struct {
+		sig     string
+		pkgPath string
+		want    string
+		wantErr string
+	}{
+		"function": {
+			sig:     "func Get(ctx context.Context, id int) error",
+			pkgPath: "example.com/app/service",
+			want:    `span := start(ctx, "service.Get", "example.com/app/service")`,
+		},
+		"method without func keyword": {
+			sig:     "(s *Service) Get(ctx context.Context) (span int)",
+			pkgPath: "example.com/app/service/v2",
+			want:    `span2 := start(ctx, "service.(*Service).Get", "example.com/app/service/v2")`,
+		},
+		"carrier with accessor": {
+			sig:     "func Handle(c echo.Context) error",
+			pkgPath: "example.com/app/handler",
+			want:    `span := start(c.Request().Context(), "handler.Handle", "example.com/app/handler")`,
+		},
+		"function shape": {
+			sig:     "func ServeHTTP(w http.ResponseWriter, r *http.Request)",
+			pkgPath: "example.com/app/handler",
+			want:    `span := start(r.Context(), "handler.ServeHTTP", "example.com/app/handler")`,
+		},
+		"no carrier": {
+			sig:     "func Get(id int) error",
+			pkgPath: "example.com/app/service",
+			wantErr: "no context carrier",
+		},
+		"carrier not first": {
+			sig:     "func Get(id int, ctx context.Context)",
+			pkgPath: "example.com/app/service",
+			wantErr: "no context carrier",
+		},
+		"invalid signature": {
+			sig:     "func Get(ctx context.Context",
+			pkgPath: "example.com/app/service",
+			wantErr: "invalid signature",
+		},
+		"several declarations": {
+			sig:     "func A(ctx context.Context) {}; func B(ctx context.Context)",
+			pkgPath: "example.com/app/service",
+			wantErr: "want a single function",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tmpl.RenderForSignature(tt.sig, tt.pkgPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("RenderForSignature() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenderForSignature() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("RenderForSignature() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_UnknownFieldSuggestion(t *testing.T) {
+	t.Parallel()
+
+	_, err := template.Parse(`defer trace({{.Ctx}}, {{.FunName | quote}})`)
+	if err == nil {
+		t.Fatal("Parse() should reject unknown fields")
+	}
+	if !strings.Contains(err.Error(), "did you mean {{.FuncName}}?") {
+		t.Errorf("error should suggest FuncName, got: %v", err)
+	}
+}
+
+func TestTemplate_Validate(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		tmpl    string
+		wantErr string
+	}{
+		"valid statement": {
+			tmpl: `defer trace({{.Ctx}}, {{.FuncName | quote}})`,
+		},
+		"multiple statements": {
+			tmpl: `{{.CtxVar}}, span := tracer.Start({{.Ctx}}, {{.FuncName | quote}})
+defer span.End()`,
+		},
+		"unbalanced parentheses": {
+			tmpl:    `defer trace({{.Ctx}}`,
+			wantErr: "not valid Go statements",
+		},
+		"syntax error points at the line": {
+			tmpl: `ctx, span := tracer.Start({{.Ctx}}, {{.FuncName | quote}})
+defer span.End(
+log.Print("done")`,
+			wantErr: "line 3:",
+		},
+		"syntax error points at the template line": {
+			tmpl: `{{if .IsGenericFunc}}
+defer trace({{.Ctx}})
+{{else}}
+defer trace({{.Ctx}}
+{{end}}`,
+			wantErr: "line 4: missing ',' before newline in argument list\n\tdefer trace({{.Ctx}}",
+		},
+		"statement after a multi-line comment": {
+			tmpl: `{{/* traces
+every function */}}
+{{.Ctx}}`,
+			wantErr: "line 3: expression is not a statement (only calls and receive operations are)\n\t{{.Ctx}}",
+		},
+		"expression only": {
+			tmpl: `defer trace({{.Ctx}})
+{{.Ctx}}`,
+			wantErr: "line 2: expression is not a statement",
+		},
+		"receive operation": {
+			tmpl: `<-done`,
+		},
+		"type declaration": {
+			tmpl:    `type span struct{}`,
+			wantErr: "line 1: type declaration is not allowed",
+		},
+		"renders nothing": {
+			tmpl:    `{{/* nothing yet */}}`,
+			wantErr: "renders no statements",
+		},
+		"conditional renders nothing for the sample": {
+			tmpl: `{{if .InPackage "handler"}}defer trace({{.Ctx}}){{end}}`,
+		},
+		"execution error": {
+			tmpl:    `{{index .FuncName 100}}`,
+			wantErr: "failed to execute template",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := template.MustParse(tt.tmpl).Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTemplate_ValidateMarked(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		tmpl    string
+		wantErr string
+	}{
+		"no comment": {
+			tmpl: `defer trace({{.Ctx}})
+`,
+		},
+		"comment between statements": {
+			tmpl: `ctx, span := tracer.Start({{.Ctx}}) // starts a span
+defer span.End()`,
+		},
+		"trailing comment": {
+			tmpl:    `defer trace({{.Ctx}}) // traced`,
+			wantErr: "line 1: the last statement ends with a comment",
+		},
+		"comment after the last statement": {
+			tmpl: `defer trace({{.Ctx}})
+{{if .IsMethod}}
+// traced
+{{end}}`,
+			wantErr: "line 3: the last statement ends with a comment, which would hide the generated marker\n\t// traced",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			err := template.MustParse(tt.tmpl).ValidateMarked()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateMarked() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateMarked() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTemplate_RenderPlaceholders(t *testing.T) {
+	t.Parallel()
+
+	tmpl := template.MustParse(`{{if .IsMethod}}defer trace({{.Ctx}}, {{.FuncName | quote}}){{else}}defer trace({{.Ctx}}){{end}}`)
+
+	got, err := tmpl.RenderPlaceholders(template.Vars{Ctx: "c.Request().Context()", FuncName: "pkg.(*T).M", IsMethod: true})
+	if err != nil {
+		t.Fatalf("RenderPlaceholders() error = %v", err)
+	}
+	want := `defer trace(__ctxweaver_Ctx__, "__ctxweaver_FuncName__")`
+	if got != want {
+		t.Errorf("RenderPlaceholders() = %q, want %q", got, want)
+	}
+}
+
+func TestVars_UniqueVar(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		declared map[string]bool
+		want     string
+	}{
+		"free name": {
+			declared: map[string]bool{"ctx": true},
+			want:     "span",
+		},
+		"declared name": {
+			declared: map[string]bool{"span": true},
+			want:     "span2",
+		},
+		"suffixed names declared too": {
+			declared: map[string]bool{"span": true, "span2": true, "span3": true},
+			want:     "span4",
+		},
+		"nothing declared": {
+			want: "span",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			vars := template.Vars{}
+			vars.SetDeclared(tt.declared)
+			if got := vars.UniqueVar("span"); got != tt.want {
+				t.Errorf("UniqueVar() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplate_Render_Repeated(t *testing.T) {
+	t.Parallel()
+
+	tmpl := template.MustParse(`{{.UniqueVar "span"}} := tracer.Start({{.Ctx}}, {{.FuncName | quote}})`)
+
+	// Repeated renders depend on every variable, including the declared names
+	render := func(funcName string, declared map[string]bool) string {
+		vars := template.Vars{Ctx: "ctx", FuncName: funcName}
+		vars.SetDeclared(declared)
+		got, err := tmpl.Render(vars)
+		if err != nil {
+			t.Fatalf("Render() error = %v", err)
+		}
+		return got
+	}
+	for _, tt := range []struct {
+		funcName string
+		declared map[string]bool
+		want     string
+	}{
+		{"Foo", nil, `span := tracer.Start(ctx, "Foo")`},
+		{"Foo", nil, `span := tracer.Start(ctx, "Foo")`},
+		{"Foo", map[string]bool{"span": true}, `span2 := tracer.Start(ctx, "Foo")`},
+		{"Bar", nil, `span := tracer.Start(ctx, "Bar")`},
+		{"Foo", nil, `span := tracer.Start(ctx, "Foo")`},
+	} {
+		if got := render(tt.funcName, tt.declared); got != tt.want {
+			t.Errorf("Render(%s, %v) = %q, want %q", tt.funcName, tt.declared, got, tt.want)
+		}
+	}
+}
+
+func TestTemplate_RenderPlaceholders_Repeated(t *testing.T) {
+	t.Parallel()
+
+	tmpl := template.MustParse(`{{if .InPackage "handler"}}defer trace({{.Ctx}}, {{.Attrs.tier | quote}}){{else if .IsMethod}}defer trace({{.Ctx}}{{.CarrierAccessor}}){{end}}`)
+
+	// Repeated renders depend on the kept variables and the attribute names,
+	// not on the others
+	for _, tt := range []struct {
+		vars template.Vars
+		want string
+	}{
+		{template.Vars{Ctx: "ctx", PackagePath: "app/handler"}, `defer trace(__ctxweaver_Ctx__, "")`},
+		{template.Vars{Ctx: "c", FuncName: "Foo", PackagePath: "app/handler"}, `defer trace(__ctxweaver_Ctx__, "")`},
+		{template.Vars{Ctx: "ctx", PackagePath: "app/handler", Attrs: map[string]string{"tier": "critical"}}, `defer trace(__ctxweaver_Ctx__, "__ctxweaver_Attrs_tier__")`},
+		{template.Vars{Ctx: "ctx", PackagePath: "app/service"}, ``},
+		{template.Vars{Ctx: "ctx", PackagePath: "app/service", IsMethod: true}, `defer trace(__ctxweaver_Ctx__)`},
+		{template.Vars{Ctx: "ctx", PackagePath: "app/service", IsMethod: true, CarrierAccessor: ".Context()"}, `defer trace(__ctxweaver_Ctx__.Context())`},
+	} {
+		got, err := tmpl.RenderPlaceholders(tt.vars)
+		if err != nil {
+			t.Fatalf("RenderPlaceholders() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("RenderPlaceholders(%+v) = %q, want %q", tt.vars, got, tt.want)
+		}
+	}
+}
+
+func TestTemplate_RenderPlaceholders_UniqueVar(t *testing.T) {
+	t.Parallel()
+
+	tmpl := template.MustParse(`{{.CtxVar}}, {{.UniqueVar "span"}} := tracer.Start({{.Ctx}})`)
+	vars := template.Vars{Ctx: "ctx", CtxVar: "ctx"}
+	vars.SetDeclared(map[string]bool{"span": true})
+
+	got, err := tmpl.RenderPlaceholders(vars)
+	if err != nil {
+		t.Fatalf("RenderPlaceholders() error = %v", err)
+	}
+	want := `__ctxweaver_CtxVar__, __ctxweaver_UniqueVar_span__ := tracer.Start(__ctxweaver_Ctx__)`
+	if got != want {
+		t.Errorf("RenderPlaceholders() = %q, want %q", got, want)
+	}
+	if !tmpl.UsesUniqueVar() {
+		t.Error("UsesUniqueVar() = false, want true")
+	}
+}
+
+func TestVars_PackageConditions(t *testing.T) {
+	t.Parallel()
+
+	tmpl := template.MustParse(`{{if .InPackage "handler"}}defer traceHandler({{.Ctx}}){{else if .PackageHasPrefix "example.com/app/internal"}}defer traceInternal({{.Ctx}}){{else if .PackageMatches "/v[0-9]+$"}}defer traceVersioned({{.Ctx}}){{else}}defer trace({{.Ctx}}){{end}}`)
+
+	tests := map[string]struct {
+		pkgPath string
+		want    string
+	}{
+		"path element":         {pkgPath: "example.com/app/handler", want: "defer traceHandler(ctx)"},
+		"nested path element":  {pkgPath: "example.com/app/handler/user", want: "defer traceHandler(ctx)"},
+		"partial element":      {pkgPath: "example.com/app/handlers", want: "defer trace(ctx)"},
+		"prefix":               {pkgPath: "example.com/app/internal/repo", want: "defer traceInternal(ctx)"},
+		"regexp":               {pkgPath: "example.com/app/api/v2", want: "defer traceVersioned(ctx)"},
+		"no condition matches": {pkgPath: "example.com/app/service", want: "defer trace(ctx)"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			vars := template.Vars{Ctx: "ctx", PackagePath: tt.pkgPath}
+			got, err := tmpl.Render(vars)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+
+			// Conditions see the real package path when rendering placeholders
+			pattern, err := tmpl.RenderPlaceholders(vars)
+			if err != nil {
+				t.Fatalf("RenderPlaceholders() error = %v", err)
+			}
+			if want := strings.Replace(tt.want, "(ctx)", "(__ctxweaver_Ctx__)", 1); pattern != want {
+				t.Errorf("RenderPlaceholders() = %q, want %q", pattern, want)
+			}
+		})
+	}
+
+	t.Run("invalid regexp", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := template.MustParse(`{{if .PackageMatches "("}}x(){{end}}`).Render(template.Vars{})
+		if err == nil || !strings.Contains(err.Error(), "failed to execute template") {
+			t.Errorf("Render() error = %v, want execution error", err)
+		}
+	})
+}
+
+func TestVars_CarrierConditions(t *testing.T) {
+	t.Parallel()
+
+	tmpl := template.MustParse(`span := start({{.Ctx}})
+{{if eq .CarrierPackage "github.com/labstack/echo/v4"}}{{.CtxVar}}.Set("span", span)
+{{end}}defer span.End()`)
+
+	tests := map[string]struct {
+		vars template.Vars
+		want string
+	}{
+		"echo.Context": {
+			vars: template.Vars{Ctx: "c.Request().Context()", CtxVar: "c", CarrierPackage: "github.com/labstack/echo/v4", CarrierType: "Context", CarrierAccessor: ".Request().Context()"},
+			want: "span := start(c.Request().Context())\nc.Set(\"span\", span)\ndefer span.End()",
+		},
+		"context.Context": {
+			vars: template.Vars{Ctx: "ctx", CtxVar: "ctx", CarrierPackage: "context", CarrierType: "Context"},
+			want: "span := start(ctx)\ndefer span.End()",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tmpl.Render(tt.vars)
+			if err != nil {
+				t.Fatalf("Render() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+
+			// Conditions see the real carrier when rendering placeholders
+			pattern, err := tmpl.RenderPlaceholders(tt.vars)
+			if err != nil {
+				t.Fatalf("RenderPlaceholders() error = %v", err)
+			}
+			want := strings.NewReplacer(tt.vars.Ctx+")", "__ctxweaver_Ctx__)", tt.vars.CtxVar+".Set", "__ctxweaver_CtxVar__.Set").Replace(tt.want)
+			if pattern != want {
+				t.Errorf("RenderPlaceholders() = %q, want %q", pattern, want)
+			}
+		})
+	}
+}
+
+func TestTemplate_RenderPlaceholders_Attrs(t *testing.T) {
+	t.Parallel()
+
+	tmpl := template.MustParse(`defer trace({{.Ctx}}, {{.Attrs.tier | quote}}, {{.Attrs.slo | quote}})`)
+
+	vars := template.Vars{Ctx: "ctx", Attrs: map[string]string{"tier": "critical"}}
+	got, err := tmpl.RenderPlaceholders(vars)
+	if err != nil {
+		t.Fatalf("RenderPlaceholders() error = %v", err)
+	}
+	want := `defer trace(__ctxweaver_Ctx__, "__ctxweaver_Attrs_tier__", "")`
+	if got != want {
+		t.Errorf("RenderPlaceholders() = %q, want %q", got, want)
+	}
+	if got := template.PlaceholderBindings(vars)["__ctxweaver_Attrs_tier__"]; got != "critical" {
+		t.Errorf("Attrs binding = %q, want %q", got, "critical")
 	}
+	if vars.Attrs["tier"] != "critical" {
+		t.Errorf("RenderPlaceholders() modified the attributes of vars: %v", vars.Attrs)
+	}
+}
+
+func TestPlaceholderBindings(t *testing.T) {
+	t.Parallel()
+
+	bindings := template.PlaceholderBindings(template.Vars{Ctx: "ctx", FuncName: "pkg.Foo", IsMethod: true})
+
+	if got := bindings["__ctxweaver_FuncName__"]; got != "pkg.Foo" {
+		t.Errorf("FuncName binding = %q, want %q", got, "pkg.Foo")
+	}
+	if got := bindings["__ctxweaver_Ctx__"]; got != "ctx" {
+		t.Errorf("Ctx binding = %q, want %q", got, "ctx")
+	}
+	if _, ok := bindings["__ctxweaver_IsMethod__"]; ok {
+		t.Error("boolean fields must not have bindings")
+	}
+}
+
+func TestPresets_Validate(t *testing.T) {
+	t.Parallel()
+
+	for _, preset := range config.Presets() {
+		t.Run(preset.Name, func(t *testing.T) {
+			t.Parallel()
+
+			tmpl, err := template.Parse(preset.Template)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if err := tmpl.Validate(); err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
+
+func BenchmarkTemplate_Render(b *testing.B) {
+	tmpl := template.MustParse(`ctx, span := otel.Tracer("app").Start({{.Ctx}}, {{.FuncName | unique | quote}})
+defer span.End()`)
+	vars := template.SampleVars()
+	vars.Attrs = map[string]string{"tier": "critical"}
+
+	b.Run("variables", func(b *testing.B) {
+		for b.Loop() {
+			if _, err := tmpl.Render(vars); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("unique function", func(b *testing.B) {
+		vars := vars
+		vars.SetNames(template.NewNameRegistry(), "sample")
+		for b.Loop() {
+			if _, err := tmpl.Render(vars); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	// The functions of a package share the placeholders output
+	b.Run("placeholders", func(b *testing.B) {
+		vars := vars
+		for i := 0; b.Loop(); i++ {
+			vars.FuncName = "Func" + strconv.Itoa(i)
+			if _, err := tmpl.RenderPlaceholders(vars); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
 }
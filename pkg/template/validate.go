@@ -0,0 +1,346 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"reflect"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"unicode"
+)
+
+// Check parses and validates the content of a template, which is also checked
+// with ValidateMarked if marked is set. It is the template checker of the
+// configurations (see config.WithTemplateChecker).
+func Check(content string, marked bool) error {
+	t, err := Parse(content)
+	if err != nil {
+		return err
+	}
+	if err := t.Validate(); err != nil || !marked {
+		return err
+	}
+	return t.ValidateMarked()
+}
+
+// Validate renders the template against SampleVars and checks that the
+// output parses as one or more Go statements. Expressions other than calls and
+// receive operations are rejected since they are not valid statements, and so
+// are type declarations, which have no place in generated statements.
+// Templates with conditional sections may render nothing for the sample, as
+// layer templates leaving the functions of other packages alone do
+// (e.g. {{if .InPackage "handler"}}...{{end}}).
+// Errors point at the offending line of the template.
+func (t *Template) Validate() error {
+	rendered, err := t.renderLines(SampleVars())
+	if err != nil {
+		return err
+	}
+
+	// Wrap in a function to parse as statements
+	src := "package p\nfunc f() {\n" + rendered + "\n}"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution)
+	if err != nil {
+		var list scanner.ErrorList
+		if errors.As(err, &list) && len(list) > 0 {
+			// Unterminated constructs are reported at the closing brace of the wrapper
+			line := min(list[0].Pos.Line, strings.Count(t.raw, "\n")+1)
+			return fmt.Errorf("rendered template is not valid Go statements: line %d: %s%s", line, list[0].Msg, quoteLine(t.raw, line))
+		}
+		return fmt.Errorf("rendered template is not valid Go statements: %w\n%s", err, rendered)
+	}
+
+	stmts := f.Decls[0].(*ast.FuncDecl).Body.List
+	if len(stmts) == 0 {
+		if hasConditional(t.tmpl.Tree.Root) {
+			return nil
+		}
+		return fmt.Errorf("template renders no statements")
+	}
+	for _, stmt := range stmts {
+		if msg := nonStatement(stmt); msg != "" {
+			line := fset.Position(stmt.Pos()).Line
+			return fmt.Errorf("rendered template is not valid Go statements: line %d: %s%s", line, msg, quoteLine(t.raw, line))
+		}
+	}
+	return nil
+}
+
+// ValidateMarked checks that the last statement rendered against SampleVars
+// does not end with a comment, since the generated marker of marker matching
+// mode is appended to it as a trailing comment and would not be recognized.
+// Templates that are not valid Go statements are left to Validate.
+func (t *Template) ValidateMarked() error {
+	rendered, err := t.renderLines(SampleVars())
+	if err != nil || rendered == "" {
+		return err
+	}
+	src := "package p\nfunc f() {\n" + rendered + "\n}"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.SkipObjectResolution|parser.ParseComments)
+	if err != nil {
+		return nil
+	}
+	body := f.Decls[0].(*ast.FuncDecl).Body
+	if len(body.List) == 0 {
+		return nil
+	}
+	end := body.List[len(body.List)-1].End()
+	for _, group := range f.Comments {
+		for _, c := range group.List {
+			if c.Pos() < end || c.Pos() > body.Rbrace || strings.HasPrefix(c.Text, "//line ") {
+				continue
+			}
+			line := fset.Position(c.Pos()).Line
+			return fmt.Errorf("line %d: the last statement ends with a comment, which would hide the generated marker%s", line, quoteLine(t.raw, line))
+		}
+	}
+	return nil
+}
+
+// renderLines renders the template like Render, with a //line directive
+// before every rendered line giving the template line it comes from, so that
+// the positions reported by go/parser are template positions.
+func (t *Template) renderLines(vars Vars) (string, error) {
+	tree := t.tmpl.Tree.Copy()
+	first := 0
+	markLines(tree.Root, t.raw, &first)
+	tmpl, err := template.Must(t.tmpl.Clone()).AddParseTree(t.tmpl.Name(), tree)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+	rendered, err := t.execute(tmpl, vars)
+	if err != nil || rendered == "" {
+		return "", err
+	}
+	// The first line is only approximated by the first text of the template
+	return fmt.Sprintf("//line :%d\n%s", max(first, 1), rendered), nil
+}
+
+// markLines inserts a //line directive after every newline of the text nodes
+// under node, and sets first to the template line of the first text that is
+// not blank, if it is not set yet.
+func markLines(node parse.Node, raw string, first *int) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			markLines(child, raw, first)
+		}
+	case *parse.IfNode:
+		markLines(n.List, raw, first)
+		markLines(n.ElseList, raw, first)
+	case *parse.WithNode:
+		markLines(n.List, raw, first)
+		markLines(n.ElseList, raw, first)
+	case *parse.RangeNode:
+		markLines(n.List, raw, first)
+		markLines(n.ElseList, raw, first)
+	case *parse.TextNode:
+		lineAt := func(i int) int {
+			return strings.Count(raw[:min(int(n.Pos)+i, len(raw))], "\n") + 1
+		}
+		if i := strings.IndexFunc(string(n.Text), func(r rune) bool { return !unicode.IsSpace(r) }); *first == 0 && i >= 0 {
+			*first = lineAt(i)
+		}
+		var text []byte
+		for i, c := range n.Text {
+			text = append(text, c)
+			if c == '\n' {
+				text = fmt.Appendf(text, "//line :%d\n", lineAt(i+1))
+			}
+		}
+		n.Text = text
+	}
+}
+
+// nonStatement describes why a parsed statement cannot appear in a function
+// body, or returns "" if it can.
+func nonStatement(stmt ast.Stmt) string {
+	switch s := stmt.(type) {
+	case *ast.ExprStmt:
+		x := ast.Unparen(s.X)
+		if _, ok := x.(*ast.CallExpr); ok {
+			return ""
+		}
+		if u, ok := x.(*ast.UnaryExpr); ok && u.Op == token.ARROW {
+			return ""
+		}
+		return "expression is not a statement (only calls and receive operations are)"
+	case *ast.DeclStmt:
+		if gen, ok := s.Decl.(*ast.GenDecl); ok && gen.Tok == token.TYPE {
+			return "type declaration is not allowed"
+		}
+	}
+	return ""
+}
+
+// hasConditional reports whether node contains an if, with or range action,
+// which may render nothing for some functions.
+func hasConditional(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		return slices.ContainsFunc(n.Nodes, hasConditional)
+	case *parse.IfNode, *parse.WithNode, *parse.RangeNode:
+		return true
+	}
+	return false
+}
+
+// quoteLine returns the given 1-based line of text, indented on a new line,
+// or "" if it is out of range.
+func quoteLine(text string, line int) string {
+	lines := strings.Split(text, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return "\n\t" + strings.TrimSpace(lines[line-1])
+}
+
+// checkFields statically verifies that every {{.Field}} reference in the
+// parse tree names a field of Vars. References inside {{with}} and {{range}}
+// bodies are not checked because dot is rebound there.
+func checkFields(tree *parse.Tree) error {
+	if tree == nil || tree.Root == nil {
+		return nil
+	}
+	return checkNode(tree.Root)
+}
+
+func checkNode(node parse.Node) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := checkNode(child); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		return checkNode(n.Pipe)
+	case *parse.IfNode:
+		return checkBranch(&n.BranchNode, true)
+	case *parse.WithNode:
+		return checkBranch(&n.BranchNode, false)
+	case *parse.RangeNode:
+		return checkBranch(&n.BranchNode, false)
+	case *parse.TemplateNode:
+		return checkNode(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			if err := checkNode(cmd); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if err := checkNode(arg); err != nil {
+				return err
+			}
+		}
+	case *parse.ChainNode:
+		return checkNode(n.Node)
+	case *parse.FieldNode:
+		return checkFieldPath(n.Ident)
+	}
+	return nil
+}
+
+// checkBranch checks an if/with/range node. The else branch always sees the
+// outer dot; the main body only does when dotUnchanged is true.
+func checkBranch(n *parse.BranchNode, dotUnchanged bool) error {
+	if err := checkNode(n.Pipe); err != nil {
+		return err
+	}
+	if dotUnchanged {
+		if err := checkNode(n.List); err != nil {
+			return err
+		}
+	}
+	return checkNode(n.ElseList)
+}
+
+// checkFieldPath resolves a field chain such as [FuncName] against Vars.
+// A single method name such as [UniqueVar] is accepted too.
+func checkFieldPath(ident []string) error {
+	typ := reflect.TypeOf(Vars{})
+	if _, ok := typ.MethodByName(ident[0]); ok && len(ident) == 1 {
+		return nil
+	}
+	for i, name := range ident {
+		if typ.Kind() == reflect.Map {
+			// Any key of a map such as Attrs is accepted
+			typ = typ.Elem()
+			continue
+		}
+		if typ.Kind() != reflect.Struct {
+			return fmt.Errorf("{{.%s}}: %s is not a struct", strings.Join(ident, "."), strings.Join(ident[:i], "."))
+		}
+		field, ok := typ.FieldByName(name)
+		if !ok || !field.IsExported() {
+			msg := fmt.Sprintf("unknown template variable {{.%s}}", strings.Join(ident, "."))
+			if i == 0 {
+				if suggestion := suggestField(name); suggestion != "" {
+					msg += fmt.Sprintf(" (did you mean {{.%s}}?)", suggestion)
+				}
+			}
+			return errors.New(msg)
+		}
+		typ = field.Type
+	}
+	return nil
+}
+
+// suggestField returns the Vars field closest to name, or "" if none is close.
+func suggestField(name string) string {
+	typ := reflect.TypeOf(Vars{})
+	best, bestDist := "", 3 // only suggest within an edit distance of 2
+	for i := range typ.NumField() {
+		if !typ.Field(i).IsExported() {
+			continue
+		}
+		candidate := typ.Field(i).Name
+		if d := editDistance(name, candidate); d < bestDist {
+			best, bestDist = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
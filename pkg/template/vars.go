@@ -2,9 +2,13 @@ package template
 
 import (
 	"fmt"
+	"go/build/constraint"
+	"strings"
+	"unicode"
 
 	"github.com/dave/dst"
 
+	"github.com/mpyw/ctxweaver/internal/directive"
 	"github.com/mpyw/ctxweaver/pkg/config"
 )
 
@@ -12,13 +16,14 @@ import (
 // This function extracts all necessary information from the function declaration
 // and builds template variables that can be used for statement rendering.
 func BuildVars(df *dst.File, decl *dst.FuncDecl, pkgPath string, carrier config.CarrierDef, varName string) Vars {
-	vars := Vars{
-		Ctx:          carrier.BuildContextExpr(varName),
-		CtxVar:       varName,
-		PackageName:  df.Name.Name,
-		PackagePath:  pkgPath,
-		FuncBaseName: decl.Name.Name,
-	}
+	vars := BuildFileVars(df, pkgPath)
+	vars.Ctx = carrier.BuildContextExpr(varName)
+	vars.CtxVar = varName
+	vars.CarrierPackage = carrier.Package
+	vars.CarrierType = carrier.Type
+	vars.CarrierAccessor = carrier.Accessor
+	vars.FuncBaseName = decl.Name.Name
+	vars.FuncNameSnake = snakeCase(decl.Name.Name)
 
 	// Check if the function itself has type parameters
 	funcHasTypeParams := decl.Type.TypeParams != nil && len(decl.Type.TypeParams.List) > 0
@@ -36,6 +41,7 @@ func BuildVars(df *dst.File, decl *dst.FuncDecl, pkgPath string, carrier config.
 		// Extract receiver type name and check for generics
 		recvTypeName, recvHasGenerics := extractReceiverTypeName(recv.Type)
 		vars.ReceiverType = recvTypeName
+		vars.FuncNameSnake = snakeCase(recvTypeName) + "_" + vars.FuncNameSnake
 		vars.IsGenericReceiver = recvHasGenerics
 
 		switch recv.Type.(type) {
@@ -62,9 +68,88 @@ func BuildVars(df *dst.File, decl *dst.FuncDecl, pkgPath string, carrier config.
 		}
 	}
 
+	// A //ctxweaver:name directive pins the name regardless of the declaration
+	if name := directive.FuncName(&decl.Decs.NodeDecs); name != "" {
+		vars.FuncName = name
+	}
+	vars.Attrs = directive.Attrs(&decl.Decs.NodeDecs)
+
 	return vars
 }
 
+// BuildFileVars constructs a Vars instance with the package variables alone,
+// for code outside functions.
+func BuildFileVars(df *dst.File, pkgPath string) Vars {
+	return Vars{
+		PackageName:      df.Name.Name,
+		PackagePath:      pkgPath,
+		PackageNameShort: shortPackageName(pkgPath, df.Name.Name),
+		BuildTags:        buildConstraint(df),
+	}
+}
+
+// buildConstraint returns the expression of the //go:build line of df, or an
+// empty string if there is none.
+func buildConstraint(df *dst.File) string {
+	for _, c := range df.Decs.Start.All() {
+		if !constraint.IsGoBuild(c) {
+			continue
+		}
+		expr, err := constraint.Parse(c)
+		if err != nil {
+			return ""
+		}
+		return expr.String()
+	}
+	return ""
+}
+
+// shortPackageName returns the last element of pkgPath, skipping a major
+// version suffix such as "/v2". Falls back to pkgName if pkgPath is empty.
+func shortPackageName(pkgPath, pkgName string) string {
+	elems := strings.Split(pkgPath, "/")
+	for i := len(elems) - 1; i >= 0; i-- {
+		if elems[i] == "" || (i > 0 && isMajorVersion(elems[i])) {
+			continue
+		}
+		return elems[i]
+	}
+	return pkgName
+}
+
+// isMajorVersion reports whether elem is a module major version suffix like "v2".
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' || elem[1] == '0' {
+		return false
+	}
+	for _, r := range elem[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// snakeCase converts a Go identifier to snake_case, keeping acronyms together
+// (e.g., "GetHTTPResponse" -> "get_http_response", "UserID" -> "user_id").
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]))
+			acronymEnd := i > 0 && unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || acronymEnd {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 // extractReceiverTypeName extracts the base type name from a receiver type expression.
 // It handles regular types, pointer types, and generic types (IndexExpr, IndexListExpr).
 // Returns the type name and a boolean indicating whether it has type parameters.
@@ -107,3 +192,28 @@ func extractReceiverTypeName(expr dst.Expr) (name string, hasGenerics bool) {
 
 	return "", false
 }
+
+// SampleVars returns a synthetic Vars set describing a pointer-receiver method.
+// It is used to validate templates without loading any source code.
+func SampleVars() Vars {
+	return Vars{
+		Ctx:               "ctx",
+		CtxVar:            "ctx",
+		CarrierPackage:    "context",
+		CarrierType:       "Context",
+		FuncName:          "sample.(*Service).Method",
+		PackageName:       "sample",
+		PackagePath:       "example.com/sample",
+		FuncBaseName:      "Method",
+		FuncNameSnake:     "service_method",
+		ModulePath:        "example.com",
+		PackageRelPath:    "sample",
+		PackageNameShort:  "sample",
+		FileName:          "internal/sample/service.go",
+		FileBase:          "service.go",
+		ReceiverType:      "Service",
+		ReceiverVar:       "s",
+		IsMethod:          true,
+		IsPointerReceiver: true,
+	}
+}
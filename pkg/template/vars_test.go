@@ -1,6 +1,7 @@
 package template
 
 import (
+	"maps"
 	"testing"
 
 	"github.com/dave/dst"
@@ -26,6 +27,50 @@ func TestBuildVars(t *testing.T) {
 			pkgPath: "github.com/example/myapp",
 			carrier: config.CarrierDef{},
 			varName: "ctx",
+			expected: Vars{
+				Ctx:              "ctx",
+				CtxVar:           "ctx",
+				PackageName:      "main",
+				PackagePath:      "github.com/example/myapp",
+				FuncBaseName:     "Foo",
+				FuncName:         "main.Foo",
+				FuncNameSnake:    "foo",
+				PackageNameShort: "myapp",
+			},
+		},
+		"name directive": {
+			file: &dst.File{Name: &dst.Ident{Name: "service"}},
+			decl: &dst.FuncDecl{
+				Name: &dst.Ident{Name: "create"},
+				Type: &dst.FuncType{},
+				Decs: dst.FuncDeclDecorations{NodeDecs: dst.NodeDecs{Start: dst.Decorations{"// create creates an order.", "//ctxweaver:name checkout.CreateOrder", "//ctxweaver:attr tier=critical"}}},
+			},
+			pkgPath: "github.com/example/myapp/service",
+			carrier: config.CarrierDef{},
+			varName: "ctx",
+			expected: Vars{
+				Ctx:           "ctx",
+				CtxVar:        "ctx",
+				PackageName:   "service",
+				PackagePath:   "github.com/example/myapp/service",
+				FuncBaseName:  "create",
+				FuncName:      "checkout.CreateOrder",
+				FuncNameSnake: "create",
+				Attrs:         map[string]string{"tier": "critical"},
+			},
+		},
+		"file with build constraint": {
+			file: &dst.File{
+				Name: &dst.Ident{Name: "main"},
+				Decs: dst.FileDecorations{NodeDecs: dst.NodeDecs{Start: dst.Decorations{"// Copyright", "//go:build linux && (amd64 || arm64)"}}},
+			},
+			decl: &dst.FuncDecl{
+				Name: &dst.Ident{Name: "Foo"},
+				Type: &dst.FuncType{},
+			},
+			pkgPath: "github.com/example/myapp",
+			carrier: config.CarrierDef{},
+			varName: "ctx",
 			expected: Vars{
 				Ctx:          "ctx",
 				CtxVar:       "ctx",
@@ -33,6 +78,7 @@ func TestBuildVars(t *testing.T) {
 				PackagePath:  "github.com/example/myapp",
 				FuncBaseName: "Foo",
 				FuncName:     "main.Foo",
+				BuildTags:    "linux && (amd64 || arm64)",
 			},
 		},
 		"generic function": {
@@ -80,6 +126,8 @@ func TestBuildVars(t *testing.T) {
 				PackagePath:       "github.com/example/myapp/service",
 				FuncBaseName:      "Process",
 				FuncName:          "service.(*Service).Process",
+				FuncNameSnake:     "service_process",
+				PackageNameShort:  "service",
 				ReceiverType:      "Service",
 				ReceiverVar:       "s",
 				IsMethod:          true,
@@ -212,15 +260,18 @@ func TestBuildVars(t *testing.T) {
 				Type: &dst.FuncType{},
 			},
 			pkgPath: "github.com/example/myapp/handler",
-			carrier: config.CarrierDef{Accessor: ".Request().Context()"},
+			carrier: config.CarrierDef{Package: "github.com/labstack/echo/v4", Type: "Context", Accessor: ".Request().Context()"},
 			varName: "c",
 			expected: Vars{
-				Ctx:          "c.Request().Context()",
-				CtxVar:       "c",
-				PackageName:  "handler",
-				PackagePath:  "github.com/example/myapp/handler",
-				FuncBaseName: "Handle",
-				FuncName:     "handler.Handle",
+				Ctx:             "c.Request().Context()",
+				CtxVar:          "c",
+				CarrierPackage:  "github.com/labstack/echo/v4",
+				CarrierType:     "Context",
+				CarrierAccessor: ".Request().Context()",
+				PackageName:     "handler",
+				PackagePath:     "github.com/example/myapp/handler",
+				FuncBaseName:    "Handle",
+				FuncName:        "handler.Handle",
 			},
 		},
 	}
@@ -235,6 +286,15 @@ func TestBuildVars(t *testing.T) {
 			if got.CtxVar != tt.expected.CtxVar {
 				t.Errorf("CtxVar = %q, want %q", got.CtxVar, tt.expected.CtxVar)
 			}
+			if got.CarrierPackage != tt.expected.CarrierPackage {
+				t.Errorf("CarrierPackage = %q, want %q", got.CarrierPackage, tt.expected.CarrierPackage)
+			}
+			if got.CarrierType != tt.expected.CarrierType {
+				t.Errorf("CarrierType = %q, want %q", got.CarrierType, tt.expected.CarrierType)
+			}
+			if got.CarrierAccessor != tt.expected.CarrierAccessor {
+				t.Errorf("CarrierAccessor = %q, want %q", got.CarrierAccessor, tt.expected.CarrierAccessor)
+			}
 			if got.PackageName != tt.expected.PackageName {
 				t.Errorf("PackageName = %q, want %q", got.PackageName, tt.expected.PackageName)
 			}
@@ -247,6 +307,12 @@ func TestBuildVars(t *testing.T) {
 			if got.FuncName != tt.expected.FuncName {
 				t.Errorf("FuncName = %q, want %q", got.FuncName, tt.expected.FuncName)
 			}
+			if tt.expected.FuncNameSnake != "" && got.FuncNameSnake != tt.expected.FuncNameSnake {
+				t.Errorf("FuncNameSnake = %q, want %q", got.FuncNameSnake, tt.expected.FuncNameSnake)
+			}
+			if tt.expected.PackageNameShort != "" && got.PackageNameShort != tt.expected.PackageNameShort {
+				t.Errorf("PackageNameShort = %q, want %q", got.PackageNameShort, tt.expected.PackageNameShort)
+			}
 			if got.ReceiverType != tt.expected.ReceiverType {
 				t.Errorf("ReceiverType = %q, want %q", got.ReceiverType, tt.expected.ReceiverType)
 			}
@@ -265,6 +331,62 @@ func TestBuildVars(t *testing.T) {
 			if got.IsGenericReceiver != tt.expected.IsGenericReceiver {
 				t.Errorf("IsGenericReceiver = %v, want %v", got.IsGenericReceiver, tt.expected.IsGenericReceiver)
 			}
+			if got.BuildTags != tt.expected.BuildTags {
+				t.Errorf("BuildTags = %q, want %q", got.BuildTags, tt.expected.BuildTags)
+			}
+			if !maps.Equal(got.Attrs, tt.expected.Attrs) {
+				t.Errorf("Attrs = %v, want %v", got.Attrs, tt.expected.Attrs)
+			}
+		})
+	}
+}
+
+func TestVarsSetModule(t *testing.T) {
+	tests := map[string]struct {
+		pkgPath     string
+		modulePath  string
+		wantRelPath string
+	}{
+		"nested package":           {pkgPath: "github.com/example/myapp/pkg/service", modulePath: "github.com/example/myapp", wantRelPath: "pkg/service"},
+		"module root package":      {pkgPath: "github.com/example/myapp", modulePath: "github.com/example/myapp", wantRelPath: ""},
+		"prefix of another module": {pkgPath: "github.com/example/myapp2/pkg", modulePath: "github.com/example/myapp", wantRelPath: ""},
+		"unknown module":           {pkgPath: "github.com/example/myapp/pkg", modulePath: "", wantRelPath: ""},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			v := Vars{PackagePath: tt.pkgPath}
+			v.SetModule(tt.modulePath)
+			if v.ModulePath != tt.modulePath {
+				t.Errorf("ModulePath = %q, want %q", v.ModulePath, tt.modulePath)
+			}
+			if v.PackageRelPath != tt.wantRelPath {
+				t.Errorf("PackageRelPath = %q, want %q", v.PackageRelPath, tt.wantRelPath)
+			}
+		})
+	}
+}
+
+func TestVarsSetFile(t *testing.T) {
+	tests := map[string]struct {
+		name     string
+		wantBase string
+	}{
+		"nested file":  {name: "internal/service/user.go", wantBase: "user.go"},
+		"root file":    {name: "main.go", wantBase: "main.go"},
+		"unknown file": {name: "", wantBase: ""},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var v Vars
+			v.SetFile(tt.name)
+			if v.FileName != tt.name {
+				t.Errorf("FileName = %q, want %q", v.FileName, tt.name)
+			}
+			if v.FileBase != tt.wantBase {
+				t.Errorf("FileBase = %q, want %q", v.FileBase, tt.wantBase)
+			}
 		})
 	}
 }
@@ -373,3 +495,44 @@ func TestExtractReceiverTypeName(t *testing.T) {
 		})
 	}
 }
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"Foo":             "foo",
+		"getUser":         "get_user",
+		"GetHTTPResponse": "get_http_response",
+		"UserID":          "user_id",
+		"HTTPServer":      "http_server",
+		"Handle2FA":       "handle2_fa",
+		"already_snake":   "already_snake",
+	}
+	for input, want := range tests {
+		t.Run(input, func(t *testing.T) {
+			if got := snakeCase(input); got != want {
+				t.Errorf("snakeCase(%q) = %q, want %q", input, got, want)
+			}
+		})
+	}
+}
+
+func TestShortPackageName(t *testing.T) {
+	tests := map[string]struct {
+		pkgPath string
+		pkgName string
+		want    string
+	}{
+		"last element":         {pkgPath: "github.com/example/app/service", pkgName: "service", want: "service"},
+		"main package":         {pkgPath: "github.com/example/app/cmd/api", pkgName: "main", want: "api"},
+		"major version suffix": {pkgPath: "github.com/example/lib/v2", pkgName: "lib", want: "lib"},
+		"v0 is not a version":  {pkgPath: "example.com/v0", pkgName: "v0", want: "v0"},
+		"single element":       {pkgPath: "v2", pkgName: "v2", want: "v2"},
+		"empty path":           {pkgPath: "", pkgName: "sample", want: "sample"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := shortPackageName(tt.pkgPath, tt.pkgName); got != tt.want {
+				t.Errorf("shortPackageName(%q, %q) = %q, want %q", tt.pkgPath, tt.pkgName, got, tt.want)
+			}
+		})
+	}
+}